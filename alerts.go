@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Tipos de regla soportados por las alertas
+const (
+	AlertPriceAbove  = "price_above"  // El precio supera el umbral
+	AlertPriceBelow  = "price_below"  // El precio cae por debajo del umbral
+	AlertPercentMove = "percent_move" // El precio se mueve más de X% desde el último snapshot
+	AlertBelowWAC    = "below_wac"    // El precio cae Y% por debajo del costo ponderado
+)
+
+// Alert representa una regla de alerta de precio sobre un ticker.
+type Alert struct {
+	gorm.Model
+	TickerID        uint
+	Ticker          Ticker `gorm:"foreignKey:TickerID"`
+	RuleType        string
+	Threshold       decimal.Decimal `gorm:"type:numeric"` // Precio o porcentaje según RuleType
+	Channel         string          // "log", "webhook", "email"
+	Target          string          // URL del webhook o dirección de correo
+	Active          bool            `gorm:"default:true"`
+	Triggered       bool            // La condición se cumplía en la última evaluación
+	LastTriggeredAt *time.Time
+}
+
+// AlertEvent representa un disparo de alerta en el historial.
+type AlertEvent struct {
+	gorm.Model
+	AlertID   uint
+	Alert     Alert `gorm:"foreignKey:AlertID"`
+	TickerID  uint
+	Ticker    Ticker          `gorm:"foreignKey:TickerID"`
+	Source    string          // "manual", "snapshot", "restore", "trash", "batch"
+	Price     decimal.Decimal `gorm:"type:numeric"`
+	Reference decimal.Decimal `gorm:"type:numeric"` // Umbral, precio del snapshot o WAC usado en la comparación
+	Message   string
+	Channel   string
+	Delivered bool
+	Error     string
+}
+
+// AlertNotification es el contenido que se entrega a un canal de notificación.
+type AlertNotification struct {
+	AlertID   uint            `json:"alert_id"`
+	Ticker    string          `json:"ticker"`
+	RuleType  string          `json:"rule_type"`
+	Threshold decimal.Decimal `json:"threshold"`
+	Price     decimal.Decimal `json:"price"`
+	Reference decimal.Decimal `json:"reference"`
+	Message   string          `json:"message"`
+	Source    string          `json:"source"`
+	FiredAt   time.Time       `json:"fired_at"`
+}
+
+// Notifier entrega notificaciones de alerta a un destino concreto.
+type Notifier interface {
+	Notify(target string, n AlertNotification) error
+}
+
+// notifiers contiene los canales disponibles indexados por nombre.
+var notifiers = map[string]Notifier{
+	"log":     logNotifier{},
+	"webhook": webhookNotifier{client: &http.Client{Timeout: 10 * time.Second}},
+	"email":   smtpNotifier{},
+}
+
+// logNotifier escribe la alerta en el log del servidor.
+type logNotifier struct{}
+
+func (logNotifier) Notify(target string, n AlertNotification) error {
+	log.Printf("[ALERTA] %s", n.Message)
+	return nil
+}
+
+// webhookNotifier envía la alerta como JSON a una URL.
+type webhookNotifier struct {
+	client *http.Client
+}
+
+func (w webhookNotifier) Notify(target string, n AlertNotification) error {
+	if target == "" {
+		return fmt.Errorf("falta la URL del webhook")
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("el webhook respondió con estado %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// smtpNotifier envía la alerta por correo usando un servidor SMTP.
+type smtpNotifier struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// smtpNotifierFromEnv configura el canal de correo con SMTP_HOST, SMTP_PORT,
+// SMTP_USER, SMTP_PASSWORD y SMTP_FROM.
+func smtpNotifierFromEnv() smtpNotifier {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "bolsa-gin@localhost"
+	}
+	return smtpNotifier{
+		Addr:     os.Getenv("SMTP_HOST") + ":" + port,
+		From:     from,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+}
+
+func (s smtpNotifier) Notify(target string, n AlertNotification) error {
+	// Sin configuración explícita se lee del entorno en cada envío
+	if s.Addr == "" {
+		s = smtpNotifierFromEnv()
+	}
+	if strings.HasPrefix(s.Addr, ":") {
+		return fmt.Errorf("falta la variable de entorno SMTP_HOST")
+	}
+	if target == "" {
+		return fmt.Errorf("falta la dirección de correo")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Addr, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Alerta %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.From, target, n.Ticker, n.Message)
+	return smtp.SendMail(s.Addr, auth, s.From, []string{target}, []byte(msg))
+}
+
+// alertRuleLabels describe cada tipo de regla para la UI.
+var alertRuleLabels = map[string]string{
+	AlertPriceAbove:  "Precio por encima de",
+	AlertPriceBelow:  "Precio por debajo de",
+	AlertPercentMove: "Movimiento % desde último snapshot",
+	AlertBelowWAC:    "% por debajo del costo ponderado",
+}
+
+// registerAlertRoutes registra las rutas de gestión de alertas.
+func registerAlertRoutes(router *gin.Engine) {
+	// Ruta para mostrar la página de alertas
+	router.GET("/alertas", func(c *gin.Context) {
+		var alerts []Alert
+		db.Preload("Ticker").Order("created_at desc").Find(&alerts)
+
+		var events []AlertEvent
+		db.Preload("Ticker").Order("created_at desc").Limit(100).Find(&events)
+
+		var tickers []Ticker
+		db.Order("name").Find(&tickers)
+
+		c.HTML(http.StatusOK, "alertas.html", gin.H{
+			"Alerts":     alerts,
+			"Events":     events,
+			"Tickers":    tickers,
+			"RuleLabels": alertRuleLabels,
+			"ActivePage": "alertas",
+		})
+	})
+
+	// Ruta para crear una alerta
+	router.POST("/add-alert", func(c *gin.Context) {
+		tickerID, err := strconv.Atoi(c.PostForm("ticker_id"))
+		if err != nil || tickerID <= 0 {
+			c.String(http.StatusBadRequest, "Debe seleccionar un ticker válido.")
+			return
+		}
+
+		ruleType := c.PostForm("rule_type")
+		if _, ok := alertRuleLabels[ruleType]; !ok {
+			c.String(http.StatusBadRequest, "Tipo de regla inválido.")
+			return
+		}
+
+		threshold, err := parseDecimal(c.PostForm("threshold"))
+		if err != nil || !threshold.IsPositive() {
+			c.String(http.StatusBadRequest, "El umbral debe ser un número positivo.")
+			return
+		}
+
+		channel := c.DefaultPostForm("channel", "log")
+		if _, ok := notifiers[channel]; !ok {
+			c.String(http.StatusBadRequest, "Canal de notificación inválido.")
+			return
+		}
+		target := strings.TrimSpace(c.PostForm("target"))
+		if channel != "log" && target == "" {
+			c.String(http.StatusBadRequest, "El destino es obligatorio para este canal.")
+			return
+		}
+
+		var ticker Ticker
+		if err := db.First(&ticker, tickerID).Error; err != nil {
+			c.String(http.StatusBadRequest, "El ticker seleccionado no existe.")
+			return
+		}
+
+		alert := Alert{
+			TickerID:  uint(tickerID),
+			RuleType:  ruleType,
+			Threshold: threshold,
+			Channel:   channel,
+			Target:    target,
+			Active:    true,
+		}
+		db.Create(&alert)
+
+		log.Printf("Nueva alerta %s creada para ticker %s", ruleType, ticker.Name)
+		c.Redirect(http.StatusFound, "/alertas")
+	})
+
+	// Ruta para activar o desactivar una alerta
+	router.POST("/toggle-alert", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		var alert Alert
+		if err := db.First(&alert, id).Error; err != nil {
+			c.String(http.StatusNotFound, "Alerta no encontrada.")
+			return
+		}
+
+		// Al reactivar se reinicia el estado para volver a notificar
+		db.Model(&alert).Updates(map[string]interface{}{
+			"active":    !alert.Active,
+			"triggered": false,
+		})
+
+		if alert.Active {
+			log.Printf("Alerta %d desactivada", id)
+		} else {
+			log.Printf("Alerta %d activada", id)
+		}
+		c.Redirect(http.StatusFound, "/alertas")
+	})
+
+	// Ruta para eliminar una alerta
+	router.POST("/delete-alert", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		db.Delete(&Alert{}, id)
+		log.Printf("Alerta %d eliminada", id)
+		c.Redirect(http.StatusFound, "/alertas")
+	})
+}
+
+// evaluateAlerts evalúa las alertas activas de los tickers indicados tras un
+// cambio de precio. Solo notifica cuando la condición pasa a cumplirse, de
+// modo que una alerta no se repite hasta que el precio vuelve a cruzar.
+func evaluateAlerts(source string, tickerIDs ...uint) {
+	if len(tickerIDs) == 0 {
+		return
+	}
+
+	var alerts []Alert
+	if err := db.Preload("Ticker").Where("active = ? AND ticker_id IN ?", true, tickerIDs).Find(&alerts).Error; err != nil {
+		log.Printf("Error al obtener alertas: %v", err)
+		return
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	// Las posiciones solo se calculan si alguna regla depende del WAC
+	var positions map[uint]PositionState
+	for _, alert := range alerts {
+		if alert.RuleType == AlertBelowWAC {
+			var err error
+			if positions, err = replayPositions(time.Now()); err != nil {
+				log.Printf("Error al reconstruir posiciones para alertas: %v", err)
+				return
+			}
+			break
+		}
+	}
+
+	for _, alert := range alerts {
+		price := alert.Ticker.CurrentPrice
+		matched, reference, message := checkAlertRule(alert, price, source, positions)
+
+		if !matched {
+			if alert.Triggered {
+				if err := db.Model(&alert).Update("triggered", false).Error; err != nil {
+					log.Printf("Error al rearmar la alerta %d: %v", alert.ID, err)
+				}
+			}
+			continue
+		}
+		if alert.Triggered {
+			continue
+		}
+
+		// Las evaluaciones pueden ejecutarse a la vez: solo notifica quien
+		// consigue marcar la alerta como disparada
+		now := time.Now()
+		claim := db.Model(&Alert{}).Where("id = ? AND triggered = ?", alert.ID, false).Updates(map[string]interface{}{
+			"triggered":         true,
+			"last_triggered_at": now,
+		})
+		if claim.Error != nil {
+			log.Printf("Error al marcar la alerta %d: %v", alert.ID, claim.Error)
+			continue
+		}
+		if claim.RowsAffected != 1 {
+			continue
+		}
+
+		notification := AlertNotification{
+			AlertID:   alert.ID,
+			Ticker:    alert.Ticker.Name,
+			RuleType:  alert.RuleType,
+			Threshold: alert.Threshold,
+			Price:     price,
+			Reference: reference,
+			Message:   message,
+			Source:    source,
+			FiredAt:   now,
+		}
+
+		event := AlertEvent{
+			AlertID:   alert.ID,
+			TickerID:  alert.TickerID,
+			Source:    source,
+			Price:     price,
+			Reference: reference,
+			Message:   message,
+			Channel:   alert.Channel,
+			Delivered: true,
+		}
+		notifier, ok := notifiers[alert.Channel]
+		if !ok {
+			notifier = logNotifier{}
+		}
+		if err := notifier.Notify(alert.Target, notification); err != nil {
+			log.Printf("Error al notificar alerta %d por %s: %v", alert.ID, alert.Channel, err)
+			event.Delivered = false
+			event.Error = err.Error()
+		}
+
+		if err := db.Create(&event).Error; err != nil {
+			log.Printf("Error al guardar el disparo de la alerta %d: %v", alert.ID, err)
+		}
+	}
+}
+
+// checkAlertRule indica si la regla se cumple para el precio dado, junto con
+// el valor de referencia usado y un mensaje descriptivo.
+func checkAlertRule(alert Alert, price decimal.Decimal, source string, positions map[uint]PositionState) (bool, decimal.Decimal, string) {
+	name := alert.Ticker.Name
+
+	switch alert.RuleType {
+	case AlertPriceAbove:
+		return price.GreaterThanOrEqual(alert.Threshold), alert.Threshold,
+			fmt.Sprintf("%s cotiza a %s, por encima de %s", name, price.StringFixed(4), alert.Threshold.StringFixed(4))
+
+	case AlertPriceBelow:
+		return price.LessThanOrEqual(alert.Threshold), alert.Threshold,
+			fmt.Sprintf("%s cotiza a %s, por debajo de %s", name, price.StringFixed(4), alert.Threshold.StringFixed(4))
+
+	case AlertPercentMove:
+		// Tras crear un snapshot se compara con el snapshot anterior
+		offset := 0
+		if source == "snapshot" {
+			offset = 1
+		}
+		var ref PriceHistory
+		if err := db.Where("ticker_id = ?", alert.TickerID).Order("created_at desc").Offset(offset).First(&ref).Error; err != nil || !ref.Price.IsPositive() {
+			return false, decimal.Zero, ""
+		}
+		change := price.Sub(ref.Price).Div(ref.Price).Mul(decimal.NewFromInt(100)).Abs()
+		return change.GreaterThanOrEqual(alert.Threshold), ref.Price,
+			fmt.Sprintf("%s se movió %s%% desde el snapshot %s (%s → %s)", name, change.StringFixed(2), ref.SnapshotID, ref.Price.StringFixed(4), price.StringFixed(4))
+
+	case AlertBelowWAC:
+		state, ok := positions[alert.TickerID]
+		if !ok || !state.Shares.IsPositive() {
+			return false, decimal.Zero, ""
+		}
+		wac := state.WAC()
+		if !wac.IsPositive() {
+			return false, decimal.Zero, ""
+		}
+		drop := wac.Sub(price).Div(wac).Mul(decimal.NewFromInt(100))
+		return drop.GreaterThanOrEqual(alert.Threshold), wac,
+			fmt.Sprintf("%s cotiza a %s, %s%% por debajo del costo ponderado %s", name, price.StringFixed(4), drop.StringFixed(2), wac.StringFixed(4))
+	}
+
+	return false, decimal.Zero, ""
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckAlertRule(t *testing.T) {
+	ticker := Ticker{Name: "ACME"}
+	ticker.ID = 7
+	positions := map[uint]PositionState{
+		7: {Shares: decimal.NewFromInt(10), Capital: decimal.NewFromInt(1000)}, // WAC 100
+	}
+
+	tests := []struct {
+		name      string
+		rule      string
+		threshold int64
+		price     int64
+		positions map[uint]PositionState
+		matched   bool
+		reference int64
+	}{
+		{"por encima, se cumple", AlertPriceAbove, 100, 105, nil, true, 100},
+		{"por encima, igual al umbral", AlertPriceAbove, 100, 100, nil, true, 100},
+		{"por encima, no se cumple", AlertPriceAbove, 100, 95, nil, false, 100},
+		{"por debajo, se cumple", AlertPriceBelow, 100, 90, nil, true, 100},
+		{"por debajo, no se cumple", AlertPriceBelow, 100, 110, nil, false, 100},
+		{"bajo WAC, se cumple", AlertBelowWAC, 10, 85, positions, true, 100},
+		{"bajo WAC, no llega al porcentaje", AlertBelowWAC, 10, 95, positions, false, 100},
+		{"bajo WAC, sin posición", AlertBelowWAC, 10, 50, nil, false, 0},
+		{"regla desconocida", "unknown", 10, 50, nil, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := Alert{TickerID: 7, Ticker: ticker, RuleType: tt.rule, Threshold: decimal.NewFromInt(tt.threshold)}
+			matched, reference, message := checkAlertRule(alert, decimal.NewFromInt(tt.price), "manual", tt.positions)
+			if matched != tt.matched {
+				t.Errorf("matched = %v, se esperaba %v (%s)", matched, tt.matched, message)
+			}
+			if !reference.Equal(decimal.NewFromInt(tt.reference)) {
+				t.Errorf("reference = %s, se esperaba %d", reference, tt.reference)
+			}
+			if matched && !strings.Contains(message, "ACME") {
+				t.Errorf("el mensaje no menciona el ticker: %q", message)
+			}
+		})
+	}
+}
+
+func TestCheckAlertRulePercentMove(t *testing.T) {
+	useTestDatabase(t)
+	ticker := Ticker{Name: "MOVE", CurrentPrice: decimal.NewFromInt(100)}
+	db.Create(&ticker)
+	db.Create(&PriceHistory{SnapshotID: "20240101-000000", TickerID: ticker.ID, Price: decimal.NewFromInt(100)})
+
+	alert := Alert{TickerID: ticker.ID, Ticker: ticker, RuleType: AlertPercentMove, Threshold: decimal.NewFromInt(5)}
+	if matched, _, _ := checkAlertRule(alert, decimal.NewFromInt(104), "manual", nil); matched {
+		t.Error("un movimiento del 4% no debería cumplir un umbral del 5%")
+	}
+	matched, reference, _ := checkAlertRule(alert, decimal.NewFromInt(94), "manual", nil)
+	if !matched || !reference.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("matched = %v, reference = %s; se esperaba true y 100", matched, reference)
+	}
+
+	// Tras un snapshot se compara con el anterior, no con el recién creado
+	db.Create(&PriceHistory{SnapshotID: "20240102-000000", TickerID: ticker.ID, Price: decimal.NewFromInt(94)})
+	if matched, reference, _ := checkAlertRule(alert, decimal.NewFromInt(94), "snapshot", nil); !matched || !reference.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("snapshot: matched = %v, reference = %s; se esperaba true y 100", matched, reference)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received AlertNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("cuerpo inválido: %v", err)
+		}
+		if received.Ticker == "FAIL" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	notifier := webhookNotifier{client: server.Client()}
+	n := AlertNotification{AlertID: 3, Ticker: "ACME", Price: decimal.RequireFromString("12.5"), Message: "ACME cotiza a 12.5"}
+	if err := notifier.Notify(server.URL, n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if received.AlertID != 3 || received.Message != n.Message || !received.Price.Equal(n.Price) {
+		t.Errorf("notificación recibida = %+v", received)
+	}
+
+	n.Ticker = "FAIL"
+	if err := notifier.Notify(server.URL, n); err == nil {
+		t.Error("se esperaba un error ante una respuesta 500")
+	}
+	if err := notifier.Notify("", n); err == nil {
+		t.Error("se esperaba un error sin URL")
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Servidor SMTP mínimo que acepta un mensaje y lo guarda
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					messages <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 Fin con <CRLF>.<CRLF>")
+			case cmd == "QUIT":
+				reply("221 Adiós")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	notifier := smtpNotifier{Addr: listener.Addr().String(), From: "bolsa@test"}
+	n := AlertNotification{Ticker: "ACME", Message: "ACME cotiza a 12.5"}
+	if err := notifier.Notify("inversor@test", n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msg := <-messages
+	for _, want := range []string{"To: inversor@test", "Subject: Alerta ACME", "ACME cotiza a 12.5"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("el mensaje no contiene %q:\n%s", want, msg)
+		}
+	}
+
+	if err := notifier.Notify("", n); err == nil {
+		t.Error("se esperaba un error sin dirección de correo")
+	}
+}
+
+// countingNotifier cuenta las notificaciones recibidas.
+type countingNotifier struct {
+	count *int32
+}
+
+func (c countingNotifier) Notify(target string, n AlertNotification) error {
+	atomic.AddInt32(c.count, 1)
+	return nil
+}
+
+func TestEvaluateAlertsNotifiesOnce(t *testing.T) {
+	useTestDatabase(t)
+	var count int32
+	notifiers["test"] = countingNotifier{count: &count}
+	t.Cleanup(func() { delete(notifiers, "test") })
+
+	ticker := Ticker{Name: "ONCE", CurrentPrice: decimal.NewFromInt(120)}
+	db.Create(&ticker)
+	alert := Alert{TickerID: ticker.ID, RuleType: AlertPriceAbove, Threshold: decimal.NewFromInt(100), Channel: "test", Active: true}
+	db.Create(&alert)
+
+	// Varias evaluaciones simultáneas solo deben disparar una vez
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			evaluateAlerts("manual", ticker.ID)
+		}()
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Fatalf("notificaciones = %d, se esperaba 1", count)
+	}
+	var events int64
+	db.Model(&AlertEvent{}).Where("alert_id = ?", alert.ID).Count(&events)
+	if events != 1 {
+		t.Fatalf("eventos = %d, se esperaba 1", events)
+	}
+
+	// Al dejar de cumplirse se rearma y vuelve a notificar al cruzar de nuevo
+	db.Model(&ticker).Update("current_price", decimal.NewFromInt(90))
+	evaluateAlerts("manual", ticker.ID)
+	db.First(&alert, alert.ID)
+	if alert.Triggered {
+		t.Fatal("la alerta debería haberse rearmado")
+	}
+	db.Model(&ticker).Update("current_price", decimal.NewFromInt(110))
+	evaluateAlerts("manual", ticker.ID)
+	if count != 2 {
+		t.Fatalf("notificaciones = %d, se esperaba 2", count)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// unclassifiedLabel agrupa las posiciones sin dato para una dimensión.
+const unclassifiedLabel = "Sin clasificar"
+
+// AllocationSlice representa el peso de un grupo dentro de la cartera.
+type AllocationSlice struct {
+	Label   string          `json:"label"`
+	Value   decimal.Decimal `json:"value"`
+	Percent float64         `json:"percent"`
+}
+
+// AllocationBreakdown agrupa el reparto de la cartera según una dimensión.
+// Sin conversión de divisas los importes de distintas monedas no se suman, así
+// que cada reparto cubre solo las posiciones de una moneda.
+type AllocationBreakdown struct {
+	Dimension  string            `json:"dimension"`
+	Label      string            `json:"label"`
+	Currency   string            `json:"currency"`
+	Currencies []string          `json:"currencies,omitempty"` // Monedas con posiciones abiertas
+	Total      decimal.Decimal   `json:"total"`
+	Slices     []AllocationSlice `json:"slices"`
+}
+
+// allocationDimensions enumera las dimensiones de clasificación en el orden de la UI.
+var allocationDimensions = []struct {
+	Key   string
+	Label string
+}{
+	{"asset_class", "Clase de Activo"},
+	{"sector", "Sector"},
+	{"industry", "Industria"},
+	{"country", "País"},
+	{"currency", "Moneda"},
+	{"exchange", "Mercado"},
+}
+
+// tickerDimensionValue devuelve el valor del ticker para la dimensión indicada.
+func tickerDimensionValue(t Ticker, dimension string) string {
+	switch dimension {
+	case "asset_class":
+		if label, ok := assetClassLabels[t.AssetClass]; ok {
+			return label
+		}
+		return ""
+	case "sector":
+		return t.Sector
+	case "industry":
+		return t.Industry
+	case "country":
+		return t.Country
+	case "currency":
+		return t.Currency
+	case "exchange":
+		return t.ExchangeMIC
+	}
+	return ""
+}
+
+// ETFConstituent representa el peso de un componente dentro de un ETF, usado
+// para repartir el valor del ETF entre sus subyacentes (look-through).
+type ETFConstituent struct {
+	gorm.Model
+	ETFTickerID uint `gorm:"index"`
+	Name        string
+	Weight      float64 // Peso en porcentaje (0-100)
+	Sector      string
+	Industry    string
+	Country     string
+	Currency    string
+	AssetClass  string
+	ExchangeMIC string
+}
+
+// asTicker devuelve un ticker con la clasificación del componente.
+func (ec ETFConstituent) asTicker() Ticker {
+	return Ticker{
+		Name:        ec.Name,
+		Sector:      ec.Sector,
+		Industry:    ec.Industry,
+		Country:     ec.Country,
+		Currency:    ec.Currency,
+		AssetClass:  ec.AssetClass,
+		ExchangeMIC: ec.ExchangeMIC,
+	}
+}
+
+// findAllocationDimension busca una dimensión por su clave.
+func findAllocationDimension(key string) (string, bool) {
+	for _, d := range allocationDimensions {
+		if d.Key == key {
+			return d.Label, true
+		}
+	}
+	return "", false
+}
+
+// allocationCurrencies devuelve las monedas de las posiciones abiertas, de la
+// de mayor a la de menor valor.
+func allocationCurrencies(summaries []TickerSummaryView, tickers map[uint]Ticker) []string {
+	values := make(map[string]decimal.Decimal)
+	for _, s := range summaries {
+		if s.TotalShares.IsPositive() && s.CurrentValue.IsPositive() {
+			currency := tickers[s.TickerID].Currency
+			values[currency] = values[currency].Add(s.CurrentValue)
+		}
+	}
+
+	currencies := make([]string, 0, len(values))
+	for currency := range values {
+		currencies = append(currencies, currency)
+	}
+	sort.Slice(currencies, func(i, j int) bool {
+		if cmp := values[currencies[i]].Cmp(values[currencies[j]]); cmp != 0 {
+			return cmp > 0
+		}
+		return currencies[i] < currencies[j]
+	})
+	return currencies
+}
+
+// computeAllocation agrupa el valor actual de las posiciones abiertas en la
+// moneda indicada por la dimensión indicada. Si se pasan componentes, el valor
+// de cada ETF se reparte según sus pesos y el resto queda clasificado con los
+// datos del propio ETF.
+func computeAllocation(dimension, label, currency string, summaries []TickerSummaryView, tickers map[uint]Ticker, constituents map[uint][]ETFConstituent) AllocationBreakdown {
+	breakdown := AllocationBreakdown{Dimension: dimension, Label: label, Currency: currency}
+
+	values := make(map[string]decimal.Decimal)
+	add := func(t Ticker, value decimal.Decimal) {
+		group := tickerDimensionValue(t, dimension)
+		if group == "" {
+			group = unclassifiedLabel
+		}
+		values[group] = values[group].Add(value)
+	}
+
+	hundred := decimal.NewFromInt(100)
+	for _, s := range summaries {
+		if !s.TotalShares.IsPositive() || !s.CurrentValue.IsPositive() {
+			continue
+		}
+		ticker := tickers[s.TickerID]
+		if ticker.Currency != currency {
+			continue
+		}
+		breakdown.Total = breakdown.Total.Add(s.CurrentValue)
+
+		remaining := hundred
+		for _, ec := range constituents[s.TickerID] {
+			weight := decimal.NewFromFloat(ec.Weight)
+			add(ec.asTicker(), s.CurrentValue.Mul(weight).Div(hundred))
+			remaining = remaining.Sub(weight)
+		}
+		if remaining.IsPositive() {
+			add(ticker, s.CurrentValue.Mul(remaining).Div(hundred))
+		}
+	}
+
+	for group, value := range values {
+		breakdown.Slices = append(breakdown.Slices, AllocationSlice{
+			Label:   group,
+			Value:   roundMoney(value, currency),
+			Percent: percentOf(value, breakdown.Total),
+		})
+	}
+
+	// Ordenar de mayor a menor peso
+	sort.Slice(breakdown.Slices, func(i, j int) bool {
+		return breakdown.Slices[i].Value.GreaterThan(breakdown.Slices[j].Value)
+	})
+	return breakdown
+}
+
+// getAllocationBreakdowns calcula el reparto de la cartera para todas las
+// dimensiones y monedas, con look-through de ETFs si se solicita.
+func getAllocationBreakdowns(summaries []TickerSummaryView, lookThrough bool) []AllocationBreakdown {
+	tickerMap, constituents := loadAllocationData(lookThrough)
+
+	var breakdowns []AllocationBreakdown
+	for _, currency := range allocationCurrencies(summaries, tickerMap) {
+		for _, d := range allocationDimensions {
+			breakdowns = append(breakdowns, computeAllocation(d.Key, d.Label, currency, summaries, tickerMap, constituents))
+		}
+	}
+	return breakdowns
+}
+
+// loadAllocationData obtiene los tickers y, si se pide look-through, los
+// componentes de los ETFs indexados por ticker.
+func loadAllocationData(lookThrough bool) (map[uint]Ticker, map[uint][]ETFConstituent) {
+	var tickers []Ticker
+	db.Find(&tickers)
+	tickerMap := make(map[uint]Ticker)
+	for _, t := range tickers {
+		tickerMap[t.ID] = t
+	}
+
+	if !lookThrough {
+		return tickerMap, nil
+	}
+
+	var list []ETFConstituent
+	db.Order("weight desc").Find(&list)
+	constituents := make(map[uint][]ETFConstituent)
+	for _, ec := range list {
+		if tickerMap[ec.ETFTickerID].AssetClass == "etf" {
+			constituents[ec.ETFTickerID] = append(constituents[ec.ETFTickerID], ec)
+		}
+	}
+	return tickerMap, constituents
+}
+
+// registerAllocationRoutes registra las rutas de reparto de la cartera y de
+// gestión de componentes de ETFs.
+func registerAllocationRoutes(router *gin.Engine) {
+	// API: Reparto de la cartera por dimensión
+	router.GET("/api/allocation/:dimension", func(c *gin.Context) {
+		dimension := c.Param("dimension")
+		label, ok := findAllocationDimension(dimension)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Dimensión inválida"})
+			return
+		}
+
+		_, summaries, _, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener los datos"})
+			return
+		}
+
+		lookThrough := c.Query("lookthrough") == "true" || c.Query("lookthrough") == "1"
+		tickerMap, constituents := loadAllocationData(lookThrough)
+
+		// Por defecto, la moneda con más valor en cartera
+		currencies := allocationCurrencies(summaries, tickerMap)
+		currency, ok := c.GetQuery("currency")
+		if !ok && len(currencies) > 0 {
+			currency = currencies[0]
+		}
+		breakdown := computeAllocation(dimension, label, strings.ToUpper(currency), summaries, tickerMap, constituents)
+		breakdown.Currencies = currencies
+		c.JSON(http.StatusOK, breakdown)
+	})
+
+	// Ruta para agregar un componente a un ETF
+	router.POST("/ticker/:id/constituents", func(c *gin.Context) {
+		tickerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		var ticker Ticker
+		if err := db.First(&ticker, tickerID).Error; err != nil {
+			c.String(http.StatusNotFound, "Ticker no encontrado.")
+			return
+		}
+
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.String(http.StatusBadRequest, "El nombre del componente es obligatorio.")
+			return
+		}
+
+		weight, err := strconv.ParseFloat(strings.Replace(c.PostForm("weight"), ",", ".", -1), 64)
+		if err != nil || weight <= 0 || weight > 100 {
+			c.String(http.StatusBadRequest, "El peso debe estar entre 0 y 100.")
+			return
+		}
+
+		// La suma de pesos no puede superar el 100%
+		var currentTotal float64
+		db.Model(&ETFConstituent{}).Where("etf_ticker_id = ?", tickerID).Select("COALESCE(SUM(weight), 0)").Scan(&currentTotal)
+		if currentTotal+weight > 100.0001 {
+			c.String(http.StatusBadRequest, "La suma de pesos superaría el 100%% (actual: %.2f%%).", currentTotal)
+			return
+		}
+
+		metadata := TickerMetadata{
+			Country:     strings.ToUpper(strings.TrimSpace(c.PostForm("country"))),
+			Currency:    strings.ToUpper(strings.TrimSpace(c.PostForm("currency"))),
+			ExchangeMIC: strings.ToUpper(strings.TrimSpace(c.PostForm("exchange_mic"))),
+			AssetClass:  strings.ToLower(strings.TrimSpace(c.PostForm("asset_class"))),
+			Sector:      strings.TrimSpace(c.PostForm("sector")),
+			Industry:    strings.TrimSpace(c.PostForm("industry")),
+		}
+		if err := metadata.Validate(); err != nil {
+			c.String(http.StatusBadRequest, "Datos inválidos: %v", err)
+			return
+		}
+
+		constituent := ETFConstituent{
+			ETFTickerID: uint(tickerID),
+			Name:        name,
+			Weight:      weight,
+			Sector:      metadata.Sector,
+			Industry:    metadata.Industry,
+			Country:     metadata.Country,
+			Currency:    metadata.Currency,
+			AssetClass:  metadata.AssetClass,
+			ExchangeMIC: metadata.ExchangeMIC,
+		}
+		db.Create(&constituent)
+
+		log.Printf("Componente %s (%.2f%%) agregado al ETF %s", name, weight, ticker.Name)
+		c.Redirect(http.StatusFound, fmt.Sprintf("/ticker/%d", tickerID))
+	})
+
+	// Ruta para eliminar un componente de un ETF
+	router.POST("/delete-constituent", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		var constituent ETFConstituent
+		if err := db.First(&constituent, id).Error; err != nil {
+			c.String(http.StatusNotFound, "Componente no encontrado.")
+			return
+		}
+
+		db.Delete(&constituent)
+		log.Printf("Componente %d eliminado del ETF %d", id, constituent.ETFTickerID)
+		c.Redirect(http.StatusFound, fmt.Sprintf("/ticker/%d", constituent.ETFTickerID))
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestComputeAllocationSeparatesCurrencies(t *testing.T) {
+	tickers := map[uint]Ticker{
+		1: {Name: "SAN", Currency: "EUR", Sector: "Financiero"},
+		2: {Name: "BBVA", Currency: "EUR", Sector: "Financiero"},
+		3: {Name: "AAPL", Currency: "USD", Sector: "Tecnología"},
+		4: {Name: "SONY", Currency: "JPY", Sector: "Tecnología"},
+	}
+	summaries := []TickerSummaryView{
+		{TickerID: 1, TotalShares: decimal.NewFromInt(10), CurrentValue: decimal.NewFromInt(300)},
+		{TickerID: 2, TotalShares: decimal.NewFromInt(10), CurrentValue: decimal.NewFromInt(100)},
+		{TickerID: 3, TotalShares: decimal.NewFromInt(1), CurrentValue: decimal.NewFromInt(200)},
+		{TickerID: 4, TotalShares: decimal.NewFromInt(100), CurrentValue: decimal.NewFromInt(150000)},
+	}
+
+	if got, want := allocationCurrencies(summaries, tickers), []string{"JPY", "EUR", "USD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("allocationCurrencies = %v, se esperaba %v", got, want)
+	}
+
+	eur := computeAllocation("sector", "Sector", "EUR", summaries, tickers, nil)
+	if !eur.Total.Equal(decimal.NewFromInt(400)) {
+		t.Errorf("total EUR = %s, se esperaba 400", eur.Total)
+	}
+	if len(eur.Slices) != 1 || eur.Slices[0].Label != "Financiero" || eur.Slices[0].Percent != 100 {
+		t.Errorf("reparto EUR = %+v", eur.Slices)
+	}
+
+	usd := computeAllocation("sector", "Sector", "USD", summaries, tickers, nil)
+	if !usd.Total.Equal(decimal.NewFromInt(200)) || len(usd.Slices) != 1 || usd.Slices[0].Label != "Tecnología" {
+		t.Errorf("reparto USD = %s %+v", usd.Total, usd.Slices)
+	}
+}
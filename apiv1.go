@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Códigos de error de la API v1
+const (
+	APICodeInvalidJSON  = "invalid_json"
+	APICodeInvalidID    = "invalid_id"
+	APICodeInvalidQuery = "invalid_query"
+	APICodeValidation   = "validation_error"
+	APICodeNotFound     = "not_found"
+	APICodeConflict     = "conflict"
+	APICodeInternal     = "internal_error"
+
+	APICodeIdempotencyReused = "idempotency_key_reused"
+)
+
+// APIResponse es el sobre de las respuestas correctas de la API v1.
+type APIResponse struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Meta    *TradePage  `json:"meta,omitempty"` // Paginación de los listados
+}
+
+// APIErrorResponse es el sobre de las respuestas de error de la API v1.
+type APIErrorResponse struct {
+	Success bool        `json:"success"`
+	Error   string      `json:"error"`
+	Code    string      `json:"code"`
+	Current interface{} `json:"current,omitempty"` // Estado actual si la versión no coincide
+}
+
+// apiError es un error con el código HTTP y el código de la API con que se responde.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Current interface{} // Estado actual del recurso en los conflictos de versión
+	Version time.Time   // Versión de Current, que se envía en la cabecera ETag
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// validationError crea un error de validación de los datos de entrada (422).
+func validationError(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusUnprocessableEntity, Code: APICodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+// notFoundError crea un error de recurso inexistente (404).
+func notFoundError(message string) error {
+	return &apiError{Status: http.StatusNotFound, Code: APICodeNotFound, Message: message}
+}
+
+// conflictError crea un error de conflicto con el estado actual (409).
+func conflictError(message string) error {
+	return &apiError{Status: http.StatusConflict, Code: APICodeConflict, Message: message}
+}
+
+// respondAPI envía una respuesta correcta con el sobre de la API v1.
+func respondAPI(c *gin.Context, status int, data interface{}, message string) {
+	c.JSON(status, APIResponse{Success: true, Data: data, Message: message})
+}
+
+// respondAPIPage envía una página de un listado con sus datos de paginación.
+func respondAPIPage(c *gin.Context, data interface{}, page TradePage) {
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: data, Meta: &page})
+}
+
+// respondAPIError envía un error con el sobre de la API v1.
+func respondAPIError(c *gin.Context, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		if !apiErr.Version.IsZero() {
+			setETag(c, apiErr.Version)
+		}
+		c.JSON(apiErr.Status, APIErrorResponse{Error: apiErr.Message, Code: apiErr.Code, Current: apiErr.Current})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, APIErrorResponse{Error: "Recurso no encontrado", Code: APICodeNotFound})
+	default:
+		log.Printf("Error en la API: %v", err)
+		c.JSON(http.StatusInternalServerError, APIErrorResponse{Error: "Error interno del servidor", Code: APICodeInternal})
+	}
+}
+
+// bindAPIJSON lee el cuerpo JSON de la petición y responde 400 si no es válido.
+func bindAPIJSON(c *gin.Context, input interface{}) bool {
+	if err := c.ShouldBindJSON(input); err != nil {
+		c.JSON(http.StatusBadRequest, APIErrorResponse{Error: "JSON inválido: " + err.Error(), Code: APICodeInvalidJSON})
+		return false
+	}
+	return true
+}
+
+// apiID lee el parámetro :id de la ruta y responde 400 si no es un ID válido.
+func apiID(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, APIErrorResponse{Error: "ID inválido", Code: APICodeInvalidID})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// parseTradeDate interpreta la fecha de una operación en los formatos que
+// usan los formularios (datetime-local o solo fecha) o en RFC 3339.
+func parseTradeDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("formato de fecha inválido: %q", value)
+}
+
+// formTradeDate convierte las fechas DD/MM/AAAA, que los formularios de
+// ventas aceptan por compatibilidad, al formato AAAA-MM-DD de parseTradeDate.
+func formTradeDate(value string) string {
+	if t, err := time.Parse("02/01/2006", value); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return value
+}
+
+// --- ENTRADAS ---
+
+// TickerInput son los datos para crear o reemplazar un ticker.
+type TickerInput struct {
+	Name               string          `json:"name"`
+	CurrentPrice       decimal.Decimal `json:"current_price"`
+	ISIN               string          `json:"isin"`
+	ExchangeMIC        string          `json:"exchange_mic"`
+	Currency           string          `json:"currency"`
+	Sector             string          `json:"sector"`
+	Industry           string          `json:"industry"`
+	Country            string          `json:"country"`
+	AssetClass         string          `json:"asset_class"`
+	YahooFinanceTicker string          `json:"yahoo_finance_ticker"`
+}
+
+// ticker normaliza y valida la entrada con las mismas reglas que el formulario.
+func (in TickerInput) ticker() (Ticker, error) {
+	name := strings.ToUpper(strings.TrimSpace(in.Name))
+	if name == "" {
+		return Ticker{}, validationError("El nombre del ticker es obligatorio.")
+	}
+	if in.CurrentPrice.IsNegative() {
+		return Ticker{}, validationError("El precio no puede ser negativo.")
+	}
+	metadata := TickerMetadata{
+		ISIN:               strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(in.ISIN)), " ", ""),
+		ExchangeMIC:        strings.ToUpper(strings.TrimSpace(in.ExchangeMIC)),
+		Currency:           strings.ToUpper(strings.TrimSpace(in.Currency)),
+		Sector:             strings.TrimSpace(in.Sector),
+		Industry:           strings.TrimSpace(in.Industry),
+		Country:            strings.ToUpper(strings.TrimSpace(in.Country)),
+		AssetClass:         strings.ToLower(strings.TrimSpace(in.AssetClass)),
+		YahooFinanceTicker: strings.TrimSpace(in.YahooFinanceTicker),
+	}
+	if err := metadata.Validate(); err != nil {
+		return Ticker{}, validationError("Metadatos inválidos: %v", err)
+	}
+	return Ticker{
+		Name:               name,
+		CurrentPrice:       roundPrice(in.CurrentPrice),
+		ISIN:               metadata.ISIN,
+		ExchangeMIC:        metadata.ExchangeMIC,
+		Currency:           metadata.Currency,
+		Sector:             metadata.Sector,
+		Industry:           metadata.Industry,
+		Country:            metadata.Country,
+		AssetClass:         metadata.AssetClass,
+		YahooFinanceTicker: metadata.YahooFinanceTicker,
+	}, nil
+}
+
+// InvestmentInput son los datos para crear o reemplazar una compra.
+type InvestmentInput struct {
+	TickerID      uint            `json:"ticker_id"`
+	PurchaseDate  string          `json:"purchase_date"`
+	Shares        decimal.Decimal `json:"shares"`
+	PurchasePrice decimal.Decimal `json:"purchase_price"`
+	OperationCost decimal.Decimal `json:"operation_cost"`
+}
+
+// investment valida la entrada y redondea los importes a la moneda del ticker.
+func (in InvestmentInput) investment(tx *gorm.DB) (Investment, error) {
+	ticker, err := tradeTicker(tx, in.TickerID)
+	if err != nil {
+		return Investment{}, err
+	}
+	purchaseDate, err := parseTradeDate(in.PurchaseDate)
+	if err != nil {
+		return Investment{}, validationError("La fecha de compra es obligatoria (AAAA-MM-DD o AAAA-MM-DDTHH:MM).")
+	}
+	if !in.Shares.IsPositive() {
+		return Investment{}, validationError("La cantidad de acciones debe ser un número positivo.")
+	}
+	if !in.PurchasePrice.IsPositive() {
+		return Investment{}, validationError("El precio de compra debe ser un número positivo.")
+	}
+	if in.OperationCost.IsNegative() {
+		return Investment{}, validationError("El costo de operación no puede ser negativo.")
+	}
+	return Investment{
+		TickerID:      ticker.ID,
+		Ticker:        ticker,
+		PurchaseDate:  purchaseDate,
+		Shares:        roundShares(in.Shares),
+		PurchasePrice: roundPrice(in.PurchasePrice),
+		OperationCost: roundMoney(in.OperationCost, ticker.Currency),
+	}, nil
+}
+
+// SaleInput son los datos para crear o reemplazar una venta.
+type SaleInput struct {
+	TickerID      uint            `json:"ticker_id"`
+	SaleDate      string          `json:"sale_date"`
+	Shares        decimal.Decimal `json:"shares"`
+	SalePrice     decimal.Decimal `json:"sale_price"`
+	OperationCost decimal.Decimal `json:"operation_cost"`
+	WithheldTax   decimal.Decimal `json:"withheld_tax"`
+}
+
+// sale valida la entrada y redondea los importes a la moneda del ticker.
+func (in SaleInput) sale(tx *gorm.DB) (Sale, error) {
+	ticker, err := tradeTicker(tx, in.TickerID)
+	if err != nil {
+		return Sale{}, err
+	}
+	saleDate, err := parseTradeDate(in.SaleDate)
+	if err != nil {
+		return Sale{}, validationError("La fecha de venta es obligatoria (AAAA-MM-DD o AAAA-MM-DDTHH:MM).")
+	}
+	if !in.Shares.IsPositive() {
+		return Sale{}, validationError("La cantidad de acciones debe ser un número positivo.")
+	}
+	if !in.SalePrice.IsPositive() {
+		return Sale{}, validationError("El precio de venta debe ser un número positivo.")
+	}
+	if in.OperationCost.IsNegative() || in.WithheldTax.IsNegative() {
+		return Sale{}, validationError("El costo de operación y la retención no pueden ser negativos.")
+	}
+	return Sale{
+		TickerID:      ticker.ID,
+		Ticker:        ticker,
+		SaleDate:      saleDate,
+		Shares:        roundShares(in.Shares),
+		SalePrice:     roundPrice(in.SalePrice),
+		OperationCost: roundMoney(in.OperationCost, ticker.Currency),
+		WithheldTax:   roundMoney(in.WithheldTax, ticker.Currency),
+	}, nil
+}
+
+// tradeTicker obtiene el ticker de una operación o un error de validación.
+func tradeTicker(tx *gorm.DB, tickerID uint) (Ticker, error) {
+	if tickerID == 0 {
+		return Ticker{}, validationError("Debe indicar un ticker_id válido.")
+	}
+	var ticker Ticker
+	if err := tx.First(&ticker, tickerID).Error; err != nil {
+		return Ticker{}, validationError("El ticker %d no existe.", tickerID)
+	}
+	return ticker, nil
+}
+
+// --- OPERACIONES ---
+//
+// Las operaciones reciben la conexión o transacción sobre la que trabajan:
+// los handlers pasan db y el endpoint de lotes, la transacción común.
+
+// createTicker da de alta un ticker y lo audita.
+func createTicker(tx *gorm.DB, actor string, in TickerInput) (Ticker, error) {
+	ticker, err := in.ticker()
+	if err != nil {
+		return Ticker{}, err
+	}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Unscoped().Model(&Ticker{}).Where("name = ?", ticker.Name).Count(&count)
+		if count > 0 {
+			return conflictError(fmt.Sprintf("El ticker %s ya existe (puede estar en la papelera).", ticker.Name))
+		}
+		if err := tx.Create(&ticker).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, AuditTicker, ticker.ID, AuditCreate, nil, ticker)
+	})
+	return ticker, err
+}
+
+// updateTicker reemplaza los datos de un ticker y lo audita. Si version no
+// está vacía (valor de If-Match) debe coincidir con la versión actual. Quien
+// lo llama reevalúa las alertas del ticker cuando la transacción se confirma.
+func updateTicker(tx *gorm.DB, actor string, id uint, version string, in TickerInput) (Ticker, error) {
+	var before Ticker
+	if err := tx.First(&before, id).Error; err != nil {
+		return Ticker{}, notFoundError("Ticker no encontrado")
+	}
+	if version != "" && !ifMatches(version, before.UpdatedAt) {
+		return Ticker{}, preconditionFailedError(newAPITicker(before), before.UpdatedAt)
+	}
+	ticker, err := in.ticker()
+	if err != nil {
+		return Ticker{}, err
+	}
+	var count int64
+	tx.Unscoped().Model(&Ticker{}).Where("name = ? AND id <> ?", ticker.Name, id).Count(&count)
+	if count > 0 {
+		return Ticker{}, conflictError(fmt.Sprintf("Ya existe otro ticker llamado %s.", ticker.Name))
+	}
+
+	updates := ticker.Metadata().updates()
+	updates["name"] = ticker.Name
+	updates["current_price"] = ticker.CurrentPrice
+	var after Ticker
+	if err := auditedUpdate(tx, actor, AuditTicker, id, before, &after, updates); err != nil {
+		return Ticker{}, staleAPIError(err, func() (interface{}, time.Time, error) {
+			var current Ticker
+			if err := tx.First(&current, id).Error; err != nil {
+				return nil, time.Time{}, notFoundError("Ticker no encontrado")
+			}
+			return newAPITicker(current), current.UpdatedAt, nil
+		})
+	}
+	return after, nil
+}
+
+// deleteTicker envía un ticker a la papelera si no tiene operaciones activas.
+func deleteTicker(tx *gorm.DB, actor string, id uint) error {
+	var investmentCount, saleCount int64
+	tx.Model(&Investment{}).Where("ticker_id = ?", id).Count(&investmentCount)
+	tx.Model(&Sale{}).Where("ticker_id = ?", id).Count(&saleCount)
+	if investmentCount > 0 || saleCount > 0 {
+		return conflictError("No se puede eliminar el ticker porque tiene inversiones o ventas asociadas.")
+	}
+	if err := auditedDelete(tx, actor, AuditTicker, id, &Ticker{}); err != nil {
+		return notFoundError("Ticker no encontrado")
+	}
+	return nil
+}
+
+// createInvestment registra una compra y la audita.
+func createInvestment(tx *gorm.DB, actor string, in InvestmentInput) (Investment, error) {
+	var inv Investment
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if inv, err = in.investment(tx); err != nil {
+			return err
+		}
+		if err := tx.Omit("Ticker").Create(&inv).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, AuditInvestment, inv.ID, AuditCreate, nil, inv)
+	})
+	return inv, err
+}
+
+// updateInvestment reemplaza los datos de una compra y la audita. Si version
+// no está vacía debe coincidir con la versión actual. Falla si alguna venta
+// queda sin acciones suficientes.
+func updateInvestment(tx *gorm.DB, actor string, id uint, version string, in InvestmentInput) (Investment, error) {
+	before, err := findInvestment(tx, id)
+	if err != nil {
+		return Investment{}, err
+	}
+	if version != "" && !ifMatches(version, before.UpdatedAt) {
+		return Investment{}, preconditionFailedError(newAPIInvestment(before), before.UpdatedAt)
+	}
+	inv, err := in.investment(tx)
+	if err != nil {
+		return Investment{}, err
+	}
+	var after Investment
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		err := auditedUpdate(tx, actor, AuditInvestment, id, before, &after, map[string]interface{}{
+			"ticker_id":      inv.TickerID,
+			"purchase_date":  inv.PurchaseDate,
+			"shares":         inv.Shares,
+			"purchase_price": inv.PurchasePrice,
+			"operation_cost": inv.OperationCost,
+		})
+		if err != nil {
+			return staleAPIError(err, func() (interface{}, time.Time, error) {
+				current, err := findInvestment(tx, id)
+				return newAPIInvestment(current), current.UpdatedAt, err
+			})
+		}
+		return validatePositions(tx, before.TickerID, inv.TickerID)
+	})
+	after.Ticker = inv.Ticker
+	return after, err
+}
+
+// deleteInvestment envía una compra a la papelera si ninguna venta se queda
+// sin acciones suficientes.
+func deleteInvestment(tx *gorm.DB, actor string, id uint) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var inv Investment
+		if err := auditedDelete(tx, actor, AuditInvestment, id, &inv); err != nil {
+			return notFoundError("Compra no encontrada")
+		}
+		return validatePositions(tx, inv.TickerID)
+	})
+}
+
+// createSale registra una venta y la audita. Falla si la posición no tiene
+// acciones suficientes en la fecha de la venta.
+func createSale(tx *gorm.DB, actor string, in SaleInput) (Sale, error) {
+	var s Sale
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if s, err = in.sale(tx); err != nil {
+			return err
+		}
+		if err := tx.Omit("Ticker").Create(&s).Error; err != nil {
+			return err
+		}
+		if err := validatePositions(tx, s.TickerID); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, AuditSale, s.ID, AuditCreate, nil, s)
+	})
+	return s, err
+}
+
+// updateSale reemplaza los datos de una venta y la audita. Si version no está
+// vacía debe coincidir con la versión actual. Falla si la posición no tiene
+// acciones suficientes.
+func updateSale(tx *gorm.DB, actor string, id uint, version string, in SaleInput) (Sale, error) {
+	before, err := findSale(tx, id)
+	if err != nil {
+		return Sale{}, err
+	}
+	if version != "" && !ifMatches(version, before.UpdatedAt) {
+		current, err := newAPISales(tx, []Sale{before})
+		if err != nil {
+			return Sale{}, err
+		}
+		return Sale{}, preconditionFailedError(current[0], before.UpdatedAt)
+	}
+	s, err := in.sale(tx)
+	if err != nil {
+		return Sale{}, err
+	}
+	var after Sale
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		err := auditedUpdate(tx, actor, AuditSale, id, before, &after, map[string]interface{}{
+			"ticker_id":      s.TickerID,
+			"sale_date":      s.SaleDate,
+			"shares":         s.Shares,
+			"sale_price":     s.SalePrice,
+			"operation_cost": s.OperationCost,
+			"withheld_tax":   s.WithheldTax,
+		})
+		if err != nil {
+			return staleAPIError(err, func() (interface{}, time.Time, error) {
+				current, err := findSale(tx, id)
+				if err != nil {
+					return nil, time.Time{}, err
+				}
+				views, err := newAPISales(tx, []Sale{current})
+				if err != nil {
+					return nil, time.Time{}, err
+				}
+				return views[0], current.UpdatedAt, nil
+			})
+		}
+		return validatePositions(tx, before.TickerID, s.TickerID)
+	})
+	after.Ticker = s.Ticker
+	return after, err
+}
+
+// deleteSale envía una venta a la papelera.
+func deleteSale(tx *gorm.DB, actor string, id uint) error {
+	if err := auditedDelete(tx, actor, AuditSale, id, &Sale{}); err != nil {
+		return notFoundError("Venta no encontrada")
+	}
+	return nil
+}
+
+// findInvestment obtiene una compra con su ticker o un error de recurso inexistente.
+func findInvestment(tx *gorm.DB, id uint) (Investment, error) {
+	var inv Investment
+	if err := tx.Preload("Ticker").First(&inv, id).Error; err != nil {
+		return Investment{}, notFoundError("Compra no encontrada")
+	}
+	return inv, nil
+}
+
+// findSale obtiene una venta con su ticker o un error de recurso inexistente.
+func findSale(tx *gorm.DB, id uint) (Sale, error) {
+	var s Sale
+	if err := tx.Preload("Ticker").First(&s, id).Error; err != nil {
+		return Sale{}, notFoundError("Venta no encontrada")
+	}
+	return s, nil
+}
+
+// deleteSnapshot envía todos los precios de un snapshot a la papelera.
+func deleteSnapshot(snapshotID string) error {
+	result := db.Where("snapshot_id = ?", snapshotID).Delete(&PriceHistory{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return notFoundError("Snapshot no encontrado")
+	}
+	return nil
+}
+
+// --- VISTAS JSON ---
+
+// APITicker representa un ticker en la API v1.
+type APITicker struct {
+	ID                 uint            `json:"id"`
+	Name               string          `json:"name"`
+	CurrentPrice       decimal.Decimal `json:"current_price"`
+	ISIN               string          `json:"isin"`
+	ExchangeMIC        string          `json:"exchange_mic"`
+	Currency           string          `json:"currency"`
+	Sector             string          `json:"sector"`
+	Industry           string          `json:"industry"`
+	Country            string          `json:"country"`
+	AssetClass         string          `json:"asset_class"`
+	YahooFinanceTicker string          `json:"yahoo_finance_ticker"`
+	CreatedAt          time.Time       `json:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at"`
+}
+
+// APIInvestment representa una compra valorada al precio actual.
+type APIInvestment struct {
+	ID              uint            `json:"id"`
+	TickerID        uint            `json:"ticker_id"`
+	Ticker          string          `json:"ticker"`
+	PurchaseDate    time.Time       `json:"purchase_date"`
+	Shares          decimal.Decimal `json:"shares"`
+	PurchasePrice   decimal.Decimal `json:"purchase_price"`
+	OperationCost   decimal.Decimal `json:"operation_cost"`
+	InvestedCapital decimal.Decimal `json:"invested_capital"`
+	CurrentPrice    decimal.Decimal `json:"current_price"`
+	CurrentValue    decimal.Decimal `json:"current_value"`
+	ProfitLoss      decimal.Decimal `json:"profit_loss"`
+	Performance     float64         `json:"performance"`
+}
+
+// APISale representa una venta con la utilidad calculada con el WAC del momento.
+type APISale struct {
+	ID              uint            `json:"id"`
+	TickerID        uint            `json:"ticker_id"`
+	Ticker          string          `json:"ticker"`
+	SaleDate        time.Time       `json:"sale_date"`
+	Shares          decimal.Decimal `json:"shares"`
+	SalePrice       decimal.Decimal `json:"sale_price"`
+	OperationCost   decimal.Decimal `json:"operation_cost"`
+	WithheldTax     decimal.Decimal `json:"withheld_tax"`
+	TotalSaleValue  decimal.Decimal `json:"total_sale_value"`
+	WACAtSale       decimal.Decimal `json:"wac_at_sale"`
+	Profit          decimal.Decimal `json:"profit"`
+	SalePerformance float64         `json:"sale_performance"`
+}
+
+// APIPortfolioSummary son los totales del dashboard.
+type APIPortfolioSummary struct {
+	TotalCapital         decimal.Decimal `json:"total_capital"`
+	NetProfitLoss        decimal.Decimal `json:"net_profit_loss"`
+	TotalOperationCost   decimal.Decimal `json:"total_operation_cost"`
+	TotalSaleUtility     decimal.Decimal `json:"total_sale_utility"`
+	PortfolioPerformance float64         `json:"portfolio_performance"`
+	PortfolioUtility     decimal.Decimal `json:"portfolio_utility"`
+	NumPositions         int             `json:"num_positions"`
+	ExitValue            decimal.Decimal `json:"exit_value"`
+}
+
+func newAPITicker(t Ticker) APITicker {
+	return APITicker{
+		ID:                 t.ID,
+		Name:               t.Name,
+		CurrentPrice:       t.CurrentPrice,
+		ISIN:               t.ISIN,
+		ExchangeMIC:        t.ExchangeMIC,
+		Currency:           t.Currency,
+		Sector:             t.Sector,
+		Industry:           t.Industry,
+		Country:            t.Country,
+		AssetClass:         t.AssetClass,
+		YahooFinanceTicker: t.YahooFinanceTicker,
+		CreatedAt:          t.CreatedAt,
+		UpdatedAt:          t.UpdatedAt,
+	}
+}
+
+// newAPIInvestment valora una compra con el precio actual de su ticker, que debe estar precargado.
+func newAPIInvestment(inv Investment) APIInvestment {
+	view := newInvestmentView(inv, inv.Ticker.Name, inv.Ticker.CurrentPrice, inv.Ticker.Currency)
+	return APIInvestment{
+		ID:              view.ID,
+		TickerID:        view.TickerID,
+		Ticker:          view.Ticker,
+		PurchaseDate:    inv.PurchaseDate,
+		Shares:          view.Shares,
+		PurchasePrice:   view.PurchasePrice,
+		OperationCost:   view.OperationCost,
+		InvestedCapital: view.InvestedCapital,
+		CurrentPrice:    view.CurrentPrice,
+		CurrentValue:    view.CurrentValue,
+		ProfitLoss:      view.ProfitLoss,
+		Performance:     view.Performance,
+	}
+}
+
+// newAPISale calcula la vista de una venta con el WAC en el momento de vender.
+func newAPISale(s Sale, wacAtSale decimal.Decimal) APISale {
+	view := newSaleView(s, s.Ticker.Name, wacAtSale, s.Ticker.Currency)
+	return APISale{
+		ID:              view.ID,
+		TickerID:        view.TickerID,
+		Ticker:          view.Ticker,
+		SaleDate:        s.SaleDate,
+		Shares:          view.Shares,
+		SalePrice:       view.SalePrice,
+		OperationCost:   view.OperationCost,
+		WithheldTax:     view.WithheldTax,
+		TotalSaleValue:  view.TotalSaleValue,
+		WACAtSale:       view.WACAtSale,
+		Profit:          view.Profit,
+		SalePerformance: view.SalePerformance,
+	}
+}
+
+// saleWACs reproduce las posiciones de los tickers indicados y devuelve el
+// WAC en el momento de cada venta, indexado por ID de venta.
+func saleWACs(tx *gorm.DB, tickerIDs ...uint) (map[uint]decimal.Decimal, error) {
+	var investments []Investment
+	if err := tx.Where("ticker_id IN ?", tickerIDs).Find(&investments).Error; err != nil {
+		return nil, err
+	}
+	var sales []Sale
+	if err := tx.Where("ticker_id IN ?", tickerIDs).Find(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	tickerEvents := make(map[uint][]positionEvent)
+	for _, inv := range investments {
+		tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], investmentEvent(inv))
+	}
+	for _, s := range sales {
+		tickerEvents[s.TickerID] = append(tickerEvents[s.TickerID], saleEvent(s))
+	}
+
+	wacs := make(map[uint]decimal.Decimal)
+	for _, events := range tickerEvents {
+		_, tickerWACs := replayEventsWithSales(events)
+		for saleID, wac := range tickerWACs {
+			wacs[saleID] = wac
+		}
+	}
+	return wacs, nil
+}
+
+// newAPISales convierte una lista de ventas (con el ticker precargado) en su vista JSON.
+func newAPISales(tx *gorm.DB, sales []Sale) ([]APISale, error) {
+	tickerIDs := make([]uint, 0, len(sales))
+	for _, s := range sales {
+		tickerIDs = append(tickerIDs, s.TickerID)
+	}
+	wacs, err := saleWACs(tx, tickerIDs...)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]APISale, 0, len(sales))
+	for _, s := range sales {
+		views = append(views, newAPISale(s, wacs[s.ID]))
+	}
+	return views, nil
+}
+
+// --- RUTAS ---
+
+// registerAPIV1Routes registra la API REST versionada en /api/v1.
+func registerAPIV1Routes(router *gin.Engine) {
+	v1 := router.Group("/api/v1")
+
+	// Tickers
+	tickers := v1.Group("/tickers")
+	{
+		tickers.GET("", func(c *gin.Context) {
+			var list []Ticker
+			if err := db.Order("name").Find(&list).Error; err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			views := make([]APITicker, 0, len(list))
+			for _, t := range list {
+				views = append(views, newAPITicker(t))
+			}
+			respondAPI(c, http.StatusOK, views, "")
+		})
+
+		tickers.POST("", func(c *gin.Context) {
+			var input TickerInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			ticker, err := createTicker(db, auditActor(c), input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Nuevo ticker creado via API: %s", ticker.Name)
+			c.Header("Location", fmt.Sprintf("/api/v1/tickers/%d", ticker.ID))
+			setETag(c, ticker.UpdatedAt)
+			respondAPI(c, http.StatusCreated, newAPITicker(ticker), "Ticker creado")
+		})
+
+		tickers.GET("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			var ticker Ticker
+			if err := db.First(&ticker, id).Error; err != nil {
+				respondAPIError(c, notFoundError("Ticker no encontrado"))
+				return
+			}
+			setETag(c, ticker.UpdatedAt)
+			respondAPI(c, http.StatusOK, newAPITicker(ticker), "")
+		})
+
+		tickers.PUT("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			version, ok := apiIfMatch(c)
+			if !ok {
+				return
+			}
+			var input TickerInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			ticker, err := updateTicker(db, auditActor(c), id, version, input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			setETag(c, ticker.UpdatedAt)
+			log.Printf("Ticker %d actualizado via API", id)
+			goBackground(func() { evaluateAlerts("manual", id) })
+			goBackground(func() { publishPriceChanges("manual", id) })
+			respondAPI(c, http.StatusOK, newAPITicker(ticker), "Ticker actualizado")
+		})
+
+		tickers.DELETE("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			if err := deleteTicker(db, auditActor(c), id); err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Ticker %d eliminado via API", id)
+			respondAPI(c, http.StatusOK, nil, "Ticker eliminado")
+		})
+	}
+
+	// Compras
+	investments := v1.Group("/investments")
+	{
+		investments.GET("", func(c *gin.Context) {
+			params, err := parseTradeListParams(c)
+			if err != nil {
+				respondAPIError(c, tradeListQueryError(err))
+				return
+			}
+			list, page, err := listInvestments(params)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			views := make([]APIInvestment, 0, len(list))
+			for _, inv := range list {
+				views = append(views, newAPIInvestment(inv))
+			}
+			respondAPIPage(c, views, page)
+		})
+
+		investments.POST("", idempotent(), func(c *gin.Context) {
+			var input InvestmentInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			inv, err := createInvestment(db, auditActor(c), input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Nueva compra registrada via API para ticker ID %d", inv.TickerID)
+			c.Header("Location", fmt.Sprintf("/api/v1/investments/%d", inv.ID))
+			setETag(c, inv.UpdatedAt)
+			respondAPI(c, http.StatusCreated, newAPIInvestment(inv), "Compra registrada")
+		})
+
+		investments.GET("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			var inv Investment
+			if err := db.Preload("Ticker").First(&inv, id).Error; err != nil {
+				respondAPIError(c, notFoundError("Compra no encontrada"))
+				return
+			}
+			setETag(c, inv.UpdatedAt)
+			respondAPI(c, http.StatusOK, newAPIInvestment(inv), "")
+		})
+
+		investments.PUT("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			version, ok := apiIfMatch(c)
+			if !ok {
+				return
+			}
+			var input InvestmentInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			inv, err := updateInvestment(db, auditActor(c), id, version, input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			setETag(c, inv.UpdatedAt)
+			log.Printf("Registro de compra con ID %d actualizado via API", id)
+			respondAPI(c, http.StatusOK, newAPIInvestment(inv), "Compra actualizada")
+		})
+
+		investments.DELETE("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			if err := deleteInvestment(db, auditActor(c), id); err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Registro de compra con ID %d eliminado via API", id)
+			respondAPI(c, http.StatusOK, nil, "Compra eliminada")
+		})
+	}
+
+	// Ventas
+	sales := v1.Group("/sales")
+	{
+		sales.GET("", func(c *gin.Context) {
+			params, err := parseTradeListParams(c)
+			if err != nil {
+				respondAPIError(c, tradeListQueryError(err))
+				return
+			}
+			list, page, err := listSales(params)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			views, err := newAPISales(db, list)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			respondAPIPage(c, views, page)
+		})
+
+		sales.POST("", idempotent(), func(c *gin.Context) {
+			var input SaleInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			s, err := createSale(db, auditActor(c), input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Nueva venta registrada via API para ticker ID %d", s.TickerID)
+			views, err := newAPISales(db, []Sale{s})
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			c.Header("Location", fmt.Sprintf("/api/v1/sales/%d", s.ID))
+			setETag(c, s.UpdatedAt)
+			respondAPI(c, http.StatusCreated, views[0], "Venta registrada")
+		})
+
+		sales.GET("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			var s Sale
+			if err := db.Preload("Ticker").First(&s, id).Error; err != nil {
+				respondAPIError(c, notFoundError("Venta no encontrada"))
+				return
+			}
+			views, err := newAPISales(db, []Sale{s})
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			setETag(c, s.UpdatedAt)
+			respondAPI(c, http.StatusOK, views[0], "")
+		})
+
+		sales.PUT("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			version, ok := apiIfMatch(c)
+			if !ok {
+				return
+			}
+			var input SaleInput
+			if !bindAPIJSON(c, &input) {
+				return
+			}
+			s, err := updateSale(db, auditActor(c), id, version, input)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			setETag(c, s.UpdatedAt)
+			log.Printf("Registro de venta con ID %d actualizado via API", id)
+			views, err := newAPISales(db, []Sale{s})
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			respondAPI(c, http.StatusOK, views[0], "Venta actualizada")
+		})
+
+		sales.DELETE("/:id", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			if err := deleteSale(db, auditActor(c), id); err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Registro de venta con ID %d eliminado via API", id)
+			respondAPI(c, http.StatusOK, nil, "Venta eliminada")
+		})
+
+		sales.GET("/:id/calculation", func(c *gin.Context) {
+			id, ok := apiID(c)
+			if !ok {
+				return
+			}
+			calculation, err := getSaleCalculation(id)
+			if err != nil {
+				respondAPIError(c, notFoundError("Venta no encontrada"))
+				return
+			}
+			respondAPI(c, http.StatusOK, calculation, "")
+		})
+	}
+
+	// Snapshots
+	snapshots := v1.Group("/snapshots")
+	{
+		snapshots.GET("", func(c *gin.Context) {
+			list, err := listSnapshots()
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			respondAPI(c, http.StatusOK, list, "")
+		})
+
+		snapshots.POST("", func(c *gin.Context) {
+			snapshotID, priceHistories, err := createPriceSnapshot()
+			if errors.Is(err, errNoTickers) {
+				respondAPIError(c, conflictError("No hay tickers para crear un snapshot"))
+				return
+			}
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			goBackground(func() { notifyPriceSnapshot(snapshotID, priceHistories) })
+			detail, err := getSnapshotDetail(snapshotID)
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			c.Header("Location", "/api/v1/snapshots/"+snapshotID)
+			respondAPI(c, http.StatusCreated, detail, "Snapshot creado")
+		})
+
+		snapshots.GET("/:id", func(c *gin.Context) {
+			detail, err := getSnapshotDetail(c.Param("id"))
+			if err != nil {
+				respondAPIError(c, notFoundError("Snapshot no encontrado"))
+				return
+			}
+			respondAPI(c, http.StatusOK, detail, "")
+		})
+
+		snapshots.DELETE("/:id", func(c *gin.Context) {
+			if err := deleteSnapshot(c.Param("id")); err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			log.Printf("Snapshot eliminado via API: %s", c.Param("id"))
+			respondAPI(c, http.StatusOK, nil, "Snapshot eliminado")
+		})
+	}
+
+	// Agregados del dashboard
+	analytics := v1.Group("/analytics")
+	{
+		analytics.GET("/portfolio-summary", func(c *gin.Context) {
+			_, _, sales, totalCapital, netProfitLoss, totalOperationCost, _, portfolioPerformance, portfolioUtility, numPositions, err := getInvestmentData()
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			totalSaleUtility := decimal.Zero
+			for _, s := range sales {
+				totalSaleUtility = totalSaleUtility.Add(s.SaleUtility)
+			}
+			respondAPI(c, http.StatusOK, APIPortfolioSummary{
+				TotalCapital:         totalCapital,
+				NetProfitLoss:        netProfitLoss,
+				TotalOperationCost:   totalOperationCost,
+				TotalSaleUtility:     totalSaleUtility,
+				PortfolioPerformance: portfolioPerformance,
+				PortfolioUtility:     portfolioUtility,
+				NumPositions:         numPositions,
+				ExitValue:            portfolioExitValue(totalSaleUtility, portfolioUtility, totalOperationCost, numPositions),
+			}, "")
+		})
+
+		analytics.GET("/ticker-summary", func(c *gin.Context) {
+			_, summaries, _, _, _, _, _, _, _, _, err := getInvestmentData()
+			if err != nil {
+				respondAPIError(c, err)
+				return
+			}
+			if summaries == nil {
+				summaries = []TickerSummaryView{}
+			}
+			respondAPI(c, http.StatusOK, summaries, "")
+		})
+
+		analytics.GET("/portfolio-utility-history", func(c *gin.Context) {
+			dates, utilities := getPortfolioUtilityHistory()
+			respondAPI(c, http.StatusOK, gin.H{"dates": dates, "utilities": utilities}, "")
+		})
+	}
+}
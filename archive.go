@@ -0,0 +1,807 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// archiveFormat identifica los ficheros de copia de seguridad de la aplicación.
+const archiveFormat = "bolsa_gin-archive"
+
+// archiveSchemaVersion es la versión actual del formato. Debe incrementarse
+// cada vez que cambie la estructura de PortfolioArchive. La versión 2 añade las
+// suscripciones de webhooks; las copias de la versión 1 se siguen aceptando.
+const archiveSchemaVersion = 2
+
+// Modos de restauración de una copia de seguridad
+const (
+	RestoreMerge   = "merge"   // Añade los datos a los existentes sin duplicarlos
+	RestoreReplace = "replace" // Borra los datos existentes antes de restaurar
+)
+
+// PortfolioArchive es el contenido completo de una copia de seguridad. Los IDs
+// son los de la base de datos de origen y solo sirven para relacionar registros
+// dentro del propio fichero; al restaurar se asignan IDs nuevos.
+type PortfolioArchive struct {
+	Format          string                  `json:"format"`
+	SchemaVersion   int                     `json:"schema_version"`
+	ExportedAt      time.Time               `json:"exported_at"`
+	Tickers         []ArchiveTicker         `json:"tickers"`
+	Investments     []ArchiveInvestment     `json:"investments"`
+	Sales           []ArchiveSale           `json:"sales"`
+	Dividends       []ArchiveDividend       `json:"dividends"`
+	PriceHistories  []ArchivePriceHistory   `json:"price_histories"`
+	Alerts          []ArchiveAlert          `json:"alerts"`
+	Watchlist       []ArchiveWatchlistItem  `json:"watchlist"`
+	ETFConstituents []ArchiveETFConstituent `json:"etf_constituents"`
+	ImportProfiles  []ArchiveImportProfile  `json:"import_profiles"`
+
+	WebhookSubscriptions []ArchiveWebhookSubscription `json:"webhook_subscriptions"`
+}
+
+// ArchiveTicker es un ticker dentro de la copia de seguridad.
+type ArchiveTicker struct {
+	ID                 uint            `json:"id"`
+	Name               string          `json:"name"`
+	CurrentPrice       decimal.Decimal `json:"current_price"`
+	ISIN               string          `json:"isin,omitempty"`
+	ExchangeMIC        string          `json:"exchange_mic,omitempty"`
+	Currency           string          `json:"currency,omitempty"`
+	Sector             string          `json:"sector,omitempty"`
+	Industry           string          `json:"industry,omitempty"`
+	Country            string          `json:"country,omitempty"`
+	AssetClass         string          `json:"asset_class,omitempty"`
+	YahooFinanceTicker string          `json:"yahoo_finance_ticker,omitempty"`
+}
+
+// ArchiveInvestment es una compra dentro de la copia de seguridad.
+type ArchiveInvestment struct {
+	TickerID      uint            `json:"ticker_id"`
+	PurchaseDate  time.Time       `json:"purchase_date"`
+	Shares        decimal.Decimal `json:"shares"`
+	PurchasePrice decimal.Decimal `json:"purchase_price"`
+	OperationCost decimal.Decimal `json:"operation_cost"`
+	ExternalID    string          `json:"external_id,omitempty"`
+}
+
+// ArchiveSale es una venta dentro de la copia de seguridad.
+type ArchiveSale struct {
+	TickerID      uint            `json:"ticker_id"`
+	SaleDate      time.Time       `json:"sale_date"`
+	Shares        decimal.Decimal `json:"shares"`
+	SalePrice     decimal.Decimal `json:"sale_price"`
+	OperationCost decimal.Decimal `json:"operation_cost"`
+	WithheldTax   decimal.Decimal `json:"withheld_tax"`
+	ExternalID    string          `json:"external_id,omitempty"`
+}
+
+// ArchiveDividend es un dividendo dentro de la copia de seguridad.
+type ArchiveDividend struct {
+	TickerID    uint            `json:"ticker_id"`
+	PaymentDate time.Time       `json:"payment_date"`
+	Amount      decimal.Decimal `json:"amount"`
+	WithheldTax decimal.Decimal `json:"withheld_tax"`
+	Currency    string          `json:"currency,omitempty"`
+	ExternalID  string          `json:"external_id,omitempty"`
+}
+
+// ArchivePriceHistory es un precio de snapshot dentro de la copia de seguridad.
+type ArchivePriceHistory struct {
+	SnapshotID string          `json:"snapshot_id"`
+	TickerID   uint            `json:"ticker_id"`
+	Price      decimal.Decimal `json:"price"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// ArchiveAlert es una alerta de precio dentro de la copia de seguridad.
+type ArchiveAlert struct {
+	TickerID  uint            `json:"ticker_id"`
+	RuleType  string          `json:"rule_type"`
+	Threshold decimal.Decimal `json:"threshold"`
+	Channel   string          `json:"channel"`
+	Target    string          `json:"target,omitempty"`
+	Active    bool            `json:"active"`
+}
+
+// ArchiveWatchlistItem es un ticker en seguimiento dentro de la copia de seguridad.
+type ArchiveWatchlistItem struct {
+	TickerID    uint            `json:"ticker_id"`
+	TargetPrice decimal.Decimal `json:"target_price"`
+	Notes       string          `json:"notes,omitempty"`
+}
+
+// ArchiveETFConstituent es un componente de ETF dentro de la copia de seguridad.
+type ArchiveETFConstituent struct {
+	ETFTickerID uint    `json:"etf_ticker_id"`
+	Name        string  `json:"name"`
+	Weight      float64 `json:"weight"`
+	Sector      string  `json:"sector,omitempty"`
+	Industry    string  `json:"industry,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	AssetClass  string  `json:"asset_class,omitempty"`
+	ExchangeMIC string  `json:"exchange_mic,omitempty"`
+}
+
+// ArchiveImportProfile es un perfil de columnas CSV dentro de la copia de
+// seguridad.
+type ArchiveImportProfile struct {
+	Name           string `json:"name"`
+	Broker         string `json:"broker"`
+	Delimiter      string `json:"delimiter"`
+	DecimalComma   bool   `json:"decimal_comma"`
+	DateFormat     string `json:"date_format"`
+	TimeFormat     string `json:"time_format"`
+	DateColumn     string `json:"date_column"`
+	TimeColumn     string `json:"time_column"`
+	TypeColumn     string `json:"type_column"`
+	BuyValues      string `json:"buy_values"`
+	SellValues     string `json:"sell_values"`
+	SymbolColumn   string `json:"symbol_column"`
+	ISINColumn     string `json:"isin_column"`
+	NameColumn     string `json:"name_column"`
+	SharesColumn   string `json:"shares_column"`
+	PriceColumn    string `json:"price_column"`
+	FeesColumn     string `json:"fees_column"`
+	TaxColumn      string `json:"tax_column"`
+	CurrencyColumn string `json:"currency_column"`
+}
+
+// ArchiveWebhookSubscription es una suscripción de webhooks dentro de la copia
+// de seguridad. El historial de entregas no se incluye, y el secreto solo si
+// se pide expresamente al exportar.
+type ArchiveWebhookSubscription struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Events string `json:"events"`
+	Secret string `json:"secret,omitempty"`
+	Active bool   `json:"active"`
+}
+
+// RestoreResult resume los registros creados y omitidos al restaurar.
+type RestoreResult struct {
+	Created map[string]int
+	Skipped map[string]int
+}
+
+// total suma los registros de todas las tablas.
+func (r RestoreResult) total(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// buildPortfolioArchive lee toda la cartera de la base de datos en una única
+// transacción de solo lectura, para que la copia sea coherente aunque haya
+// escrituras durante la exportación. Los secretos de los webhooks solo se
+// incluyen con includeSecrets.
+func buildPortfolioArchive(includeSecrets bool) (*PortfolioArchive, error) {
+	archive := &PortfolioArchive{
+		Format:        archiveFormat,
+		SchemaVersion: archiveSchemaVersion,
+		ExportedAt:    time.Now(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return readPortfolioArchiveData(tx, archive, includeSecrets)
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// readPortfolioArchiveData rellena la copia con los datos leídos en tx.
+func readPortfolioArchiveData(tx *gorm.DB, archive *PortfolioArchive, includeSecrets bool) error {
+
+	var tickers []Ticker
+	if err := tx.Order("id").Find(&tickers).Error; err != nil {
+		return err
+	}
+	for _, t := range tickers {
+		archive.Tickers = append(archive.Tickers, ArchiveTicker{
+			ID:                 t.ID,
+			Name:               t.Name,
+			CurrentPrice:       t.CurrentPrice,
+			ISIN:               t.ISIN,
+			ExchangeMIC:        t.ExchangeMIC,
+			Currency:           t.Currency,
+			Sector:             t.Sector,
+			Industry:           t.Industry,
+			Country:            t.Country,
+			AssetClass:         t.AssetClass,
+			YahooFinanceTicker: t.YahooFinanceTicker,
+		})
+	}
+
+	var investments []Investment
+	if err := tx.Order("purchase_date, id").Find(&investments).Error; err != nil {
+		return err
+	}
+	for _, i := range investments {
+		archive.Investments = append(archive.Investments, ArchiveInvestment{
+			TickerID:      i.TickerID,
+			PurchaseDate:  i.PurchaseDate,
+			Shares:        i.Shares,
+			PurchasePrice: i.PurchasePrice,
+			OperationCost: i.OperationCost,
+			ExternalID:    i.ExternalID,
+		})
+	}
+
+	var sales []Sale
+	if err := tx.Order("sale_date, id").Find(&sales).Error; err != nil {
+		return err
+	}
+	for _, s := range sales {
+		archive.Sales = append(archive.Sales, ArchiveSale{
+			TickerID:      s.TickerID,
+			SaleDate:      s.SaleDate,
+			Shares:        s.Shares,
+			SalePrice:     s.SalePrice,
+			OperationCost: s.OperationCost,
+			WithheldTax:   s.WithheldTax,
+			ExternalID:    s.ExternalID,
+		})
+	}
+
+	var dividends []Dividend
+	if err := tx.Order("payment_date, id").Find(&dividends).Error; err != nil {
+		return err
+	}
+	for _, d := range dividends {
+		archive.Dividends = append(archive.Dividends, ArchiveDividend{
+			TickerID:    d.TickerID,
+			PaymentDate: d.PaymentDate,
+			Amount:      d.Amount,
+			WithheldTax: d.WithheldTax,
+			Currency:    d.Currency,
+			ExternalID:  d.ExternalID,
+		})
+	}
+
+	var histories []PriceHistory
+	if err := tx.Order("created_at, id").Find(&histories).Error; err != nil {
+		return err
+	}
+	for _, ph := range histories {
+		archive.PriceHistories = append(archive.PriceHistories, ArchivePriceHistory{
+			SnapshotID: ph.SnapshotID,
+			TickerID:   ph.TickerID,
+			Price:      ph.Price,
+			CreatedAt:  ph.CreatedAt,
+		})
+	}
+
+	var alerts []Alert
+	if err := tx.Order("id").Find(&alerts).Error; err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		archive.Alerts = append(archive.Alerts, ArchiveAlert{
+			TickerID:  a.TickerID,
+			RuleType:  a.RuleType,
+			Threshold: a.Threshold,
+			Channel:   a.Channel,
+			Target:    a.Target,
+			Active:    a.Active,
+		})
+	}
+
+	var watchlist []WatchlistItem
+	if err := tx.Order("id").Find(&watchlist).Error; err != nil {
+		return err
+	}
+	for _, w := range watchlist {
+		archive.Watchlist = append(archive.Watchlist, ArchiveWatchlistItem{
+			TickerID:    w.TickerID,
+			TargetPrice: w.TargetPrice,
+			Notes:       w.Notes,
+		})
+	}
+
+	var constituents []ETFConstituent
+	if err := tx.Order("id").Find(&constituents).Error; err != nil {
+		return err
+	}
+	for _, ec := range constituents {
+		archive.ETFConstituents = append(archive.ETFConstituents, ArchiveETFConstituent{
+			ETFTickerID: ec.ETFTickerID,
+			Name:        ec.Name,
+			Weight:      ec.Weight,
+			Sector:      ec.Sector,
+			Industry:    ec.Industry,
+			Country:     ec.Country,
+			Currency:    ec.Currency,
+			AssetClass:  ec.AssetClass,
+			ExchangeMIC: ec.ExchangeMIC,
+		})
+	}
+
+	var profiles []ImportProfile
+	if err := tx.Order("id").Find(&profiles).Error; err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		archive.ImportProfiles = append(archive.ImportProfiles, ArchiveImportProfile{
+			Name:           p.Name,
+			Broker:         p.Broker,
+			Delimiter:      p.Delimiter,
+			DecimalComma:   p.DecimalComma,
+			DateFormat:     p.DateFormat,
+			TimeFormat:     p.TimeFormat,
+			DateColumn:     p.DateColumn,
+			TimeColumn:     p.TimeColumn,
+			TypeColumn:     p.TypeColumn,
+			BuyValues:      p.BuyValues,
+			SellValues:     p.SellValues,
+			SymbolColumn:   p.SymbolColumn,
+			ISINColumn:     p.ISINColumn,
+			NameColumn:     p.NameColumn,
+			SharesColumn:   p.SharesColumn,
+			PriceColumn:    p.PriceColumn,
+			FeesColumn:     p.FeesColumn,
+			TaxColumn:      p.TaxColumn,
+			CurrencyColumn: p.CurrencyColumn,
+		})
+	}
+
+	var subscriptions []WebhookSubscription
+	if err := tx.Order("id").Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	for _, ws := range subscriptions {
+		subscription := ArchiveWebhookSubscription{Name: ws.Name, URL: ws.URL, Events: ws.Events, Active: ws.Active}
+		if includeSecrets {
+			subscription.Secret = ws.Secret
+		}
+		archive.WebhookSubscriptions = append(archive.WebhookSubscriptions, subscription)
+	}
+	return nil
+}
+
+// writePortfolioArchive escribe la copia de seguridad en formato JSON.
+func writePortfolioArchive(w io.Writer, includeSecrets bool) error {
+	archive, err := buildPortfolioArchive(includeSecrets)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// readPortfolioArchive lee y valida una copia de seguridad.
+func readPortfolioArchive(r io.Reader) (*PortfolioArchive, error) {
+	var archive PortfolioArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("JSON inválido: %v", err)
+	}
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// Validate comprueba la versión del formato y que todas las referencias a
+// tickers apunten a tickers incluidos en la copia.
+func (a *PortfolioArchive) Validate() error {
+	if a.Format != archiveFormat {
+		return fmt.Errorf("el fichero no es una copia de seguridad de bolsa_gin")
+	}
+	if a.SchemaVersion < 1 || a.SchemaVersion > archiveSchemaVersion {
+		return fmt.Errorf("versión de formato %d no soportada (máxima: %d)", a.SchemaVersion, archiveSchemaVersion)
+	}
+
+	tickerIDs := make(map[uint]bool)
+	names := make(map[string]bool)
+	for _, t := range a.Tickers {
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("ticker %d sin nombre", t.ID)
+		}
+		if tickerIDs[t.ID] {
+			return fmt.Errorf("ID de ticker %d repetido", t.ID)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("ticker %s repetido", t.Name)
+		}
+		tickerIDs[t.ID] = true
+		names[t.Name] = true
+	}
+
+	check := func(kind string, i int, tickerID uint) error {
+		if !tickerIDs[tickerID] {
+			return fmt.Errorf("%s %d: ticker %d no incluido en la copia", kind, i+1, tickerID)
+		}
+		return nil
+	}
+	for i, inv := range a.Investments {
+		if err := check("compra", i, inv.TickerID); err != nil {
+			return err
+		}
+		if !inv.Shares.IsPositive() || inv.PurchasePrice.IsNegative() || inv.PurchaseDate.IsZero() {
+			return fmt.Errorf("compra %d: datos inválidos", i+1)
+		}
+	}
+	for i, s := range a.Sales {
+		if err := check("venta", i, s.TickerID); err != nil {
+			return err
+		}
+		if !s.Shares.IsPositive() || s.SalePrice.IsNegative() || s.SaleDate.IsZero() {
+			return fmt.Errorf("venta %d: datos inválidos", i+1)
+		}
+	}
+	for i, d := range a.Dividends {
+		if err := check("dividendo", i, d.TickerID); err != nil {
+			return err
+		}
+	}
+	for i, ph := range a.PriceHistories {
+		if err := check("precio histórico", i, ph.TickerID); err != nil {
+			return err
+		}
+	}
+	for i, al := range a.Alerts {
+		if err := check("alerta", i, al.TickerID); err != nil {
+			return err
+		}
+	}
+	for i, w := range a.Watchlist {
+		if err := check("seguimiento", i, w.TickerID); err != nil {
+			return err
+		}
+	}
+	for i, ec := range a.ETFConstituents {
+		if err := check("componente de ETF", i, ec.ETFTickerID); err != nil {
+			return err
+		}
+	}
+	for i, ws := range a.WebhookSubscriptions {
+		u, err := url.Parse(ws.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || ws.Events == "" {
+			return fmt.Errorf("webhook %d: datos inválidos", i+1)
+		}
+	}
+	return nil
+}
+
+// restorePortfolioArchive guarda la copia de seguridad en una única
+// transacción. En modo replace se borran antes todos los datos; en modo merge
+// los tickers se asocian por nombre, recuperando de la papelera los borrados, y
+// se omiten los registros ya existentes.
+func restorePortfolioArchive(archive *PortfolioArchive, mode, actor string) (RestoreResult, error) {
+	result := RestoreResult{Created: make(map[string]int), Skipped: make(map[string]int)}
+	if mode != RestoreMerge && mode != RestoreReplace {
+		return result, fmt.Errorf("modo de restauración %q inválido", mode)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if mode == RestoreReplace {
+			// Auditar la baja de los tickers y operaciones que se van a sustituir
+			if err := auditReplacedRecords(tx, actor); err != nil {
+				return err
+			}
+			// Borrado definitivo en orden inverso a las dependencias
+			for _, model := range []interface{}{&AlertEvent{}, &Alert{}, &WatchlistItem{}, &ETFConstituent{},
+				&PriceHistory{}, &Dividend{}, &Sale{}, &Investment{}, &Ticker{}, &ImportProfile{},
+				&WebhookDelivery{}, &WebhookSubscription{}} {
+				if err := tx.Unscoped().Where("1 = 1").Delete(model).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		// Tickers: se asocian por nombre a los existentes. El nombre es único
+		// también en la papelera, así que un ticker borrado se recupera
+		tickerIDs := make(map[uint]uint)
+		for _, at := range archive.Tickers {
+			var ticker Ticker
+			err := tx.Unscoped().Where("name = ?", at.Name).First(&ticker).Error
+			if err == nil && ticker.DeletedAt.Valid {
+				if err := restoreRecord(tx, actor, AuditTicker, ticker.ID, &Ticker{}); err != nil {
+					return fmt.Errorf("ticker %s: %v", at.Name, err)
+				}
+				tickerIDs[at.ID] = ticker.ID
+				result.Created["tickers"]++
+				continue
+			}
+			if err == nil {
+				tickerIDs[at.ID] = ticker.ID
+				result.Skipped["tickers"]++
+				continue
+			}
+			ticker = Ticker{
+				Name:               at.Name,
+				CurrentPrice:       at.CurrentPrice,
+				ISIN:               at.ISIN,
+				ExchangeMIC:        at.ExchangeMIC,
+				Currency:           at.Currency,
+				Sector:             at.Sector,
+				Industry:           at.Industry,
+				Country:            at.Country,
+				AssetClass:         at.AssetClass,
+				YahooFinanceTicker: at.YahooFinanceTicker,
+			}
+			if err := tx.Create(&ticker).Error; err != nil {
+				return fmt.Errorf("ticker %s: %v", at.Name, err)
+			}
+			if err := recordAudit(tx, actor, AuditTicker, ticker.ID, AuditCreate, nil, ticker); err != nil {
+				return err
+			}
+			tickerIDs[at.ID] = ticker.ID
+			result.Created["tickers"]++
+		}
+
+		// exists comprueba si ya hay un registro igual (solo en modo merge)
+		exists := func(model interface{}, query string, args ...interface{}) bool {
+			if mode != RestoreMerge {
+				return false
+			}
+			var count int64
+			tx.Model(model).Where(query, args...).Count(&count)
+			return count > 0
+		}
+
+		for _, ai := range archive.Investments {
+			tickerID := tickerIDs[ai.TickerID]
+			if exists(&Investment{}, "ticker_id = ? AND purchase_date = ? AND shares = ? AND purchase_price = ?", tickerID, ai.PurchaseDate, ai.Shares, ai.PurchasePrice) {
+				result.Skipped["investments"]++
+				continue
+			}
+			investment := Investment{
+				TickerID:      tickerID,
+				PurchaseDate:  ai.PurchaseDate,
+				Shares:        ai.Shares,
+				PurchasePrice: ai.PurchasePrice,
+				OperationCost: ai.OperationCost,
+				ExternalID:    ai.ExternalID,
+			}
+			if err := tx.Create(&investment).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, AuditInvestment, investment.ID, AuditCreate, nil, investment); err != nil {
+				return err
+			}
+			result.Created["investments"]++
+		}
+
+		for _, as := range archive.Sales {
+			tickerID := tickerIDs[as.TickerID]
+			if exists(&Sale{}, "ticker_id = ? AND sale_date = ? AND shares = ? AND sale_price = ?", tickerID, as.SaleDate, as.Shares, as.SalePrice) {
+				result.Skipped["sales"]++
+				continue
+			}
+			sale := Sale{
+				TickerID:      tickerID,
+				SaleDate:      as.SaleDate,
+				Shares:        as.Shares,
+				SalePrice:     as.SalePrice,
+				OperationCost: as.OperationCost,
+				WithheldTax:   as.WithheldTax,
+				ExternalID:    as.ExternalID,
+			}
+			if err := tx.Create(&sale).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, AuditSale, sale.ID, AuditCreate, nil, sale); err != nil {
+				return err
+			}
+			result.Created["sales"]++
+		}
+
+		for _, ad := range archive.Dividends {
+			tickerID := tickerIDs[ad.TickerID]
+			if exists(&Dividend{}, "ticker_id = ? AND payment_date = ? AND amount = ?", tickerID, ad.PaymentDate, ad.Amount) {
+				result.Skipped["dividends"]++
+				continue
+			}
+			dividend := Dividend{
+				TickerID:    tickerID,
+				PaymentDate: ad.PaymentDate,
+				Amount:      ad.Amount,
+				WithheldTax: ad.WithheldTax,
+				Currency:    ad.Currency,
+				ExternalID:  ad.ExternalID,
+			}
+			if err := tx.Create(&dividend).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, AuditDividend, dividend.ID, AuditCreate, nil, dividend); err != nil {
+				return err
+			}
+			result.Created["dividends"]++
+		}
+
+		for _, ap := range archive.PriceHistories {
+			tickerID := tickerIDs[ap.TickerID]
+			if exists(&PriceHistory{}, "snapshot_id = ? AND ticker_id = ?", ap.SnapshotID, tickerID) {
+				result.Skipped["price_histories"]++
+				continue
+			}
+			// Se conserva la fecha original porque agrupa y ordena los snapshots
+			history := PriceHistory{SnapshotID: ap.SnapshotID, TickerID: tickerID, Price: ap.Price}
+			history.CreatedAt = ap.CreatedAt
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+			result.Created["price_histories"]++
+		}
+
+		for _, aa := range archive.Alerts {
+			tickerID := tickerIDs[aa.TickerID]
+			if exists(&Alert{}, "ticker_id = ? AND rule_type = ? AND threshold = ? AND channel = ?", tickerID, aa.RuleType, aa.Threshold, aa.Channel) {
+				result.Skipped["alerts"]++
+				continue
+			}
+			alert := Alert{
+				TickerID:  tickerID,
+				RuleType:  aa.RuleType,
+				Threshold: aa.Threshold,
+				Channel:   aa.Channel,
+				Target:    aa.Target,
+				Active:    aa.Active,
+			}
+			if err := tx.Create(&alert).Error; err != nil {
+				return err
+			}
+			// Active tiene valor por defecto true, así que false no se inserta
+			if !aa.Active {
+				tx.Model(&alert).Update("active", false)
+			}
+			result.Created["alerts"]++
+		}
+
+		for _, aw := range archive.Watchlist {
+			tickerID := tickerIDs[aw.TickerID]
+			if exists(&WatchlistItem{}, "ticker_id = ?", tickerID) {
+				result.Skipped["watchlist"]++
+				continue
+			}
+			item := WatchlistItem{TickerID: tickerID, TargetPrice: aw.TargetPrice, Notes: aw.Notes}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+			result.Created["watchlist"]++
+		}
+
+		for _, ae := range archive.ETFConstituents {
+			tickerID := tickerIDs[ae.ETFTickerID]
+			if exists(&ETFConstituent{}, "etf_ticker_id = ? AND name = ?", tickerID, ae.Name) {
+				result.Skipped["etf_constituents"]++
+				continue
+			}
+			constituent := ETFConstituent{
+				ETFTickerID: tickerID,
+				Name:        ae.Name,
+				Weight:      ae.Weight,
+				Sector:      ae.Sector,
+				Industry:    ae.Industry,
+				Country:     ae.Country,
+				Currency:    ae.Currency,
+				AssetClass:  ae.AssetClass,
+				ExchangeMIC: ae.ExchangeMIC,
+			}
+			if err := tx.Create(&constituent).Error; err != nil {
+				return err
+			}
+			result.Created["etf_constituents"]++
+		}
+
+		for _, ap := range archive.ImportProfiles {
+			if exists(&ImportProfile{}, "name = ?", ap.Name) {
+				result.Skipped["import_profiles"]++
+				continue
+			}
+			profile := ImportProfile{
+				Name:           ap.Name,
+				Broker:         ap.Broker,
+				Delimiter:      ap.Delimiter,
+				DecimalComma:   ap.DecimalComma,
+				DateFormat:     ap.DateFormat,
+				TimeFormat:     ap.TimeFormat,
+				DateColumn:     ap.DateColumn,
+				TimeColumn:     ap.TimeColumn,
+				TypeColumn:     ap.TypeColumn,
+				BuyValues:      ap.BuyValues,
+				SellValues:     ap.SellValues,
+				SymbolColumn:   ap.SymbolColumn,
+				ISINColumn:     ap.ISINColumn,
+				NameColumn:     ap.NameColumn,
+				SharesColumn:   ap.SharesColumn,
+				PriceColumn:    ap.PriceColumn,
+				FeesColumn:     ap.FeesColumn,
+				TaxColumn:      ap.TaxColumn,
+				CurrencyColumn: ap.CurrencyColumn,
+			}
+			if err := tx.Create(&profile).Error; err != nil {
+				return fmt.Errorf("perfil %s: %v", ap.Name, err)
+			}
+			result.Created["import_profiles"]++
+		}
+
+		for _, aw := range archive.WebhookSubscriptions {
+			if exists(&WebhookSubscription{}, "url = ? AND events = ?", aw.URL, aw.Events) {
+				result.Skipped["webhook_subscriptions"]++
+				continue
+			}
+			// Sin secreto en la copia se genera uno nuevo, que habrá que configurar en el destino
+			secret := aw.Secret
+			if secret == "" {
+				secret = newWebhookToken("whsec_")
+			}
+			subscription := WebhookSubscription{Name: aw.Name, URL: aw.URL, Events: aw.Events, Secret: secret, Active: aw.Active}
+			if err := tx.Create(&subscription).Error; err != nil {
+				return fmt.Errorf("webhook %s: %v", aw.URL, err)
+			}
+			// Active tiene valor por defecto true, así que false no se inserta
+			if !aw.Active {
+				tx.Model(&subscription).Update("active", false)
+			}
+			result.Created["webhook_subscriptions"]++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	log.Printf("Copia de seguridad restaurada (%s): creados %v, omitidos %v", mode, result.Created, result.Skipped)
+	return result, nil
+}
+
+// registerArchiveRoutes registra las rutas de copia de seguridad y restauración.
+func registerArchiveRoutes(router *gin.Engine) {
+	// Ruta para descargar la copia de seguridad completa (?secrets=1 incluye los secretos de los webhooks)
+	router.GET("/export/archive", func(c *gin.Context) {
+		includeSecrets := c.Query("secrets") == "1"
+		filename := fmt.Sprintf("bolsa_gin-%s.json", time.Now().Format("20060102-1504"))
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if err := writePortfolioArchive(c.Writer, includeSecrets); err != nil {
+			log.Printf("Error al exportar la copia de seguridad: %v", err)
+			c.String(http.StatusInternalServerError, "Error al exportar: %v", err)
+		}
+	})
+
+	// Ruta para restaurar una copia de seguridad
+	router.POST("/restore/archive", func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Debe seleccionar un fichero.")
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.String(http.StatusBadRequest, "No se pudo leer el fichero.")
+			return
+		}
+		defer f.Close()
+
+		archive, err := readPortfolioArchive(f)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Copia de seguridad inválida: %v", err)
+			return
+		}
+
+		result, err := restorePortfolioArchive(archive, c.DefaultPostForm("mode", RestoreMerge), auditActor(c))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al restaurar: %v", err)
+			return
+		}
+
+		c.Redirect(http.StatusFound, fmt.Sprintf("/importar?restored=%d&restore_skipped=%d", result.total(result.Created), result.total(result.Skipped)))
+	})
+}
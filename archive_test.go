@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRestoreMergeRecoversDeletedTicker(t *testing.T) {
+	useTestDatabase(t)
+	ticker := Ticker{Name: "GONE", CurrentPrice: decimal.NewFromInt(10)}
+	db.Create(&ticker)
+	db.Delete(&ticker)
+
+	archive := &PortfolioArchive{
+		Format:        archiveFormat,
+		SchemaVersion: archiveSchemaVersion,
+		Tickers:       []ArchiveTicker{{ID: 99, Name: "GONE", CurrentPrice: decimal.NewFromInt(12)}},
+		Investments: []ArchiveInvestment{{TickerID: 99, PurchaseDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Shares: decimal.NewFromInt(5), PurchasePrice: decimal.NewFromInt(11)}},
+	}
+	result, err := restorePortfolioArchive(archive, RestoreMerge, "test")
+	if err != nil {
+		t.Fatalf("restorePortfolioArchive: %v", err)
+	}
+	if result.Created["tickers"] != 1 || result.Created["investments"] != 1 {
+		t.Errorf("creados = %v", result.Created)
+	}
+
+	var restored Ticker
+	if err := db.Where("name = ?", "GONE").First(&restored).Error; err != nil {
+		t.Fatalf("el ticker sigue en la papelera: %v", err)
+	}
+	if restored.ID != ticker.ID {
+		t.Errorf("se creó un ticker nuevo (%d) en lugar de recuperar el %d", restored.ID, ticker.ID)
+	}
+	var inv Investment
+	if err := db.Where("ticker_id = ?", ticker.ID).First(&inv).Error; err != nil {
+		t.Errorf("la compra no quedó asociada al ticker recuperado: %v", err)
+	}
+	var audits int64
+	db.Model(&AuditLog{}).Where("entity_type = ? AND entity_id = ? AND action = ?", AuditTicker, ticker.ID, AuditRestore).Count(&audits)
+	if audits != 1 {
+		t.Errorf("entradas de auditoría de recuperación = %d, se esperaba 1", audits)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	useTestDatabase(t)
+	var buf bytes.Buffer
+	if err := writePortfolioArchive(&buf, false); err != nil {
+		t.Fatalf("writePortfolioArchive: %v", err)
+	}
+	archive, err := readPortfolioArchive(&buf)
+	if err != nil {
+		t.Fatalf("readPortfolioArchive: %v", err)
+	}
+	if len(archive.Tickers) == 0 || len(archive.Investments) == 0 {
+		t.Fatalf("la copia no contiene los datos de ejemplo: %d tickers, %d compras", len(archive.Tickers), len(archive.Investments))
+	}
+
+	// Restaurar sobre los mismos datos no duplica nada
+	result, err := restorePortfolioArchive(archive, RestoreMerge, "test")
+	if err != nil {
+		t.Fatalf("restorePortfolioArchive: %v", err)
+	}
+	if n := result.total(result.Created); n != 0 {
+		t.Errorf("registros creados = %v, se esperaba ninguno", result.Created)
+	}
+}
+
+func TestArchiveWebhookSubscriptions(t *testing.T) {
+	useTestDatabase(t)
+	subscription := WebhookSubscription{Name: "n8n", URL: "https://hooks.example.com/bolsa", Events: WebhookSaleCreated, Secret: "whsec_archivo", Active: true}
+	db.Create(&subscription)
+	db.Model(&subscription).Update("active", false)
+	db.Create(&WebhookDelivery{SubscriptionID: subscription.ID, Event: WebhookSaleCreated, Status: WebhookFailed})
+
+	archive, err := buildPortfolioArchive(true)
+	if err != nil {
+		t.Fatalf("buildPortfolioArchive: %v", err)
+	}
+	if archive.SchemaVersion != 2 || len(archive.WebhookSubscriptions) != 1 {
+		t.Fatalf("versión %d con %d webhooks, se esperaba la 2 con 1", archive.SchemaVersion, len(archive.WebhookSubscriptions))
+	}
+
+	result, err := restorePortfolioArchive(archive, RestoreReplace, "test")
+	if err != nil {
+		t.Fatalf("restorePortfolioArchive: %v", err)
+	}
+	if result.Created["webhook_subscriptions"] != 1 {
+		t.Errorf("creados = %v", result.Created)
+	}
+	var restored []WebhookSubscription
+	db.Find(&restored)
+	if len(restored) != 1 || restored[0].URL != subscription.URL || restored[0].Secret != subscription.Secret || restored[0].Active {
+		t.Errorf("suscripciones restauradas = %+v", restored)
+	}
+	var deliveries int64
+	db.Model(&WebhookDelivery{}).Count(&deliveries)
+	if deliveries != 0 {
+		t.Errorf("entregas tras reemplazar = %d, se esperaba ninguna", deliveries)
+	}
+
+	// En modo merge no se duplica la suscripción
+	result, err = restorePortfolioArchive(archive, RestoreMerge, "test")
+	if err != nil {
+		t.Fatalf("restorePortfolioArchive: %v", err)
+	}
+	if result.Skipped["webhook_subscriptions"] != 1 || result.Created["webhook_subscriptions"] != 0 {
+		t.Errorf("merge: creados %v, omitidos %v", result.Created, result.Skipped)
+	}
+}
+
+func TestArchiveAcceptsSchemaVersion1(t *testing.T) {
+	archive := &PortfolioArchive{Format: archiveFormat, SchemaVersion: 1}
+	if err := archive.Validate(); err != nil {
+		t.Errorf("una copia de la versión 1 debe aceptarse: %v", err)
+	}
+	archive.SchemaVersion = archiveSchemaVersion + 1
+	if err := archive.Validate(); err == nil {
+		t.Error("se esperaba un error con una versión posterior")
+	}
+	archive = &PortfolioArchive{Format: archiveFormat, SchemaVersion: archiveSchemaVersion,
+		WebhookSubscriptions: []ArchiveWebhookSubscription{{URL: "ftp://example.com", Events: "*"}}}
+	if err := archive.Validate(); err == nil {
+		t.Error("se esperaba un error con una URL de webhook inválida")
+	}
+}
+
+func TestArchiveOmitsWebhookSecretsByDefault(t *testing.T) {
+	useTestDatabase(t)
+	db.Create(&WebhookSubscription{Name: "n8n", URL: "https://hooks.example.com/bolsa", Events: "*", Secret: "whsec_privado", Active: true})
+
+	var buf bytes.Buffer
+	if err := writePortfolioArchive(&buf, false); err != nil {
+		t.Fatalf("writePortfolioArchive: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("whsec_privado")) {
+		t.Fatal("la copia incluye el secreto del webhook sin pedirlo")
+	}
+	archive, err := readPortfolioArchive(&buf)
+	if err != nil {
+		t.Fatalf("readPortfolioArchive: %v", err)
+	}
+	if len(archive.ImportProfiles) == 0 || archive.ImportProfiles[0].Name == "" {
+		t.Errorf("perfiles de importación = %+v", archive.ImportProfiles)
+	}
+
+	// Al restaurar sin secreto se genera uno nuevo
+	if _, err := restorePortfolioArchive(archive, RestoreReplace, "test"); err != nil {
+		t.Fatalf("restorePortfolioArchive: %v", err)
+	}
+	var restored WebhookSubscription
+	db.First(&restored)
+	if restored.Secret == "" || restored.Secret == "whsec_privado" {
+		t.Errorf("secreto restaurado = %q, se esperaba uno nuevo", restored.Secret)
+	}
+	var profiles int64
+	db.Model(&ImportProfile{}).Count(&profiles)
+	if int(profiles) != len(archive.ImportProfiles) {
+		t.Errorf("perfiles restaurados = %d, se esperaban %d", profiles, len(archive.ImportProfiles))
+	}
+}
+
+func TestArchiveExportReturnsQueryErrors(t *testing.T) {
+	useTestDatabase(t)
+	if err := db.Migrator().DropTable(&WatchlistItem{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildPortfolioArchive(false); err == nil {
+		t.Error("se esperaba un error al fallar una de las consultas de la copia")
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Entidades auditadas
+const (
+	AuditTicker     = "ticker"
+	AuditInvestment = "investment"
+	AuditSale       = "sale"
+	AuditDividend   = "dividend" // Solo altas, desde la importación y la restauración
+)
+
+// Acciones auditadas
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore" // Recuperado de la papelera
+	AuditPurge   = "purge"   // Eliminado definitivamente desde la papelera
+)
+
+// auditPageSize es el número máximo de registros que muestra /auditoria.
+const auditPageSize = 200
+
+// auditEntityLabels traduce las entidades auditadas para la UI.
+var auditEntityLabels = map[string]string{
+	AuditTicker:     "Ticker",
+	AuditInvestment: "Compra",
+	AuditSale:       "Venta",
+	AuditDividend:   "Dividendo",
+}
+
+// auditActionLabels traduce las acciones auditadas para la UI.
+var auditActionLabels = map[string]string{
+	AuditCreate:  "Alta",
+	AuditUpdate:  "Modificación",
+	AuditDelete:  "Baja",
+	AuditRestore: "Restauración",
+	AuditPurge:   "Eliminación definitiva",
+}
+
+// auditIgnoredFields no se muestran en las diferencias porque cambian en cada escritura.
+var auditIgnoredFields = map[string]bool{"UpdatedAt": true}
+
+// errAuditAppendOnly se devuelve al intentar modificar o borrar el registro de auditoría.
+var errAuditAppendOnly = errors.New("el registro de auditoría no se puede modificar")
+
+// AuditLog es una entrada del registro de auditoría. Solo se insertan filas:
+// los hooks BeforeUpdate y BeforeDelete impiden modificarlas desde la aplicación.
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey"`
+	CreatedAt  time.Time `gorm:"index"`
+	EntityType string    `gorm:"index:idx_audit_logs_entity"`
+	EntityID   uint      `gorm:"index:idx_audit_logs_entity"`
+	Action     string
+	Before     string // Estado anterior en JSON (vacío en las altas)
+	After      string // Estado posterior en JSON (vacío en las bajas)
+	Actor      string
+}
+
+// BeforeUpdate impide modificar entradas de auditoría.
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return errAuditAppendOnly }
+
+// BeforeDelete impide borrar entradas de auditoría.
+func (AuditLog) BeforeDelete(*gorm.DB) error { return errAuditAppendOnly }
+
+// AuditChange es un campo que cambió entre el estado anterior y el posterior.
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditView representa una entrada de auditoría para mostrar en la UI.
+type AuditView struct {
+	ID          uint          `json:"id"`
+	CreatedAt   string        `json:"created_at"`
+	EntityType  string        `json:"entity_type"`
+	EntityLabel string        `json:"entity_label"`
+	EntityID    uint          `json:"entity_id"`
+	Action      string        `json:"action"`
+	ActionLabel string        `json:"action_label"`
+	Actor       string        `json:"actor"`
+	Changes     []AuditChange `json:"changes"`
+}
+
+// AuditFilter son los filtros de la página de auditoría.
+type AuditFilter struct {
+	Entity   string
+	EntityID uint
+	Action   string
+	Actor    string
+	From     string // AAAA-MM-DD
+	To       string // AAAA-MM-DD, inclusive
+}
+
+// auditActor identifica a quien hace la petición: el usuario que envía el
+// proxy de autenticación, el de la autenticación básica o, si no hay, la IP.
+func auditActor(c *gin.Context) string {
+	for _, header := range []string{"X-Forwarded-User", "X-Remote-User"} {
+		if user := strings.TrimSpace(c.GetHeader(header)); user != "" {
+			return user
+		}
+	}
+	if user, _, ok := c.Request.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return "web " + c.ClientIP()
+}
+
+// auditState convierte un registro en JSON sin sus asociaciones (p. ej. el
+// Ticker precargado de una compra), que se auditan por separado.
+func auditState(record interface{}) (string, error) {
+	if record == nil {
+		return "", nil
+	}
+	fields, err := auditFields(record)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// auditFields devuelve los campos de primer nivel de un registro, conservando
+// los números tal cual para no perder decimales.
+func auditFields(record interface{}) (map[string]interface{}, error) {
+	var data []byte
+	switch v := record.(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(record); err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if _, nested := value.(map[string]interface{}); nested {
+			delete(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// recordAudit inserta una entrada de auditoría. Debe llamarse dentro de la
+// misma transacción que el cambio para que no quede uno sin el otro.
+func recordAudit(tx *gorm.DB, actor, entity string, entityID uint, action string, before, after interface{}) error {
+	beforeJSON, err := auditState(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditState(after)
+	if err != nil {
+		return err
+	}
+	entry := AuditLog{
+		EntityType: entity,
+		EntityID:   entityID,
+		Action:     action,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		Actor:      actor,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+	// Todo cambio auditado pasa por aquí, así que es el punto donde se encolan los webhooks
+	return enqueueAuditWebhooks(tx, entry)
+}
+
+// auditedUpdate aplica los cambios a un registro y los audita en la misma
+// transacción dentro de tx. record debe ser un puntero vacío del modelo, donde
+// se carga el estado posterior. Devuelve errStaleRecord si el registro cambió
+// después de leer before.
+func auditedUpdate(tx *gorm.DB, actor, entity string, id uint, before, record interface{}, updates map[string]interface{}) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(record).Where("id = ?", id)
+		// Solo se actualiza si nadie ha cambiado el registro desde que se leyó before
+		if updatedAt, ok := recordUpdatedAt(before); ok {
+			query = query.Where("updated_at = ?", updatedAt)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errStaleRecord
+		}
+		if err := tx.First(record, id).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, entity, id, AuditUpdate, before, record)
+	})
+}
+
+// auditedDelete elimina un registro (borrado suave) y audita su último estado
+// en la misma transacción dentro de tx. record debe ser un puntero vacío del modelo.
+func auditedDelete(tx *gorm.DB, actor, entity string, id uint, record interface{}) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(record, id).Error; err != nil {
+			return err
+		}
+		// Se audita antes de borrar: Delete rellena DeletedAt en record
+		if err := recordAudit(tx, actor, entity, id, AuditDelete, record, nil); err != nil {
+			return err
+		}
+		return tx.Delete(record).Error
+	})
+}
+
+// auditReplacedRecords audita la baja de todos los tickers, compras y ventas
+// antes de que una restauración en modo replace los borre.
+func auditReplacedRecords(tx *gorm.DB, actor string) error {
+	var sales []Sale
+	tx.Find(&sales)
+	for _, s := range sales {
+		if err := recordAudit(tx, actor, AuditSale, s.ID, AuditDelete, s, nil); err != nil {
+			return err
+		}
+	}
+	var investments []Investment
+	tx.Find(&investments)
+	for _, i := range investments {
+		if err := recordAudit(tx, actor, AuditInvestment, i.ID, AuditDelete, i, nil); err != nil {
+			return err
+		}
+	}
+	var tickers []Ticker
+	tx.Find(&tickers)
+	for _, t := range tickers {
+		if err := recordAudit(tx, actor, AuditTicker, t.ID, AuditDelete, t, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditChanges compara el estado anterior y el posterior campo a campo.
+func auditChanges(entry AuditLog) []AuditChange {
+	before, _ := auditFields(entry.Before)
+	after, _ := auditFields(entry.After)
+
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	var changes []AuditChange
+	for name := range names {
+		if auditIgnoredFields[name] {
+			continue
+		}
+		b, a := auditValue(before[name]), auditValue(after[name])
+		if b == a {
+			continue
+		}
+		changes = append(changes, AuditChange{Field: name, Before: b, After: a})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// auditValue formatea el valor de un campo para mostrarlo.
+func auditValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// newAuditView prepara una entrada de auditoría para la UI.
+func newAuditView(entry AuditLog) AuditView {
+	return AuditView{
+		ID:          entry.ID,
+		CreatedAt:   entry.CreatedAt.Format("02 Jan 2006 15:04:05"),
+		EntityType:  entry.EntityType,
+		EntityLabel: auditEntityLabels[entry.EntityType],
+		EntityID:    entry.EntityID,
+		Action:      entry.Action,
+		ActionLabel: auditActionLabels[entry.Action],
+		Actor:       entry.Actor,
+		Changes:     auditChanges(entry),
+	}
+}
+
+// findAuditLogs devuelve las entradas que cumplen el filtro, de la más reciente
+// a la más antigua.
+func findAuditLogs(filter AuditFilter, limit int) ([]AuditView, error) {
+	query := db.Model(&AuditLog{})
+	if filter.Entity != "" {
+		query = query.Where("entity_type = ?", filter.Entity)
+	}
+	if filter.EntityID > 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		query = query.Where("LOWER(actor) LIKE ?", "%"+strings.ToLower(filter.Actor)+"%")
+	}
+	if from, err := time.ParseInLocation("2006-01-02", filter.From, time.Local); err == nil {
+		query = query.Where("created_at >= ?", from)
+	}
+	if to, err := time.ParseInLocation("2006-01-02", filter.To, time.Local); err == nil {
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	var entries []AuditLog
+	if err := query.Order("created_at desc, id desc").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	views := make([]AuditView, 0, len(entries))
+	for _, entry := range entries {
+		views = append(views, newAuditView(entry))
+	}
+	return views, nil
+}
+
+// auditHistory devuelve el historial de cambios de un registro.
+func auditHistory(entity string, id uint) []AuditView {
+	views, _ := findAuditLogs(AuditFilter{Entity: entity, EntityID: id}, auditPageSize)
+	return views
+}
+
+// registerAuditRoutes registra la página de auditoría y el historial por registro.
+func registerAuditRoutes(router *gin.Engine) {
+	// Página de auditoría con filtros
+	router.GET("/auditoria", func(c *gin.Context) {
+		filter := AuditFilter{
+			Entity: c.Query("entity"),
+			Action: c.Query("action"),
+			Actor:  strings.TrimSpace(c.Query("actor")),
+			From:   c.Query("from"),
+			To:     c.Query("to"),
+		}
+		if id, err := strconv.Atoi(c.Query("entity_id")); err == nil && id > 0 {
+			filter.EntityID = uint(id)
+		}
+
+		entries, err := findAuditLogs(filter, auditPageSize)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener la auditoría: %v", err)
+			return
+		}
+
+		c.HTML(http.StatusOK, "auditoria.html", gin.H{
+			"Entries":      entries,
+			"Filter":       filter,
+			"Entities":     auditEntityLabels,
+			"Actions":      auditActionLabels,
+			"Limit":        auditPageSize,
+			"LimitReached": len(entries) == auditPageSize,
+			"ActivePage":   "auditoria",
+		})
+	})
+
+	// API: Historial de cambios de un registro (para las pantallas de edición)
+	router.GET("/api/audit/:entity/:id", func(c *gin.Context) {
+		entity := c.Param("entity")
+		if _, ok := auditEntityLabels[entity]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Entidad inválida"})
+			return
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+			return
+		}
+		c.JSON(http.StatusOK, auditHistory(entity, uint(id)))
+	})
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxBatchOperations es el número máximo de operaciones por lote.
+const maxBatchOperations = 500
+
+// Operaciones admitidas en un lote
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// Estados de cada operación en la respuesta de un lote
+const (
+	BatchApplied    = "applied"     // Aplicada y confirmada
+	BatchFailed     = "failed"      // Provocó la cancelación del lote
+	BatchRolledBack = "rolled_back" // Se aplicó, pero se deshizo al cancelarse el lote
+	BatchSkipped    = "skipped"     // No llegó a ejecutarse
+)
+
+// BatchOperation es una alta, modificación o baja de un ticker, compra o venta.
+// Data lleva el mismo cuerpo que el endpoint individual correspondiente.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	Entity  string          `json:"entity"`
+	ID      uint            `json:"id"`
+	IfMatch string          `json:"if_match"` // ETag opcional en las modificaciones
+	Data    json.RawMessage `json:"data"`
+}
+
+// BatchRequest es el cuerpo de POST /api/v1/batch.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult es el resultado de una operación del lote.
+type BatchResult struct {
+	Index   int         `json:"index"`
+	Op      string      `json:"op"`
+	Entity  string      `json:"entity"`
+	ID      uint        `json:"id,omitempty"`
+	Status  string      `json:"status"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	Current interface{} `json:"current,omitempty"` // Estado actual si if_match no coincide
+}
+
+// BatchErrorResponse es la respuesta de un lote cancelado: el error que lo
+// canceló y el estado de cada operación.
+type BatchErrorResponse struct {
+	Success bool          `json:"success"`
+	Error   string        `json:"error"`
+	Code    string        `json:"code"`
+	Results []BatchResult `json:"results"`
+}
+
+// batchApplied es el resultado de aplicar una operación dentro de la transacción.
+type batchApplied struct {
+	id        uint
+	data      interface{}
+	sale      *Sale  // Las ventas se presentan tras confirmar, con el WAC resultante
+	tickerIDs []uint // Tickers cuyas posiciones o precios cambian
+}
+
+// decodeBatchData lee los datos de una operación en la entrada del endpoint individual.
+func decodeBatchData(op BatchOperation, input interface{}) error {
+	if len(op.Data) == 0 || string(op.Data) == "null" {
+		return validationError("La operación %s de %s necesita el campo data.", op.Op, op.Entity)
+	}
+	if err := json.Unmarshal(op.Data, input); err != nil {
+		return &apiError{Status: http.StatusBadRequest, Code: APICodeInvalidJSON, Message: "JSON inválido en data: " + err.Error()}
+	}
+	return nil
+}
+
+// applyBatchOperation ejecuta una operación del lote dentro de tx.
+func applyBatchOperation(tx *gorm.DB, actor string, op BatchOperation) (batchApplied, error) {
+	if op.Op != BatchCreate && op.ID == 0 {
+		return batchApplied{}, validationError("La operación %s de %s necesita un id.", op.Op, op.Entity)
+	}
+
+	switch op.Entity + "/" + op.Op {
+	case AuditTicker + "/" + BatchCreate:
+		var input TickerInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		ticker, err := createTicker(tx, actor, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: ticker.ID, data: newAPITicker(ticker)}, nil
+
+	case AuditTicker + "/" + BatchUpdate:
+		var input TickerInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		ticker, err := updateTicker(tx, actor, op.ID, op.IfMatch, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: ticker.ID, data: newAPITicker(ticker), tickerIDs: []uint{ticker.ID}}, nil
+
+	case AuditTicker + "/" + BatchDelete:
+		return batchApplied{id: op.ID}, deleteTicker(tx, actor, op.ID)
+
+	case AuditInvestment + "/" + BatchCreate:
+		var input InvestmentInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		inv, err := createInvestment(tx, actor, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: inv.ID, data: newAPIInvestment(inv), tickerIDs: []uint{inv.TickerID}}, nil
+
+	case AuditInvestment + "/" + BatchUpdate:
+		var input InvestmentInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		before, err := findInvestment(tx, op.ID)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		inv, err := updateInvestment(tx, actor, op.ID, op.IfMatch, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: inv.ID, data: newAPIInvestment(inv), tickerIDs: []uint{before.TickerID, inv.TickerID}}, nil
+
+	case AuditInvestment + "/" + BatchDelete:
+		before, err := findInvestment(tx, op.ID)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: op.ID, tickerIDs: []uint{before.TickerID}}, deleteInvestment(tx, actor, op.ID)
+
+	case AuditSale + "/" + BatchCreate:
+		var input SaleInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		s, err := createSale(tx, actor, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: s.ID, sale: &s, tickerIDs: []uint{s.TickerID}}, nil
+
+	case AuditSale + "/" + BatchUpdate:
+		var input SaleInput
+		if err := decodeBatchData(op, &input); err != nil {
+			return batchApplied{}, err
+		}
+		before, err := findSale(tx, op.ID)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		s, err := updateSale(tx, actor, op.ID, op.IfMatch, input)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: s.ID, sale: &s, tickerIDs: []uint{before.TickerID, s.TickerID}}, nil
+
+	case AuditSale + "/" + BatchDelete:
+		before, err := findSale(tx, op.ID)
+		if err != nil {
+			return batchApplied{}, err
+		}
+		return batchApplied{id: op.ID, tickerIDs: []uint{before.TickerID}}, deleteSale(tx, actor, op.ID)
+	}
+
+	switch op.Op {
+	case BatchCreate, BatchUpdate, BatchDelete:
+		return batchApplied{}, validationError("Entidad inválida: %q (ticker, investment o sale).", op.Entity)
+	default:
+		return batchApplied{}, validationError("Operación inválida: %q (create, update o delete).", op.Op)
+	}
+}
+
+// applyBatch ejecuta las operaciones en orden dentro de una única transacción
+// y valida las posiciones resultantes al final, de modo que una venta puede ir
+// antes que la compra con fecha anterior que la cubre. Si algo falla no se
+// aplica ninguna.
+// Devuelve el resultado de cada operación y los tickers afectados.
+func applyBatch(actor string, ops []BatchOperation) ([]BatchResult, []uint, error) {
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Index: i, Op: op.Op, Entity: op.Entity, ID: op.ID, Status: BatchSkipped}
+	}
+
+	sales := make(map[int]Sale)
+	touched := make(map[uint]bool)
+	opTickers := make([][]uint, len(ops))
+	failed := -1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		deferred := deferPositionChecks(tx)
+		for i, op := range ops {
+			applied, err := applyBatchOperation(deferred, actor, op)
+			if err != nil {
+				failed = i
+				return err
+			}
+			results[i].ID = applied.id
+			results[i].Data = applied.data
+			results[i].Status = BatchApplied
+			if applied.sale != nil {
+				sales[i] = *applied.sale
+			}
+			opTickers[i] = applied.tickerIDs
+			for _, id := range applied.tickerIDs {
+				touched[id] = true
+			}
+		}
+
+		tickerID, oversold, err := firstOversell(tx, sortedTickerIDs(touched)...)
+		if err != nil || oversold == nil {
+			return err
+		}
+		failed = batchOversellOperation(ops, results, opTickers, tickerID, oversold.ID)
+		return oversellError(tx, tickerID, *oversold)
+	})
+
+	if err != nil {
+		for i := range results {
+			if results[i].Status == BatchApplied {
+				results[i].Status = BatchRolledBack
+				results[i].ID = ops[i].ID // Los IDs de las altas deshechas no llegan a existir
+				results[i].Data = nil
+			}
+		}
+		if failed >= 0 {
+			results[failed].Status = BatchFailed
+			results[failed].Error, results[failed].Code = batchErrorDetail(err)
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				results[failed].Current = apiErr.Current
+			}
+		}
+		return results, nil, err
+	}
+
+	// Las ventas se presentan con el WAC de su fecha una vez confirmado el lote
+	if len(sales) > 0 {
+		indexes := make([]int, 0, len(sales))
+		list := make([]Sale, 0, len(sales))
+		for i, s := range sales {
+			indexes = append(indexes, i)
+			list = append(list, s)
+		}
+		views, err := newAPISales(db, list)
+		if err != nil {
+			log.Printf("Error al calcular el WAC de las ventas del lote: %v", err)
+		} else {
+			for k, i := range indexes {
+				results[i].Data = views[k]
+			}
+		}
+	}
+	return results, sortedTickerIDs(touched), nil
+}
+
+// batchOversellOperation devuelve la operación a la que se atribuye una venta
+// sin acciones suficientes: la que dio de alta o modificó esa venta o, si no
+// está en el lote, la última que cambió la posición del ticker.
+func batchOversellOperation(ops []BatchOperation, results []BatchResult, opTickers [][]uint, tickerID, saleID uint) int {
+	last := -1
+	for i, op := range ops {
+		if op.Entity == AuditSale && op.Op != BatchDelete && results[i].ID == saleID {
+			return i
+		}
+		for _, id := range opTickers[i] {
+			if id == tickerID {
+				last = i
+			}
+		}
+	}
+	return last
+}
+
+// sortedTickerIDs devuelve los IDs del conjunto en orden ascendente.
+func sortedTickerIDs(set map[uint]bool) []uint {
+	ids := make([]uint, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// batchErrorDetail devuelve el mensaje y el código de API de un error.
+func batchErrorDetail(err error) (string, string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Message, apiErr.Code
+	}
+	return "Error interno del servidor", APICodeInternal
+}
+
+// respondBatchError envía el error que canceló un lote junto con el estado de cada operación.
+func respondBatchError(c *gin.Context, err error, results []BatchResult) {
+	status := http.StatusInternalServerError
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.Status
+	} else {
+		log.Printf("Error en el lote: %v", err)
+	}
+	message, code := batchErrorDetail(err)
+	c.JSON(status, BatchErrorResponse{Error: message, Code: code, Results: results})
+}
+
+// registerBatchRoutes registra el endpoint de operaciones en lote de la API v1.
+func registerBatchRoutes(router *gin.Engine) {
+	// Altas, modificaciones y bajas de tickers, compras y ventas en una única transacción
+	router.POST("/api/v1/batch", idempotent(), func(c *gin.Context) {
+		var input BatchRequest
+		if !bindAPIJSON(c, &input) {
+			return
+		}
+		if len(input.Operations) == 0 {
+			respondAPIError(c, validationError("El lote debe contener al menos una operación."))
+			return
+		}
+		if len(input.Operations) > maxBatchOperations {
+			respondAPIError(c, validationError("El lote no puede contener más de %d operaciones.", maxBatchOperations))
+			return
+		}
+
+		results, tickerIDs, err := applyBatch(auditActor(c), input.Operations)
+		if err != nil {
+			respondBatchError(c, err, results)
+			return
+		}
+		log.Printf("Lote de %d operaciones aplicado via API", len(results))
+
+		// Los precios y las posiciones pueden haber cambiado: se reevalúan las alertas
+		goBackground(func() { evaluateAlerts("batch", tickerIDs...) })
+		goBackground(func() { publishPriceChanges("batch", tickerIDs...) })
+
+		respondAPI(c, http.StatusOK, results, fmt.Sprintf("%d operaciones aplicadas", len(results)))
+	})
+}
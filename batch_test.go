@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+// batchOp construye una operación de alta con el cuerpo JSON indicado.
+func batchOp(entity, data string) BatchOperation {
+	return BatchOperation{Op: BatchCreate, Entity: entity, Data: []byte(data)}
+}
+
+// TestBatchSaleBeforeCoveringBuy comprueba que las posiciones se validan al
+// final del lote y no operación a operación.
+func TestBatchSaleBeforeCoveringBuy(t *testing.T) {
+	useTestDatabase(t)
+
+	// El ejemplo tiene 8 MSFT (ticker 3) desde el 10/03/2023; la venta de 10
+	// solo es posible con la compra de 5 con fecha anterior que va después
+	results, tickerIDs, err := applyBatch("test", []BatchOperation{
+		batchOp(AuditSale, `{"ticker_id": 3, "sale_date": "2023-06-01", "shares": 10, "sale_price": 320}`),
+		batchOp(AuditInvestment, `{"ticker_id": 3, "purchase_date": "2023-05-01", "shares": 5, "purchase_price": 300}`),
+	})
+	if err != nil {
+		t.Fatalf("applyBatch: %v", err)
+	}
+	for _, r := range results {
+		if r.Status != BatchApplied || r.ID == 0 {
+			t.Errorf("operación %d: estado %s con id %d, se esperaba applied", r.Index, r.Status, r.ID)
+		}
+	}
+	if len(tickerIDs) != 1 || tickerIDs[0] != 3 {
+		t.Errorf("tickers afectados = %v, se esperaba [3]", tickerIDs)
+	}
+	var sales int64
+	db.Model(&Sale{}).Where("ticker_id = ?", 3).Count(&sales)
+	if sales != 1 {
+		t.Errorf("ventas guardadas = %d, se esperaba 1", sales)
+	}
+}
+
+// TestBatchOversellRollsBack comprueba que un lote que termina con una
+// posición negativa no guarda ninguna operación.
+func TestBatchOversellRollsBack(t *testing.T) {
+	useTestDatabase(t)
+	var investmentsBefore int64
+	db.Model(&Investment{}).Count(&investmentsBefore)
+
+	results, _, err := applyBatch("test", []BatchOperation{
+		batchOp(AuditInvestment, `{"ticker_id": 3, "purchase_date": "2023-05-01", "shares": 1, "purchase_price": 300}`),
+		batchOp(AuditSale, `{"ticker_id": 3, "sale_date": "2023-06-01", "shares": 10, "sale_price": 320}`),
+	})
+	requireOversell(t, "applyBatch", err)
+	// La comprobación final se atribuye a la venta que queda en descubierto
+	if len(results) != 2 || results[0].Status != BatchRolledBack || results[1].Status != BatchFailed || results[1].ID != 0 {
+		t.Errorf("estados = %+v, se esperaba la compra deshecha y la venta fallida", results)
+	}
+
+	var investments, sales int64
+	db.Model(&Investment{}).Count(&investments)
+	db.Model(&Sale{}).Count(&sales)
+	if investments != investmentsBefore || sales != 0 {
+		t.Errorf("se guardaron operaciones del lote cancelado: %d compras (antes %d), %d ventas", investments, investmentsBefore, sales)
+	}
+}
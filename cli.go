@@ -0,0 +1,618 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// cliCommands son los subcomandos disponibles desde la línea de comandos. Sin
+// subcomando la aplicación arranca el servidor web.
+var cliCommands = map[string]func(args []string) error{
+	"export":    runExportCommand,
+	"import":    runImportCommand,
+	"migrate":   runMigrateCommand,
+	"report":    runReportCommand,
+	"restore":   runRestoreCommand,
+	"serve":     runServeCommand,
+	"snapshot":  runSnapshotCommand,
+	"statement": runStatementCommand,
+	"ticker":    runTickerCommand,
+	"trade":     runTradeCommand,
+}
+
+// cliUsage resume los subcomandos disponibles.
+const cliUsage = `uso: bolsa_gin <subcomando> [opciones]
+
+  serve            arranca el servidor web (subcomando por defecto)
+  trade add        registra una compra o una venta
+  ticker set-price actualiza el precio actual de un ticker
+  snapshot create  guarda el precio actual de todos los tickers
+  report positions muestra las posiciones abiertas
+  import           importa operaciones de un extracto CSV, OFX o QIF
+  export           escribe una copia de seguridad en JSON
+  restore          restaura una copia de seguridad
+  statement        genera el extracto en PDF de un periodo
+  migrate          consulta o modifica el estado de las migraciones
+
+Usa bolsa_gin <subcomando> -h para ver sus opciones.`
+
+// runCLI ejecuta el subcomando indicado en args. Sin argumentos arranca el
+// servidor web.
+func runCLI(args []string) error {
+	if len(args) == 0 {
+		return runServeCommand(nil)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Println(cliUsage)
+		return nil
+	}
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("subcomando desconocido %q\n\n%s", args[0], cliUsage)
+	}
+	// -h ya muestra las opciones del subcomando
+	if err := command(args[1:]); !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+// openDatabase abre la base de datos la primera vez que un subcomando la
+// necesita, de modo que la ayuda y los errores de uso no dependen de
+// DATABASE_URL. Con migrate se aplican las migraciones pendientes.
+func openDatabase(migrate bool) error {
+	if db != nil {
+		return nil
+	}
+	conn, err := setupDatabase(migrate)
+	if err != nil {
+		return fmt.Errorf("error al configurar la base de datos: %v", err)
+	}
+	db = conn
+	return nil
+}
+
+// cliActor identifica al usuario del sistema en el registro de auditoría.
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli " + u.Username
+	}
+	return "cli"
+}
+
+// runExportCommand escribe la copia de seguridad en un fichero o en la salida estándar.
+//
+//	bolsa_gin export [-secrets] [-o fichero.json]
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "fichero de salida (por defecto, salida estándar)")
+	secrets := fs.Bool("secrets", false, "incluir los secretos de los webhooks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := writePortfolioArchive(w, *secrets); err != nil {
+		return fmt.Errorf("error al exportar: %v", err)
+	}
+	if *output != "" {
+		log.Printf("Copia de seguridad guardada en %s", *output)
+	}
+	return nil
+}
+
+// runRestoreCommand restaura una copia de seguridad.
+//
+//	bolsa_gin restore [-mode merge|replace] fichero.json
+func runRestoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	mode := fs.String("mode", RestoreMerge, "merge para añadir a los datos existentes, replace para sustituirlos")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("uso: bolsa_gin restore [-mode merge|replace] fichero.json")
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	archive, err := readPortfolioArchive(f)
+	if err != nil {
+		return fmt.Errorf("copia de seguridad inválida: %v", err)
+	}
+
+	result, err := restorePortfolioArchive(archive, *mode, cliActor())
+	if err != nil {
+		return fmt.Errorf("error al restaurar: %v", err)
+	}
+
+	fmt.Printf("Registros creados: %d, omitidos: %d\n", result.total(result.Created), result.total(result.Skipped))
+	for table, n := range result.Created {
+		fmt.Printf("  %-18s %d\n", table, n)
+	}
+	return nil
+}
+
+// runStatementCommand genera el extracto en PDF de un mes, un trimestre o un
+// rango de fechas.
+//
+//	bolsa_gin statement [-period month|quarter] [-month AAAA-MM] [-from AAAA-MM-DD -to AAAA-MM-DD] [-o fichero.pdf]
+func runStatementCommand(args []string) error {
+	fs := flag.NewFlagSet("statement", flag.ContinueOnError)
+	period := fs.String("period", StatementMonth, "month para un extracto mensual, quarter para trimestral")
+	month := fs.String("month", "", "mes del extracto (AAAA-MM, por defecto el actual)")
+	from := fs.String("from", "", "fecha inicial de un rango (AAAA-MM-DD)")
+	to := fs.String("to", "", "fecha final de un rango, incluida (AAAA-MM-DD)")
+	output := fs.String("o", "", "fichero de salida (por defecto, extracto-<fecha>.pdf)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, err := parseStatementPeriod(*period, *month, *from, *to)
+	if err != nil {
+		return err
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+	statement, err := buildPortfolioStatement(p)
+	if err != nil {
+		return fmt.Errorf("error al generar el extracto: %v", err)
+	}
+
+	if *output == "" {
+		*output = fmt.Sprintf("extracto-%s.pdf", p.From.Format("2006-01-02"))
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeStatementPDF(f, statement); err != nil {
+		return fmt.Errorf("error al escribir el extracto: %v", err)
+	}
+	log.Printf("Extracto %s guardado en %s", p.Label, *output)
+	return nil
+}
+
+// runMigrateCommand consulta o modifica el estado de las migraciones.
+//
+//	bolsa_gin migrate status
+//	bolsa_gin migrate up [-to versión]
+//	bolsa_gin migrate down [-n 1]
+//	bolsa_gin migrate redo
+func runMigrateCommand(args []string) error {
+	usage := fmt.Errorf("uso: bolsa_gin migrate status|up|down|redo")
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	target := fs.String("to", "", "aplicar hasta esta versión incluida (solo up)")
+	steps := fs.Int("n", 1, "número de migraciones a revertir (solo down)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	// Las migraciones se gestionan aquí, así que no se aplican al abrir
+	if err := openDatabase(false); err != nil {
+		return err
+	}
+
+	var done []string
+	var err error
+	switch args[0] {
+	case "status":
+		statuses, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state, appliedAt := "pendiente", ""
+			if s.Applied {
+				state, appliedAt = "aplicada", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state = "modificada"
+			}
+			fmt.Printf("%-45s %-4s %-11s %s\n", s.Version, s.Kind, state, appliedAt)
+		}
+		return nil
+	case "up":
+		done, err = migrateUp(db, *target)
+	case "down":
+		done, err = migrateDown(db, *steps)
+	case "redo":
+		if done, err = migrateDown(db, 1); err == nil && len(done) == 1 {
+			done, err = migrateUp(db, done[0])
+		}
+	default:
+		return usage
+	}
+
+	if len(done) == 0 && err == nil {
+		fmt.Println("No hay migraciones que ejecutar")
+	}
+	return err
+}
+
+// runTradeCommand registra una compra o una venta con las mismas
+// validaciones que la API. Las ventas no pueden dejar la posición con
+// acciones negativas.
+//
+//	bolsa_gin trade add -type buy|sell -ticker NOMBRE|ID -shares N -price P [-date AAAA-MM-DD] [-cost C] [-tax T] [-json]
+func runTradeCommand(args []string) error {
+	if len(args) == 0 || args[0] != "add" {
+		return fmt.Errorf("uso: bolsa_gin trade add -type buy|sell -ticker NOMBRE|ID -shares N -price P [-date AAAA-MM-DD] [-cost C] [-tax T] [-json]")
+	}
+
+	fs := flag.NewFlagSet("trade add", flag.ContinueOnError)
+	kind := fs.String("type", "", "buy para una compra, sell para una venta")
+	tickerRef := fs.String("ticker", "", "nombre o ID del ticker")
+	date := fs.String("date", time.Now().Format("2006-01-02"), "fecha de la operación (AAAA-MM-DD o AAAA-MM-DDTHH:MM)")
+	shares := fs.String("shares", "", "número de acciones")
+	price := fs.String("price", "", "precio por acción")
+	cost := fs.String("cost", "0", "costo de la operación")
+	tax := fs.String("tax", "0", "retención practicada (solo ventas)")
+	asJSON := fs.Bool("json", false, "escribir el resultado en JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	ticker, err := cliTicker(*tickerRef)
+	if err != nil {
+		return err
+	}
+	var amounts [4]decimal.Decimal
+	for i, v := range []struct{ name, value string }{{"shares", *shares}, {"price", *price}, {"cost", *cost}, {"tax", *tax}} {
+		if amounts[i], err = decimal.NewFromString(v.value); err != nil {
+			return fmt.Errorf("valor de -%s inválido: %q", v.name, v.value)
+		}
+	}
+
+	actor := cliActor()
+	switch *kind {
+	case "buy":
+		inv, err := createInvestment(db, actor, InvestmentInput{
+			TickerID:      ticker.ID,
+			PurchaseDate:  *date,
+			Shares:        amounts[0],
+			PurchasePrice: amounts[1],
+			OperationCost: amounts[2],
+		})
+		if err != nil {
+			return err
+		}
+		processDueWebhooks()
+		if *asJSON {
+			return writeCLIJSON(newAPIInvestment(inv))
+		}
+		fmt.Printf("Compra %d registrada: %s acciones de %s a %s\n", inv.ID, inv.Shares, ticker.Name, inv.PurchasePrice)
+	case "sell":
+		s, err := createSale(db, actor, SaleInput{
+			TickerID:      ticker.ID,
+			SaleDate:      *date,
+			Shares:        amounts[0],
+			SalePrice:     amounts[1],
+			OperationCost: amounts[2],
+			WithheldTax:   amounts[3],
+		})
+		if err != nil {
+			return err
+		}
+		processDueWebhooks()
+		if *asJSON {
+			views, err := newAPISales(db, []Sale{s})
+			if err != nil {
+				return err
+			}
+			return writeCLIJSON(views[0])
+		}
+		fmt.Printf("Venta %d registrada: %s acciones de %s a %s\n", s.ID, s.Shares, ticker.Name, s.SalePrice)
+	default:
+		return fmt.Errorf("tipo de operación inválido: %q (buy o sell)", *kind)
+	}
+	return nil
+}
+
+// runTickerCommand actualiza el precio actual de un ticker y evalúa sus
+// alertas.
+//
+//	bolsa_gin ticker set-price [-json] NOMBRE|ID PRECIO
+func runTickerCommand(args []string) error {
+	usage := fmt.Errorf("uso: bolsa_gin ticker set-price [-json] NOMBRE|ID PRECIO")
+	if len(args) == 0 || args[0] != "set-price" {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("ticker set-price", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "escribir el ticker actualizado en JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usage
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	ticker, err := cliTicker(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	price, err := decimal.NewFromString(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("precio inválido: %q", fs.Arg(1))
+	}
+
+	// updateTicker reemplaza todos los datos, así que se parte de los actuales
+	metadata := ticker.Metadata()
+	updated, err := updateTicker(db, cliActor(), ticker.ID, "", TickerInput{
+		Name:               ticker.Name,
+		CurrentPrice:       price,
+		ISIN:               metadata.ISIN,
+		ExchangeMIC:        metadata.ExchangeMIC,
+		Currency:           metadata.Currency,
+		Sector:             metadata.Sector,
+		Industry:           metadata.Industry,
+		Country:            metadata.Country,
+		AssetClass:         metadata.AssetClass,
+		YahooFinanceTicker: metadata.YahooFinanceTicker,
+	})
+	if err != nil {
+		return err
+	}
+	evaluateAlerts("cli", ticker.ID)
+	processDueWebhooks()
+
+	if *asJSON {
+		return writeCLIJSON(newAPITicker(updated))
+	}
+	fmt.Printf("Precio de %s actualizado: %s → %s\n", updated.Name, ticker.CurrentPrice, updated.CurrentPrice)
+	return nil
+}
+
+// runSnapshotCommand guarda el precio actual de todos los tickers, pensado
+// para lanzarse desde cron.
+//
+//	bolsa_gin snapshot create [-json]
+func runSnapshotCommand(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return fmt.Errorf("uso: bolsa_gin snapshot create [-json]")
+	}
+
+	fs := flag.NewFlagSet("snapshot create", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "escribir el snapshot en JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	snapshotID, priceHistories, err := createPriceSnapshot()
+	if err != nil {
+		return err
+	}
+	notifyPriceSnapshot(snapshotID, priceHistories)
+	processDueWebhooks()
+
+	if *asJSON {
+		detail, err := getSnapshotDetail(snapshotID)
+		if err != nil {
+			return err
+		}
+		return writeCLIJSON(detail)
+	}
+	fmt.Printf("Snapshot %s creado con %d precios\n", snapshotID, len(priceHistories))
+	return nil
+}
+
+// runReportCommand muestra las posiciones valoradas al precio actual, como en
+// el resumen del dashboard.
+//
+//	bolsa_gin report positions [-all] [-json]
+func runReportCommand(args []string) error {
+	if len(args) == 0 || args[0] != "positions" {
+		return fmt.Errorf("uso: bolsa_gin report positions [-all] [-json]")
+	}
+
+	fs := flag.NewFlagSet("report positions", flag.ContinueOnError)
+	all := fs.Bool("all", false, "incluir las posiciones cerradas")
+	asJSON := fs.Bool("json", false, "escribir las posiciones en JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+
+	_, summaries, _, _, _, _, _, _, _, _, err := getInvestmentData()
+	if err != nil {
+		return fmt.Errorf("error al calcular las posiciones: %v", err)
+	}
+	positions := make([]TickerSummaryView, 0, len(summaries))
+	for _, s := range summaries {
+		if *all || s.TotalShares.IsPositive() {
+			positions = append(positions, s)
+		}
+	}
+
+	if *asJSON {
+		return writeCLIJSON(positions)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Ticker\tAcciones\tInvertido\tValor actual\tP/L\tRend.\t")
+	totalInvested, totalValue, totalProfitLoss := decimal.Zero, decimal.Zero, decimal.Zero
+	for _, p := range positions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.2f%%\t\n", p.Ticker, p.TotalShares,
+			p.CurrentInvestment.StringFixed(2), p.CurrentValue.StringFixed(2), p.ProfitLoss.StringFixed(2), p.Performance)
+		totalInvested = totalInvested.Add(p.CurrentInvestment)
+		totalValue = totalValue.Add(p.CurrentValue)
+		totalProfitLoss = totalProfitLoss.Add(p.ProfitLoss)
+	}
+	fmt.Fprintf(w, "Total\t\t%s\t%s\t%s\t\t\n", totalInvested.StringFixed(2), totalValue.StringFixed(2), totalProfitLoss.StringFixed(2))
+	return w.Flush()
+}
+
+// runImportCommand importa las operaciones de un extracto de broker. Igual
+// que en la página de importación, las operaciones duplicadas o con errores
+// se omiten, así que puede repetirse con el mismo fichero.
+//
+//	bolsa_gin import [-format csv|ofx|qif] [-profile NOMBRE|ID] [-dry-run] [-json] fichero
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "csv, ofx o qif (por defecto, según la extensión del fichero)")
+	profile := fs.String("profile", "", "nombre o ID del perfil de columnas (solo CSV)")
+	dryRun := fs.Bool("dry-run", false, "mostrar las operaciones sin guardarlas")
+	asJSON := fs.Bool("json", false, "escribir el resultado en JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("uso: bolsa_gin import [-format csv|ofx|qif] [-profile NOMBRE|ID] [-dry-run] [-json] fichero")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fs.Arg(0))), ".")
+		if *format == "qfx" {
+			*format = "ofx"
+		}
+	}
+	if err := openDatabase(true); err != nil {
+		return err
+	}
+	var profileID string
+	if *profile != "" {
+		if *format != "csv" {
+			return fmt.Errorf("-profile solo se usa con ficheros CSV")
+		}
+		p, err := cliImportProfile(*profile)
+		if err != nil {
+			return err
+		}
+		profileID = strconv.FormatUint(uint64(p.ID), 10)
+	}
+
+	trades, source, err := parseImportFile(*format, profileID, data)
+	if err != nil {
+		return fmt.Errorf("error al leer el fichero: %v", err)
+	}
+	resolveImportedTrades(trades)
+
+	if *dryRun {
+		if *asJSON {
+			return writeCLIJSON(trades)
+		}
+		fmt.Printf("%s · %d operaciones\n", source, len(trades))
+		for _, t := range trades {
+			state := "importar"
+			switch {
+			case !t.Valid():
+				state = strings.Join(t.Errors, "; ")
+			case t.Duplicate:
+				state = "duplicada"
+			}
+			symbol := t.Symbol
+			if symbol == "" {
+				symbol = t.ISIN
+			}
+			fmt.Printf("  %4d %-8s %s %-12s %10s %10s  %s\n", t.Row, t.Type, t.Date.Format("2006-01-02"),
+				symbol, t.Shares, t.Price, state)
+		}
+		return nil
+	}
+
+	result, err := commitImportedTrades(trades, nil, cliActor())
+	if err != nil {
+		return fmt.Errorf("error al importar: %v", err)
+	}
+	processDueWebhooks()
+
+	if *asJSON {
+		return writeCLIJSON(result)
+	}
+	fmt.Printf("Importado desde %s: %d compras, %d ventas, %d dividendos, %d tickers nuevos, %d omitidas\n",
+		source, result.Investments, result.Sales, result.Dividends, result.Tickers, result.Skipped)
+	return nil
+}
+
+// cliTicker busca un ticker por nombre o, si no existe, por ID.
+func cliTicker(ref string) (Ticker, error) {
+	var ticker Ticker
+	if ref == "" {
+		return ticker, fmt.Errorf("falta el ticker")
+	}
+	if db.Where("name = ?", strings.ToUpper(strings.TrimSpace(ref))).Limit(1).Find(&ticker); ticker.ID != 0 {
+		return ticker, nil
+	}
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		if db.Limit(1).Find(&ticker, id); ticker.ID != 0 {
+			return ticker, nil
+		}
+	}
+	return ticker, fmt.Errorf("ticker %q no encontrado", ref)
+}
+
+// cliImportProfile busca un perfil de importación por nombre, sin distinguir
+// mayúsculas, o, si no existe, por ID.
+func cliImportProfile(ref string) (ImportProfile, error) {
+	var profile ImportProfile
+	if db.Where("LOWER(name) = LOWER(?)", strings.TrimSpace(ref)).Limit(1).Find(&profile); profile.ID != 0 {
+		return profile, nil
+	}
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		if db.Limit(1).Find(&profile, id); profile.ID != 0 {
+			return profile, nil
+		}
+	}
+	return profile, fmt.Errorf("perfil de importación %q no encontrado", ref)
+}
+
+// writeCLIJSON escribe v en la salida estándar como JSON indentado.
+func writeCLIJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLIHelpWithoutDatabase(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	previous := db
+	db = nil
+	t.Cleanup(func() { db = previous })
+
+	for _, args := range [][]string{{"help"}, {"-h"}, {"trade", "add", "-h"}, {"migrate", "up", "-h"}} {
+		if err := runCLI(args); err != nil {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+	if err := runCLI([]string{"desconocido"}); err == nil || !strings.Contains(err.Error(), "subcomando desconocido") {
+		t.Errorf("subcomando desconocido: %v", err)
+	}
+	if db != nil {
+		t.Error("la ayuda no debe abrir la base de datos")
+	}
+
+	err := runCLI([]string{"report", "positions"})
+	if err == nil || !strings.Contains(err.Error(), "DATABASE_URL") {
+		t.Errorf("sin DATABASE_URL se esperaba un error al abrir la base de datos, se obtuvo %v", err)
+	}
+}
+
+func TestImportCommandProfile(t *testing.T) {
+	useTestDatabase(t)
+	file := filepath.Join(t.TempDir(), "extracto.csv")
+	if err := os.WriteFile(file, []byte("fecha;ticker\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"inexistente", "999"} {
+		err := runImportCommand([]string{"-format", "csv", "-profile", ref, "-dry-run", file})
+		if err == nil || !strings.Contains(err.Error(), "no encontrado") {
+			t.Errorf("-profile %s: se esperaba perfil no encontrado, se obtuvo %v", ref, err)
+		}
+	}
+
+	var profile ImportProfile
+	db.First(&profile)
+	if _, err := cliImportProfile(strings.ToUpper(profile.Name)); err != nil {
+		t.Errorf("el perfil %q debe encontrarse sin distinguir mayúsculas: %v", profile.Name, err)
+	}
+	if err := runImportCommand([]string{"-format", "ofx", "-profile", profile.Name, file}); err == nil {
+		t.Error("se esperaba un error al usar -profile con OFX")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Códigos de error del control de concurrencia en la API v1
+const (
+	APICodePreconditionFailed   = "precondition_failed"
+	APICodePreconditionRequired = "precondition_required"
+)
+
+// versionField es el campo oculto con la versión del registro en los formularios de edición.
+const versionField = "version"
+
+// staleHiddenFields no se muestran entre los valores actuales de un registro en conflicto.
+var staleHiddenFields = map[string]bool{"ID": true, "CreatedAt": true, "DeletedAt": true}
+
+// errStaleRecord indica que el registro cambió entre su lectura y su actualización.
+var errStaleRecord = errors.New("el registro fue modificado por otra petición")
+
+// recordVersion deriva la versión de un registro de su UpdatedAt. Se usan
+// microsegundos porque es la precisión que conserva PostgreSQL.
+func recordVersion(updatedAt time.Time) string {
+	return strconv.FormatInt(updatedAt.UnixMicro(), 10)
+}
+
+// recordETag devuelve la versión de un registro como ETag.
+func recordETag(updatedAt time.Time) string {
+	return `"` + recordVersion(updatedAt) + `"`
+}
+
+// setETag envía la versión del registro en la cabecera ETag.
+func setETag(c *gin.Context, updatedAt time.Time) {
+	c.Header("ETag", recordETag(updatedAt))
+}
+
+// recordUpdatedAt devuelve el UpdatedAt de los modelos con control de concurrencia.
+func recordUpdatedAt(record interface{}) (time.Time, bool) {
+	switch r := record.(type) {
+	case Ticker:
+		return r.UpdatedAt, true
+	case *Ticker:
+		return r.UpdatedAt, true
+	case Investment:
+		return r.UpdatedAt, true
+	case *Investment:
+		return r.UpdatedAt, true
+	case Sale:
+		return r.UpdatedAt, true
+	case *Sale:
+		return r.UpdatedAt, true
+	}
+	return time.Time{}, false
+}
+
+// ifMatches comprueba si un valor de If-Match (una lista de ETags o *)
+// incluye la versión actual. Se aceptan también ETags débiles y versiones sin comillas.
+func ifMatches(header string, updatedAt time.Time) bool {
+	current := recordVersion(updatedAt)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailedError crea el error de la API v1 cuando If-Match no
+// coincide (412). current es el estado actual del recurso y updatedAt su
+// versión, que se devuelve en la cabecera ETag.
+func preconditionFailedError(current interface{}, updatedAt time.Time) error {
+	return &apiError{
+		Status:  http.StatusPreconditionFailed,
+		Code:    APICodePreconditionFailed,
+		Message: "El registro ha cambiado desde que se leyó. Vuelve a cargarlo y repite los cambios.",
+		Current: current,
+		Version: updatedAt,
+	}
+}
+
+// preconditionRequiredError crea el error de la API v1 cuando falta If-Match (428).
+func preconditionRequiredError() error {
+	return &apiError{
+		Status:  http.StatusPreconditionRequired,
+		Code:    APICodePreconditionRequired,
+		Message: "Falta la cabecera If-Match con el ETag obtenido al leer el registro.",
+	}
+}
+
+// apiIfMatch lee la cabecera If-Match obligatoria de las actualizaciones de
+// la API v1 y responde 428 si falta.
+func apiIfMatch(c *gin.Context) (string, bool) {
+	version := strings.TrimSpace(c.GetHeader("If-Match"))
+	if version == "" {
+		respondAPIError(c, preconditionRequiredError())
+		return "", false
+	}
+	return version, true
+}
+
+// staleAPIError convierte errStaleRecord, una actualización concurrente
+// detectada al escribir, en el error 412 de la API v1 con el estado que dejó
+// la otra petición. current vuelve a leer el registro y su versión.
+func staleAPIError(err error, current func() (interface{}, time.Time, error)) error {
+	if !errors.Is(err, errStaleRecord) {
+		return err
+	}
+	record, updatedAt, err := current()
+	if err != nil {
+		return err
+	}
+	return preconditionFailedError(record, updatedAt)
+}
+
+// requireIfMatch comprueba la cabecera If-Match de las actualizaciones JSON
+// antiguas. Responde 428 si falta y 412 con el estado actual si no coincide.
+func requireIfMatch(c *gin.Context, updatedAt time.Time, current func() interface{}) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Falta la cabecera If-Match"})
+		return false
+	}
+	if !ifMatches(header, updatedAt) {
+		respondStaleJSON(c, updatedAt, current())
+		return false
+	}
+	return true
+}
+
+// respondStaleJSON responde 412 a las actualizaciones JSON antiguas con el
+// estado actual del registro y su versión en la cabecera ETag.
+func respondStaleJSON(c *gin.Context, updatedAt time.Time, current interface{}) {
+	setETag(c, updatedAt)
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "El registro ha cambiado desde que se abrió el formulario",
+		"current": current,
+	})
+}
+
+// requireFormVersion comprueba el campo oculto version de los formularios de
+// edición. Responde 428 si falta y 409 con los valores actuales si el
+// registro cambió desde que se mostró el formulario.
+func requireFormVersion(c *gin.Context, record interface{}) bool {
+	updatedAt, _ := recordUpdatedAt(record)
+	version := strings.TrimSpace(c.PostForm(versionField))
+	if version == "" {
+		c.String(http.StatusPreconditionRequired, "Falta la versión del registro. Recarga la página y vuelve a intentarlo.")
+		return false
+	}
+	if !ifMatches(version, updatedAt) {
+		c.String(http.StatusConflict, staleRecordMessage(record))
+		return false
+	}
+	return true
+}
+
+// respondStaleForm responde 409 con los valores actuales cuando otra petición
+// actualizó el registro a la vez que el formulario. record es un puntero vacío del modelo.
+func respondStaleForm(c *gin.Context, id uint, record interface{}) {
+	db.First(record, id)
+	c.String(http.StatusConflict, staleRecordMessage(record))
+}
+
+// respondFormError responde a un formulario con el error de un servicio: los
+// errores de la API (validación, recurso inexistente) con su estado y mensaje,
+// y el resto con 500 describiendo action. En las ediciones, record es un
+// puntero vacío del modelo del registro id para responder a los conflictos de
+// versión como respondStaleForm; en las altas y bajas puede ser nil.
+func respondFormError(c *gin.Context, err error, action string, id uint, record interface{}) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		c.String(http.StatusInternalServerError, "Error al %s: %v", action, err)
+		return
+	}
+	if apiErr.Code == APICodePreconditionFailed && record != nil {
+		respondStaleForm(c, id, record)
+		return
+	}
+	c.String(apiErr.Status, apiErr.Message)
+}
+
+// staleRecordMessage explica el conflicto y muestra los valores actuales del registro.
+func staleRecordMessage(record interface{}) string {
+	var b strings.Builder
+	b.WriteString("Otra persona modificó este registro mientras lo editabas y tus cambios no se han guardado.\n")
+	b.WriteString("Recarga la página y repite los cambios.\n\nValores actuales:\n")
+	fields, err := auditFields(record)
+	if err != nil {
+		return b.String()
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		// Se omiten los campos internos y los vacíos
+		if auditIgnoredFields[name] || staleHiddenFields[name] || auditValue(fields[name]) == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s: %s\n", name, auditValue(fields[name]))
+	}
+	return b.String()
+}
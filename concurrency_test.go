@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// simulateConcurrentWrite modifica la fila indicada justo después de la
+// próxima lectura de su tabla, como si otra petición se adelantara entre la
+// lectura y la escritura. Devuelve la versión que deja la otra petición.
+func simulateConcurrentWrite(t *testing.T, table string, id uint, column string, value interface{}) time.Time {
+	t.Helper()
+	version := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+	fired := false
+	err := db.Callback().Query().After("gorm:query").Register("test:concurrent_write", func(tx *gorm.DB) {
+		if fired || tx.Statement.Table != table {
+			return
+		}
+		fired = true
+		err := tx.Statement.DB.Session(&gorm.Session{NewDB: true}).
+			Exec("UPDATE "+table+" SET "+column+" = ?, updated_at = ? WHERE id = ?", value, version, id).Error
+		if err != nil {
+			t.Errorf("escritura concurrente: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Callback().Query().Remove("test:concurrent_write") })
+	return version
+}
+
+// TestStaleWriteReturnsCurrentRecord comprueba que, si otra petición cambia el
+// registro entre la comprobación de If-Match y la escritura, el 412 trae el
+// estado actual y su ETag.
+func TestStaleWriteReturnsCurrentRecord(t *testing.T) {
+	useTestDatabase(t)
+	router := newStrictAPIRouter(t)
+	registerAPIV1Routes(router)
+
+	var ticker Ticker
+	if err := db.First(&ticker, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	version := simulateConcurrentWrite(t, "tickers", ticker.ID, "current_price", 99)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/tickers/1", strings.NewReader(`{"name":"AAPL","current_price":200}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", recordETag(ticker.UpdatedAt))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("estado %d, se esperaba 412: %s", w.Code, w.Body.String())
+	}
+	if mismatch := w.Header().Get(openAPIMismatchHeader); mismatch != "" {
+		t.Errorf("la respuesta no cumple openapi.yaml: %s", mismatch)
+	}
+	if got, want := w.Header().Get("ETag"), recordETag(version); got != want {
+		t.Errorf("ETag %s, se esperaba %s", got, want)
+	}
+	var resp struct {
+		Current APITicker `json:"current"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Current.CurrentPrice.Equal(decimal.NewFromInt(99)) {
+		t.Errorf("current trae el precio %s, se esperaba el de la otra petición (99)", resp.Current.CurrentPrice)
+	}
+}
+
+func TestStaleSaleUpdateReturnsCurrentSale(t *testing.T) {
+	useTestDatabase(t)
+
+	sale, err := createSale(db, "test", SaleInput{TickerID: 1, SaleDate: "2023-06-01",
+		Shares: decimal.NewFromInt(2), SalePrice: decimal.NewFromInt(180)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := simulateConcurrentWrite(t, "sales", sale.ID, "shares", 3)
+
+	_, err = updateSale(db, "test", sale.ID, "", SaleInput{TickerID: 1, SaleDate: "2023-06-01",
+		Shares: decimal.NewFromInt(1), SalePrice: decimal.NewFromInt(180)})
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusPreconditionFailed {
+		t.Fatalf("se esperaba un 412, se obtuvo %v", err)
+	}
+	current, ok := apiErr.Current.(APISale)
+	if !ok {
+		t.Fatalf("current es %T, se esperaba APISale", apiErr.Current)
+	}
+	if !current.Shares.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("current trae %s acciones, se esperaban las 3 de la otra petición", current.Shares)
+	}
+	if !apiErr.Version.Equal(version) {
+		t.Errorf("versión %s, se esperaba %s", apiErr.Version, version)
+	}
+}
+
+// TestRespondFormError comprueba cómo responden los formularios a los errores
+// de los servicios.
+func TestRespondFormError(t *testing.T) {
+	useTestDatabase(t)
+	var inv Investment
+	db.First(&inv, 3)
+
+	cases := []struct {
+		name   string
+		err    error
+		record interface{}
+		status int
+		body   string
+	}{
+		{"no encontrada", deleteInvestment(db, "test", 999), nil, http.StatusNotFound, "Compra no encontrada"},
+		{"descubierto", validationError("La venta 1 dejaría la posición con acciones negativas."), nil, http.StatusUnprocessableEntity, "acciones negativas"},
+		{"versión", preconditionFailedError(newAPIInvestment(inv), inv.UpdatedAt), &Investment{}, http.StatusConflict, "Valores actuales"},
+		{"interno", errors.New("disco lleno"), nil, http.StatusInternalServerError, "Error al guardar: disco lleno"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		respondFormError(c, tc.err, "guardar", inv.ID, tc.record)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: estado %d con %q, se esperaba %d con %q", tc.name, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+	}
+}
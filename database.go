@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Motores de base de datos soportados
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// databaseDialector elige el motor según el esquema de DATABASE_URL:
+// postgres:// o postgresql:// para Postgres y sqlite://, sqlite: o file: para
+// SQLite (por ejemplo sqlite://portfolio.db o sqlite://:memory:).
+func databaseDialector(dsn string) (gorm.Dialector, error) {
+	lower := strings.ToLower(dsn)
+	switch {
+	case strings.HasPrefix(lower, "postgres://"), strings.HasPrefix(lower, "postgresql://"):
+		// Desactivar prepared statements (necesario para connection pooler de Supabase)
+		if !strings.Contains(dsn, "prepared_statements") {
+			if strings.Contains(dsn, "?") {
+				dsn += "&prepare=false"
+			} else {
+				dsn += "?prepare=false"
+			}
+		}
+		return postgres.New(postgres.Config{
+			DSN:                  dsn,
+			PreferSimpleProtocol: true, // Desactiva prepared statements
+		}), nil
+
+	case strings.HasPrefix(lower, "sqlite:"), strings.HasPrefix(lower, "file:"):
+		path := dsn
+		if strings.HasPrefix(lower, "sqlite:") {
+			path = strings.TrimPrefix(dsn[len("sqlite:"):], "//")
+		}
+		if path == "" {
+			return nil, fmt.Errorf("falta la ruta del fichero SQLite en DATABASE_URL")
+		}
+		// Activar claves foráneas y esperar en lugar de fallar si la base está bloqueada
+		if !strings.Contains(path, "_busy_timeout") {
+			if strings.Contains(path, "?") {
+				path += "&_busy_timeout=5000&_foreign_keys=1"
+			} else {
+				path += "?_busy_timeout=5000&_foreign_keys=1"
+			}
+		}
+		return sqlite.Open(path), nil
+	}
+
+	return nil, fmt.Errorf("esquema de DATABASE_URL no soportado (use postgres:// o sqlite://)")
+}
+
+// isSQLite indica si la conexión usa SQLite, para las migraciones que
+// necesitan DDL específico de cada motor.
+func isSQLite(database *gorm.DB) bool {
+	return database.Dialector.Name() == DialectSQLite
+}
+
+// columnTypes devuelve los tipos de columna equivalentes en cada motor para
+// las tablas creadas con DDL explícito.
+func columnTypes(database *gorm.DB) (primaryKey, timestamp, number, foreignKey string) {
+	if isSQLite(database) {
+		return "integer PRIMARY KEY AUTOINCREMENT", "datetime", "real", "integer"
+	}
+	return "bigserial PRIMARY KEY", "timestamptz", "decimal", "bigint"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+)
+
+func TestDatabaseDialector(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		dialect string
+		want    string // DSN final que recibe el driver
+	}{
+		{"postgres://u:p@localhost/bolsa", DialectPostgres, "postgres://u:p@localhost/bolsa?prepare=false"},
+		{"postgresql://localhost/bolsa?sslmode=disable", DialectPostgres, "postgresql://localhost/bolsa?sslmode=disable&prepare=false"},
+		{"sqlite://:memory:", DialectSQLite, ":memory:?_busy_timeout=5000&_foreign_keys=1"},
+		{"sqlite:portfolio.db", DialectSQLite, "portfolio.db?_busy_timeout=5000&_foreign_keys=1"},
+		{"SQLITE:///tmp/bolsa.db?cache=shared", DialectSQLite, "/tmp/bolsa.db?cache=shared&_busy_timeout=5000&_foreign_keys=1"},
+		{"file:bolsa.db?_busy_timeout=100", DialectSQLite, "file:bolsa.db?_busy_timeout=100"},
+	}
+	for _, tt := range tests {
+		dialector, err := databaseDialector(tt.dsn)
+		if err != nil {
+			t.Errorf("%s: error inesperado: %v", tt.dsn, err)
+			continue
+		}
+		if dialector.Name() != tt.dialect {
+			t.Errorf("%s: motor %q, se esperaba %q", tt.dsn, dialector.Name(), tt.dialect)
+		}
+		var got string
+		switch d := dialector.(type) {
+		case *postgres.Dialector:
+			got = d.Config.DSN
+		case *sqlite.Dialector:
+			got = d.DSN
+		}
+		if got != tt.want {
+			t.Errorf("%s: DSN %q, se esperaba %q", tt.dsn, got, tt.want)
+		}
+	}
+
+	for _, dsn := range []string{"mysql://localhost/bolsa", "sqlite://", "bolsa.db"} {
+		if _, err := databaseDialector(dsn); err == nil {
+			t.Errorf("%s: se esperaba un error", dsn)
+		}
+	}
+}
+
+func TestSetupDatabaseRequiresURL(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	if _, err := setupDatabase(false); err == nil || !strings.Contains(err.Error(), "DATABASE_URL") {
+		t.Errorf("se esperaba un error por falta de DATABASE_URL, se obtuvo %v", err)
+	}
+}
+
+// TestSetupDatabaseMigratesEmptySQLite aplica todas las migraciones, incluidos
+// los datos de ejemplo, sobre una base vacía.
+func TestSetupDatabaseMigratesEmptySQLite(t *testing.T) {
+	useTestDatabase(t)
+
+	statuses, err := migrationStatus(db)
+	if err != nil {
+		t.Fatalf("migrationStatus: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("no se encontraron migraciones")
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("la migración %s no se aplicó", s.Version)
+		}
+	}
+
+	var names []string
+	if err := db.Model(&Ticker{}).Order("name").Pluck("name", &names).Error; err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "AAPL,GOOGL,MSFT" {
+		t.Errorf("tickers de ejemplo %v, se esperaba [AAPL GOOGL MSFT]", names)
+	}
+	var investments int64
+	if err := db.Model(&Investment{}).Count(&investments).Error; err != nil {
+		t.Fatal(err)
+	}
+	if investments != 4 {
+		t.Errorf("%d inversiones de ejemplo, se esperaban 4", investments)
+	}
+
+	// Volver a ejecutarlas no aplica nada
+	done, err := migrateUp(db, "")
+	if err != nil || len(done) != 0 {
+		t.Errorf("la segunda ejecución aplicó %v (error %v)", done, err)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	useTestDatabase(t)
+
+	// Revertir hasta 010_add_dividends_and_external_ids incluida
+	reverted, err := migrateDown(db, 5)
+	if err != nil {
+		t.Fatalf("migrateDown: %v", err)
+	}
+	if len(reverted) != 5 || reverted[4] != "010_add_dividends_and_external_ids" {
+		t.Fatalf("se revirtieron %v, se esperaban 014 a 010", reverted)
+	}
+
+	applied, err := migrateUp(db, "")
+	if err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+	for i, version := range applied {
+		if version != reverted[len(reverted)-1-i] {
+			t.Fatalf("se aplicaron %v tras revertir %v", applied, reverted)
+		}
+	}
+	if len(applied) != len(reverted) {
+		t.Errorf("se aplicaron %v tras revertir %v", applied, reverted)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Dividend representa un cobro de dividendos de un ticker.
+type Dividend struct {
+	gorm.Model
+	TickerID    uint
+	Ticker      Ticker `gorm:"foreignKey:TickerID"`
+	PaymentDate time.Time
+	Amount      decimal.Decimal `gorm:"type:numeric"` // Importe bruto
+	WithheldTax decimal.Decimal `gorm:"type:numeric"`
+	Currency    string
+	ExternalID  string `gorm:"index"` // Identificador de la operación en el extracto importado (FITID)
+}
+
+// DividendView representa un dividendo para mostrar en la UI.
+type DividendView struct {
+	ID          uint
+	PaymentDate string
+	Amount      decimal.Decimal
+	WithheldTax decimal.Decimal
+	NetAmount   decimal.Decimal
+	Currency    string
+}
+
+// getTickerDividends devuelve los dividendos de un ticker y el total neto cobrado.
+func getTickerDividends(tickerID uint) ([]DividendView, decimal.Decimal) {
+	var dividends []Dividend
+	db.Where("ticker_id = ?", tickerID).Order("payment_date desc").Find(&dividends)
+
+	var views []DividendView
+	var totalNet decimal.Decimal
+	for _, d := range dividends {
+		net := d.Amount.Sub(d.WithheldTax)
+		views = append(views, DividendView{
+			ID:          d.ID,
+			PaymentDate: d.PaymentDate.Format("02 Jan 2006"),
+			Amount:      d.Amount,
+			WithheldTax: d.WithheldTax,
+			NetAmount:   net,
+			Currency:    d.Currency,
+		})
+		totalNet = totalNet.Add(net)
+	}
+	return views, totalNet
+}
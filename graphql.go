@@ -0,0 +1,779 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+//go:embed schema.graphql
+var graphQLSchemaSDL string
+
+// graphQLMaxDepth limita el anidamiento de las consultas (ticker → compras → ticker → ...).
+const graphQLMaxDepth = 10
+
+// graphQLSchema es el esquema ejecutable, creado en registerGraphQLRoutes.
+var graphQLSchema *graphql.Schema
+
+// GraphQLRequest es el cuerpo de una petición POST /api/graphql.
+type GraphQLRequest struct {
+	Query         string                 `json:"query" form:"query"`
+	OperationName string                 `json:"operationName" form:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// --- ESCALARES ---
+
+// gqlDecimal es el escalar Decimal; se serializa como número igual que la API REST.
+type gqlDecimal struct {
+	decimal.Decimal
+}
+
+func (gqlDecimal) ImplementsGraphQLType(name string) bool { return name == "Decimal" }
+
+func (d *gqlDecimal) UnmarshalGraphQL(input interface{}) error {
+	var err error
+	switch v := input.(type) {
+	case string:
+		d.Decimal, err = decimal.NewFromString(v)
+	case float64:
+		d.Decimal = decimal.NewFromFloat(v)
+	case int32:
+		d.Decimal = decimal.NewFromInt32(v)
+	default:
+		err = fmt.Errorf("valor Decimal inválido: %v", input)
+	}
+	return err
+}
+
+func newGQLDecimal(d decimal.Decimal) gqlDecimal { return gqlDecimal{d} }
+
+// parseGraphQLID convierte un ID de GraphQL en el ID numérico de la base de datos.
+func parseGraphQLID(id graphql.ID) (uint, error) {
+	n, err := strconv.ParseUint(string(id), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("ID inválido: %q", id)
+	}
+	return uint(n), nil
+}
+
+func graphQLID(id uint) graphql.ID { return graphql.ID(strconv.FormatUint(uint64(id), 10)) }
+
+// --- CARGA POR LOTES ---
+
+// batchLoader agrupa las lecturas de una petición al estilo dataloader. Las
+// listas registran con Prime las claves de sus elementos y la primera Load trae
+// todas las pendientes en una sola consulta, en lugar de una por elemento. Los
+// resultados quedan en caché hasta el final de la petición; una clave sin
+// resultado devuelve el valor cero.
+type batchLoader[K comparable, V any] struct {
+	mu      sync.Mutex
+	fetch   func(keys []K) (map[K]V, error)
+	pending []K
+	queued  map[K]bool
+	cache   map[K]V
+}
+
+func newBatchLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *batchLoader[K, V] {
+	return &batchLoader[K, V]{fetch: fetch, queued: make(map[K]bool), cache: make(map[K]V)}
+}
+
+// Prime registra claves que se van a pedir para cargarlas en el mismo lote.
+func (l *batchLoader[K, V]) Prime(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queue(keys...)
+}
+
+func (l *batchLoader[K, V]) queue(keys ...K) {
+	for _, k := range keys {
+		if _, cached := l.cache[k]; !cached && !l.queued[k] {
+			l.queued[k] = true
+			l.pending = append(l.pending, k)
+		}
+	}
+}
+
+// Set guarda un valor ya obtenido por otra consulta.
+func (l *batchLoader[K, V]) Set(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cache[key] = value
+}
+
+// Load devuelve el valor de una clave, cargando a la vez todas las pendientes.
+func (l *batchLoader[K, V]) Load(key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v, ok := l.cache[key]; ok {
+		return v, nil
+	}
+
+	l.queue(key)
+	keys := l.pending
+	l.pending = nil
+	l.queued = make(map[K]bool)
+
+	values, err := l.fetch(keys)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	for _, k := range keys {
+		l.cache[k] = values[k]
+	}
+	return l.cache[key], nil
+}
+
+// tickerPosition es el resultado de reproducir las operaciones de un ticker.
+type tickerPosition struct {
+	State    PositionState
+	SaleWACs map[uint]decimal.Decimal // WAC en el momento de cada venta
+}
+
+// graphQLLoaders son los cargadores de una petición GraphQL.
+type graphQLLoaders struct {
+	tickers        *batchLoader[uint, *Ticker]         // Por ID, incluidos los de la papelera
+	investments    *batchLoader[uint, []Investment]    // Por ticker
+	sales          *batchLoader[uint, []Sale]          // Por ticker
+	positions      *batchLoader[uint, *tickerPosition] // Por ticker; nil si no tiene operaciones
+	priceHistory   *batchLoader[uint, []PriceHistory]  // Por ticker
+	snapshotPrices *batchLoader[string, []PriceHistory]
+}
+
+type graphQLLoadersKey struct{}
+
+func newGraphQLLoaders() *graphQLLoaders {
+	l := &graphQLLoaders{}
+	l.tickers = newBatchLoader(func(ids []uint) (map[uint]*Ticker, error) {
+		var list []Ticker
+		if err := db.Unscoped().Where("id IN ?", ids).Find(&list).Error; err != nil {
+			return nil, err
+		}
+		result := make(map[uint]*Ticker, len(list))
+		for i := range list {
+			result[list[i].ID] = &list[i]
+		}
+		return result, nil
+	})
+	l.investments = newBatchLoader(func(tickerIDs []uint) (map[uint][]Investment, error) {
+		var list []Investment
+		if err := db.Where("ticker_id IN ?", tickerIDs).Order("purchase_date desc, id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		result := make(map[uint][]Investment)
+		for _, inv := range list {
+			result[inv.TickerID] = append(result[inv.TickerID], inv)
+		}
+		return result, nil
+	})
+	l.sales = newBatchLoader(func(tickerIDs []uint) (map[uint][]Sale, error) {
+		var list []Sale
+		if err := db.Where("ticker_id IN ?", tickerIDs).Order("sale_date desc, id desc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		result := make(map[uint][]Sale)
+		for _, s := range list {
+			result[s.TickerID] = append(result[s.TickerID], s)
+		}
+		return result, nil
+	})
+	// Las posiciones se calculan con las compras y ventas de sus propios
+	// cargadores, así que comparten las consultas con los campos investments y sales.
+	l.positions = newBatchLoader(func(tickerIDs []uint) (map[uint]*tickerPosition, error) {
+		l.investments.Prime(tickerIDs...)
+		l.sales.Prime(tickerIDs...)
+		result := make(map[uint]*tickerPosition)
+		for _, id := range tickerIDs {
+			investments, err := l.investments.Load(id)
+			if err != nil {
+				return nil, err
+			}
+			sales, err := l.sales.Load(id)
+			if err != nil {
+				return nil, err
+			}
+			if len(investments) == 0 && len(sales) == 0 {
+				continue
+			}
+
+			events := make([]positionEvent, 0, len(investments)+len(sales))
+			for _, inv := range investments {
+				events = append(events, investmentEvent(inv))
+			}
+			for _, s := range sales {
+				events = append(events, saleEvent(s))
+			}
+			state, wacs := replayEventsWithSales(events)
+			result[id] = &tickerPosition{State: state, SaleWACs: wacs}
+		}
+		return result, nil
+	})
+	l.priceHistory = newBatchLoader(func(tickerIDs []uint) (map[uint][]PriceHistory, error) {
+		var list []PriceHistory
+		if err := db.Where("ticker_id IN ?", tickerIDs).Order("created_at asc, id asc").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		result := make(map[uint][]PriceHistory)
+		for _, ph := range list {
+			result[ph.TickerID] = append(result[ph.TickerID], ph)
+		}
+		return result, nil
+	})
+	l.snapshotPrices = newBatchLoader(func(snapshotIDs []string) (map[string][]PriceHistory, error) {
+		var list []PriceHistory
+		if err := db.Where("snapshot_id IN ?", snapshotIDs).Order("ticker_id").Find(&list).Error; err != nil {
+			return nil, err
+		}
+		result := make(map[string][]PriceHistory)
+		for _, ph := range list {
+			result[ph.SnapshotID] = append(result[ph.SnapshotID], ph)
+		}
+		return result, nil
+	})
+	return l
+}
+
+// loadersFrom obtiene los cargadores de la petición en curso.
+func loadersFrom(ctx context.Context) *graphQLLoaders {
+	return ctx.Value(graphQLLoadersKey{}).(*graphQLLoaders)
+}
+
+// primeTickers registra los tickers de una lista en todos los cargadores por ticker.
+func (l *graphQLLoaders) primeTickers(ids ...uint) {
+	l.tickers.Prime(ids...)
+	l.investments.Prime(ids...)
+	l.sales.Prime(ids...)
+	l.positions.Prime(ids...)
+	l.priceHistory.Prime(ids...)
+}
+
+// ticker carga un ticker que debe existir, como el de una operación.
+func (l *graphQLLoaders) ticker(id uint) (*Ticker, error) {
+	t, err := l.tickers.Load(id)
+	if err == nil && t == nil {
+		err = fmt.Errorf("ticker %d no encontrado", id)
+	}
+	return t, err
+}
+
+// --- RESOLVERS ---
+
+// graphQLResolver resuelve los campos de Query.
+type graphQLResolver struct{}
+
+func (r *graphQLResolver) Tickers(ctx context.Context) ([]*tickerResolver, error) {
+	var list []Ticker
+	if err := db.Order("name").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return newTickerResolvers(loadersFrom(ctx), list), nil
+}
+
+func (r *graphQLResolver) Ticker(ctx context.Context, args struct {
+	ID   *graphql.ID
+	Name *string
+}) (*tickerResolver, error) {
+	query := db
+	switch {
+	case args.ID != nil:
+		id, err := parseGraphQLID(*args.ID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id = ?", id)
+	case args.Name != nil:
+		query = query.Where("name = ?", *args.Name)
+	default:
+		return nil, errors.New("indica id o name")
+	}
+
+	var t Ticker
+	if err := query.First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return newTickerResolvers(loadersFrom(ctx), []Ticker{t})[0], nil
+}
+
+// tradeArgs son los filtros comunes de investments y sales.
+type tradeArgs struct {
+	TickerID *graphql.ID
+	From     *graphql.Time
+	To       *graphql.Time
+	Limit    *int32
+}
+
+// apply añade los filtros a la consulta sobre la columna de fecha indicada.
+func (a tradeArgs) apply(query *gorm.DB, dateColumn string) (*gorm.DB, error) {
+	if a.TickerID != nil {
+		id, err := parseGraphQLID(*a.TickerID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("ticker_id = ?", id)
+	}
+	if a.From != nil {
+		query = query.Where(dateColumn+" >= ?", a.From.Time)
+	}
+	if a.To != nil {
+		query = query.Where(dateColumn+" <= ?", a.To.Time)
+	}
+	if a.Limit != nil {
+		query = query.Limit(int(*a.Limit))
+	}
+	return query.Order(dateColumn + " desc, id desc"), nil
+}
+
+func (r *graphQLResolver) Investments(ctx context.Context, args tradeArgs) ([]*investmentResolver, error) {
+	query, err := args.apply(db, "purchase_date")
+	if err != nil {
+		return nil, err
+	}
+	var list []Investment
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return newInvestmentResolvers(loadersFrom(ctx), list), nil
+}
+
+func (r *graphQLResolver) Sales(ctx context.Context, args tradeArgs) ([]*saleResolver, error) {
+	query, err := args.apply(db, "sale_date")
+	if err != nil {
+		return nil, err
+	}
+	var list []Sale
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return newSaleResolvers(loadersFrom(ctx), list), nil
+}
+
+func (r *graphQLResolver) Positions(ctx context.Context, args struct {
+	MinPerformance *float64
+	MaxPerformance *float64
+	IncludeClosed  bool
+}) ([]*positionResolver, error) {
+	// Todo ticker con operaciones tiene al menos una compra
+	var tickerIDs []uint
+	if err := db.Model(&Investment{}).Distinct().Pluck("ticker_id", &tickerIDs).Error; err != nil {
+		return nil, err
+	}
+	loaders := loadersFrom(ctx)
+	loaders.primeTickers(tickerIDs...)
+
+	var positions []*positionResolver
+	for _, id := range tickerIDs {
+		p, err := loadPosition(loaders, id)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil || (!args.IncludeClosed && !p.position.State.Shares.IsPositive()) {
+			continue
+		}
+		performance := p.Performance()
+		if args.MinPerformance != nil && performance < *args.MinPerformance {
+			continue
+		}
+		if args.MaxPerformance != nil && performance > *args.MaxPerformance {
+			continue
+		}
+		positions = append(positions, p)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].ticker.Name < positions[j].ticker.Name })
+	return positions, nil
+}
+
+func (r *graphQLResolver) Snapshots(ctx context.Context, args struct{ Limit *int32 }) ([]*snapshotResolver, error) {
+	snapshots, err := listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if args.Limit != nil && int(*args.Limit) >= 0 && int(*args.Limit) < len(snapshots) {
+		snapshots = snapshots[:*args.Limit]
+	}
+
+	loaders := loadersFrom(ctx)
+	resolvers := make([]*snapshotResolver, 0, len(snapshots))
+	for _, s := range snapshots {
+		loaders.snapshotPrices.Prime(s.SnapshotID)
+		resolvers = append(resolvers, &snapshotResolver{summary: s})
+	}
+	return resolvers, nil
+}
+
+func (r *graphQLResolver) Snapshot(ctx context.Context, args struct{ ID graphql.ID }) (*snapshotResolver, error) {
+	prices, err := loadersFrom(ctx).snapshotPrices.Load(string(args.ID))
+	if err != nil || len(prices) == 0 {
+		return nil, err
+	}
+	summary := SnapshotSummary{SnapshotID: string(args.ID), CreatedAt: prices[0].CreatedAt, Count: int64(len(prices))}
+	for _, ph := range prices {
+		if ph.CreatedAt.Before(summary.CreatedAt) {
+			summary.CreatedAt = ph.CreatedAt
+		}
+	}
+	return &snapshotResolver{summary: summary}, nil
+}
+
+// tickerResolver resuelve un Ticker.
+type tickerResolver struct {
+	t Ticker
+}
+
+// newTickerResolvers prepara una lista de tickers y registra sus IDs para
+// cargar en lote las compras, ventas, posiciones e históricos que se pidan.
+func newTickerResolvers(loaders *graphQLLoaders, list []Ticker) []*tickerResolver {
+	resolvers := make([]*tickerResolver, 0, len(list))
+	ids := make([]uint, 0, len(list))
+	for i := range list {
+		loaders.tickers.Set(list[i].ID, &list[i])
+		ids = append(ids, list[i].ID)
+		resolvers = append(resolvers, &tickerResolver{t: list[i]})
+	}
+	loaders.primeTickers(ids...)
+	return resolvers
+}
+
+func (r *tickerResolver) ID() graphql.ID             { return graphQLID(r.t.ID) }
+func (r *tickerResolver) Name() string               { return r.t.Name }
+func (r *tickerResolver) CurrentPrice() gqlDecimal   { return newGQLDecimal(r.t.CurrentPrice) }
+func (r *tickerResolver) ISIN() string               { return r.t.ISIN }
+func (r *tickerResolver) ExchangeMIC() string        { return r.t.ExchangeMIC }
+func (r *tickerResolver) Currency() string           { return r.t.Currency }
+func (r *tickerResolver) Sector() string             { return r.t.Sector }
+func (r *tickerResolver) Industry() string           { return r.t.Industry }
+func (r *tickerResolver) Country() string            { return r.t.Country }
+func (r *tickerResolver) AssetClass() string         { return r.t.AssetClass }
+func (r *tickerResolver) YahooFinanceTicker() string { return r.t.YahooFinanceTicker }
+func (r *tickerResolver) CreatedAt() graphql.Time    { return graphql.Time{Time: r.t.CreatedAt} }
+func (r *tickerResolver) UpdatedAt() graphql.Time    { return graphql.Time{Time: r.t.UpdatedAt} }
+
+func (r *tickerResolver) Investments(ctx context.Context) ([]*investmentResolver, error) {
+	loaders := loadersFrom(ctx)
+	list, err := loaders.investments.Load(r.t.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newInvestmentResolvers(loaders, list), nil
+}
+
+func (r *tickerResolver) Sales(ctx context.Context) ([]*saleResolver, error) {
+	loaders := loadersFrom(ctx)
+	list, err := loaders.sales.Load(r.t.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newSaleResolvers(loaders, list), nil
+}
+
+func (r *tickerResolver) Position(ctx context.Context) (*positionResolver, error) {
+	return loadPosition(loadersFrom(ctx), r.t.ID)
+}
+
+func (r *tickerResolver) PriceHistory(ctx context.Context, args struct{ Last *int32 }) ([]*pricePointResolver, error) {
+	list, err := loadersFrom(ctx).priceHistory.Load(r.t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if args.Last != nil && int(*args.Last) >= 0 && int(*args.Last) < len(list) {
+		list = list[len(list)-int(*args.Last):]
+	}
+	resolvers := make([]*pricePointResolver, 0, len(list))
+	for _, ph := range list {
+		resolvers = append(resolvers, &pricePointResolver{ph: ph})
+	}
+	return resolvers, nil
+}
+
+// investmentResolver resuelve una compra; los campos valorados usan el
+// ticker, que se carga en lote con el de las demás compras de la lista.
+type investmentResolver struct {
+	inv Investment
+}
+
+func newInvestmentResolvers(loaders *graphQLLoaders, list []Investment) []*investmentResolver {
+	resolvers := make([]*investmentResolver, 0, len(list))
+	for _, inv := range list {
+		loaders.tickers.Prime(inv.TickerID)
+		resolvers = append(resolvers, &investmentResolver{inv: inv})
+	}
+	return resolvers
+}
+
+func (r *investmentResolver) view(ctx context.Context) (APIInvestment, error) {
+	t, err := loadersFrom(ctx).ticker(r.inv.TickerID)
+	if err != nil {
+		return APIInvestment{}, err
+	}
+	inv := r.inv
+	inv.Ticker = *t
+	return newAPIInvestment(inv), nil
+}
+
+func (r *investmentResolver) ID() graphql.ID { return graphQLID(r.inv.ID) }
+func (r *investmentResolver) PurchaseDate() graphql.Time {
+	return graphql.Time{Time: r.inv.PurchaseDate}
+}
+func (r *investmentResolver) Shares() gqlDecimal        { return newGQLDecimal(r.inv.Shares) }
+func (r *investmentResolver) PurchasePrice() gqlDecimal { return newGQLDecimal(r.inv.PurchasePrice) }
+func (r *investmentResolver) OperationCost() gqlDecimal { return newGQLDecimal(r.inv.OperationCost) }
+
+func (r *investmentResolver) Ticker(ctx context.Context) (*tickerResolver, error) {
+	t, err := loadersFrom(ctx).ticker(r.inv.TickerID)
+	if err != nil {
+		return nil, err
+	}
+	return &tickerResolver{t: *t}, nil
+}
+
+func (r *investmentResolver) InvestedCapital(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.InvestedCapital), err
+}
+
+func (r *investmentResolver) CurrentPrice(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.CurrentPrice), err
+}
+
+func (r *investmentResolver) CurrentValue(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.CurrentValue), err
+}
+
+func (r *investmentResolver) ProfitLoss(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.ProfitLoss), err
+}
+
+func (r *investmentResolver) Performance(ctx context.Context) (float64, error) {
+	v, err := r.view(ctx)
+	return v.Performance, err
+}
+
+// saleResolver resuelve una venta; el WAC del momento sale de la posición de
+// su ticker, que se reproduce una sola vez para todas sus ventas.
+type saleResolver struct {
+	sale Sale
+}
+
+func newSaleResolvers(loaders *graphQLLoaders, list []Sale) []*saleResolver {
+	resolvers := make([]*saleResolver, 0, len(list))
+	for _, s := range list {
+		loaders.tickers.Prime(s.TickerID)
+		loaders.positions.Prime(s.TickerID)
+		resolvers = append(resolvers, &saleResolver{sale: s})
+	}
+	return resolvers
+}
+
+func (r *saleResolver) view(ctx context.Context) (APISale, error) {
+	loaders := loadersFrom(ctx)
+	t, err := loaders.ticker(r.sale.TickerID)
+	if err != nil {
+		return APISale{}, err
+	}
+	position, err := loaders.positions.Load(r.sale.TickerID)
+	if err != nil {
+		return APISale{}, err
+	}
+	wac := decimal.Zero
+	if position != nil {
+		wac = position.SaleWACs[r.sale.ID]
+	}
+	s := r.sale
+	s.Ticker = *t
+	return newAPISale(s, wac), nil
+}
+
+func (r *saleResolver) ID() graphql.ID            { return graphQLID(r.sale.ID) }
+func (r *saleResolver) SaleDate() graphql.Time    { return graphql.Time{Time: r.sale.SaleDate} }
+func (r *saleResolver) Shares() gqlDecimal        { return newGQLDecimal(r.sale.Shares) }
+func (r *saleResolver) SalePrice() gqlDecimal     { return newGQLDecimal(r.sale.SalePrice) }
+func (r *saleResolver) OperationCost() gqlDecimal { return newGQLDecimal(r.sale.OperationCost) }
+func (r *saleResolver) WithheldTax() gqlDecimal   { return newGQLDecimal(r.sale.WithheldTax) }
+
+func (r *saleResolver) Ticker(ctx context.Context) (*tickerResolver, error) {
+	t, err := loadersFrom(ctx).ticker(r.sale.TickerID)
+	if err != nil {
+		return nil, err
+	}
+	return &tickerResolver{t: *t}, nil
+}
+
+func (r *saleResolver) TotalSaleValue(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.TotalSaleValue), err
+}
+
+func (r *saleResolver) WACAtSale(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.WACAtSale), err
+}
+
+func (r *saleResolver) Profit(ctx context.Context) (gqlDecimal, error) {
+	v, err := r.view(ctx)
+	return newGQLDecimal(v.Profit), err
+}
+
+func (r *saleResolver) SalePerformance(ctx context.Context) (float64, error) {
+	v, err := r.view(ctx)
+	return v.SalePerformance, err
+}
+
+// positionResolver resuelve la posición de un ticker con los mismos cálculos
+// que el resumen del dashboard.
+type positionResolver struct {
+	ticker   *Ticker
+	position *tickerPosition
+}
+
+// loadPosition carga la posición de un ticker; nil si no tiene operaciones.
+func loadPosition(loaders *graphQLLoaders, tickerID uint) (*positionResolver, error) {
+	position, err := loaders.positions.Load(tickerID)
+	if err != nil || position == nil {
+		return nil, err
+	}
+	t, err := loaders.ticker(tickerID)
+	if err != nil {
+		return nil, err
+	}
+	return &positionResolver{ticker: t, position: position}, nil
+}
+
+func (r *positionResolver) Ticker() *tickerResolver  { return &tickerResolver{t: *r.ticker} }
+func (r *positionResolver) Shares() gqlDecimal       { return newGQLDecimal(r.position.State.Shares) }
+func (r *positionResolver) WAC() gqlDecimal          { return newGQLDecimal(roundPrice(r.position.State.WAC())) }
+func (r *positionResolver) CurrentPrice() gqlDecimal { return newGQLDecimal(r.ticker.CurrentPrice) }
+
+func (r *positionResolver) CostBasis() gqlDecimal {
+	return newGQLDecimal(roundMoney(r.position.State.Capital, r.ticker.Currency))
+}
+
+func (r *positionResolver) CurrentValue() gqlDecimal {
+	return newGQLDecimal(roundMoney(r.position.State.Shares.Mul(r.ticker.CurrentPrice), r.ticker.Currency))
+}
+
+func (r *positionResolver) ProfitLoss() gqlDecimal {
+	return newGQLDecimal(r.CurrentValue().Sub(r.CostBasis().Decimal))
+}
+
+func (r *positionResolver) Performance() float64 {
+	if !r.position.State.Shares.IsPositive() {
+		return 0
+	}
+	return percentChange(r.position.State.WAC(), r.ticker.CurrentPrice)
+}
+
+func (r *positionResolver) RealizedProfit(ctx context.Context) (gqlDecimal, error) {
+	sales, err := loadersFrom(ctx).sales.Load(r.ticker.ID)
+	if err != nil {
+		return gqlDecimal{}, err
+	}
+	total := decimal.Zero
+	for _, s := range sales {
+		total = total.Add(newSaleView(s, r.ticker.Name, r.position.SaleWACs[s.ID], r.ticker.Currency).Profit)
+	}
+	return newGQLDecimal(total), nil
+}
+
+// pricePointResolver resuelve el precio de un ticker en un snapshot.
+type pricePointResolver struct {
+	ph PriceHistory
+}
+
+func (r *pricePointResolver) SnapshotID() graphql.ID  { return graphql.ID(r.ph.SnapshotID) }
+func (r *pricePointResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.ph.CreatedAt} }
+func (r *pricePointResolver) Price() gqlDecimal       { return newGQLDecimal(r.ph.Price) }
+
+func (r *pricePointResolver) Ticker(ctx context.Context) (*tickerResolver, error) {
+	t, err := loadersFrom(ctx).ticker(r.ph.TickerID)
+	if err != nil {
+		return nil, err
+	}
+	return &tickerResolver{t: *t}, nil
+}
+
+// snapshotResolver resuelve un snapshot; sus precios se cargan en lote con
+// los de los demás snapshots de la lista.
+type snapshotResolver struct {
+	summary SnapshotSummary
+}
+
+func (r *snapshotResolver) ID() graphql.ID          { return graphql.ID(r.summary.SnapshotID) }
+func (r *snapshotResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.summary.CreatedAt} }
+
+func (r *snapshotResolver) Prices(ctx context.Context) ([]*pricePointResolver, error) {
+	loaders := loadersFrom(ctx)
+	list, err := loaders.snapshotPrices.Load(r.summary.SnapshotID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*pricePointResolver, 0, len(list))
+	for _, ph := range list {
+		loaders.tickers.Prime(ph.TickerID)
+		resolvers = append(resolvers, &pricePointResolver{ph: ph})
+	}
+	return resolvers, nil
+}
+
+// --- RUTAS ---
+
+// executeGraphQL ejecuta una consulta con cargadores nuevos para la petición.
+func executeGraphQL(ctx context.Context, req GraphQLRequest) *graphql.Response {
+	ctx = context.WithValue(ctx, graphQLLoadersKey{}, newGraphQLLoaders())
+	return graphQLSchema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+}
+
+// registerGraphQLRoutes registra el endpoint GraphQL y la descarga del esquema.
+func registerGraphQLRoutes(router *gin.Engine) {
+	graphQLSchema = graphql.MustParseSchema(graphQLSchemaSDL, &graphQLResolver{},
+		graphql.MaxDepth(graphQLMaxDepth),
+		graphql.UseStringDescriptions(),
+	)
+
+	// Consultas por POST con cuerpo JSON
+	router.POST("/api/graphql", func(c *gin.Context) {
+		var req GraphQLRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "Cuerpo inválido: " + err.Error()}}})
+			return
+		}
+		c.JSON(http.StatusOK, executeGraphQL(c.Request.Context(), req))
+	})
+
+	// Consultas por GET, con las variables como JSON en el parámetro variables
+	router.GET("/api/graphql", func(c *gin.Context) {
+		var req GraphQLRequest
+		if err := c.ShouldBindQuery(&req); err != nil || req.Query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "Falta el parámetro query"}}})
+			return
+		}
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"errors": []gin.H{{"message": "variables no es un objeto JSON válido"}}})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, executeGraphQL(c.Request.Context(), req))
+	})
+
+	// Esquema en SDL, para herramientas y generadores de código
+	router.GET("/api/graphql/schema", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(graphQLSchemaSDL))
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// TestSaleWACRounded comprueba que el WAC en el momento de vender se expone con
+// los decimales de un precio en REST y en GraphQL.
+func TestSaleWACRounded(t *testing.T) {
+	useTestDatabase(t)
+	registerGraphQLRoutes(gin.New())
+
+	// 8 MSFT del ejemplo más 3 a 100: el WAC tiene infinitos decimales
+	extra := Investment{TickerID: 3, PurchaseDate: time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+		Shares: decimal.NewFromInt(3), PurchasePrice: decimal.NewFromInt(100)}
+	if err := db.Create(&extra).Error; err != nil {
+		t.Fatal(err)
+	}
+	sale := Sale{TickerID: 3, SaleDate: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		Shares: decimal.NewFromInt(1), SalePrice: decimal.NewFromInt(300)}
+	if err := db.Create(&sale).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	wacs, err := saleWACs(db, 3)
+	if err != nil {
+		t.Fatalf("saleWACs: %v", err)
+	}
+	if wacs[sale.ID].Exponent() >= -6 {
+		t.Fatalf("el WAC de prueba %s debería tener más de 6 decimales", wacs[sale.ID])
+	}
+	if err := db.Preload("Ticker").First(&sale, sale.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	rest := newAPISale(sale, wacs[sale.ID])
+	if !rest.WACAtSale.Equal(roundPrice(wacs[sale.ID])) {
+		t.Errorf("wac_at_sale %s, se esperaba %s", rest.WACAtSale, roundPrice(wacs[sale.ID]))
+	}
+
+	resp := executeGraphQL(context.Background(), GraphQLRequest{Query: `{ sales(tickerId: "3") { wacAtSale } }`})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errores GraphQL: %v", resp.Errors)
+	}
+	var data struct {
+		Sales []struct {
+			WACAtSale json.RawMessage `json:"wacAtSale"`
+		} `json:"sales"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Sales) != 1 {
+		t.Fatalf("se esperaba una venta, se obtuvo %s", resp.Data)
+	}
+	var wac decimal.Decimal
+	if err := wac.UnmarshalJSON(data.Sales[0].WACAtSale); err != nil {
+		t.Fatal(err)
+	}
+	if !wac.Equal(rest.WACAtSale) {
+		t.Errorf("wacAtSale GraphQL %s, se esperaba %s", wac, rest.WACAtSale)
+	}
+}
+
+// countQueries cuenta las consultas de lectura por tabla mientras dura la prueba.
+func countQueries(t *testing.T) func() map[string]int {
+	t.Helper()
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	name := "test:contar_consultas"
+	err := db.Callback().Query().After("gorm:query").Register(name, func(tx *gorm.DB) {
+		mu.Lock()
+		counts[tx.Statement.Table]++
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Callback().Query().Remove(name) })
+	return func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		snapshot := make(map[string]int, len(counts))
+		for table, n := range counts {
+			snapshot[table] = n
+		}
+		return snapshot
+	}
+}
+
+// TestGraphQLLoadersBatchPerRelation comprueba que una consulta sobre N
+// tickers con sus operaciones hace una sola consulta por relación.
+func TestGraphQLLoadersBatchPerRelation(t *testing.T) {
+	useTestDatabase(t)
+	registerGraphQLRoutes(gin.New())
+
+	date := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ticker := Ticker{Name: fmt.Sprintf("LOTE%d", i), CurrentPrice: decimal.NewFromInt(int64(50 + i))}
+		if err := db.Create(&ticker).Error; err != nil {
+			t.Fatal(err)
+		}
+		db.Create(&Investment{TickerID: ticker.ID, PurchaseDate: date, Shares: decimal.NewFromInt(4), PurchasePrice: decimal.NewFromInt(40)})
+		db.Create(&Investment{TickerID: ticker.ID, PurchaseDate: date.AddDate(0, 1, 0), Shares: decimal.NewFromInt(2), PurchasePrice: decimal.NewFromInt(45)})
+		db.Create(&Sale{TickerID: ticker.ID, SaleDate: date.AddDate(0, 2, 0), Shares: decimal.NewFromInt(1), SalePrice: decimal.NewFromInt(60)})
+		db.Create(&PriceHistory{SnapshotID: "lote", TickerID: ticker.ID, Price: decimal.NewFromInt(55)})
+	}
+	var tickers int64
+	db.Model(&Ticker{}).Count(&tickers)
+
+	queries := countQueries(t)
+	resp := executeGraphQL(context.Background(), GraphQLRequest{Query: `{
+		tickers {
+			name
+			investments { id ticker { name } }
+			sales { id wacAtSale ticker { name } }
+			position { shares ticker { name } }
+			priceHistory { price }
+		}
+	}`})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errores GraphQL: %v", resp.Errors)
+	}
+	var data struct {
+		Tickers []struct {
+			Name        string            `json:"name"`
+			Investments []json.RawMessage `json:"investments"`
+		} `json:"tickers"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Tickers) != int(tickers) {
+		t.Fatalf("se obtuvieron %d tickers, se esperaban %d", len(data.Tickers), tickers)
+	}
+
+	// La lista de tickers, y una consulta por cada relación sea cual sea N
+	want := map[string]int{"tickers": 1, "investments": 1, "sales": 1, "price_histories": 1}
+	if got := queries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("consultas por tabla = %v, se esperaba %v", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// Cabeceras y campo de formulario de las claves de idempotencia
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeyField       = "idempotency_key" // Campo oculto de los formularios
+)
+
+// Duración de las claves y espera máxima a que termine la petición original
+const (
+	idempotencyTTL     = 24 * time.Hour
+	idempotencyWait    = 10 * time.Second
+	idempotencyPoll    = 100 * time.Millisecond
+	idempotencyKeySize = 255
+)
+
+// IdempotencyKey guarda la huella y la respuesta de una petición que crea
+// operaciones, para devolver el mismo resultado si se repite.
+type IdempotencyKey struct {
+	ID          uint   `gorm:"primaryKey"`
+	Key         string `gorm:"size:255;uniqueIndex"`
+	Fingerprint string // SHA-256 del método, la ruta y el cuerpo
+	StatusCode  int    // 0 mientras la petición original está en curso
+	ContentType string
+	Location    string
+	Body        string
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// newIdempotencyKey genera una clave aleatoria para los formularios.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error al generar la clave de idempotencia: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// idempotencyFingerprint identifica el contenido de una petición.
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecorder copia la respuesta mientras se envía al cliente.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// respondIdempotencyError responde en JSON en la API y en texto en los formularios.
+func respondIdempotencyError(c *gin.Context, status int, code, message string) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		c.AbortWithStatusJSON(status, APIErrorResponse{Error: message, Code: code})
+		return
+	}
+	c.String(status, message)
+	c.Abort()
+}
+
+// replayIdempotent envía de nuevo la respuesta guardada de una petición.
+func replayIdempotent(c *gin.Context, record IdempotencyKey) {
+	if record.ContentType != "" {
+		c.Header("Content-Type", record.ContentType)
+	}
+	if record.Location != "" {
+		c.Header("Location", record.Location)
+	}
+	c.Header(idempotencyReplayedHeader, "true")
+	c.Status(record.StatusCode)
+	if record.Body != "" {
+		c.Writer.WriteString(record.Body)
+	}
+	c.Abort()
+}
+
+// claimIdempotencyKey registra la clave como en curso. Si ya existía, espera
+// a que la petición original termine y devuelve su registro.
+func claimIdempotencyKey(key, fingerprint string) (IdempotencyKey, bool, error) {
+	db.Where("created_at < ?", time.Now().Add(-idempotencyTTL)).Delete(&IdempotencyKey{})
+
+	record := IdempotencyKey{Key: key, Fingerprint: fingerprint}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return IdempotencyKey{}, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	deadline := time.Now().Add(idempotencyWait)
+	for {
+		var existing IdempotencyKey
+		if err := db.Where(&IdempotencyKey{Key: key}).First(&existing).Error; err != nil {
+			return IdempotencyKey{}, false, err
+		}
+		if existing.StatusCode != 0 || existing.Fingerprint != fingerprint || time.Now().After(deadline) {
+			return existing, false, nil
+		}
+		time.Sleep(idempotencyPoll)
+	}
+}
+
+// idempotent evita que una petición que crea operaciones se aplique dos veces.
+// La clave llega en la cabecera Idempotency-Key o en el campo oculto
+// idempotency_key de los formularios. La primera respuesta correcta (2xx o 3xx)
+// se guarda y se devuelve a las repeticiones con el mismo contenido; si la
+// petición falla o el handler entra en pánico la clave se libera para poder
+// reintentarla.
+func idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondIdempotencyError(c, http.StatusBadRequest, APICodeInvalidJSON, "No se pudo leer la petición.")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := strings.TrimSpace(c.GetHeader(idempotencyKeyHeader))
+		if key == "" && !strings.HasPrefix(c.ContentType(), gin.MIMEJSON) {
+			key = strings.TrimSpace(c.PostForm(idempotencyKeyField))
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeySize {
+			respondIdempotencyError(c, http.StatusBadRequest, APICodeValidation, "La clave de idempotencia no puede superar los 255 caracteres.")
+			return
+		}
+
+		fingerprint := idempotencyFingerprint(c.Request, body)
+		record, claimed, err := claimIdempotencyKey(key, fingerprint)
+		if err != nil {
+			log.Printf("Error al registrar la clave de idempotencia: %v", err)
+			respondIdempotencyError(c, http.StatusInternalServerError, APICodeInternal, "Error interno del servidor")
+			return
+		}
+		if !claimed {
+			switch {
+			case record.Fingerprint != fingerprint:
+				respondIdempotencyError(c, http.StatusUnprocessableEntity, APICodeIdempotencyReused, "La clave de idempotencia ya se usó con una petición distinta.")
+			case record.StatusCode == 0:
+				respondIdempotencyError(c, http.StatusConflict, APICodeConflict, "La petición original con esta clave de idempotencia aún está en curso.")
+			default:
+				log.Printf("Petición repetida con la clave de idempotencia %s: se devuelve la respuesta original", key)
+				replayIdempotent(c, record)
+			}
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		// Si el handler entra en pánico se libera la clave antes de propagarlo,
+		// para que los reintentos no esperen en vano a que termine
+		defer func() {
+			if p := recover(); p != nil {
+				c.Writer = recorder.ResponseWriter
+				if err := db.Delete(&record).Error; err != nil {
+					log.Printf("Error al liberar la clave de idempotencia %s: %v", key, err)
+				}
+				panic(p)
+			}
+		}()
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		status := recorder.Status()
+		if status < http.StatusOK || status >= http.StatusBadRequest {
+			if err := db.Delete(&record).Error; err != nil {
+				log.Printf("Error al liberar la clave de idempotencia %s: %v", key, err)
+			}
+			return
+		}
+		err = db.Model(&record).Updates(map[string]interface{}{
+			"status_code":  status,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"location":     recorder.Header().Get("Location"),
+			"body":         recorder.body.String(),
+		}).Error
+		if err != nil {
+			log.Printf("Error al guardar la respuesta de la clave de idempotencia %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentRequest envía una petición JSON con la clave indicada.
+func idempotentRequest(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/prueba", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// newIdempotentRouter registra un endpoint idempotente que responde con handle
+// y cuenta las veces que se ejecuta.
+func newIdempotentRouter(handle gin.HandlerFunc) (*gin.Engine, *int32) {
+	var calls int32
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	router.POST("/api/v1/prueba", idempotent(), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		handle(c)
+	})
+	return router, &calls
+}
+
+func TestIdempotentReplaysResponse(t *testing.T) {
+	useTestDatabase(t)
+	router, calls := newIdempotentRouter(func(c *gin.Context) {
+		c.Header("Location", "/api/v1/prueba/1")
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	first := idempotentRequest(router, "clave-1", `{"a":1}`)
+	second := idempotentRequest(router, "clave-1", `{"a":1}`)
+	if *calls != 1 {
+		t.Fatalf("el handler se ejecutó %d veces, se esperaba 1", *calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("repetición: %d %s, se esperaba %d %s", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get(idempotencyReplayedHeader) != "true" || second.Header().Get("Location") != "/api/v1/prueba/1" {
+		t.Errorf("cabeceras de la repetición = %v", second.Header())
+	}
+
+	if w := idempotentRequest(router, "clave-1", `{"a":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("otro cuerpo con la misma clave: estado %d, se esperaba 422", w.Code)
+	}
+}
+
+func TestIdempotentConcurrentClaimWaitsForOriginal(t *testing.T) {
+	useTestDatabase(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	router, calls := newIdempotentRouter(func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	var wg sync.WaitGroup
+	var first *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = idempotentRequest(router, "clave-2", `{}`)
+	}()
+	<-started
+
+	// La segunda petición espera a que termine la original y recibe su respuesta
+	time.AfterFunc(3*idempotencyPoll, func() { close(release) })
+	second := idempotentRequest(router, "clave-2", `{}`)
+	wg.Wait()
+
+	if *calls != 1 {
+		t.Errorf("el handler se ejecutó %d veces, se esperaba 1", *calls)
+	}
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated || second.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Errorf("original %d, repetición %d (%q), se esperaba la respuesta original repetida",
+			first.Code, second.Code, second.Header().Get(idempotencyReplayedHeader))
+	}
+}
+
+func TestIdempotentReleasesKeyOnFailure(t *testing.T) {
+	useTestDatabase(t)
+	var mode atomic.Value
+	router, calls := newIdempotentRouter(func(c *gin.Context) {
+		switch mode.Load() {
+		case "error":
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "inválido"})
+		case "panic":
+			panic("fallo del handler")
+		default:
+			c.JSON(http.StatusCreated, gin.H{"ok": true})
+		}
+	})
+
+	for _, m := range []string{"error", "panic"} {
+		mode.Store(m)
+		if w := idempotentRequest(router, "clave-"+m, `{}`); w.Code < http.StatusBadRequest {
+			t.Fatalf("%s: estado %d, se esperaba un error", m, w.Code)
+		}
+		var count int64
+		db.Model(&IdempotencyKey{}).Where("key = ?", "clave-"+m).Count(&count)
+		if count != 0 {
+			t.Errorf("%s: la clave no se liberó", m)
+		}
+
+		// El reintento con la misma clave vuelve a ejecutar el handler
+		mode.Store("ok")
+		before := atomic.LoadInt32(calls)
+		if w := idempotentRequest(router, "clave-"+m, `{}`); w.Code != http.StatusCreated || w.Header().Get(idempotencyReplayedHeader) != "" {
+			t.Errorf("%s: reintento con estado %d, se esperaba 201 sin repetir", m, w.Code)
+		}
+		if atomic.LoadInt32(calls) != before+1 {
+			t.Errorf("%s: el reintento no ejecutó el handler", m)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ImportProfile define cómo leer el CSV de un broker: separador, formato de
+// fechas y números, y qué columna (por nombre de cabecera) contiene cada dato.
+type ImportProfile struct {
+	gorm.Model     `json:"-"`
+	Name           string `gorm:"uniqueIndex" json:"name"`
+	Broker         string `json:"broker"` // degiro, ibkr, generic
+	Delimiter      string `json:"delimiter"`
+	DecimalComma   bool   `json:"decimal_comma"` // Los números usan coma decimal (1.234,56)
+	DateFormat     string `json:"date_format"`   // Formato de fecha en notación Go, ej: 02-01-2006
+	TimeFormat     string `json:"time_format"`
+	DateColumn     string `json:"date_column"`
+	TimeColumn     string `json:"time_column"`
+	TypeColumn     string `json:"type_column"` // Si está vacío, el signo de la cantidad indica compra o venta
+	BuyValues      string `json:"buy_values"`  // Valores de TypeColumn que indican compra, separados por coma
+	SellValues     string `json:"sell_values"`
+	SymbolColumn   string `json:"symbol_column"`
+	ISINColumn     string `json:"isin_column"`
+	NameColumn     string `json:"name_column"`
+	SharesColumn   string `json:"shares_column"`
+	PriceColumn    string `json:"price_column"`
+	FeesColumn     string `json:"fees_column"`
+	TaxColumn      string `json:"tax_column"`
+	CurrencyColumn string `json:"currency_column"`
+}
+
+// defaultImportProfiles son los perfiles que se crean al migrar la base de datos.
+var defaultImportProfiles = []ImportProfile{
+	{
+		Name:         "DEGIRO",
+		Broker:       "degiro",
+		Delimiter:    ",",
+		DecimalComma: true,
+		DateFormat:   "02-01-2006",
+		TimeFormat:   "15:04",
+		DateColumn:   "Fecha",
+		TimeColumn:   "Hora",
+		NameColumn:   "Producto",
+		ISINColumn:   "ISIN",
+		SharesColumn: "Número",
+		PriceColumn:  "Precio",
+		FeesColumn:   "Costes de transacción y/o externos EUR",
+	},
+	{
+		Name:           "Interactive Brokers Flex",
+		Broker:         "ibkr",
+		Delimiter:      ",",
+		DateFormat:     "20060102",
+		DateColumn:     "TradeDate",
+		TypeColumn:     "Buy/Sell",
+		BuyValues:      "BUY",
+		SellValues:     "SELL",
+		SymbolColumn:   "Symbol",
+		ISINColumn:     "ISIN",
+		NameColumn:     "Description",
+		SharesColumn:   "Quantity",
+		PriceColumn:    "TradePrice",
+		FeesColumn:     "IBCommission",
+		CurrencyColumn: "CurrencyPrimary",
+	},
+	{
+		Name:           "Genérico",
+		Broker:         "generic",
+		Delimiter:      ",",
+		DateFormat:     "2006-01-02",
+		DateColumn:     "date",
+		TypeColumn:     "type",
+		BuyValues:      "buy,compra",
+		SellValues:     "sell,venta",
+		SymbolColumn:   "symbol",
+		ISINColumn:     "isin",
+		NameColumn:     "name",
+		SharesColumn:   "shares",
+		PriceColumn:    "price",
+		FeesColumn:     "fees",
+		TaxColumn:      "tax",
+		CurrencyColumn: "currency",
+	},
+}
+
+// parseCSVTrades lee un CSV con el perfil indicado y devuelve las operaciones
+// encontradas. Los errores de una fila se guardan en la propia operación.
+func parseCSVTrades(data []byte, profile ImportProfile) ([]ImportedTrade, error) {
+	// Eliminar BOM de UTF-8 que añaden algunos brokers
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	if profile.Delimiter != "" {
+		reader.Comma = []rune(profile.Delimiter)[0]
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer la cabecera: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := columns[name]; !exists && name != "" {
+			columns[name] = i
+		}
+	}
+
+	// Comprobar que existen las columnas obligatorias
+	for _, required := range []string{profile.DateColumn, profile.SharesColumn, profile.PriceColumn} {
+		if _, ok := columns[strings.ToLower(required)]; !ok {
+			return nil, fmt.Errorf("falta la columna %q en el fichero", required)
+		}
+	}
+
+	var trades []ImportedTrade
+	row := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			trades = append(trades, ImportedTrade{Row: row, Errors: []string{err.Error()}})
+			continue
+		}
+
+		get := func(column string) string {
+			if column == "" {
+				return ""
+			}
+			if i, ok := columns[strings.ToLower(column)]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		// Ignorar filas vacías
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		trade := ImportedTrade{
+			Row:      row,
+			Symbol:   get(profile.SymbolColumn),
+			ISIN:     get(profile.ISINColumn),
+			Name:     get(profile.NameColumn),
+			Currency: strings.ToUpper(get(profile.CurrencyColumn)),
+		}
+
+		trade.Date, err = parseImportDate(get(profile.DateColumn), get(profile.TimeColumn), profile)
+		if err != nil {
+			trade.Errors = append(trade.Errors, err.Error())
+		}
+
+		shares, err := parseImportNumber(get(profile.SharesColumn), profile.DecimalComma)
+		if err != nil {
+			trade.Errors = append(trade.Errors, "cantidad inválida")
+		}
+		trade.Price, err = parseImportNumber(get(profile.PriceColumn), profile.DecimalComma)
+		if err != nil {
+			trade.Errors = append(trade.Errors, "precio inválido")
+		}
+		// Las comisiones suelen venir en negativo (cargo en cuenta)
+		fees, _ := parseImportNumber(get(profile.FeesColumn), profile.DecimalComma)
+		trade.Fees = fees.Abs()
+		tax, _ := parseImportNumber(get(profile.TaxColumn), profile.DecimalComma)
+		trade.Tax = tax.Abs()
+
+		if profile.TypeColumn != "" {
+			trade.Type = matchImportType(get(profile.TypeColumn), profile)
+		} else if shares.IsNegative() {
+			trade.Type = ImportSell
+		} else {
+			trade.Type = ImportBuy
+		}
+		trade.Shares = shares.Abs()
+
+		trades = append(trades, trade)
+	}
+
+	return trades, nil
+}
+
+// matchImportType traduce el valor de la columna de tipo a compra o venta.
+func matchImportType(value string, profile ImportProfile) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, v := range strings.Split(profile.BuyValues, ",") {
+		if strings.ToLower(strings.TrimSpace(v)) == value {
+			return ImportBuy
+		}
+	}
+	for _, v := range strings.Split(profile.SellValues, ",") {
+		if strings.ToLower(strings.TrimSpace(v)) == value {
+			return ImportSell
+		}
+	}
+	return ""
+}
+
+// parseImportNumber interpreta un número con punto o coma decimal. Un valor
+// vacío equivale a 0.
+func parseImportNumber(value string, decimalComma bool) (decimal.Decimal, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return decimal.Zero, nil
+	}
+	if decimalComma {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	} else {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return decimal.NewFromString(value)
+}
+
+// parseImportDate interpreta la fecha (y la hora si hay columna propia) con el
+// formato del perfil, probando formatos habituales si no coincide.
+func parseImportDate(dateStr, timeStr string, profile ImportProfile) (time.Time, error) {
+	if dateStr == "" {
+		return time.Time{}, fmt.Errorf("fecha vacía")
+	}
+
+	// IBKR puede añadir la hora tras punto y coma: 20240115;093000
+	if i := strings.IndexAny(dateStr, ";"); i > 0 {
+		dateStr = dateStr[:i]
+	}
+
+	formats := []string{profile.DateFormat, "2006-01-02", "02/01/2006", "02-01-2006", "20060102", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+	for _, format := range formats {
+		if format == "" {
+			continue
+		}
+		date, err := time.Parse(format, dateStr)
+		if err != nil {
+			continue
+		}
+		if timeStr != "" {
+			timeFormat := profile.TimeFormat
+			if timeFormat == "" {
+				timeFormat = "15:04"
+			}
+			if t, err := time.Parse(timeFormat, timeStr); err == nil {
+				date = date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second)
+			}
+		}
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("fecha %q no coincide con el formato %s", dateStr, profile.DateFormat)
+}
+
+// registerImportRoutes registra las rutas de importación de extractos.
+func registerImportRoutes(router *gin.Engine) {
+	// Ruta para mostrar la página de importación
+	router.GET("/importar", func(c *gin.Context) {
+		var profiles []ImportProfile
+		db.Order("name").Find(&profiles)
+
+		c.HTML(http.StatusOK, "importar.html", gin.H{
+			"Profiles":       profiles,
+			"Investments":    c.Query("investments"),
+			"Sales":          c.Query("sales"),
+			"Dividends":      c.Query("dividends"),
+			"NewTickers":     c.Query("tickers"),
+			"Skipped":        c.Query("skipped"),
+			"Restored":       c.Query("restored"),
+			"RestoreSkipped": c.Query("restore_skipped"),
+			"ActivePage":     "importar",
+		})
+	})
+
+	// Ruta para previsualizar un fichero antes de importarlo
+	router.POST("/importar/preview", func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Debe seleccionar un fichero.")
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.String(http.StatusBadRequest, "No se pudo leer el fichero.")
+			return
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			c.String(http.StatusBadRequest, "No se pudo leer el fichero.")
+			return
+		}
+
+		format := c.DefaultPostForm("format", "csv")
+		trades, source, err := parseImportFile(format, c.PostForm("profile_id"), data)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Error al leer el fichero: %v", err)
+			return
+		}
+		resolveImportedTrades(trades)
+
+		importable := 0
+		for _, t := range trades {
+			if t.Importable() {
+				importable++
+			}
+		}
+
+		c.HTML(http.StatusOK, "importar_preview.html", gin.H{
+			"Trades":     trades,
+			"FileName":   file.Filename,
+			"Source":     source,
+			"Format":     format,
+			"ProfileID":  c.PostForm("profile_id"),
+			"Payload":    base64.StdEncoding.EncodeToString(data),
+			"Importable": importable,
+			"ActivePage": "importar",
+		})
+	})
+
+	// Ruta para confirmar la importación de las filas seleccionadas
+	router.POST("/importar/commit", func(c *gin.Context) {
+		data, err := base64.StdEncoding.DecodeString(c.PostForm("payload"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "Datos de importación inválidos.")
+			return
+		}
+
+		trades, _, err := parseImportFile(c.DefaultPostForm("format", "csv"), c.PostForm("profile_id"), data)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Error al leer el fichero: %v", err)
+			return
+		}
+		resolveImportedTrades(trades)
+
+		selected := make(map[int]bool)
+		for _, r := range c.PostFormArray("rows") {
+			if row, err := strconv.Atoi(r); err == nil {
+				selected[row] = true
+			}
+		}
+
+		result, err := commitImportedTrades(trades, selected, auditActor(c))
+		if err != nil {
+			log.Printf("Error al importar: %v", err)
+			respondFormError(c, err, "importar", 0, nil)
+			return
+		}
+
+		c.Redirect(http.StatusFound, fmt.Sprintf("/importar?investments=%d&sales=%d&dividends=%d&tickers=%d&skipped=%d",
+			result.Investments, result.Sales, result.Dividends, result.Tickers, result.Skipped))
+	})
+
+	// Ruta para guardar un perfil de columnas
+	router.POST("/add-import-profile", func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.String(http.StatusBadRequest, "El nombre del perfil es obligatorio.")
+			return
+		}
+
+		profile := ImportProfile{
+			Name:           name,
+			Broker:         "generic",
+			Delimiter:      c.DefaultPostForm("delimiter", ","),
+			DecimalComma:   c.PostForm("decimal_comma") == "on",
+			DateFormat:     c.DefaultPostForm("date_format", "2006-01-02"),
+			TimeFormat:     strings.TrimSpace(c.PostForm("time_format")),
+			DateColumn:     strings.TrimSpace(c.PostForm("date_column")),
+			TimeColumn:     strings.TrimSpace(c.PostForm("time_column")),
+			TypeColumn:     strings.TrimSpace(c.PostForm("type_column")),
+			BuyValues:      strings.TrimSpace(c.PostForm("buy_values")),
+			SellValues:     strings.TrimSpace(c.PostForm("sell_values")),
+			SymbolColumn:   strings.TrimSpace(c.PostForm("symbol_column")),
+			ISINColumn:     strings.TrimSpace(c.PostForm("isin_column")),
+			NameColumn:     strings.TrimSpace(c.PostForm("name_column")),
+			SharesColumn:   strings.TrimSpace(c.PostForm("shares_column")),
+			PriceColumn:    strings.TrimSpace(c.PostForm("price_column")),
+			FeesColumn:     strings.TrimSpace(c.PostForm("fees_column")),
+			TaxColumn:      strings.TrimSpace(c.PostForm("tax_column")),
+			CurrencyColumn: strings.TrimSpace(c.PostForm("currency_column")),
+		}
+		if profile.Delimiter == `\t` {
+			profile.Delimiter = "\t"
+		}
+
+		if profile.DateColumn == "" || profile.SharesColumn == "" || profile.PriceColumn == "" {
+			c.String(http.StatusBadRequest, "Las columnas de fecha, cantidad y precio son obligatorias.")
+			return
+		}
+		if profile.SymbolColumn == "" && profile.ISINColumn == "" {
+			c.String(http.StatusBadRequest, "Debe indicar la columna de símbolo o de ISIN.")
+			return
+		}
+
+		if err := db.Create(&profile).Error; err != nil {
+			c.String(http.StatusBadRequest, "No se pudo guardar el perfil: %v", err)
+			return
+		}
+
+		log.Printf("Perfil de importación creado: %s", name)
+		c.Redirect(http.StatusFound, "/importar")
+	})
+
+	// Ruta para eliminar un perfil de columnas
+	router.POST("/delete-import-profile", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		// Borrado definitivo para poder reutilizar el nombre
+		db.Unscoped().Delete(&ImportProfile{}, id)
+		log.Printf("Perfil de importación %d eliminado", id)
+		c.Redirect(http.StatusFound, "/importar")
+	})
+}
+
+// parseImportFile interpreta el fichero según su formato y devuelve las
+// operaciones junto con una descripción del origen.
+func parseImportFile(format, profileID string, data []byte) ([]ImportedTrade, string, error) {
+	switch format {
+	case "csv":
+		id, err := strconv.ParseUint(profileID, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("perfil de importación inválido")
+		}
+		var profile ImportProfile
+		if err := db.Where("id = ?", id).First(&profile).Error; err != nil {
+			return nil, "", fmt.Errorf("perfil de importación no encontrado")
+		}
+		trades, err := parseCSVTrades(data, profile)
+		return trades, "CSV · " + profile.Name, err
+	case "ofx":
+		trades, err := parseOFXTrades(data)
+		return trades, "OFX/QFX", err
+	case "qif":
+		trades, err := parseQIFTrades(data)
+		return trades, "QIF", err
+	}
+	return nil, "", fmt.Errorf("formato %q no soportado", format)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseImportFileProfileID(t *testing.T) {
+	useTestDatabase(t)
+	var profile ImportProfile
+	if err := db.Where("broker = ?", "generic").First(&profile).Error; err != nil {
+		t.Fatalf("perfil genérico: %v", err)
+	}
+
+	for _, id := range []string{"", "abc", "1 OR 1=1", "0; DROP TABLE import_profiles", "-1"} {
+		if _, _, err := parseImportFile("csv", id, []byte("")); err == nil {
+			t.Errorf("parseImportFile(%q) debería fallar", id)
+		}
+	}
+	if _, _, err := parseImportFile("csv", "999999", []byte("")); err == nil {
+		t.Error("un perfil inexistente debería fallar")
+	}
+
+	// El fichero vacío puede fallar, pero el perfil debe encontrarse
+	if _, source, err := parseImportFile("csv", strconv.FormatUint(uint64(profile.ID), 10), []byte("")); source != "CSV · "+profile.Name {
+		t.Errorf("perfil %d: origen %q, error %v", profile.ID, source, err)
+	}
+	var count int64
+	db.Model(&ImportProfile{}).Count(&count)
+	if count == 0 {
+		t.Error("los perfiles de importación han desaparecido")
+	}
+}
+
+// importProfile devuelve el perfil por defecto del broker indicado.
+func importProfile(t *testing.T, broker string) ImportProfile {
+	t.Helper()
+	for _, profile := range defaultImportProfiles {
+		if profile.Broker == broker {
+			return profile
+		}
+	}
+	t.Fatalf("no existe el perfil %s", broker)
+	return ImportProfile{}
+}
+
+func TestParseDEGIROTransactions(t *testing.T) {
+	// Exportación de "Transacciones" de DEGIRO: coma decimal entre comillas,
+	// columnas de divisa sin cabecera y ventas con cantidad negativa
+	data := "\xef\xbb\xbf" + `Fecha,Hora,Producto,ISIN,Bolsa de referencia,Centro de ejecución,Número,Precio,,Valor local,,Valor,,Tipo de cambio,Costes de transacción y/o externos EUR,,Total,,ID Orden
+15-01-2024,09:32,ISHARES CORE MSCI WORLD UCITS ETF USD (ACC),IE00B4L5Y983,EAM,XAMS,12,"81,234",EUR,"-974,81",EUR,"-974,81",EUR,,"-2,00",EUR,"-976,81",EUR,4f1c2a
+20-03-2024,15:45,APPLE INC. - COMMON ST,US0378331005,NDQ,XNAS,-4,"1.172,50",USD,"4.690,00",USD,"4.312,45",EUR,"1,0876","-1,00",EUR,"4.311,45",EUR,9b7d3e
+21-03-2024,10:00,APPLE INC. - COMMON ST,US0378331005,NDQ,XNAS,abc,"170,50",USD,,,,,,,,,,
+`
+	trades, err := parseCSVTrades([]byte(data), importProfile(t, "degiro"))
+	if err != nil {
+		t.Fatalf("parseCSVTrades: %v", err)
+	}
+	if len(trades) != 3 {
+		t.Fatalf("se leyeron %d operaciones, se esperaban 3", len(trades))
+	}
+
+	buy := trades[0]
+	if buy.Type != ImportBuy || !buy.Shares.Equal(decimal.NewFromInt(12)) || buy.Price.String() != "81.234" || buy.Fees.String() != "2" {
+		t.Errorf("compra = %s %s a %s con costes %s", buy.Type, buy.Shares, buy.Price, buy.Fees)
+	}
+	if want := time.Date(2024, 1, 15, 9, 32, 0, 0, time.UTC); !buy.Date.Equal(want) || buy.ISIN != "IE00B4L5Y983" {
+		t.Errorf("compra: fecha %v e ISIN %s, se esperaba %v e IE00B4L5Y983", buy.Date, buy.ISIN, want)
+	}
+
+	// La cantidad negativa indica venta y los miles llevan punto
+	sell := trades[1]
+	if sell.Type != ImportSell || !sell.Shares.Equal(decimal.NewFromInt(4)) || sell.Price.String() != "1172.5" || sell.Fees.String() != "1" {
+		t.Errorf("venta = %s %s a %s con costes %s", sell.Type, sell.Shares, sell.Price, sell.Fees)
+	}
+
+	if len(trades[2].Errors) == 0 || trades[2].Row != 4 {
+		t.Errorf("la fila %d con cantidad inválida no tiene errores: %+v", trades[2].Row, trades[2])
+	}
+}
+
+func TestParseIBKRFlexTrades(t *testing.T) {
+	data := `"ClientAccountID","CurrencyPrimary","Symbol","Description","ISIN","TradeDate","Quantity","TradePrice","IBCommission","Buy/Sell"
+"U1234567","USD","MSFT","MICROSOFT CORP","US5949181045","20240115;093015","10","388.47","-1.0035","BUY"
+"U1234567","USD","MSFT","MICROSOFT CORP","US5949181045","20240220","-5","402.1","-1","SELL"
+"U1234567","USD","AAPL","APPLE INC","US0378331006","20240221","1,000","182.32","-0.35","BUY"
+"U1234567","USD","AAPL","APPLE INC","US0378331005","20240222","3","181","0","TRANSFER"
+`
+	trades, err := parseCSVTrades([]byte(data), importProfile(t, "ibkr"))
+	if err != nil {
+		t.Fatalf("parseCSVTrades: %v", err)
+	}
+	if len(trades) != 4 {
+		t.Fatalf("se leyeron %d operaciones, se esperaban 4", len(trades))
+	}
+
+	if buy := trades[0]; buy.Type != ImportBuy || buy.Symbol != "MSFT" || buy.Currency != "USD" || buy.Fees.String() != "1.0035" ||
+		!buy.Date.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("compra = %+v", buy)
+	}
+	// Con columna de tipo el signo de la cantidad no decide la operación
+	if sell := trades[1]; sell.Type != ImportSell || !sell.Shares.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("venta = %s %s, se esperaba sell 5", sell.Type, sell.Shares)
+	}
+	// Sin coma decimal la coma es separador de miles
+	if !trades[2].Shares.Equal(decimal.NewFromInt(1000)) {
+		t.Errorf("cantidad con separador de miles = %s, se esperaba 1000", trades[2].Shares)
+	}
+	if trades[3].Type != "" {
+		t.Errorf("tipo desconocido interpretado como %q", trades[3].Type)
+	}
+}
+
+func TestResolveImportedTradesValidatesCSV(t *testing.T) {
+	useTestDatabase(t)
+	data := `"CurrencyPrimary","Symbol","Description","ISIN","TradeDate","Quantity","TradePrice","IBCommission","Buy/Sell"
+"USD","AAPL","APPLE INC","US0378331006","20240221","2","182.32","-0.35","BUY"
+"USD","MSFT","MICROSOFT CORP","US5949181045","20240115","10","388.47","-1","BUY"
+"USD","MSFT","MICROSOFT CORP","US5949181045","20240115","10","388.47","-1","BUY"
+"USD","NVDA","NVIDIA CORP","US67066G1040","20240222","3","181","0","TRANSFER"
+`
+	trades, err := parseCSVTrades([]byte(data), importProfile(t, "ibkr"))
+	if err != nil {
+		t.Fatalf("parseCSVTrades: %v", err)
+	}
+	resolveImportedTrades(trades)
+
+	// El dígito de control del ISIN de Apple es 5
+	if !hasImportError(trades[0], "ISIN US0378331006 inválido") {
+		t.Errorf("ISIN inválido aceptado: %v", trades[0].Errors)
+	}
+	if !trades[1].Importable() || trades[1].TickerID != 3 || trades[1].NewTicker {
+		t.Errorf("MSFT = %+v, se esperaba el ticker 3 existente", trades[1])
+	}
+	if !trades[2].Duplicate {
+		t.Error("la fila repetida no se marcó como duplicada")
+	}
+	if !hasImportError(trades[3], "tipo de operación desconocido") || !trades[3].NewTicker {
+		t.Errorf("NVDA = %+v", trades[3])
+	}
+}
+
+// hasImportError indica si la operación tiene el error indicado.
+func hasImportError(trade ImportedTrade, message string) bool {
+	for _, e := range trade.Errors {
+		if e == message {
+			return true
+		}
+	}
+	return false
+}
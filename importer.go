@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ImportedTrade representa una operación leída de un extracto de broker antes
+// de guardarse en la base de datos.
+type ImportedTrade struct {
+	Row        int             `json:"row"` // Número de fila o registro en el fichero de origen
+	Type       string          `json:"type"`
+	Date       time.Time       `json:"date"`
+	Symbol     string          `json:"symbol"`
+	ISIN       string          `json:"isin"`
+	Name       string          `json:"name"`
+	Shares     decimal.Decimal `json:"shares"`
+	Price      decimal.Decimal `json:"price"`
+	Fees       decimal.Decimal `json:"fees"`
+	Tax        decimal.Decimal `json:"tax"`
+	Amount     decimal.Decimal `json:"amount"` // Importe bruto, solo para dividendos
+	Currency   string          `json:"currency"`
+	ExternalID string          `json:"external_id"` // Identificador de la operación en el extracto, si existe
+
+	TickerID  uint     `json:"ticker_id"`  // Ticker existente al que corresponde la operación
+	TickerKey string   `json:"ticker_key"` // Nombre con el que se creará el ticker si no existe
+	NewTicker bool     `json:"new_ticker"`
+	Duplicate bool     `json:"duplicate"`
+	Errors    []string `json:"errors"`
+}
+
+// Tipos de operación importables
+const (
+	ImportBuy      = "buy"
+	ImportSell     = "sell"
+	ImportDividend = "dividend"
+)
+
+// Valid indica si la operación puede importarse.
+func (t ImportedTrade) Valid() bool {
+	return len(t.Errors) == 0
+}
+
+// Importable indica si la operación se importará por defecto.
+func (t ImportedTrade) Importable() bool {
+	return t.Valid() && !t.Duplicate
+}
+
+// ImportResult resume el resultado de guardar un lote de operaciones.
+type ImportResult struct {
+	Investments int `json:"investments"`
+	Sales       int `json:"sales"`
+	Dividends   int `json:"dividends"`
+	Tickers     int `json:"tickers"`
+	Skipped     int `json:"skipped"`
+}
+
+// validateImportedTrade completa la lista de errores de una operación.
+func validateImportedTrade(t *ImportedTrade) {
+	if t.Type != ImportBuy && t.Type != ImportSell && t.Type != ImportDividend {
+		t.Errors = append(t.Errors, "tipo de operación desconocido")
+	}
+	if t.Date.IsZero() {
+		t.Errors = append(t.Errors, "fecha inválida")
+	}
+	if t.Symbol == "" && t.ISIN == "" {
+		t.Errors = append(t.Errors, "falta el símbolo o ISIN")
+	}
+	if t.ISIN != "" && !validISIN(t.ISIN) {
+		t.Errors = append(t.Errors, fmt.Sprintf("ISIN %s inválido", t.ISIN))
+	}
+	if t.Type == ImportDividend {
+		if !t.Amount.IsPositive() {
+			t.Errors = append(t.Errors, "el importe del dividendo debe ser positivo")
+		}
+	} else {
+		if !t.Shares.IsPositive() {
+			t.Errors = append(t.Errors, "la cantidad de acciones debe ser positiva")
+		}
+		if !t.Price.IsPositive() {
+			t.Errors = append(t.Errors, "el precio debe ser positivo")
+		}
+	}
+	if t.Fees.IsNegative() || t.Tax.IsNegative() {
+		t.Errors = append(t.Errors, "los costos no pueden ser negativos")
+	}
+}
+
+// resolveImportedTrades asocia cada operación con su ticker (por ISIN o
+// símbolo), valida sus datos y marca las que ya existen en la base de datos o
+// se repiten dentro del mismo fichero.
+func resolveImportedTrades(trades []ImportedTrade) {
+	var tickers []Ticker
+	db.Find(&tickers)
+	byISIN := make(map[string]uint)
+	byName := make(map[string]uint)
+	for _, t := range tickers {
+		if t.ISIN != "" {
+			byISIN[t.ISIN] = t.ID
+		}
+		byName[t.Name] = t.ID
+	}
+
+	seen := make(map[string]bool)
+	for i := range trades {
+		t := &trades[i]
+		t.Symbol = strings.ToUpper(strings.TrimSpace(t.Symbol))
+		t.ISIN = strings.ToUpper(strings.TrimSpace(t.ISIN))
+		validateImportedTrade(t)
+
+		if id, ok := byISIN[t.ISIN]; ok && t.ISIN != "" {
+			t.TickerID = id
+		} else if id, ok := byName[t.Symbol]; ok && t.Symbol != "" {
+			t.TickerID = id
+		} else {
+			t.NewTicker = true
+			t.TickerKey = t.Symbol
+			if t.TickerKey == "" {
+				t.TickerKey = t.ISIN
+			}
+		}
+
+		if !t.Valid() {
+			continue
+		}
+
+		key := importDedupKey(t)
+		if seen[key] || ((t.TickerID != 0 || t.ExternalID != "") && tradeExists(t)) {
+			t.Duplicate = true
+		}
+		seen[key] = true
+	}
+}
+
+// importDedupKey identifica una operación para detectar repeticiones en el mismo fichero.
+func importDedupKey(t *ImportedTrade) string {
+	if t.ExternalID != "" {
+		return "id:" + t.ExternalID
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", t.Type, t.TickerKey, t.ISIN, t.Date.Format(time.RFC3339), t.Shares.StringFixed(6), t.Price.StringFixed(6), t.Amount.StringFixed(6))
+}
+
+// tradeExists comprueba si la operación ya fue importada. Si el extracto
+// incluye un identificador (FITID) se busca por él; si no, por ticker, fecha,
+// cantidad y precio.
+func tradeExists(t *ImportedTrade) bool {
+	tolerance := decimal.New(1, -6)
+	near := func(a, b decimal.Decimal) bool { return a.Sub(b).Abs().LessThan(tolerance) }
+
+	if t.ExternalID != "" {
+		var count int64
+		switch t.Type {
+		case ImportBuy:
+			db.Model(&Investment{}).Where("external_id = ?", t.ExternalID).Count(&count)
+		case ImportSell:
+			db.Model(&Sale{}).Where("external_id = ?", t.ExternalID).Count(&count)
+		case ImportDividend:
+			db.Model(&Dividend{}).Where("external_id = ?", t.ExternalID).Count(&count)
+		}
+		return count > 0
+	}
+
+	if t.Type == ImportDividend {
+		var existing []Dividend
+		db.Where("ticker_id = ? AND payment_date = ?", t.TickerID, t.Date).Find(&existing)
+		for _, d := range existing {
+			if near(d.Amount, t.Amount) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if t.Type == ImportBuy {
+		var existing []Investment
+		db.Where("ticker_id = ? AND purchase_date = ?", t.TickerID, t.Date).Find(&existing)
+		for _, inv := range existing {
+			if near(inv.Shares, t.Shares) && near(inv.PurchasePrice, t.Price) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var existing []Sale
+	db.Where("ticker_id = ? AND sale_date = ?", t.TickerID, t.Date).Find(&existing)
+	for _, s := range existing {
+		if near(s.Shares, t.Shares) && near(s.SalePrice, t.Price) {
+			return true
+		}
+	}
+	return false
+}
+
+// commitImportedTrades guarda en una única transacción las operaciones
+// seleccionadas, creando los tickers que falten. Si selected es nil se
+// importan todas las operaciones válidas y no duplicadas. Como en los lotes,
+// las posiciones se validan al final, así que el orden de las filas no
+// importa; si alguna venta queda sin acciones suficientes no se importa nada.
+func commitImportedTrades(trades []ImportedTrade, selected map[int]bool, actor string) (ImportResult, error) {
+	var result ImportResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		createdTickers := make(map[string]uint)
+		touched := make(map[uint]bool)
+
+		for _, t := range trades {
+			include := t.Importable()
+			if selected != nil {
+				include = t.Valid() && selected[t.Row]
+			}
+			if !include {
+				result.Skipped++
+				continue
+			}
+
+			tickerID := t.TickerID
+			if t.NewTicker {
+				if id, ok := createdTickers[t.TickerKey]; ok {
+					tickerID = id
+				} else {
+					ticker := Ticker{Name: t.TickerKey, ISIN: t.ISIN, Currency: t.Currency, CurrentPrice: roundPrice(t.Price)}
+					if err := tx.Create(&ticker).Error; err != nil {
+						return fmt.Errorf("fila %d: error al crear ticker %s: %v", t.Row, t.TickerKey, err)
+					}
+					if err := recordAudit(tx, actor, AuditTicker, ticker.ID, AuditCreate, nil, ticker); err != nil {
+						return err
+					}
+					createdTickers[t.TickerKey] = ticker.ID
+					tickerID = ticker.ID
+					result.Tickers++
+				}
+			}
+
+			touched[tickerID] = true
+			switch t.Type {
+			case ImportBuy:
+				investment := Investment{
+					TickerID:      tickerID,
+					PurchaseDate:  t.Date,
+					Shares:        roundShares(t.Shares),
+					PurchasePrice: roundPrice(t.Price),
+					OperationCost: roundMoney(t.Fees, t.Currency),
+					ExternalID:    t.ExternalID,
+				}
+				if err := tx.Create(&investment).Error; err != nil {
+					return fmt.Errorf("fila %d: %v", t.Row, err)
+				}
+				if err := recordAudit(tx, actor, AuditInvestment, investment.ID, AuditCreate, nil, investment); err != nil {
+					return err
+				}
+				result.Investments++
+			case ImportSell:
+				sale := Sale{
+					TickerID:      tickerID,
+					SaleDate:      t.Date,
+					Shares:        roundShares(t.Shares),
+					SalePrice:     roundPrice(t.Price),
+					OperationCost: roundMoney(t.Fees, t.Currency),
+					WithheldTax:   roundMoney(t.Tax, t.Currency),
+					ExternalID:    t.ExternalID,
+				}
+				if err := tx.Create(&sale).Error; err != nil {
+					return fmt.Errorf("fila %d: %v", t.Row, err)
+				}
+				if err := recordAudit(tx, actor, AuditSale, sale.ID, AuditCreate, nil, sale); err != nil {
+					return err
+				}
+				result.Sales++
+			case ImportDividend:
+				dividend := Dividend{
+					TickerID:    tickerID,
+					PaymentDate: t.Date,
+					Amount:      roundMoney(t.Amount, t.Currency),
+					WithheldTax: roundMoney(t.Tax, t.Currency),
+					Currency:    t.Currency,
+					ExternalID:  t.ExternalID,
+				}
+				if err := tx.Create(&dividend).Error; err != nil {
+					return fmt.Errorf("fila %d: %v", t.Row, err)
+				}
+				if err := recordAudit(tx, actor, AuditDividend, dividend.ID, AuditCreate, nil, dividend); err != nil {
+					return err
+				}
+				result.Dividends++
+			}
+		}
+		return validatePositions(tx, sortedTickerIDs(touched)...)
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	log.Printf("Importación completada: %d compras, %d ventas, %d dividendos, %d tickers nuevos, %d omitidas",
+		result.Investments, result.Sales, result.Dividends, result.Tickers, result.Skipped)
+	return result, nil
+}
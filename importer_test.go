@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestImportRejectsOversell comprueba que una importación cuya venta supera
+// las acciones disponibles no guarda ninguna operación.
+func TestImportRejectsOversell(t *testing.T) {
+	useTestDatabase(t)
+	date := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// El ejemplo tiene 8 MSFT (ticker 3) desde el 10/03/2023
+	trades := []ImportedTrade{
+		{Row: 1, Type: ImportDividend, Date: date, TickerID: 3, Amount: decimal.NewFromInt(12), Currency: "USD"},
+		{Row: 2, Type: ImportSell, Date: date, TickerID: 3, Shares: decimal.NewFromInt(20), Price: decimal.NewFromInt(320)},
+	}
+	_, err := commitImportedTrades(trades, nil, "test")
+	requireOversell(t, "commitImportedTrades", err)
+
+	var sales, dividends int64
+	db.Model(&Sale{}).Count(&sales)
+	db.Model(&Dividend{}).Count(&dividends)
+	if sales != 0 || dividends != 0 {
+		t.Errorf("se guardaron %d ventas y %d dividendos de una importación rechazada", sales, dividends)
+	}
+}
+
+// TestImportValidatesPositionsAtEnd comprueba que una venta puede ir antes
+// que la compra que la cubre y que los dividendos quedan auditados.
+func TestImportValidatesPositionsAtEnd(t *testing.T) {
+	useTestDatabase(t)
+	trades := []ImportedTrade{
+		{Row: 1, Type: ImportSell, Date: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), TickerID: 3,
+			Shares: decimal.NewFromInt(10), Price: decimal.NewFromInt(320)},
+		{Row: 2, Type: ImportBuy, Date: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), TickerID: 3,
+			Shares: decimal.NewFromInt(5), Price: decimal.NewFromInt(300)},
+		{Row: 3, Type: ImportDividend, Date: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC), TickerID: 3,
+			Amount: decimal.NewFromInt(12), Currency: "USD"},
+	}
+	result, err := commitImportedTrades(trades, nil, "test")
+	if err != nil {
+		t.Fatalf("commitImportedTrades: %v", err)
+	}
+	if result.Sales != 1 || result.Investments != 1 || result.Dividends != 1 {
+		t.Errorf("resultado = %+v", result)
+	}
+
+	var dividend Dividend
+	db.First(&dividend)
+	var audits int64
+	db.Model(&AuditLog{}).Where("entity_type = ? AND entity_id = ? AND action = ?", AuditDividend, dividend.ID, AuditCreate).Count(&audits)
+	if audits != 1 {
+		t.Errorf("entradas de auditoría del dividendo = %d, se esperaba 1", audits)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ofxNode es un elemento de un documento OFX. Los elementos hoja tienen valor
+// y los agregados tienen hijos.
+type ofxNode struct {
+	Name     string
+	Value    string
+	Children []*ofxNode
+}
+
+// child devuelve el primer hijo directo con el nombre indicado.
+func (n *ofxNode) child(name string) *ofxNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// value sigue la ruta de hijos indicada y devuelve el valor del último.
+func (n *ofxNode) value(path ...string) string {
+	for _, name := range path {
+		n = n.child(name)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.Value
+}
+
+// number devuelve el valor numérico de la ruta indicada, 0 si no existe.
+func (n *ofxNode) number(path ...string) decimal.Decimal {
+	v, err := decimal.NewFromString(strings.ReplaceAll(n.value(path...), ",", "."))
+	if err != nil {
+		return decimal.Zero
+	}
+	return v
+}
+
+// findAll devuelve todos los descendientes con el nombre indicado.
+func (n *ofxNode) findAll(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, c := range n.Children {
+		if c.Name == name {
+			found = append(found, c)
+		}
+		found = append(found, c.findAll(name)...)
+	}
+	return found
+}
+
+// parseOFX construye el árbol de un documento OFX. Admite tanto OFX 1.x (SGML,
+// sin etiquetas de cierre en las hojas) como OFX 2.x (XML).
+func parseOFX(data []byte) (*ofxNode, error) {
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+	if start < 0 {
+		return nil, fmt.Errorf("no es un fichero OFX válido")
+	}
+	content := string(data[start:])
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for {
+		open := strings.IndexByte(content, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(content[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("etiqueta sin cerrar")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(content[open+1 : open+end]))
+		content = content[open+end+1:]
+
+		next := strings.IndexByte(content, '<')
+		if next < 0 {
+			next = len(content)
+		}
+		text := strings.TrimSpace(content[:next])
+
+		if tag == "" || tag[0] == '?' || tag[0] == '!' {
+			continue
+		}
+
+		if tag[0] == '/' {
+			// Cerrar el agregado correspondiente; los cierres de hojas no están en la pila
+			name := tag[1:]
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		node := &ofxNode{Name: tag, Value: html.UnescapeString(text)}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node)
+		if text == "" {
+			stack = append(stack, node)
+		}
+	}
+
+	ofx := root.child("OFX")
+	if ofx == nil {
+		return nil, fmt.Errorf("no es un fichero OFX válido")
+	}
+	return ofx, nil
+}
+
+// parseOFXDate interpreta fechas OFX como 20240115, 20240115120000 o
+// 20240115120000.000[-5:EST]. Se ignora la zona horaria.
+func parseOFXDate(value string) (time.Time, error) {
+	if i := strings.IndexAny(value, ".["); i >= 0 {
+		value = value[:i]
+	}
+	switch len(value) {
+	case 8:
+		return time.Parse("20060102", value)
+	case 12:
+		return time.Parse("200601021504", value)
+	case 14:
+		return time.Parse("20060102150405", value)
+	}
+	return time.Time{}, fmt.Errorf("fecha OFX %q inválida", value)
+}
+
+// isinFromCUSIP convierte un CUSIP (valores de EE. UU.) en su ISIN calculando
+// el dígito de control.
+func isinFromCUSIP(cusip string) string {
+	cusip = strings.ToUpper(strings.TrimSpace(cusip))
+	if len(cusip) != 9 {
+		return ""
+	}
+	for d := '0'; d <= '9'; d++ {
+		if isin := "US" + cusip + string(d); validISIN(isin) {
+			return isin
+		}
+	}
+	return ""
+}
+
+// ofxSecurity son los datos de un valor de la lista SECLIST.
+type ofxSecurity struct {
+	Symbol string
+	ISIN   string
+	Name   string
+}
+
+// ofxSecurityKey identifica un valor por su SECID.
+func ofxSecurityKey(secID *ofxNode) string {
+	return strings.ToUpper(secID.value("UNIQUEIDTYPE")) + ":" + strings.ToUpper(secID.value("UNIQUEID"))
+}
+
+// parseOFXTrades extrae las compras (INVBUY), ventas (INVSELL) y dividendos
+// (INCOME) de un extracto de inversión OFX/QFX. El FITID de cada transacción
+// se usa como identificador para no importarla dos veces.
+func parseOFXTrades(data []byte) ([]ImportedTrade, error) {
+	ofx, err := parseOFX(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Valores referenciados por las transacciones
+	securities := make(map[string]ofxSecurity)
+	for _, info := range ofx.findAll("SECINFO") {
+		secID := info.child("SECID")
+		if secID == nil {
+			continue
+		}
+		sec := ofxSecurity{
+			Symbol: info.value("TICKER"),
+			Name:   info.value("SECNAME"),
+		}
+		switch strings.ToUpper(secID.value("UNIQUEIDTYPE")) {
+		case "ISIN":
+			sec.ISIN = strings.ToUpper(secID.value("UNIQUEID"))
+		case "CUSIP":
+			sec.ISIN = isinFromCUSIP(secID.value("UNIQUEID"))
+		}
+		securities[ofxSecurityKey(secID)] = sec
+	}
+
+	var trades []ImportedTrade
+	for _, stmt := range ofx.findAll("INVSTMTRS") {
+		defaultCurrency := strings.ToUpper(stmt.value("CURDEF"))
+		tranList := stmt.child("INVTRANLIST")
+		if tranList == nil {
+			continue
+		}
+
+		for _, tran := range tranList.Children {
+			var record *ofxNode
+			var tradeType string
+			switch {
+			case strings.HasPrefix(tran.Name, "BUY"):
+				record, tradeType = tran.child("INVBUY"), ImportBuy
+			case strings.HasPrefix(tran.Name, "SELL"):
+				record, tradeType = tran.child("INVSELL"), ImportSell
+			case tran.Name == "INCOME":
+				record, tradeType = tran, ImportDividend
+			default:
+				continue
+			}
+			if record == nil {
+				continue
+			}
+
+			trade := ImportedTrade{
+				Row:        len(trades) + 1,
+				Type:       tradeType,
+				ExternalID: record.value("INVTRAN", "FITID"),
+				Currency:   defaultCurrency,
+			}
+
+			if secID := record.child("SECID"); secID != nil {
+				sec, ok := securities[ofxSecurityKey(secID)]
+				if !ok {
+					trade.Errors = append(trade.Errors, fmt.Sprintf("valor %s no encontrado en SECLIST", secID.value("UNIQUEID")))
+				}
+				trade.Symbol, trade.ISIN, trade.Name = sec.Symbol, sec.ISIN, sec.Name
+			}
+
+			trade.Date, err = parseOFXDate(record.value("INVTRAN", "DTTRADE"))
+			if err != nil {
+				trade.Errors = append(trade.Errors, err.Error())
+			}
+
+			for _, path := range []string{"CURRENCY", "ORIGCURRENCY"} {
+				if cur := record.value(path, "CURSYM"); cur != "" {
+					trade.Currency = strings.ToUpper(cur)
+				}
+			}
+
+			if tradeType == ImportDividend {
+				incomeType := strings.ToUpper(record.value("INCOMETYPE"))
+				if incomeType != "DIV" {
+					trade.Errors = append(trade.Errors, fmt.Sprintf("tipo de ingreso %s no soportado", incomeType))
+				}
+				trade.Amount = record.number("TOTAL").Abs()
+				trade.Tax = record.number("WITHHOLDING").Abs()
+			} else {
+				trade.Shares = record.number("UNITS").Abs()
+				trade.Price = record.number("UNITPRICE")
+				trade.Fees = record.number("COMMISSION").Abs().Add(record.number("FEES").Abs())
+				trade.Tax = record.number("TAXES").Abs().Add(record.number("WITHHOLDING").Abs())
+			}
+
+			trades = append(trades, trade)
+		}
+	}
+
+	return trades, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ofxSample es un extracto OFX 1.x (SGML) de un broker estadounidense con el
+// valor identificado por CUSIP y una transacción repetida.
+const ofxSample = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+SECURITY:NONE
+ENCODING:USASCII
+CHARSET:1252
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20240301120000.000[-5:EST]<LANGUAGE>ENG</SONRS></SIGNONMSGSRSV1>
+<INVSTMTMSGSRSV1><INVSTMTTRNRS><TRNUID>1<STATUS><CODE>0<SEVERITY>INFO</STATUS>
+<INVSTMTRS>
+<DTASOF>20240301
+<CURDEF>USD
+<INVACCTFROM><BROKERID>example.com<ACCTID>123456</INVACCTFROM>
+<INVTRANLIST>
+<DTSTART>20240101<DTEND>20240301
+<BUYSTOCK><INVBUY><INVTRAN><FITID>T-1001<DTTRADE>20240115093000.000[-5:EST]</INVTRAN>
+<SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>10<UNITPRICE>388.47<COMMISSION>1.00<FEES>0.02<TOTAL>-3885.72<SUBACCTSEC>CASH<SUBACCTFUND>CASH</INVBUY><BUYTYPE>BUY</BUYSTOCK>
+<SELLSTOCK><INVSELL><INVTRAN><FITID>T-1002<DTTRADE>20240220</INVTRAN>
+<SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>-4<UNITPRICE>402.10<COMMISSION>-1.00<TOTAL>1607.40<SUBACCTSEC>CASH<SUBACCTFUND>CASH</INVSELL><SELLTYPE>SELL</SELLSTOCK>
+<INCOME><INVTRAN><FITID>T-1003<DTTRADE>20240314</INVTRAN>
+<SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<INCOMETYPE>DIV<TOTAL>7.50<WITHHOLDING>1.13<SUBACCTSEC>CASH<SUBACCTFUND>CASH</INCOME>
+<BUYSTOCK><INVBUY><INVTRAN><FITID>T-1001<DTTRADE>20240115093000</INVTRAN>
+<SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>10<UNITPRICE>388.47<COMMISSION>1.00<TOTAL>-3885.70<SUBACCTSEC>CASH<SUBACCTFUND>CASH</INVBUY><BUYTYPE>BUY</BUYSTOCK>
+<BUYSTOCK><INVBUY><INVTRAN><FITID>T-1004<DTTRADE>20240116</INVTRAN>
+<SECID><UNIQUEID>US0378331006<UNIQUEIDTYPE>ISIN</SECID>
+<UNITS>2<UNITPRICE>182,32<TOTAL>-364.64<SUBACCTSEC>CASH<SUBACCTFUND>CASH</INVBUY><BUYTYPE>BUY</BUYSTOCK>
+</INVTRANLIST>
+</INVSTMTRS>
+</INVSTMTTRNRS></INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1><SECLIST>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID><SECNAME>MICROSOFT CORP<TICKER>MSFT</SECINFO></STOCKINFO>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>US0378331006<UNIQUEIDTYPE>ISIN</SECID><SECNAME>APPLE INC<TICKER>AAPL</SECINFO></STOCKINFO>
+</SECLIST></SECLISTMSGSRSV1>
+</OFX>
+`
+
+func TestParseOFXTrades(t *testing.T) {
+	trades, err := parseOFXTrades([]byte(ofxSample))
+	if err != nil {
+		t.Fatalf("parseOFXTrades: %v", err)
+	}
+	if len(trades) != 5 {
+		t.Fatalf("se leyeron %d operaciones, se esperaban 5", len(trades))
+	}
+
+	buy := trades[0]
+	if buy.Type != ImportBuy || buy.ExternalID != "T-1001" || buy.Symbol != "MSFT" || buy.ISIN != "US5949181045" {
+		t.Errorf("compra = %s %s %s %s, se esperaba buy T-1001 MSFT US5949181045", buy.Type, buy.ExternalID, buy.Symbol, buy.ISIN)
+	}
+	if !buy.Shares.Equal(decimal.NewFromInt(10)) || buy.Price.String() != "388.47" || buy.Fees.String() != "1.02" || buy.Currency != "USD" {
+		t.Errorf("compra: %s a %s con costes %s en %s", buy.Shares, buy.Price, buy.Fees, buy.Currency)
+	}
+	if want := time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC); !buy.Date.Equal(want) {
+		t.Errorf("fecha %v, se esperaba %v", buy.Date, want)
+	}
+
+	// Las ventas traen las unidades y la comisión en negativo
+	if sell := trades[1]; sell.Type != ImportSell || !sell.Shares.Equal(decimal.NewFromInt(4)) || sell.Fees.String() != "1" {
+		t.Errorf("venta = %s %s con costes %s, se esperaba sell 4 con costes 1", sell.Type, sell.Shares, sell.Fees)
+	}
+	if div := trades[2]; div.Type != ImportDividend || div.Amount.String() != "7.5" || div.Tax.String() != "1.13" {
+		t.Errorf("dividendo = %s %s con retención %s", div.Type, div.Amount, div.Tax)
+	}
+	// Algunos brokers usan coma decimal dentro del OFX
+	if trades[4].Price.String() != "182.32" {
+		t.Errorf("precio con coma decimal = %s, se esperaba 182.32", trades[4].Price)
+	}
+}
+
+func TestImportOFXDeduplicatesByFITID(t *testing.T) {
+	useTestDatabase(t)
+	trades, err := parseOFXTrades([]byte(ofxSample))
+	if err != nil {
+		t.Fatalf("parseOFXTrades: %v", err)
+	}
+	resolveImportedTrades(trades)
+
+	if !hasImportError(trades[4], "ISIN US0378331006 inválido") {
+		t.Errorf("ISIN inválido aceptado: %v", trades[4].Errors)
+	}
+	// La transacción repetida comparte FITID aunque el total difiera
+	if trades[0].Duplicate || !trades[3].Duplicate {
+		t.Errorf("duplicadas: primera %v, repetida %v; se esperaba solo la repetida", trades[0].Duplicate, trades[3].Duplicate)
+	}
+
+	result, err := commitImportedTrades(trades, nil, "test")
+	if err != nil {
+		t.Fatalf("commitImportedTrades: %v", err)
+	}
+	if result.Investments != 1 || result.Sales != 1 || result.Dividends != 1 {
+		t.Errorf("resultado = %+v, se esperaba una operación de cada tipo", result)
+	}
+
+	// Al importar de nuevo el mismo extracto todas las operaciones ya existen
+	again, _ := parseOFXTrades([]byte(ofxSample))
+	resolveImportedTrades(again)
+	for _, trade := range again[:4] {
+		if !trade.Duplicate {
+			t.Errorf("operación %s %s importada dos veces", trade.Type, trade.ExternalID)
+		}
+	}
+}
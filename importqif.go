@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// qifDateFormats son los formatos de fecha habituales en ficheros QIF, una vez
+// sustituido el apóstrofo de los años (1/15'24) por una barra.
+var qifDateFormats = []string{"1/2/2006", "1/2/06", "2006-01-02", "02.01.2006"}
+
+// parseQIFDate interpreta una fecha QIF.
+func parseQIFDate(value string) (time.Time, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), "'", "/")
+	value = strings.ReplaceAll(value, " ", "")
+	// Quicken escribe los años 2000 a 2009 con una sola cifra: 3/14' 4
+	if i := strings.LastIndexByte(value, '/'); i >= 0 && len(value)-i == 2 {
+		value = value[:i+1] + "0" + value[i+1:]
+	}
+	for _, format := range qifDateFormats {
+		if date, err := time.Parse(format, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fecha QIF %q inválida", value)
+}
+
+// qifActions traduce las acciones de inversión QIF a tipos de operación.
+var qifActions = map[string]string{
+	"buy":   ImportBuy,
+	"buyx":  ImportBuy,
+	"sell":  ImportSell,
+	"sellx": ImportSell,
+	"div":   ImportDividend,
+	"divx":  ImportDividend,
+}
+
+// parseQIFTrades extrae las operaciones de las secciones !Type:Invst de un
+// fichero QIF. Los símbolos se obtienen de las secciones !Type:Security si
+// existen; si no, se usa el nombre del valor.
+func parseQIFTrades(data []byte) ([]ImportedTrade, error) {
+	var records []map[byte]string
+	hasInvestments := false
+	symbols := make(map[string]string)
+
+	section := ""
+	record := make(map[byte]string)
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			section = strings.ToLower(line)
+			if section == "!type:invst" {
+				hasInvestments = true
+			}
+			continue
+		}
+		if line == "^" {
+			switch section {
+			case "!type:invst":
+				records = append(records, record)
+			case "!type:security":
+				if record['N'] != "" && record['S'] != "" {
+					symbols[record['N']] = record['S']
+				}
+			}
+			record = make(map[byte]string)
+			continue
+		}
+		// Solo se guarda el primer valor de cada campo
+		if _, exists := record[line[0]]; !exists {
+			record[line[0]] = strings.TrimSpace(line[1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !hasInvestments {
+		return nil, fmt.Errorf("el fichero no contiene operaciones de inversión (!Type:Invst)")
+	}
+
+	var trades []ImportedTrade
+	for i, r := range records {
+		// Ignorar movimientos de efectivo sin valor asociado
+		if r['Y'] == "" {
+			continue
+		}
+
+		trade := ImportedTrade{
+			Row:    i + 1,
+			Name:   r['Y'],
+			Symbol: r['Y'],
+		}
+		if symbol, ok := symbols[r['Y']]; ok {
+			trade.Symbol = symbol
+		}
+
+		var err error
+		trade.Date, err = parseQIFDate(r['D'])
+		if err != nil {
+			trade.Errors = append(trade.Errors, err.Error())
+		}
+
+		action := strings.ToLower(r['N'])
+		tradeType, ok := qifActions[action]
+		if !ok {
+			trade.Errors = append(trade.Errors, fmt.Sprintf("acción QIF %s no soportada", r['N']))
+		}
+		trade.Type = tradeType
+
+		total, _ := parseImportNumber(r['T'], false)
+		fees, _ := parseImportNumber(r['O'], false)
+		trade.Fees = fees.Abs()
+
+		if tradeType == ImportDividend {
+			trade.Amount = total.Abs()
+			trades = append(trades, trade)
+			continue
+		}
+
+		shares, _ := parseImportNumber(r['Q'], false)
+		trade.Shares = shares.Abs()
+		trade.Price, _ = parseImportNumber(r['I'], false)
+
+		// Si falta el precio se deduce del total sin comisiones
+		if trade.Price.IsZero() && trade.Shares.IsPositive() {
+			if tradeType == ImportBuy {
+				trade.Price = total.Abs().Sub(trade.Fees).Div(trade.Shares)
+			} else {
+				trade.Price = total.Abs().Add(trade.Fees).Div(trade.Shares)
+			}
+		}
+
+		trades = append(trades, trade)
+	}
+
+	return trades, nil
+}
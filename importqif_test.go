@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseQIFTrades(t *testing.T) {
+	// Exportación de Quicken con fechas de año abreviado, precio ausente en
+	// una compra y movimientos de efectivo mezclados con las operaciones
+	data := "\xef\xbb\xbf" + `!Type:Security
+NMicrosoft Corp
+SMSFT
+TStock
+^
+!Type:Invst
+D1/15'24
+NBuy
+YMicrosoft Corp
+I388.47
+Q10
+T3,885.70
+O1.00
+^
+D2/20'24
+NSell
+YMicrosoft Corp
+Q-4
+T1,607.40
+O1.00
+^
+D 3/14' 4
+NDiv
+YMicrosoft Corp
+T7.50
+^
+D3/15'24
+NXIn
+T1,000.00
+^
+D3/16'24
+NReinvDiv
+YMicrosoft Corp
+Q0.02
+T7.50
+^
+`
+	trades, err := parseQIFTrades([]byte(data))
+	if err != nil {
+		t.Fatalf("parseQIFTrades: %v", err)
+	}
+	if len(trades) != 4 {
+		t.Fatalf("se leyeron %d operaciones, se esperaban 4 (sin el movimiento de efectivo)", len(trades))
+	}
+
+	buy := trades[0]
+	if buy.Type != ImportBuy || buy.Symbol != "MSFT" || buy.Name != "Microsoft Corp" || !buy.Shares.Equal(decimal.NewFromInt(10)) ||
+		buy.Price.String() != "388.47" || buy.Fees.String() != "1" {
+		t.Errorf("compra = %+v", buy)
+	}
+	if want := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC); !buy.Date.Equal(want) {
+		t.Errorf("fecha %v, se esperaba %v", buy.Date, want)
+	}
+
+	// Sin precio se deduce del total sumando la comisión de la venta
+	sell := trades[1]
+	if sell.Type != ImportSell || !sell.Shares.Equal(decimal.NewFromInt(4)) || sell.Price.String() != "402.1" {
+		t.Errorf("venta = %s %s a %s, se esperaba sell 4 a 402.1", sell.Type, sell.Shares, sell.Price)
+	}
+
+	if div := trades[2]; div.Type != ImportDividend || div.Amount.String() != "7.5" ||
+		!div.Date.Equal(time.Date(2004, 3, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("dividendo = %s %s el %v", div.Type, div.Amount, div.Date)
+	}
+	if !hasImportError(trades[3], "acción QIF ReinvDiv no soportada") {
+		t.Errorf("acción no soportada aceptada: %v", trades[3].Errors)
+	}
+}
+
+func TestParseQIFWithoutInvestments(t *testing.T) {
+	data := "!Type:Bank\nD1/15'24\nT-25.00\nPSupermercado\n^\n"
+	if _, err := parseQIFTrades([]byte(data)); err == nil {
+		t.Error("un QIF sin !Type:Invst debería fallar")
+	}
+}
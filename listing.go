@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Campos por los que se pueden ordenar los listados de compras y ventas
+const (
+	TradeSortDate   = "date"
+	TradeSortTicker = "ticker"
+	TradeSortShares = "shares"
+	TradeSortPrice  = "price"
+	TradeSortAmount = "amount" // Acciones × precio
+	TradeSortCost   = "cost"
+)
+
+// Tamaño de página de los listados
+const (
+	defaultTradePageSize = 50
+	maxTradePageSize     = 500
+)
+
+// tradePageSizes son los tamaños de página que ofrece la UI.
+var tradePageSizes = []int{25, 50, 100, 250}
+
+// tradeTable describe las columnas de una tabla de operaciones (compras o ventas).
+type tradeTable struct {
+	Name        string
+	DateColumn  string
+	PriceColumn string
+}
+
+var (
+	investmentTradeTable = tradeTable{Name: "investments", DateColumn: "purchase_date", PriceColumn: "purchase_price"}
+	saleTradeTable       = tradeTable{Name: "sales", DateColumn: "sale_date", PriceColumn: "sale_price"}
+)
+
+// TradeListParams son los filtros, el orden y la página de un listado de
+// compras o ventas, leídos de la query string.
+type TradeListParams struct {
+	TickerID  uint
+	From      time.Time // Inclusive; cero si no se filtra
+	To        time.Time // Inclusive (día completo); cero si no se filtra
+	MinAmount decimal.NullDecimal
+	MaxAmount decimal.NullDecimal
+	Sort      string
+	Desc      bool
+	Page      int
+	PageSize  int
+}
+
+// TradePage son los datos de paginación de un listado.
+type TradePage struct {
+	Page       int   `json:"page"`
+	PageSize   int   `json:"page_size"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// parseTradeListParams lee los parámetros de listado de la petición. Los
+// valores vacíos se ignoran; los inválidos devuelven un error descriptivo.
+func parseTradeListParams(c *gin.Context) (TradeListParams, error) {
+	params := TradeListParams{Sort: TradeSortDate, Desc: true, Page: 1, PageSize: defaultTradePageSize}
+
+	if value := c.Query("ticker_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			return params, fmt.Errorf("ticker_id inválido: %q", value)
+		}
+		params.TickerID = uint(id)
+	}
+	for _, date := range []struct {
+		key    string
+		target *time.Time
+	}{{"from", &params.From}, {"to", &params.To}} {
+		if value := c.Query(date.key); value != "" {
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return params, fmt.Errorf("%s debe tener el formato AAAA-MM-DD", date.key)
+			}
+			*date.target = t
+		}
+	}
+	for _, amount := range []struct {
+		key    string
+		target *decimal.NullDecimal
+	}{{"min_amount", &params.MinAmount}, {"max_amount", &params.MaxAmount}} {
+		if value := c.Query(amount.key); value != "" {
+			d, err := parseDecimal(value)
+			if err != nil {
+				return params, fmt.Errorf("%s debe ser un número", amount.key)
+			}
+			*amount.target = decimal.NewNullDecimal(d)
+		}
+	}
+	if value := c.Query("sort"); value != "" {
+		switch value {
+		case TradeSortDate, TradeSortTicker, TradeSortShares, TradeSortPrice, TradeSortAmount, TradeSortCost:
+			params.Sort = value
+		default:
+			return params, fmt.Errorf("sort inválido: %q (date, ticker, shares, price, amount o cost)", value)
+		}
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		params.Desc = false
+	case "desc":
+		params.Desc = true
+	default:
+		return params, fmt.Errorf("order debe ser asc o desc")
+	}
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return params, fmt.Errorf("page debe ser un entero positivo")
+		}
+		params.Page = page
+	}
+	if value := c.Query("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > maxTradePageSize {
+			return params, fmt.Errorf("page_size debe estar entre 1 y %d", maxTradePageSize)
+		}
+		params.PageSize = size
+	}
+	return params, nil
+}
+
+// order devuelve la dirección de ordenación en SQL.
+func (p TradeListParams) order() string {
+	return sortOrder(p.Desc)
+}
+
+func sortOrder(desc bool) string {
+	if desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// filter aplica los filtros a una consulta sobre la tabla indicada. El rango
+// de fechas y el ticker aprovechan los índices (ticker_id, fecha) y (fecha).
+func (p TradeListParams) filter(query *gorm.DB, table tradeTable) *gorm.DB {
+	if p.TickerID != 0 {
+		query = query.Where(table.Name+".ticker_id = ?", p.TickerID)
+	}
+	if !p.From.IsZero() {
+		query = query.Where(table.Name+"."+table.DateColumn+" >= ?", p.From)
+	}
+	if !p.To.IsZero() {
+		query = query.Where(table.Name+"."+table.DateColumn+" < ?", p.To.AddDate(0, 0, 1))
+	}
+	// CAST para que SQLite compare números y no el texto del parámetro
+	amount := table.Name + ".shares * " + table.Name + "." + table.PriceColumn
+	if p.MinAmount.Valid {
+		query = query.Where(amount+" >= CAST(? AS NUMERIC)", p.MinAmount.Decimal.String())
+	}
+	if p.MaxAmount.Valid {
+		query = query.Where(amount+" <= CAST(? AS NUMERIC)", p.MaxAmount.Decimal.String())
+	}
+	return query
+}
+
+// sorted aplica el orden a una consulta. El ID desempata para que la
+// paginación sea estable.
+func (p TradeListParams) sorted(query *gorm.DB, table tradeTable) *gorm.DB {
+	var column string
+	switch p.Sort {
+	case TradeSortTicker:
+		query = query.Joins("JOIN tickers ON tickers.id = " + table.Name + ".ticker_id")
+		column = "tickers.name"
+	case TradeSortShares:
+		column = table.Name + ".shares"
+	case TradeSortPrice:
+		column = table.Name + "." + table.PriceColumn
+	case TradeSortAmount:
+		column = table.Name + ".shares * " + table.Name + "." + table.PriceColumn
+	case TradeSortCost:
+		column = table.Name + ".operation_cost"
+	default:
+		column = table.Name + "." + table.DateColumn
+	}
+	return query.Order(column + " " + p.order()).Order(table.Name + ".id " + p.order())
+}
+
+// paginate cuenta los registros filtrados y carga la página pedida en dest.
+func (p TradeListParams) paginate(model interface{}, dest interface{}, table tradeTable) (TradePage, error) {
+	page := TradePage{Page: p.Page, PageSize: p.PageSize}
+	if err := p.filter(db.Model(model), table).Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+	page.TotalPages = int(math.Ceil(float64(page.Total) / float64(p.PageSize)))
+
+	query := p.sorted(p.filter(db.Preload("Ticker"), table), table)
+	err := query.Offset((p.Page - 1) * p.PageSize).Limit(p.PageSize).Find(dest).Error
+	return page, err
+}
+
+// listInvestments devuelve una página de compras con su ticker precargado.
+func listInvestments(p TradeListParams) ([]Investment, TradePage, error) {
+	var investments []Investment
+	page, err := p.paginate(&Investment{}, &investments, investmentTradeTable)
+	return investments, page, err
+}
+
+// listSales devuelve una página de ventas con su ticker precargado.
+func listSales(p TradeListParams) ([]Sale, TradePage, error) {
+	var sales []Sale
+	page, err := p.paginate(&Sale{}, &sales, saleTradeTable)
+	return sales, page, err
+}
+
+// newInvestmentViews valora una página de compras al precio actual de su ticker.
+func newInvestmentViews(investments []Investment) []InvestmentView {
+	views := make([]InvestmentView, 0, len(investments))
+	for _, inv := range investments {
+		views = append(views, newInvestmentView(inv, inv.Ticker.Name, inv.Ticker.CurrentPrice, inv.Ticker.Currency))
+	}
+	return views
+}
+
+// newSaleViews calcula la vista de una página de ventas. El WAC de cada venta
+// necesita reproducir el historial completo de sus tickers.
+func newSaleViews(sales []Sale) ([]SaleView, error) {
+	views := make([]SaleView, 0, len(sales))
+	if len(sales) == 0 {
+		return views, nil
+	}
+	tickerIDs := make([]uint, 0, len(sales))
+	for _, s := range sales {
+		tickerIDs = append(tickerIDs, s.TickerID)
+	}
+	wacs, err := saleWACs(db, tickerIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sales {
+		view := newSaleView(s, s.Ticker.Name, wacs[s.ID], s.Ticker.Currency)
+		views = append(views, withCurrentPrice(view, s, s.Ticker.CurrentPrice, s.Ticker.Currency))
+	}
+	return views, nil
+}
+
+// TradeListView acompaña a las páginas de compras y ventas con los filtros
+// aplicados y los enlaces de ordenación y paginación.
+type TradeListView struct {
+	Path      string
+	Params    TradeListParams
+	Page      TradePage
+	PageSizes []int
+}
+
+func newTradeListView(path string, params TradeListParams, page TradePage) TradeListView {
+	return TradeListView{Path: path, Params: params, Page: page, PageSizes: tradePageSizes}
+}
+
+// values codifica los parámetros en una query string, omitiendo los valores por defecto.
+func (v TradeListView) values() url.Values {
+	p := v.Params
+	values := url.Values{}
+	if p.TickerID != 0 {
+		values.Set("ticker_id", strconv.FormatUint(uint64(p.TickerID), 10))
+	}
+	if !p.From.IsZero() {
+		values.Set("from", p.From.Format("2006-01-02"))
+	}
+	if !p.To.IsZero() {
+		values.Set("to", p.To.Format("2006-01-02"))
+	}
+	if p.MinAmount.Valid {
+		values.Set("min_amount", p.MinAmount.Decimal.String())
+	}
+	if p.MaxAmount.Valid {
+		values.Set("max_amount", p.MaxAmount.Decimal.String())
+	}
+	if p.Sort != TradeSortDate || !p.Desc {
+		values.Set("sort", p.Sort)
+		values.Set("order", p.order())
+	}
+	if p.Page > 1 {
+		values.Set("page", strconv.Itoa(p.Page))
+	}
+	if p.PageSize != defaultTradePageSize {
+		values.Set("page_size", strconv.Itoa(p.PageSize))
+	}
+	return values
+}
+
+func (v TradeListView) url(values url.Values) string {
+	if len(values) == 0 {
+		return v.Path
+	}
+	return v.Path + "?" + values.Encode()
+}
+
+// CurrentURL es la URL de la página actual, para volver a ella tras editar o eliminar.
+func (v TradeListView) CurrentURL() string {
+	return v.url(v.values())
+}
+
+// PageURL es la URL de otra página con los mismos filtros y orden.
+func (v TradeListView) PageURL(page int) string {
+	values := v.values()
+	values.Del("page")
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return v.url(values)
+}
+
+// SortURL ordena por el campo indicado, invirtiendo el sentido si ya es el
+// campo actual. Vuelve a la primera página.
+func (v TradeListView) SortURL(field string) string {
+	values := v.values()
+	values.Del("page")
+	desc := true
+	if v.Params.Sort == field {
+		desc = !v.Params.Desc
+	}
+	values.Del("sort")
+	values.Del("order")
+	if field != TradeSortDate || !desc {
+		values.Set("sort", field)
+		values.Set("order", sortOrder(desc))
+	}
+	return v.url(values)
+}
+
+// SortIndicator devuelve la flecha del campo por el que se ordena.
+func (v TradeListView) SortIndicator(field string) string {
+	if v.Params.Sort != field {
+		return ""
+	}
+	if v.Params.Desc {
+		return "▼"
+	}
+	return "▲"
+}
+
+// TradeSortHeader es el enlace de ordenación de una cabecera de columna.
+type TradeSortHeader struct {
+	Label     string
+	URL       string
+	Indicator string
+}
+
+// SortHeader prepara la cabecera de una columna ordenable en el servidor.
+func (v TradeListView) SortHeader(field, label string) TradeSortHeader {
+	return TradeSortHeader{Label: label, URL: v.SortURL(field), Indicator: v.SortIndicator(field)}
+}
+
+// FromValue, ToValue, MinAmountValue y MaxAmountValue rellenan el formulario de filtros.
+func (v TradeListView) FromValue() string { return formatFilterDate(v.Params.From) }
+func (v TradeListView) ToValue() string   { return formatFilterDate(v.Params.To) }
+func (v TradeListView) MinAmountValue() string {
+	return formatFilterAmount(v.Params.MinAmount)
+}
+func (v TradeListView) MaxAmountValue() string {
+	return formatFilterAmount(v.Params.MaxAmount)
+}
+
+func formatFilterDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func formatFilterAmount(d decimal.NullDecimal) string {
+	if !d.Valid {
+		return ""
+	}
+	return d.Decimal.String()
+}
+
+// Filtered indica si hay algún filtro aplicado.
+func (v TradeListView) Filtered() bool {
+	p := v.Params
+	return p.TickerID != 0 || !p.From.IsZero() || !p.To.IsZero() || p.MinAmount.Valid || p.MaxAmount.Valid
+}
+
+// FirstItem y LastItem son las posiciones (desde 1) de la página mostrada.
+func (v TradeListView) FirstItem() int64 {
+	if v.Page.Total == 0 {
+		return 0
+	}
+	return int64((v.Page.Page-1)*v.Page.PageSize) + 1
+}
+
+func (v TradeListView) LastItem() int64 {
+	last := int64(v.Page.Page * v.Page.PageSize)
+	if last > v.Page.Total {
+		return v.Page.Total
+	}
+	return last
+}
+
+// HasPrev, HasNext, PrevPage y NextPage controlan los botones de paginación.
+func (v TradeListView) HasPrev() bool { return v.Page.Page > 1 }
+func (v TradeListView) HasNext() bool { return v.Page.Page < v.Page.TotalPages }
+func (v TradeListView) PrevPage() int { return v.Page.Page - 1 }
+func (v TradeListView) NextPage() int { return v.Page.Page + 1 }
+
+// tradeListQueryError convierte un parámetro de listado inválido en un error de la API.
+func tradeListQueryError(err error) error {
+	return &apiError{Status: http.StatusBadRequest, Code: APICodeInvalidQuery, Message: err.Error()}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// Tipos de evento que se envían a las páginas abiertas
+const (
+	LiveEventPrice     = "price"     // Cambio del precio de un ticker
+	LiveEventPositions = "positions" // Valoración de la cartera recalculada
+	LiveEventSnapshot  = "snapshot"  // Nuevo snapshot de precios
+)
+
+// Parámetros del flujo de eventos
+const (
+	liveBufferSize        = 32               // Eventos pendientes por suscriptor
+	liveHeartbeatInterval = 25 * time.Second // Comentario periódico para mantener viva la conexión
+)
+
+// LiveEvent es un evento del flujo en directo.
+type LiveEvent struct {
+	Type string
+	Data interface{}
+}
+
+// LivePrice es el contenido de un evento price.
+type LivePrice struct {
+	TickerID     uint            `json:"ticker_id"`
+	Ticker       string          `json:"ticker"`
+	CurrentPrice decimal.Decimal `json:"current_price"`
+	Currency     string          `json:"currency"`
+	UpdatedAt    string          `json:"updated_at"` // Mismo formato que /precios
+	Version      string          `json:"version"`    // Versión para los formularios de edición
+	Source       string          `json:"source"`
+}
+
+// LivePortfolio es el contenido de un evento positions: los indicadores del
+// dashboard y el resumen de cada posición.
+type LivePortfolio struct {
+	NumPositions         int                 `json:"num_positions"`
+	TotalOperationCost   decimal.Decimal     `json:"total_operation_cost"`
+	TotalSaleUtility     decimal.Decimal     `json:"total_sale_utility"`
+	PortfolioPerformance float64             `json:"portfolio_performance"`
+	PortfolioUtility     decimal.Decimal     `json:"portfolio_utility"`
+	ExitValue            decimal.Decimal     `json:"exit_value"`
+	Positions            []TickerSummaryView `json:"positions"`
+	Source               string              `json:"source"`
+}
+
+// LiveSnapshot es el contenido de un evento snapshot.
+type LiveSnapshot struct {
+	SnapshotID string                   `json:"snapshot_id"`
+	CreatedAt  string                   `json:"created_at"` // Formato de los gráficos: "02 Jan 2006 15:04"
+	Prices     map[uint]decimal.Decimal `json:"prices"`
+	Changes    map[uint]*float64        `json:"changes"` // Cambio respecto al snapshot anterior
+}
+
+// eventHub reparte los eventos entre los clientes conectados al flujo. Cada
+// suscriptor tiene un buffer propio; si se llena, el evento se descarta para
+// ese cliente en lugar de bloquear a quien publica.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan LiveEvent]struct{}
+}
+
+// liveHub es el hub de eventos del proceso.
+var liveHub = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan LiveEvent]struct{})}
+}
+
+// Subscribe registra un cliente y devuelve su canal de eventos.
+func (h *eventHub) Subscribe() chan LiveEvent {
+	ch := make(chan LiveEvent, liveBufferSize)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe da de baja a un cliente y cierra su canal.
+func (h *eventHub) Unsubscribe(ch chan LiveEvent) {
+	h.mu.Lock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	h.mu.Unlock()
+}
+
+// HasSubscribers indica si hay algún cliente conectado, para no calcular
+// eventos que nadie va a recibir.
+func (h *eventHub) HasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+// Publish envía un evento a todos los clientes conectados.
+func (h *eventHub) Publish(event LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Evento %s descartado: el cliente no lo consume a tiempo", event.Type)
+		}
+	}
+}
+
+// publishPriceChanges envía el precio actual de los tickers indicados y la
+// valoración de la cartera recalculada con esos precios.
+func publishPriceChanges(source string, tickerIDs ...uint) {
+	if len(tickerIDs) == 0 || !liveHub.HasSubscribers() {
+		return
+	}
+
+	var tickers []Ticker
+	if err := db.Where("id IN ?", tickerIDs).Find(&tickers).Error; err != nil {
+		log.Printf("Error al obtener los tickers para el flujo en directo: %v", err)
+		return
+	}
+	for _, t := range tickers {
+		liveHub.Publish(LiveEvent{Type: LiveEventPrice, Data: LivePrice{
+			TickerID:     t.ID,
+			Ticker:       t.Name,
+			CurrentPrice: t.CurrentPrice,
+			Currency:     t.Currency,
+			UpdatedAt:    t.UpdatedAt.Format("02 Jan 2006 15:04"),
+			Version:      recordVersion(t.UpdatedAt),
+			Source:       source,
+		}})
+	}
+	publishPositions(source)
+}
+
+// publishPositions recalcula la valoración de la cartera y la envía.
+func publishPositions(source string) {
+	if !liveHub.HasSubscribers() {
+		return
+	}
+
+	_, summaries, sales, _, _, totalOperationCost, _, portfolioPerformance, portfolioUtility, numPositions, err := getInvestmentData()
+	if err != nil {
+		log.Printf("Error al recalcular la cartera para el flujo en directo: %v", err)
+		return
+	}
+
+	// Mismos indicadores que el dashboard
+	totalSaleUtility := decimal.Zero
+	for _, s := range sales {
+		totalSaleUtility = totalSaleUtility.Add(s.SaleUtility)
+	}
+
+	liveHub.Publish(LiveEvent{Type: LiveEventPositions, Data: LivePortfolio{
+		NumPositions:         numPositions,
+		TotalOperationCost:   totalOperationCost,
+		TotalSaleUtility:     totalSaleUtility,
+		PortfolioPerformance: portfolioPerformance,
+		PortfolioUtility:     portfolioUtility,
+		ExitValue:            portfolioExitValue(totalSaleUtility, portfolioUtility, totalOperationCost, numPositions),
+		Positions:            summaries,
+		Source:               source,
+	}})
+}
+
+// publishSnapshot envía los precios de un snapshot recién creado y el cambio
+// de cada ticker respecto al anterior.
+func publishSnapshot(snapshotID string, priceHistories []PriceHistory) {
+	if !liveHub.HasSubscribers() || len(priceHistories) == 0 {
+		return
+	}
+
+	event := LiveSnapshot{
+		SnapshotID: snapshotID,
+		CreatedAt:  priceHistories[0].CreatedAt.Format("02 Jan 2006 15:04"),
+		Prices:     make(map[uint]decimal.Decimal),
+		Changes:    latestSnapshotChanges(),
+	}
+	for _, ph := range priceHistories {
+		event.Prices[ph.TickerID] = ph.Price
+	}
+
+	liveHub.Publish(LiveEvent{Type: LiveEventSnapshot, Data: event})
+}
+
+// registerLiveRoutes registra el flujo de eventos en directo (Server-Sent Events).
+func registerLiveRoutes(router *gin.Engine) {
+	router.GET("/api/events", func(c *gin.Context) {
+		events := liveHub.Subscribe()
+		defer liveHub.Unsubscribe(events)
+
+		heartbeat := time.NewTicker(liveHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // Evita que un proxy nginx acumule los eventos
+		c.Status(http.StatusOK)
+		c.SSEvent("ready", gin.H{"heartbeat": int(liveHeartbeatInterval.Seconds())})
+		c.Writer.Flush()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				c.SSEvent(event.Type, event.Data)
+			case <-heartbeat.C:
+				io.WriteString(w, ": ping\n\n")
+			}
+			return true
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEventHubDeliversAndDrops(t *testing.T) {
+	hub := newEventHub()
+	if hub.HasSubscribers() {
+		t.Fatal("un hub nuevo no debería tener suscriptores")
+	}
+	a, b := hub.Subscribe(), hub.Subscribe()
+
+	hub.Publish(LiveEvent{Type: LiveEventPrice, Data: 1})
+	for _, ch := range []chan LiveEvent{a, b} {
+		if event := <-ch; event.Type != LiveEventPrice || event.Data != 1 {
+			t.Errorf("evento recibido = %+v", event)
+		}
+	}
+
+	// Un cliente que no consume pierde eventos sin bloquear a los demás
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < liveBufferSize+5; i++ {
+			hub.Publish(LiveEvent{Type: LiveEventPositions, Data: i})
+			<-b
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish se bloqueó con un suscriptor lleno")
+	}
+	if len(a) != liveBufferSize {
+		t.Errorf("eventos pendientes = %d, se esperaban %d", len(a), liveBufferSize)
+	}
+
+	// Dar de baja cierra el canal y puede repetirse sin fallar
+	hub.Unsubscribe(a)
+	hub.Unsubscribe(a)
+	for range a {
+	}
+	hub.Unsubscribe(b)
+	if hub.HasSubscribers() {
+		t.Error("quedan suscriptores tras darlos de baja")
+	}
+	hub.Publish(LiveEvent{Type: LiveEventPrice})
+}
+
+// sseEvent es un evento leído del flujo.
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// readSSEEvent lee el siguiente evento del flujo, ignorando los comentarios.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("leer el flujo: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && event.Name != "":
+			return event
+		case strings.HasPrefix(line, "event:"):
+			event.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.Data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+func TestLiveRouteStreamsPriceChanges(t *testing.T) {
+	useTestDatabase(t)
+	router := gin.New()
+	registerLiveRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("conectar al flujo: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q, se esperaba text/event-stream", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event := readSSEEvent(t, reader); event.Name != "ready" {
+		t.Fatalf("primer evento = %q, se esperaba ready", event.Name)
+	}
+
+	db.Model(&Ticker{}).Where("id = ?", 1).Update("current_price", 199.5)
+	publishPriceChanges("test", 1)
+
+	event := readSSEEvent(t, reader)
+	var price LivePrice
+	if err := json.Unmarshal([]byte(event.Data), &price); err != nil || event.Name != LiveEventPrice {
+		t.Fatalf("evento %q con datos %s: %v", event.Name, event.Data, err)
+	}
+	if price.TickerID != 1 || price.Ticker != "AAPL" || price.CurrentPrice.String() != "199.5" || price.Source != "test" || price.Version == "" {
+		t.Errorf("precio = %+v", price)
+	}
+	if event := readSSEEvent(t, reader); event.Name != LiveEventPositions {
+		t.Errorf("tras el precio llegó %q, se esperaba %s", event.Name, LiveEventPositions)
+	}
+
+	// Al cerrar la conexión el cliente se da de baja
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for liveHub.HasSubscribers() {
+		if time.Now().After(deadline) {
+			t.Fatal("el cliente desconectado sigue suscrito")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,11 +10,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -22,14 +25,23 @@ import (
 type Migration struct {
 	ID        uint   `gorm:"primaryKey"`
 	Name      string `gorm:"uniqueIndex"`
+	Checksum  string
 	AppliedAt time.Time
 }
 
 // Ticker representa un símbolo bursátil con su precio actual.
 type Ticker struct {
 	gorm.Model
-	Name         string `gorm:"uniqueIndex"`
-	CurrentPrice float64
+	Name               string          `gorm:"uniqueIndex"`
+	CurrentPrice       decimal.Decimal `gorm:"type:numeric"`
+	ISIN               string
+	ExchangeMIC        string // Código MIC del mercado (ISO 10383), ej: XMAD
+	Currency           string // Código ISO 4217, ej: EUR
+	Sector             string
+	Industry           string
+	Country            string // Código ISO 3166-1 alfa-2, ej: ES
+	AssetClass         string // stock, etf, fund, bond, crypto, other
+	YahooFinanceTicker string
 }
 
 // Investment representa una única compra de acciones en la BD.
@@ -38,9 +50,10 @@ type Investment struct {
 	TickerID      uint
 	Ticker        Ticker `gorm:"foreignKey:TickerID"`
 	PurchaseDate  time.Time
-	Shares        float64
-	PurchasePrice float64
-	OperationCost float64
+	Shares        decimal.Decimal `gorm:"type:numeric"`
+	PurchasePrice decimal.Decimal `gorm:"type:numeric"`
+	OperationCost decimal.Decimal `gorm:"type:numeric"`
+	ExternalID    string          `gorm:"index"` // Identificador de la operación en el extracto importado (FITID)
 }
 
 // Sale representa una única venta de acciones en la BD.
@@ -49,10 +62,11 @@ type Sale struct {
 	TickerID      uint
 	Ticker        Ticker `gorm:"foreignKey:TickerID"`
 	SaleDate      time.Time
-	Shares        float64
-	SalePrice     float64
-	OperationCost float64
-	WithheldTax   float64
+	Shares        decimal.Decimal `gorm:"type:numeric"`
+	SalePrice     decimal.Decimal `gorm:"type:numeric"`
+	OperationCost decimal.Decimal `gorm:"type:numeric"`
+	WithheldTax   decimal.Decimal `gorm:"type:numeric"`
+	ExternalID    string          `gorm:"index"` // Identificador de la operación en el extracto importado (FITID)
 }
 
 // PriceHistory representa un snapshot histórico de precio de un ticker.
@@ -60,8 +74,8 @@ type PriceHistory struct {
 	gorm.Model
 	SnapshotID string // UUID o timestamp para agrupar snapshots
 	TickerID   uint
-	Ticker     Ticker `gorm:"foreignKey:TickerID"`
-	Price      float64
+	Ticker     Ticker          `gorm:"foreignKey:TickerID"`
+	Price      decimal.Decimal `gorm:"type:numeric"`
 }
 
 // --- VISTAS ---
@@ -70,10 +84,12 @@ type PriceHistory struct {
 type TickerView struct {
 	ID                uint
 	Name              string
-	CurrentPrice      float64
+	CurrentPrice      decimal.Decimal
 	UpdatedAt         string
+	Version           string  // Versión del registro para el formulario de edición
 	SnapshotChange    float64 // Cambio porcentual entre los últimos 2 snapshots
 	HasSnapshotChange bool    // Indica si hay datos suficientes para mostrar el cambio
+	Metadata          TickerMetadata
 }
 
 // InvestmentView representa los datos de inversión que se mostrarán en la página.
@@ -82,26 +98,26 @@ type InvestmentView struct {
 	TickerID        uint
 	Ticker          string
 	PurchaseDate    string
-	Shares          float64
-	PurchasePrice   float64
-	OperationCost   float64
-	InvestedCapital float64
-	CurrentPrice    float64
-	CurrentValue    float64
-	ProfitLoss      float64
+	Shares          decimal.Decimal
+	PurchasePrice   decimal.Decimal
+	OperationCost   decimal.Decimal
+	InvestedCapital decimal.Decimal
+	CurrentPrice    decimal.Decimal
+	CurrentValue    decimal.Decimal
+	ProfitLoss      decimal.Decimal
 	Performance     float64
 }
 
 // TickerSummaryView representa un resumen de las inversiones por ticker.
 type TickerSummaryView struct {
-	TickerID          uint
-	Ticker            string
-	TotalShares       float64
-	CurrentInvestment float64
-	TotalCost         float64
-	CurrentValue      float64
-	ProfitLoss        float64
-	Performance       float64
+	TickerID          uint            `json:"ticker_id"`
+	Ticker            string          `json:"ticker"`
+	TotalShares       decimal.Decimal `json:"total_shares"`
+	CurrentInvestment decimal.Decimal `json:"current_investment"`
+	TotalCost         decimal.Decimal `json:"total_cost"`
+	CurrentValue      decimal.Decimal `json:"current_value"`
+	ProfitLoss        decimal.Decimal `json:"profit_loss"`
+	Performance       float64         `json:"performance"`
 }
 
 // SaleView representa los datos de venta que se mostrarán en la página.
@@ -110,19 +126,20 @@ type SaleView struct {
 	TickerID        uint
 	Ticker          string
 	SaleDate        string
-	Shares          float64
-	SalePrice       float64
-	OperationCost   float64
-	WithheldTax     float64
-	TotalSaleValue  float64
-	CurrentPrice    float64
-	CurrentValue    float64
+	Shares          decimal.Decimal
+	SalePrice       decimal.Decimal
+	OperationCost   decimal.Decimal
+	WithheldTax     decimal.Decimal
+	TotalSaleValue  decimal.Decimal
+	CurrentPrice    decimal.Decimal
+	CurrentValue    decimal.Decimal
 	Performance     float64
-	Profit          float64
-	Projection      float64
-	WACAtSale       float64
+	Profit          decimal.Decimal
+	Projection      decimal.Decimal
+	WACAtSale       decimal.Decimal
 	SalePerformance float64
-	SaleUtility     float64
+	SaleUtility     decimal.Decimal
+	Version         string // Versión del registro para el formulario de edición
 }
 
 var db *gorm.DB
@@ -133,15 +150,42 @@ func main() {
 		log.Println("No se encontró archivo .env, usando variables de entorno del sistema")
 	}
 
-	var err error
-	// Configurar la base de datos con GORM
-	db, err = setupDatabase()
-	if err != nil {
-		log.Fatalf("Error al configurar la base de datos: %v", err)
+	// Ejecutar el subcomando indicado; sin subcomando se arranca el servidor.
+	// Cada subcomando abre la base de datos cuando la necesita
+	if err := runCLI(os.Args[1:]); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// runServeCommand arranca el servidor web.
+//
+//	bolsa_gin serve [-port 8081]
+func runServeCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.String("port", os.Getenv("PORT"), "puerto HTTP (por defecto, $PORT o 8081)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *port == "" {
+		*port = "8081"
+	}
+	if err := openDatabase(true); err != nil {
+		return err
 	}
 
 	// Configurar Gin
 	router := gin.Default()
+
+	// Validación de peticiones y respuestas contra openapi.yaml
+	if mode := openAPIValidationMode(); mode != OpenAPIValidateOff {
+		validator, err := newOpenAPIValidator(mode)
+		if err != nil {
+			log.Fatalf("Error al cargar openapi.yaml: %v", err)
+		}
+		router.Use(validator)
+		log.Printf("Validación OpenAPI activada (modo %s)", mode)
+	}
+
 	router.LoadHTMLGlob("templates/*")
 	router.Static("/static", "./static")
 
@@ -154,13 +198,12 @@ func main() {
 		}
 
 		// Calcular utilidad neta de ventas
-		totalSaleUtility := 0.0
+		totalSaleUtility := decimal.Zero
 		for _, s := range sales {
-			totalSaleUtility += s.SaleUtility
+			totalSaleUtility = totalSaleUtility.Add(s.SaleUtility)
 		}
 
-		// Calcular Valor de Salida: Utilidad Ventas + Utilidad Cartera - Costos de Operación - Número de Posiciones
-		exitValue := totalSaleUtility + portfolioUtility - totalOperationCost - float64(numPositions)
+		exitValue := portfolioExitValue(totalSaleUtility, portfolioUtility, totalOperationCost, numPositions)
 
 		c.HTML(http.StatusOK, "index.html", gin.H{
 			"Investments":          investments,
@@ -173,6 +216,8 @@ func main() {
 			"PortfolioUtility":     portfolioUtility,
 			"NumPositions":         numPositions,
 			"ExitValue":            exitValue,
+			"Dimensions":           allocationDimensions,
+			"StatementMonth":       time.Now().Format("2006-01"),
 			"ActivePage":           "home",
 		})
 	})
@@ -186,14 +231,21 @@ func main() {
 		}
 
 		c.HTML(http.StatusOK, "resumen.html", gin.H{
-			"Summaries":  summaries,
-			"ActivePage": "resumen",
+			"Summaries":   summaries,
+			"Allocations": getAllocationBreakdowns(summaries, c.Query("lookthrough") == "1"),
+			"LookThrough": c.Query("lookthrough") == "1",
+			"ActivePage":  "resumen",
 		})
 	})
 
 	// Ruta para mostrar la página de compras
 	router.GET("/compras", func(c *gin.Context) {
-		investments, _, _, _, _, _, _, _, _, _, err := getInvestmentData()
+		params, err := parseTradeListParams(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Parámetros de listado inválidos: %v", err)
+			return
+		}
+		investments, page, err := listInvestments(params)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
 			return
@@ -208,15 +260,27 @@ func main() {
 		}
 
 		c.HTML(http.StatusOK, "compras.html", gin.H{
-			"Investments": investments,
-			"Tickers":     tickerViews,
-			"ActivePage":  "compras",
+			"Investments":    newInvestmentViews(investments),
+			"Tickers":        tickerViews,
+			"List":           newTradeListView("/compras", params, page),
+			"IdempotencyKey": newIdempotencyKey(),
+			"ActivePage":     "compras",
 		})
 	})
 
 	// Ruta para mostrar la página de ventas
 	router.GET("/ventas", func(c *gin.Context) {
-		_, _, sales, _, _, _, _, _, _, _, err := getInvestmentData()
+		params, err := parseTradeListParams(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Parámetros de listado inválidos: %v", err)
+			return
+		}
+		list, page, err := listSales(params)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+		sales, err := newSaleViews(list)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
 			return
@@ -231,9 +295,11 @@ func main() {
 		}
 
 		c.HTML(http.StatusOK, "ventas.html", gin.H{
-			"Sales":      sales,
-			"Tickers":    tickerViews,
-			"ActivePage": "ventas",
+			"Sales":          sales,
+			"Tickers":        tickerViews,
+			"List":           newTradeListView("/ventas", params, page),
+			"IdempotencyKey": newIdempotencyKey(),
+			"ActivePage":     "ventas",
 		})
 	})
 
@@ -242,51 +308,8 @@ func main() {
 		var tickers []Ticker
 		db.Order("name").Find(&tickers)
 
-		// Obtener los dos últimos snapshots
-		type SnapshotInfo struct {
-			SnapshotID string
-			CreatedAt  time.Time
-		}
-		var snapshots []SnapshotInfo
-		db.Model(&PriceHistory{}).
-			Select("DISTINCT snapshot_id, MIN(created_at) as created_at").
-			Group("snapshot_id").
-			Order("created_at DESC").
-			Limit(2).
-			Scan(&snapshots)
-
-		// Crear un mapa para almacenar los cambios porcentuales por ticker
-		snapshotChanges := make(map[uint]*float64)
-
-		// Si hay al menos 2 snapshots, calcular los cambios
-		if len(snapshots) >= 2 {
-			lastSnapshotID := snapshots[0].SnapshotID
-			prevSnapshotID := snapshots[1].SnapshotID
-
-			// Obtener precios del último snapshot
-			var lastPrices []PriceHistory
-			db.Where("snapshot_id = ?", lastSnapshotID).Find(&lastPrices)
-			lastPriceMap := make(map[uint]float64)
-			for _, p := range lastPrices {
-				lastPriceMap[p.TickerID] = p.Price
-			}
-
-			// Obtener precios del snapshot anterior
-			var prevPrices []PriceHistory
-			db.Where("snapshot_id = ?", prevSnapshotID).Find(&prevPrices)
-			prevPriceMap := make(map[uint]float64)
-			for _, p := range prevPrices {
-				prevPriceMap[p.TickerID] = p.Price
-			}
-
-			// Calcular cambios porcentuales
-			for tickerID, lastPrice := range lastPriceMap {
-				if prevPrice, exists := prevPriceMap[tickerID]; exists && prevPrice > 0 {
-					change := ((lastPrice - prevPrice) / prevPrice) * 100
-					snapshotChanges[tickerID] = &change
-				}
-			}
-		}
+		// Cambios porcentuales entre los dos últimos snapshots
+		snapshotChanges := latestSnapshotChanges()
 
 		var tickerViews []TickerView
 		for _, t := range tickers {
@@ -302,53 +325,97 @@ func main() {
 				Name:              t.Name,
 				CurrentPrice:      t.CurrentPrice,
 				UpdatedAt:         t.UpdatedAt.Format("02 Jan 2006 15:04"),
+				Version:           recordVersion(t.UpdatedAt),
 				SnapshotChange:    changeVal,
 				HasSnapshotChange: hasChange,
+				Metadata:          t.Metadata(),
 			})
 		}
 
 		c.HTML(http.StatusOK, "precios.html", gin.H{
-			"Tickers":    tickerViews,
-			"ActivePage": "precios",
+			"Tickers":      tickerViews,
+			"AssetClasses": assetClassLabels,
+			"ActivePage":   "precios",
 		})
 	})
 
 	// Ruta para mostrar la página de snapshots
 	router.GET("/snapshots", func(c *gin.Context) {
 		// Obtener todos los snapshots agrupados por SnapshotID
-		type SnapshotGroup struct {
-			SnapshotID string
-			CreatedAt  time.Time
-			Count      int64
+		snapshots, err := listSnapshots()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los snapshots: %v", err)
+			return
 		}
 
-		var snapshots []SnapshotGroup
-		db.Model(&PriceHistory{}).
-			Select("snapshot_id, MIN(created_at) as created_at, COUNT(*) as count").
-			Group("snapshot_id").
-			Order("created_at DESC").
-			Scan(&snapshots)
-
 		c.HTML(http.StatusOK, "snapshots.html", gin.H{
 			"Snapshots":  snapshots,
 			"ActivePage": "snapshots",
 		})
 	})
 
+	// Rutas de detalle, exportación y restauración de snapshots
+	registerSnapshotRoutes(router)
+
+	// Rutas de gestión de alertas de precio
+	registerAlertRoutes(router)
+
+	// Rutas de la lista de seguimiento
+	registerWatchlistRoutes(router)
+
+	// Rutas de reparto de la cartera y componentes de ETFs
+	registerAllocationRoutes(router)
+
+	// Rutas de importación de extractos de brokers
+	registerImportRoutes(router)
+
+	// Rutas de copia de seguridad y restauración
+	registerArchiveRoutes(router)
+
+	// Rutas de exportación a Excel
+	registerXLSXRoutes(router)
+
+	// Rutas de extractos de cartera en PDF
+	registerStatementRoutes(router)
+
+	// Rutas del registro de auditoría
+	registerAuditRoutes(router)
+
+	// Rutas de la papelera
+	registerTrashRoutes(router)
+
+	// API REST versionada
+	registerAPIV1Routes(router)
+
+	// Operaciones en lote de la API v1
+	registerBatchRoutes(router)
+
+	// Flujo de eventos en directo (precios, cartera y snapshots)
+	registerLiveRoutes(router)
+
+	// Rutas de gestión de webhooks salientes
+	registerWebhookRoutes(router)
+
+	// API GraphQL de consulta
+	registerGraphQLRoutes(router)
+
+	// Especificación OpenAPI y Swagger UI
+	registerOpenAPIRoutes(router)
+
 	// Ruta para agregar un nuevo ticker
 	router.POST("/add-ticker", func(c *gin.Context) {
 		name := strings.ToUpper(c.PostForm("name"))
-		priceStr := strings.Replace(c.PostForm("current_price"), ",", ".", -1)
 
 		if name == "" {
 			c.String(http.StatusBadRequest, "El nombre del ticker es obligatorio.")
 			return
 		}
 
-		price, err := strconv.ParseFloat(priceStr, 64)
+		price, err := parseDecimal(c.PostForm("current_price"))
 		if err != nil {
-			price = 0
+			price = decimal.Zero
 		}
+		price = roundPrice(price)
 
 		// Verificar si ya existe
 		var existing Ticker
@@ -358,7 +425,30 @@ func main() {
 		}
 
 		newTicker := Ticker{Name: name, CurrentPrice: price}
-		db.Create(&newTicker)
+		if metadata, ok := parseTickerMetadataForm(c); ok {
+			if err := metadata.Validate(); err != nil {
+				c.String(http.StatusBadRequest, "Metadatos inválidos: %v", err)
+				return
+			}
+			newTicker.ISIN = metadata.ISIN
+			newTicker.ExchangeMIC = metadata.ExchangeMIC
+			newTicker.Currency = metadata.Currency
+			newTicker.Sector = metadata.Sector
+			newTicker.Industry = metadata.Industry
+			newTicker.Country = metadata.Country
+			newTicker.AssetClass = metadata.AssetClass
+			newTicker.YahooFinanceTicker = metadata.YahooFinanceTicker
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newTicker).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, auditActor(c), AuditTicker, newTicker.ID, AuditCreate, nil, newTicker)
+		})
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al crear el ticker: %v", err)
+			return
+		}
 
 		log.Printf("Nuevo ticker creado: %s", name)
 		c.Redirect(http.StatusFound, "/precios")
@@ -378,26 +468,52 @@ func main() {
 			c.String(http.StatusNotFound, "Ticker no encontrado.")
 			return
 		}
+		if !requireFormVersion(c, ticker) {
+			return
+		}
 
 		name := strings.ToUpper(c.PostForm("name"))
-		priceStr := strings.Replace(c.PostForm("current_price"), ",", ".", -1)
 
 		if name == "" {
 			c.String(http.StatusBadRequest, "El nombre del ticker es obligatorio.")
 			return
 		}
 
-		price, err := strconv.ParseFloat(priceStr, 64)
+		price, err := parseDecimal(c.PostForm("current_price"))
 		if err != nil {
 			price = ticker.CurrentPrice
 		}
+		price = roundPrice(price)
+		priceChanged := !price.Equal(ticker.CurrentPrice)
 
-		db.Model(&ticker).Updates(map[string]interface{}{
+		updates := map[string]interface{}{
 			"name":          name,
 			"current_price": price,
-		})
+		}
+		if metadata, ok := parseTickerMetadataForm(c); ok {
+			if err := metadata.Validate(); err != nil {
+				c.String(http.StatusBadRequest, "Metadatos inválidos: %v", err)
+				return
+			}
+			for column, value := range metadata.updates() {
+				updates[column] = value
+			}
+		}
+
+		if err := auditedUpdate(db, auditActor(c), AuditTicker, ticker.ID, ticker, &Ticker{}, updates); err != nil {
+			if errors.Is(err, errStaleRecord) {
+				respondStaleForm(c, ticker.ID, &Ticker{})
+				return
+			}
+			c.String(http.StatusInternalServerError, "Error al actualizar el ticker: %v", err)
+			return
+		}
 
 		log.Printf("Ticker %d actualizado: %s", id, name)
+		if priceChanged {
+			goBackground(func() { evaluateAlerts("manual", ticker.ID) })
+			goBackground(func() { publishPriceChanges("manual", ticker.ID) })
+		}
 		c.Redirect(http.StatusFound, "/precios")
 	})
 
@@ -421,40 +537,25 @@ func main() {
 			return
 		}
 
-		db.Delete(&Ticker{}, id)
+		if err := auditedDelete(db, auditActor(c), AuditTicker, uint(id), &Ticker{}); err != nil {
+			c.String(http.StatusNotFound, "Ticker no encontrado.")
+			return
+		}
 		log.Printf("Ticker %d eliminado", id)
 		c.Redirect(http.StatusFound, "/precios")
 	})
 
 	// Ruta para crear un snapshot de precios
 	router.POST("/create-snapshot", func(c *gin.Context) {
-		// Obtener todos los tickers
-		var tickers []Ticker
-		db.Find(&tickers)
-
-		if len(tickers) == 0 {
+		snapshotID, priceHistories, err := createPriceSnapshot()
+		if errors.Is(err, errNoTickers) {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
 				"message": "No hay tickers para crear un snapshot",
 			})
 			return
 		}
-
-		// Generar un ID único para este snapshot usando timestamp
-		snapshotID := time.Now().Format("20060102-150405")
-
-		// Crear un registro de precio para cada ticker
-		var priceHistories []PriceHistory
-		for _, ticker := range tickers {
-			priceHistories = append(priceHistories, PriceHistory{
-				SnapshotID: snapshotID,
-				TickerID:   ticker.ID,
-				Price:      ticker.CurrentPrice,
-			})
-		}
-
-		// Guardar todos los registros en la base de datos
-		if err := db.Create(&priceHistories).Error; err != nil {
+		if err != nil {
 			log.Printf("Error al crear snapshot: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"success": false,
@@ -462,8 +563,8 @@ func main() {
 			})
 			return
 		}
+		goBackground(func() { notifyPriceSnapshot(snapshotID, priceHistories) })
 
-		log.Printf("Snapshot creado: %s con %d precios", snapshotID, len(priceHistories))
 		c.JSON(http.StatusOK, gin.H{
 			"success":    true,
 			"message":    fmt.Sprintf("Snapshot creado exitosamente con %d precios", len(priceHistories)),
@@ -492,13 +593,10 @@ func main() {
 	})
 
 	// Ruta para registrar una nueva compra
-	router.POST("/add-investment", func(c *gin.Context) {
+	router.POST("/add-investment", idempotent(), func(c *gin.Context) {
 		// Parsear valores del formulario
 		tickerIDStr := c.PostForm("ticker_id")
 		purchaseDateStr := c.PostForm("purchase_date")
-		sharesStr := strings.Replace(c.PostForm("shares"), ",", ".", -1)
-		purchasePriceStr := strings.Replace(c.PostForm("purchase_price"), ",", ".", -1)
-		operationCostStr := strings.Replace(c.PostForm("operation_cost"), ",", ".", -1)
 		redirectTo := c.PostForm("redirect_to")
 		if redirectTo == "" {
 			redirectTo = "/"
@@ -516,63 +614,45 @@ func main() {
 			return
 		}
 
-		shares, err := strconv.ParseFloat(sharesStr, 64)
-		if err != nil || shares <= 0 {
+		shares, err := parseDecimal(c.PostForm("shares"))
+		if err != nil || !shares.IsPositive() {
 			c.String(http.StatusBadRequest, "La cantidad de acciones debe ser un número positivo.")
 			return
 		}
 
-		purchasePrice, err := strconv.ParseFloat(purchasePriceStr, 64)
-		if err != nil || purchasePrice <= 0 {
+		purchasePrice, err := parseDecimal(c.PostForm("purchase_price"))
+		if err != nil || !purchasePrice.IsPositive() {
 			c.String(http.StatusBadRequest, "El precio de compra debe ser un número positivo.")
 			return
 		}
 
-		operationCost, err := strconv.ParseFloat(operationCostStr, 64)
+		operationCost, err := parseDecimal(c.PostForm("operation_cost"))
 		if err != nil {
-			operationCost = 0 // Default to 0 if empty or invalid
+			operationCost = decimal.Zero // Default to 0 if empty or invalid
 		}
 
-		purchaseDate, err := time.Parse("2006-01-02T15:04", purchaseDateStr)
-		if err != nil {
-			// Intentar formato sin hora para compatibilidad
-			purchaseDate, err = time.Parse("2006-01-02", purchaseDateStr)
-			if err != nil {
-				c.String(http.StatusBadRequest, "Formato de fecha inválido.")
-				return
-			}
-		}
-
-		// Verificar que el ticker existe
-		var ticker Ticker
-		if err := db.First(&ticker, tickerID).Error; err != nil {
-			c.String(http.StatusBadRequest, "El ticker seleccionado no existe.")
-			return
-		}
-
-		// Crear la nueva inversión
-		newInvestment := Investment{
+		// Crear la nueva inversión con las mismas validaciones que la API
+		_, err = createInvestment(db, auditActor(c), InvestmentInput{
 			TickerID:      uint(tickerID),
-			PurchaseDate:  purchaseDate,
+			PurchaseDate:  purchaseDateStr,
 			Shares:        shares,
 			PurchasePrice: purchasePrice,
 			OperationCost: operationCost,
+		})
+		if err != nil {
+			respondFormError(c, err, "registrar la compra", 0, nil)
+			return
 		}
-		db.Create(&newInvestment)
 
 		log.Printf("Nueva compra registrada para ticker ID %d", tickerID)
 		c.Redirect(http.StatusFound, redirectTo)
 	})
 
 	// Ruta para registrar una nueva venta
-	router.POST("/add-sale", func(c *gin.Context) {
+	router.POST("/add-sale", idempotent(), func(c *gin.Context) {
 		// Parsear valores del formulario
 		tickerIDStr := c.PostForm("ticker_id")
 		saleDateStr := c.PostForm("sale_date")
-		sharesStr := strings.Replace(c.PostForm("shares"), ",", ".", -1)
-		salePriceStr := strings.Replace(c.PostForm("sale_price"), ",", ".", -1)
-		operationCostStr := strings.Replace(c.PostForm("operation_cost"), ",", ".", -1)
-		withheldTaxStr := strings.Replace(c.PostForm("withheld_tax"), ",", ".", -1)
 		redirectTo := c.PostForm("redirect_to")
 		if redirectTo == "" {
 			redirectTo = "/"
@@ -590,55 +670,41 @@ func main() {
 			return
 		}
 
-		shares, err := strconv.ParseFloat(sharesStr, 64)
-		if err != nil || shares <= 0 {
+		shares, err := parseDecimal(c.PostForm("shares"))
+		if err != nil || !shares.IsPositive() {
 			c.String(http.StatusBadRequest, "La cantidad de acciones debe ser un número positivo.")
 			return
 		}
 
-		salePrice, err := strconv.ParseFloat(salePriceStr, 64)
-		if err != nil || salePrice <= 0 {
+		salePrice, err := parseDecimal(c.PostForm("sale_price"))
+		if err != nil || !salePrice.IsPositive() {
 			c.String(http.StatusBadRequest, "El precio de venta debe ser un número positivo.")
 			return
 		}
 
-		operationCost, err := strconv.ParseFloat(operationCostStr, 64)
-		if err != nil {
-			operationCost = 0 // Default to 0 if empty or invalid
-		}
-
-		withheldTax, err := strconv.ParseFloat(withheldTaxStr, 64)
+		operationCost, err := parseDecimal(c.PostForm("operation_cost"))
 		if err != nil {
-			withheldTax = 0 // Default to 0 if empty or invalid
+			operationCost = decimal.Zero // Default to 0 if empty or invalid
 		}
 
-		saleDate, err := time.Parse("2006-01-02T15:04", saleDateStr)
+		withheldTax, err := parseDecimal(c.PostForm("withheld_tax"))
 		if err != nil {
-			// Intentar formato DD/MM/YYYY para compatibilidad
-			saleDate, err = time.Parse("02/01/2006", saleDateStr)
-			if err != nil {
-				c.String(http.StatusBadRequest, "Formato de fecha inválido.")
-				return
-			}
-		}
-
-		// Verificar que el ticker existe
-		var ticker Ticker
-		if err := db.First(&ticker, tickerID).Error; err != nil {
-			c.String(http.StatusBadRequest, "El ticker seleccionado no existe.")
-			return
+			withheldTax = decimal.Zero // Default to 0 if empty or invalid
 		}
 
-		// Crear la nueva venta
-		newSale := Sale{
+		// Crear la nueva venta; el servicio rechaza las ventas sin acciones suficientes
+		_, err = createSale(db, auditActor(c), SaleInput{
 			TickerID:      uint(tickerID),
-			SaleDate:      saleDate,
+			SaleDate:      formTradeDate(saleDateStr),
 			Shares:        shares,
 			SalePrice:     salePrice,
 			OperationCost: operationCost,
 			WithheldTax:   withheldTax,
+		})
+		if err != nil {
+			respondFormError(c, err, "registrar la venta", 0, nil)
+			return
 		}
-		db.Create(&newSale)
 
 		log.Printf("Nueva venta registrada para ticker ID %d", tickerID)
 		c.Redirect(http.StatusFound, redirectTo)
@@ -658,7 +724,10 @@ func main() {
 			return
 		}
 
-		db.Delete(&Sale{}, id)
+		if err := deleteSale(db, auditActor(c), uint(id)); err != nil {
+			respondFormError(c, err, "eliminar la venta", 0, nil)
+			return
+		}
 
 		log.Printf("Registro de venta con ID %d marcado como eliminado", id)
 		c.Redirect(http.StatusFound, redirectTo)
@@ -678,38 +747,37 @@ func main() {
 			c.String(http.StatusNotFound, "Venta no encontrada.")
 			return
 		}
+		if !requireFormVersion(c, sale) {
+			return
+		}
 
 		// Parsear y validar datos del formulario
 		tickerIDStr := c.PostForm("ticker_id")
 		saleDateStr := c.PostForm("sale_date")
-		sharesStr := strings.Replace(c.PostForm("shares"), ",", ".", -1)
-		salePriceStr := strings.Replace(c.PostForm("sale_price"), ",", ".", -1)
-		operationCostStr := strings.Replace(c.PostForm("operation_cost"), ",", ".", -1)
-		withheldTaxStr := strings.Replace(c.PostForm("withheld_tax"), ",", ".", -1)
 		redirectTo := c.PostForm("redirect_to")
 		if redirectTo == "" {
 			redirectTo = "/ventas"
 		}
 
 		tickerID, _ := strconv.Atoi(tickerIDStr)
-		shares, _ := strconv.ParseFloat(sharesStr, 64)
-		salePrice, _ := strconv.ParseFloat(salePriceStr, 64)
-		operationCost, _ := strconv.ParseFloat(operationCostStr, 64)
-		withheldTax, _ := strconv.ParseFloat(withheldTaxStr, 64)
-		saleDate, err := time.Parse("2006-01-02T15:04", saleDateStr)
-		if err != nil {
-			saleDate, _ = time.Parse("02/01/2006", saleDateStr)
-		}
+		shares, _ := parseDecimal(c.PostForm("shares"))
+		salePrice, _ := parseDecimal(c.PostForm("sale_price"))
+		operationCost, _ := parseDecimal(c.PostForm("operation_cost"))
+		withheldTax, _ := parseDecimal(c.PostForm("withheld_tax"))
 
-		// Actualizar el registro
-		db.Model(&sale).Updates(map[string]interface{}{
-			"ticker_id":      tickerID,
-			"sale_date":      saleDate,
-			"shares":         shares,
-			"sale_price":     salePrice,
-			"operation_cost": operationCost,
-			"withheld_tax":   withheldTax,
+		// Actualizar el registro con la versión del formulario
+		_, err = updateSale(db, auditActor(c), sale.ID, c.PostForm(versionField), SaleInput{
+			TickerID:      uint(tickerID),
+			SaleDate:      formTradeDate(saleDateStr),
+			Shares:        shares,
+			SalePrice:     salePrice,
+			OperationCost: operationCost,
+			WithheldTax:   withheldTax,
 		})
+		if err != nil {
+			respondFormError(c, err, "actualizar la venta", sale.ID, &Sale{})
+			return
+		}
 
 		log.Printf("Registro de venta con ID %d actualizado", id)
 		c.Redirect(http.StatusFound, redirectTo)
@@ -724,103 +792,12 @@ func main() {
 			return
 		}
 
-		var sale Sale
-		if err := db.Preload("Ticker").First(&sale, id).Error; err != nil {
+		calculation, err := getSaleCalculation(uint(id))
+		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Venta no encontrada"})
 			return
 		}
-
-		// Obtener todas las inversiones y ventas anteriores para este ticker
-		var investments []Investment
-		db.Where("ticker_id = ? AND purchase_date <= ?", sale.TickerID, sale.SaleDate).Order("purchase_date asc").Find(&investments)
-
-		var sales []Sale
-		db.Where("ticker_id = ? AND sale_date <= ?", sale.TickerID, sale.SaleDate).Order("sale_date asc").Find(&sales)
-
-		// Reconstruir la historia para calcular el WAC en el momento de la venta
-		type Event struct {
-			Date   time.Time
-			Type   string // "buy", "sell"
-			Shares float64
-			Price  float64
-			ID     uint
-		}
-
-		var events []Event
-		for _, inv := range investments {
-			events = append(events, Event{Date: inv.PurchaseDate, Type: "buy", Shares: inv.Shares, Price: inv.PurchasePrice, ID: inv.ID})
-		}
-		for _, s := range sales {
-			// Excluir la venta actual del cálculo histórico (queremos el estado JUSTO ANTES)
-			if s.ID == sale.ID {
-				continue
-			}
-			events = append(events, Event{Date: s.SaleDate, Type: "sell", Shares: s.Shares, Price: s.SalePrice, ID: s.ID})
-		}
-
-		// Ordenar eventos
-		sort.Slice(events, func(i, j int) bool {
-			if events[i].Date.Equal(events[j].Date) {
-				return events[i].Type == "buy"
-			}
-			return events[i].Date.Before(events[j].Date)
-		})
-
-		currentShares := 0.0
-		currentCapital := 0.0
-
-		for _, e := range events {
-			if e.Type == "buy" {
-				currentShares += e.Shares
-				currentCapital += e.Shares * e.Price
-			} else if e.Type == "sell" {
-				wac := 0.0
-				if currentShares > 0 {
-					wac = currentCapital / currentShares
-				}
-				currentCapital -= e.Shares * wac
-				currentShares -= e.Shares
-			}
-		}
-
-		// Calcular WAC final
-		wac := 0.0
-		if currentShares > 0 {
-			wac = currentCapital / currentShares
-		}
-
-		// Preparar respuesta
-		type PurchaseInfo struct {
-			Date   string  `json:"date"`
-			Shares float64 `json:"shares"`
-			Price  float64 `json:"price"`
-			Total  float64 `json:"total"`
-		}
-
-		var purchasesList []PurchaseInfo
-		for _, inv := range investments {
-			purchasesList = append(purchasesList, PurchaseInfo{
-				Date:   inv.PurchaseDate.Format("02 Jan 2006 15:04"),
-				Shares: inv.Shares,
-				Price:  inv.PurchasePrice,
-				Total:  inv.Shares * inv.PurchasePrice,
-			})
-		}
-
-		// Utilidad calculada solo con precios
-		profit := (sale.SalePrice - wac) * sale.Shares
-
-		c.JSON(http.StatusOK, gin.H{
-			"ticker":        sale.Ticker.Name,
-			"sale_date":     sale.SaleDate.Format("02 Jan 2006 15:04"),
-			"shares":        sale.Shares,
-			"sale_price":    sale.SalePrice,
-			"purchases":     purchasesList,
-			"total_capital": currentCapital, // Capital acumulado antes de la venta
-			"total_shares":  currentShares,  // Acciones acumuladas antes de la venta
-			"wac":           wac,
-			"profit":        profit,
-		})
+		c.JSON(http.StatusOK, calculation)
 	})
 
 	// Ruta para mostrar el formulario de edición
@@ -848,7 +825,9 @@ func main() {
 
 		c.HTML(http.StatusOK, "edit.html", gin.H{
 			"Investment": investment,
+			"Version":    recordVersion(investment.UpdatedAt),
 			"Tickers":    tickerViews,
+			"History":    auditHistory(AuditInvestment, investment.ID),
 			"ActivePage": "compras",
 		})
 	})
@@ -867,31 +846,31 @@ func main() {
 			c.String(http.StatusNotFound, "Registro no encontrado.")
 			return
 		}
+		if !requireFormVersion(c, investment) {
+			return
+		}
 
 		// Parsear y validar datos del formulario
 		tickerIDStr := c.PostForm("ticker_id")
 		purchaseDateStr := c.PostForm("purchase_date")
-		sharesStr := strings.Replace(c.PostForm("shares"), ",", ".", -1)
-		purchasePriceStr := strings.Replace(c.PostForm("purchase_price"), ",", ".", -1)
-		operationCostStr := strings.Replace(c.PostForm("operation_cost"), ",", ".", -1)
 
 		tickerID, _ := strconv.Atoi(tickerIDStr)
-		shares, _ := strconv.ParseFloat(sharesStr, 64)
-		purchasePrice, _ := strconv.ParseFloat(purchasePriceStr, 64)
-		operationCost, _ := strconv.ParseFloat(operationCostStr, 64)
-		purchaseDate, err := time.Parse("2006-01-02T15:04", purchaseDateStr)
-		if err != nil {
-			purchaseDate, _ = time.Parse("2006-01-02", purchaseDateStr)
-		}
+		shares, _ := parseDecimal(c.PostForm("shares"))
+		purchasePrice, _ := parseDecimal(c.PostForm("purchase_price"))
+		operationCost, _ := parseDecimal(c.PostForm("operation_cost"))
 
-		// Actualizar el registro
-		db.Model(&investment).Updates(map[string]interface{}{
-			"ticker_id":      tickerID,
-			"purchase_date":  purchaseDate,
-			"shares":         shares,
-			"purchase_price": purchasePrice,
-			"operation_cost": operationCost,
+		// Actualizar el registro con la versión del formulario
+		_, err = updateInvestment(db, auditActor(c), investment.ID, c.PostForm(versionField), InvestmentInput{
+			TickerID:      uint(tickerID),
+			PurchaseDate:  purchaseDateStr,
+			Shares:        shares,
+			PurchasePrice: purchasePrice,
+			OperationCost: operationCost,
 		})
+		if err != nil {
+			respondFormError(c, err, "actualizar la compra", investment.ID, &Investment{})
+			return
+		}
 
 		log.Printf("Registro de compra con ID %d actualizado", id)
 		c.Redirect(http.StatusFound, "/compras")
@@ -907,18 +886,21 @@ func main() {
 		}
 
 		var investment Investment
-		if err := db.First(&investment, id).Error; err != nil {
+		if err := db.Preload("Ticker").First(&investment, id).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Registro no encontrado"})
 			return
 		}
+		if !requireIfMatch(c, investment.UpdatedAt, func() interface{} { return investmentEditJSON(investment) }) {
+			return
+		}
 
 		// Parsear JSON del body
 		var input struct {
-			TickerID      uint    `json:"ticker_id"`
-			PurchaseDate  string  `json:"purchase_date"`
-			Shares        float64 `json:"shares"`
-			PurchasePrice float64 `json:"purchase_price"`
-			OperationCost float64 `json:"operation_cost"`
+			TickerID      uint            `json:"ticker_id"`
+			PurchaseDate  string          `json:"purchase_date"`
+			Shares        decimal.Decimal `json:"shares"`
+			PurchasePrice decimal.Decimal `json:"purchase_price"`
+			OperationCost decimal.Decimal `json:"operation_cost"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -931,22 +913,42 @@ func main() {
 			purchaseDate, _ = time.Parse("2006-01-02", input.PurchaseDate)
 		}
 
+		// Obtener el ticker para redondear y devolver los datos completos
+		var ticker Ticker
+		db.First(&ticker, input.TickerID)
+
+		input.Shares = roundShares(input.Shares)
+		input.PurchasePrice = roundPrice(input.PurchasePrice)
+		input.OperationCost = roundMoney(input.OperationCost, ticker.Currency)
+
 		// Actualizar el registro
-		db.Model(&investment).Updates(map[string]interface{}{
+		var updated Investment
+		err = auditedUpdate(db, auditActor(c), AuditInvestment, investment.ID, investment, &updated, map[string]interface{}{
 			"ticker_id":      input.TickerID,
 			"purchase_date":  purchaseDate,
 			"shares":         input.Shares,
 			"purchase_price": input.PurchasePrice,
 			"operation_cost": input.OperationCost,
 		})
+		if errors.Is(err, errStaleRecord) {
+			// Otra petición la modificó entre la comprobación de If-Match y la escritura
+			var current Investment
+			if err := db.Preload("Ticker").First(&current, id).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Registro no encontrado"})
+				return
+			}
+			respondStaleJSON(c, current.UpdatedAt, investmentEditJSON(current))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar la compra"})
+			return
+		}
+		setETag(c, updated.UpdatedAt)
 
-		// Obtener el ticker actualizado para devolver los datos completos
-		var ticker Ticker
-		db.First(&ticker, input.TickerID)
-
-		investedCapital := input.Shares * input.PurchasePrice
-		currentValue := input.Shares * ticker.CurrentPrice
-		profitLoss := currentValue - (investedCapital + input.OperationCost)
+		investedCapital := roundMoney(input.Shares.Mul(input.PurchasePrice), ticker.Currency)
+		currentValue := roundMoney(input.Shares.Mul(ticker.CurrentPrice), ticker.Currency)
+		profitLoss := currentValue.Sub(investedCapital.Add(input.OperationCost))
 
 		log.Printf("Registro de compra con ID %d actualizado via API", id)
 		c.JSON(http.StatusOK, gin.H{
@@ -979,15 +981,8 @@ func main() {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"id":             investment.ID,
-			"ticker_id":      investment.TickerID,
-			"ticker":         investment.Ticker.Name,
-			"purchase_date":  investment.PurchaseDate.Format("2006-01-02T15:04"),
-			"shares":         investment.Shares,
-			"purchase_price": investment.PurchasePrice,
-			"operation_cost": investment.OperationCost,
-		})
+		setETag(c, investment.UpdatedAt)
+		c.JSON(http.StatusOK, investmentEditJSON(investment))
 	})
 
 	// API: Actualizar una venta (devuelve JSON)
@@ -1000,19 +995,22 @@ func main() {
 		}
 
 		var sale Sale
-		if err := db.First(&sale, id).Error; err != nil {
+		if err := db.Preload("Ticker").First(&sale, id).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Venta no encontrada"})
 			return
 		}
+		if !requireIfMatch(c, sale.UpdatedAt, func() interface{} { return saleEditJSON(sale) }) {
+			return
+		}
 
 		// Parsear JSON del body
 		var input struct {
-			TickerID      uint    `json:"ticker_id"`
-			SaleDate      string  `json:"sale_date"`
-			Shares        float64 `json:"shares"`
-			SalePrice     float64 `json:"sale_price"`
-			OperationCost float64 `json:"operation_cost"`
-			WithheldTax   float64 `json:"withheld_tax"`
+			TickerID      uint            `json:"ticker_id"`
+			SaleDate      string          `json:"sale_date"`
+			Shares        decimal.Decimal `json:"shares"`
+			SalePrice     decimal.Decimal `json:"sale_price"`
+			OperationCost decimal.Decimal `json:"operation_cost"`
+			WithheldTax   decimal.Decimal `json:"withheld_tax"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -1025,8 +1023,18 @@ func main() {
 			saleDate, _ = time.Parse("2006-01-02", input.SaleDate)
 		}
 
+		// Obtener el ticker actualizado
+		var ticker Ticker
+		db.First(&ticker, input.TickerID)
+
+		input.Shares = roundShares(input.Shares)
+		input.SalePrice = roundPrice(input.SalePrice)
+		input.OperationCost = roundMoney(input.OperationCost, ticker.Currency)
+		input.WithheldTax = roundMoney(input.WithheldTax, ticker.Currency)
+
 		// Actualizar el registro
-		db.Model(&sale).Updates(map[string]interface{}{
+		var updated Sale
+		err = auditedUpdate(db, auditActor(c), AuditSale, sale.ID, sale, &updated, map[string]interface{}{
 			"ticker_id":      input.TickerID,
 			"sale_date":      saleDate,
 			"shares":         input.Shares,
@@ -1034,13 +1042,21 @@ func main() {
 			"operation_cost": input.OperationCost,
 			"withheld_tax":   input.WithheldTax,
 		})
-
-		// Obtener el ticker actualizado
-		var ticker Ticker
-		db.First(&ticker, input.TickerID)
-
-		// Calcular valores para la respuesta
-		totalSaleValue := input.Shares * input.SalePrice
+		if errors.Is(err, errStaleRecord) {
+			// Otra petición la modificó entre la comprobación de If-Match y la escritura
+			var current Sale
+			if err := db.Preload("Ticker").First(&current, id).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Venta no encontrada"})
+				return
+			}
+			respondStaleJSON(c, current.UpdatedAt, saleEditJSON(current))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar la venta"})
+			return
+		}
+		setETag(c, updated.UpdatedAt)
 
 		// Calcular WAC y utilidad (similar a sale-calculation)
 		var investments []Investment
@@ -1049,55 +1065,16 @@ func main() {
 		var previousSales []Sale
 		db.Where("ticker_id = ? AND sale_date <= ? AND id != ?", input.TickerID, saleDate, id).Order("sale_date asc").Find(&previousSales)
 
-		type Event struct {
-			Date   time.Time
-			Type   string
-			Shares float64
-			Price  float64
-		}
-
-		var events []Event
+		var events []positionEvent
 		for _, inv := range investments {
-			events = append(events, Event{Date: inv.PurchaseDate, Type: "buy", Shares: inv.Shares, Price: inv.PurchasePrice})
+			events = append(events, investmentEvent(inv))
 		}
 		for _, s := range previousSales {
-			events = append(events, Event{Date: s.SaleDate, Type: "sell", Shares: s.Shares, Price: s.SalePrice})
+			events = append(events, saleEvent(s))
 		}
 
-		sort.Slice(events, func(i, j int) bool {
-			if events[i].Date.Equal(events[j].Date) {
-				return events[i].Type == "buy"
-			}
-			return events[i].Date.Before(events[j].Date)
-		})
-
-		currentShares := 0.0
-		currentCapital := 0.0
-
-		for _, e := range events {
-			if e.Type == "buy" {
-				currentShares += e.Shares
-				currentCapital += e.Shares * e.Price
-			} else if e.Type == "sell" {
-				wac := 0.0
-				if currentShares > 0 {
-					wac = currentCapital / currentShares
-				}
-				currentCapital -= e.Shares * wac
-				currentShares -= e.Shares
-			}
-		}
-
-		wac := 0.0
-		if currentShares > 0 {
-			wac = currentCapital / currentShares
-		}
-
-		profit := (input.SalePrice - wac) * input.Shares
-		performance := 0.0
-		if wac > 0 {
-			performance = ((input.SalePrice - wac) / wac) * 100
-		}
+		wac := replayEvents(events).WAC()
+		view := newSaleView(updated, ticker.Name, wac, ticker.Currency)
 
 		log.Printf("Registro de venta con ID %d actualizado via API", id)
 		c.JSON(http.StatusOK, gin.H{
@@ -1109,9 +1086,9 @@ func main() {
 			"sale_price":       input.SalePrice,
 			"operation_cost":   input.OperationCost,
 			"withheld_tax":     input.WithheldTax,
-			"total_sale_value": totalSaleValue,
-			"performance":      performance,
-			"profit":           profit,
+			"total_sale_value": view.TotalSaleValue,
+			"performance":      view.SalePerformance,
+			"profit":           view.Profit,
 		})
 	})
 
@@ -1130,16 +1107,8 @@ func main() {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"id":             sale.ID,
-			"ticker_id":      sale.TickerID,
-			"ticker":         sale.Ticker.Name,
-			"sale_date":      sale.SaleDate.Format("2006-01-02T15:04"),
-			"shares":         sale.Shares,
-			"sale_price":     sale.SalePrice,
-			"operation_cost": sale.OperationCost,
-			"withheld_tax":   sale.WithheldTax,
-		})
+		setETag(c, sale.UpdatedAt)
+		c.JSON(http.StatusOK, saleEditJSON(sale))
 	})
 
 	// Ruta para eliminar una compra
@@ -1156,8 +1125,12 @@ func main() {
 			return
 		}
 
-		// GORM usa borrado suave (soft delete) porque gorm.Model tiene el campo DeletedAt
-		db.Delete(&Investment{}, id)
+		// GORM usa borrado suave (soft delete) porque gorm.Model tiene el campo DeletedAt.
+		// No se borra si alguna venta se queda sin acciones suficientes
+		if err := deleteInvestment(db, auditActor(c), uint(id)); err != nil {
+			respondFormError(c, err, "eliminar la compra", 0, nil)
+			return
+		}
 
 		log.Printf("Registro de compra con ID %d marcado como eliminado", id)
 		c.Redirect(http.StatusFound, redirectTo)
@@ -1184,34 +1157,13 @@ func main() {
 		db.Where("ticker_id = ?", tickerID).Order("purchase_date desc").Find(&investments)
 
 		var investmentViews []InvestmentView
-		var totalInvested float64
-		var totalCostBuy float64
+		totalInvested := decimal.Zero
+		totalCostBuy := decimal.Zero
 		for _, i := range investments {
-			investedCapital := i.Shares * i.PurchasePrice
-			currentValue := i.Shares * ticker.CurrentPrice
-			profitLoss := currentValue - (investedCapital + i.OperationCost)
-			performance := 0.0
-			if i.PurchasePrice > 0 {
-				performance = (ticker.CurrentPrice - i.PurchasePrice) / i.PurchasePrice * 100
-			}
-
-			view := InvestmentView{
-				ID:              i.ID,
-				TickerID:        i.TickerID,
-				Ticker:          ticker.Name,
-				PurchaseDate:    i.PurchaseDate.Format("02 Jan 2006 15:04"),
-				Shares:          i.Shares,
-				PurchasePrice:   i.PurchasePrice,
-				OperationCost:   i.OperationCost,
-				InvestedCapital: investedCapital,
-				CurrentPrice:    ticker.CurrentPrice,
-				CurrentValue:    currentValue,
-				ProfitLoss:      profitLoss,
-				Performance:     performance,
-			}
+			view := newInvestmentView(i, ticker.Name, ticker.CurrentPrice, ticker.Currency)
 			investmentViews = append(investmentViews, view)
-			totalInvested += investedCapital
-			totalCostBuy += i.OperationCost
+			totalInvested = totalInvested.Add(view.InvestedCapital)
+			totalCostBuy = totalCostBuy.Add(i.OperationCost)
 		}
 
 		// Obtener las ventas del ticker
@@ -1220,113 +1172,39 @@ func main() {
 
 		// Calcular WAC (Weighted Average Cost) para cada venta
 		// Crear eventos ordenados cronológicamente
-		type Event struct {
-			Date   time.Time
-			Type   string // "buy", "sell"
-			Shares float64
-			Price  float64
-			SaleID uint // Para identificar la venta
-		}
-
-		var events []Event
+		var events []positionEvent
 		for _, i := range investments {
-			events = append(events, Event{
-				Date:   i.PurchaseDate,
-				Type:   "buy",
-				Shares: i.Shares,
-				Price:  i.PurchasePrice,
-			})
+			events = append(events, investmentEvent(i))
 		}
 		for _, s := range sales {
-			events = append(events, Event{
-				Date:   s.SaleDate,
-				Type:   "sell",
-				Shares: s.Shares,
-				Price:  s.SalePrice,
-				SaleID: s.ID,
-			})
+			events = append(events, saleEvent(s))
 		}
 
-		// Ordenar eventos por fecha
-		sort.Slice(events, func(i, j int) bool {
-			if events[i].Date.Equal(events[j].Date) {
-				// Si la fecha es igual, procesar compras antes que ventas
-				return events[i].Type == "buy"
-			}
-			return events[i].Date.Before(events[j].Date)
-		})
-
-		// Mapa para guardar el WAC al momento de cada venta
-		saleWACMap := make(map[uint]float64)
-
-		currentShares := 0.0
-		currentCapital := 0.0
-
-		for _, e := range events {
-			if e.Type == "buy" {
-				currentShares += e.Shares
-				currentCapital += e.Shares * e.Price
-			} else if e.Type == "sell" {
-				wac := 0.0
-				if currentShares > 0 {
-					wac = currentCapital / currentShares
-				}
-				// Guardar el WAC para esta venta
-				saleWACMap[e.SaleID] = wac
-				// Reducir capital proporcionalmente al WAC
-				currentCapital -= e.Shares * wac
-				currentShares -= e.Shares
-			}
-		}
+		// Mapa con el WAC al momento de cada venta y estado final de la posición
+		state, saleWACMap := replayEventsWithSales(events)
+		currentShares := state.Shares
 
 		// WAC final de las acciones en cartera
-		portfolioWAC := 0.0
-		if currentShares > 0 {
-			portfolioWAC = currentCapital / currentShares
-		}
+		portfolioWAC := state.WAC()
 
 		// Construir saleViews con el WAC calculado
 		var saleViews []SaleView
-		var totalSold float64
-		var totalCostSell float64
-		var totalSaleUtility float64
+		totalSold := decimal.Zero
+		totalCostSell := decimal.Zero
+		totalSaleUtility := decimal.Zero
 		for _, s := range sales {
-			totalSaleValue := s.Shares * s.SalePrice
-			wacAtSale := saleWACMap[s.ID]
-			salePerformance := 0.0
-			if wacAtSale > 0 {
-				salePerformance = ((s.SalePrice - wacAtSale) / wacAtSale) * 100
-			}
-			saleUtility := (s.SalePrice - wacAtSale) * s.Shares
-
-			view := SaleView{
-				ID:              s.ID,
-				TickerID:        s.TickerID,
-				Ticker:          ticker.Name,
-				SaleDate:        s.SaleDate.Format("02 Jan 2006 15:04"),
-				Shares:          s.Shares,
-				SalePrice:       s.SalePrice,
-				OperationCost:   s.OperationCost,
-				WithheldTax:     s.WithheldTax,
-				TotalSaleValue:  totalSaleValue,
-				WACAtSale:       wacAtSale,
-				SalePerformance: salePerformance,
-				SaleUtility:     saleUtility,
-			}
+			view := newSaleView(s, ticker.Name, saleWACMap[s.ID], ticker.Currency)
 			saleViews = append(saleViews, view)
-			totalSold += totalSaleValue
-			totalCostSell += s.OperationCost
-			totalSaleUtility += saleUtility
+			totalSold = totalSold.Add(view.TotalSaleValue)
+			totalCostSell = totalCostSell.Add(s.OperationCost)
+			totalSaleUtility = totalSaleUtility.Add(view.SaleUtility)
 		}
 
 		// Rendimiento porcentual vs precio ponderado
-		wacPerformance := 0.0
-		if portfolioWAC > 0 {
-			wacPerformance = ((ticker.CurrentPrice - portfolioWAC) / portfolioWAC) * 100
-		}
+		wacPerformance := percentChange(portfolioWAC, ticker.CurrentPrice)
 
 		// Utilidad: diferencia entre valor actual y valor ponderado del portafolio
-		utilidad := (ticker.CurrentPrice * currentShares) - (portfolioWAC * currentShares)
+		utilidad := roundMoney(ticker.CurrentPrice.Mul(currentShares).Sub(state.Capital), ticker.Currency)
 
 		// Obtener historial de precios del ticker
 		var priceHistories []PriceHistory
@@ -1334,7 +1212,7 @@ func main() {
 
 		// Preparar datos para el gráfico
 		var priceChartDates []string
-		var priceChartValues []float64
+		var priceChartValues []decimal.Decimal
 		for _, ph := range priceHistories {
 			priceChartDates = append(priceChartDates, ph.CreatedAt.Format("02 Jan 2006 15:04"))
 			priceChartValues = append(priceChartValues, ph.Price)
@@ -1342,7 +1220,7 @@ func main() {
 
 		// Preparar datos de compras para el gráfico
 		var purchaseChartDates []string
-		var purchaseChartPrices []float64
+		var purchaseChartPrices []decimal.Decimal
 		for _, inv := range investmentViews {
 			purchaseChartDates = append(purchaseChartDates, inv.PurchaseDate)
 			purchaseChartPrices = append(purchaseChartPrices, inv.PurchasePrice)
@@ -1350,21 +1228,39 @@ func main() {
 
 		// Preparar datos de ventas para el gráfico
 		var saleChartDates []string
-		var saleChartPrices []float64
+		var saleChartPrices []decimal.Decimal
 		for _, s := range saleViews {
 			saleChartDates = append(saleChartDates, s.SaleDate)
 			saleChartPrices = append(saleChartPrices, s.SalePrice)
 		}
 
+		// Obtener los componentes del ETF para el look-through
+		var constituents []ETFConstituent
+		var constituentsWeight float64
+		if ticker.AssetClass == "etf" {
+			db.Where("etf_ticker_id = ?", tickerID).Order("weight desc").Find(&constituents)
+			for _, ec := range constituents {
+				constituentsWeight += ec.Weight
+			}
+		}
+
+		// Obtener los dividendos cobrados
+		dividends, totalDividends := getTickerDividends(ticker.ID)
+
 		c.HTML(http.StatusOK, "ticker_detail.html", gin.H{
 			"Ticker":              ticker,
+			"Dividends":           dividends,
+			"TotalDividends":      totalDividends,
+			"Constituents":        constituents,
+			"ConstituentsWeight":  constituentsWeight,
+			"AssetClasses":        assetClassLabels,
 			"Investments":         investmentViews,
 			"Sales":               saleViews,
 			"TotalInvested":       totalInvested,
 			"TotalCostBuy":        totalCostBuy,
 			"TotalSold":           totalSold,
 			"TotalCostSell":       totalCostSell,
-			"TotalCosts":          totalCostBuy + totalCostSell,
+			"TotalCosts":          totalCostBuy.Add(totalCostSell),
 			"SharesInPortfolio":   currentShares,
 			"PortfolioWAC":        portfolioWAC,
 			"WACPerformance":      wacPerformance,
@@ -1382,168 +1278,49 @@ func main() {
 
 	// API: Obtener historial de utilidad de la cartera por snapshot
 	router.GET("/api/portfolio-utility-history", func(c *gin.Context) {
-		// Obtener todos los snapshots ordenados por fecha
-		type SnapshotInfo struct {
-			SnapshotID string
-			CreatedAt  time.Time
-		}
-		var snapshots []SnapshotInfo
-		db.Model(&PriceHistory{}).
-			Select("DISTINCT snapshot_id, MIN(created_at) as created_at").
-			Group("snapshot_id").
-			Order("created_at ASC").
-			Scan(&snapshots)
-
-		if len(snapshots) == 0 {
-			c.JSON(http.StatusOK, gin.H{
-				"dates":     []string{},
-				"utilities": []float64{},
-			})
-			return
-		}
-
-		// Obtener todas las inversiones y ventas
-		var allInvestments []Investment
-		db.Preload("Ticker").Order("purchase_date asc").Find(&allInvestments)
-
-		var allSales []Sale
-		db.Preload("Ticker").Order("sale_date asc").Find(&allSales)
-
-		// Para cada snapshot, calcular la utilidad de la cartera en ese momento
-		var dates []string
-		var utilities []float64
-
-		for _, snapshot := range snapshots {
-			// Obtener los precios de este snapshot
-			var priceHistories []PriceHistory
-			db.Where("snapshot_id = ?", snapshot.SnapshotID).Find(&priceHistories)
-
-			// Crear mapa de precios del snapshot
-			snapshotPrices := make(map[uint]float64)
-			for _, ph := range priceHistories {
-				snapshotPrices[ph.TickerID] = ph.Price
-			}
-
-			// Filtrar inversiones y ventas hasta la fecha del snapshot
-			type Event struct {
-				Date   time.Time
-				Type   string
-				Shares float64
-				Price  float64
-			}
-
-			tickerEvents := make(map[uint][]Event)
-
-			// Agregar compras hasta la fecha del snapshot
-			for _, inv := range allInvestments {
-				if inv.PurchaseDate.Before(snapshot.CreatedAt) || inv.PurchaseDate.Equal(snapshot.CreatedAt) {
-					tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], Event{
-						Date:   inv.PurchaseDate,
-						Type:   "buy",
-						Shares: inv.Shares,
-						Price:  inv.PurchasePrice,
-					})
-				}
-			}
-
-			// Agregar ventas hasta la fecha del snapshot
-			for _, sale := range allSales {
-				if sale.SaleDate.Before(snapshot.CreatedAt) || sale.SaleDate.Equal(snapshot.CreatedAt) {
-					tickerEvents[sale.TickerID] = append(tickerEvents[sale.TickerID], Event{
-						Date:   sale.SaleDate,
-						Type:   "sell",
-						Shares: sale.Shares,
-						Price:  sale.SalePrice,
-					})
-				}
-			}
-
-			// Calcular el estado de la cartera en este snapshot
-			totalUtility := 0.0
-
-			for tickerID, events := range tickerEvents {
-				// Ordenar eventos por fecha
-				sort.Slice(events, func(i, j int) bool {
-					if events[i].Date.Equal(events[j].Date) {
-						return events[i].Type == "buy"
-					}
-					return events[i].Date.Before(events[j].Date)
-				})
-
-				currentShares := 0.0
-				currentCapital := 0.0
-
-				for _, e := range events {
-					if e.Type == "buy" {
-						currentShares += e.Shares
-						currentCapital += e.Shares * e.Price
-					} else if e.Type == "sell" {
-						wac := 0.0
-						if currentShares > 0 {
-							wac = currentCapital / currentShares
-						}
-						currentCapital -= e.Shares * wac
-						currentShares -= e.Shares
-					}
-				}
-
-				// Calcular utilidad para este ticker
-				if currentShares > 0 {
-					snapshotPrice, exists := snapshotPrices[tickerID]
-					if exists {
-						wac := 0.0
-						if currentShares > 0 {
-							wac = currentCapital / currentShares
-						}
-						utility := (snapshotPrice - wac) * currentShares
-						totalUtility += utility
-					}
-				}
-			}
-
-			dates = append(dates, snapshot.CreatedAt.Format("02 Jan 2006 15:04"))
-			utilities = append(utilities, totalUtility)
-		}
-
+		dates, utilities := getPortfolioUtilityHistory()
 		c.JSON(http.StatusOK, gin.H{
 			"dates":     dates,
 			"utilities": utilities,
 		})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
-	}
-	log.Printf("Servidor iniciado en http://localhost:%s", port)
-	router.Run(":" + port)
+	startWebhookWorker()
+	log.Printf("Servidor iniciado en http://localhost:%s", *port)
+	return router.Run(":" + *port)
 }
 
-func setupDatabase() (*gorm.DB, error) {
-	// Usar connection string directo de Supabase
+func setupDatabase(migrate bool) (*gorm.DB, error) {
+	// Postgres (p. ej. Supabase) o un fichero SQLite local, según el esquema
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
 		return nil, fmt.Errorf("falta la variable de entorno DATABASE_URL")
 	}
 
-	// Agregar parámetro para desactivar prepared statements (necesario para connection pooler de Supabase)
-	if !strings.Contains(dsn, "prepared_statements") {
-		if strings.Contains(dsn, "?") {
-			dsn += "&prepare=false"
-		} else {
-			dsn += "?prepare=false"
-		}
+	dialector, err := databaseDialector(dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	database, err := gorm.Open(postgres.New(postgres.Config{
-		DSN:                  dsn,
-		PreferSimpleProtocol: true, // Desactiva prepared statements
-	}), &gorm.Config{})
+	database, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
+	// SQLite admite un único escritor: una sola conexión evita errores de bloqueo
+	// y mantiene la misma base en memoria con sqlite://:memory:
+	if isSQLite(database) {
+		sqlDB, err := database.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
 	// Ejecutar migraciones
+	if !migrate {
+		return database, nil
+	}
 	if err := runMigrations(database); err != nil {
 		return nil, fmt.Errorf("error ejecutando migraciones: %v", err)
 	}
@@ -1551,52 +1328,6 @@ func setupDatabase() (*gorm.DB, error) {
 	return database, nil
 }
 
-// runMigrations ejecuta todas las migraciones pendientes en orden
-func runMigrations(database *gorm.DB) error {
-	// Crear tabla de migraciones si no existe
-	database.AutoMigrate(&Migration{})
-
-	// Definir todas las migraciones disponibles
-	migrations := map[string]func(*gorm.DB) error{
-		"001_create_initial_schema":       migration001CreateInitialSchema,
-		"002_migrate_to_ticker_id_schema": migration002MigrateToTickerIDSchema,
-		"003_create_price_history_table":  migration003CreatePriceHistoryTable,
-	}
-
-	// Obtener migraciones ya aplicadas
-	var appliedMigrations []Migration
-	database.Find(&appliedMigrations)
-	appliedMap := make(map[string]bool)
-	for _, m := range appliedMigrations {
-		appliedMap[m.Name] = true
-	}
-
-	// Ordenar las migraciones por nombre
-	var migrationNames []string
-	for name := range migrations {
-		migrationNames = append(migrationNames, name)
-	}
-	sort.Strings(migrationNames)
-
-	// Ejecutar migraciones pendientes
-	for _, name := range migrationNames {
-		if appliedMap[name] {
-			continue
-		}
-
-		log.Printf("Ejecutando migración: %s", name)
-		if err := migrations[name](database); err != nil {
-			return fmt.Errorf("error en migración %s: %v", name, err)
-		}
-
-		// Registrar migración como aplicada
-		database.Create(&Migration{Name: name, AppliedAt: time.Now()})
-		log.Printf("Migración completada: %s", name)
-	}
-
-	return nil
-}
-
 // migration001CreateInitialSchema crea el esquema inicial con la tabla Ticker
 func migration001CreateInitialSchema(database *gorm.DB) error {
 	// Verificar si estamos migrando desde esquema antiguo
@@ -1606,33 +1337,44 @@ func migration001CreateInitialSchema(database *gorm.DB) error {
 	// Crear tabla tickers si no existe
 	if !database.Migrator().HasTable("tickers") {
 		log.Println("Creando tabla tickers...")
-		if err := database.Exec(`CREATE TABLE "tickers" (
-			"id" bigserial PRIMARY KEY,
-			"created_at" timestamptz,
-			"updated_at" timestamptz,
-			"deleted_at" timestamptz,
+		primaryKey, timestamp, number, _ := columnTypes(database)
+		if err := database.Exec(fmt.Sprintf(`CREATE TABLE "tickers" (
+			"id" %s,
+			"created_at" %s,
+			"updated_at" %s,
+			"deleted_at" %s,
 			"name" text,
-			"current_price" decimal
-		)`).Error; err != nil {
+			"current_price" %s
+		)`, primaryKey, timestamp, timestamp, timestamp, number)).Error; err != nil {
+			return err
+		}
+		if err := database.Exec(`CREATE UNIQUE INDEX "idx_tickers_name" ON "tickers" ("name")`).Error; err != nil {
+			return err
+		}
+		if err := database.Exec(`CREATE INDEX "idx_tickers_deleted_at" ON "tickers" ("deleted_at")`).Error; err != nil {
 			return err
 		}
-		database.Exec(`CREATE UNIQUE INDEX "idx_tickers_name" ON "tickers" ("name")`)
-		database.Exec(`CREATE INDEX "idx_tickers_deleted_at" ON "tickers" ("deleted_at")`)
 	}
 
 	if hasOldSchema && hasInvestments {
 		// Esquema antiguo existe
 		log.Println("Detectado esquema antiguo, preparando para migración...")
 
+		_, _, _, foreignKey := columnTypes(database)
+
 		// Agregar columna ticker_id a investments si no existe
 		if !database.Migrator().HasColumn(&Investment{}, "ticker_id") {
 			log.Println("Agregando columna ticker_id a investments...")
-			database.Exec("ALTER TABLE investments ADD COLUMN ticker_id bigint")
+			if err := database.Exec("ALTER TABLE investments ADD COLUMN ticker_id " + foreignKey).Error; err != nil {
+				return err
+			}
 		}
 		// Agregar columna ticker_id a sales si no existe
 		if database.Migrator().HasTable("sales") && !database.Migrator().HasColumn(&Sale{}, "ticker_id") {
 			log.Println("Agregando columna ticker_id a sales...")
-			database.Exec("ALTER TABLE sales ADD COLUMN ticker_id bigint")
+			if err := database.Exec("ALTER TABLE sales ADD COLUMN ticker_id " + foreignKey).Error; err != nil {
+				return err
+			}
 		}
 		return nil
 	}
@@ -1640,11 +1382,15 @@ func migration001CreateInitialSchema(database *gorm.DB) error {
 	// Base de datos nueva - crear tablas investments y sales si no existen
 	if !database.Migrator().HasTable("investments") {
 		log.Println("Creando tabla investments...")
-		database.AutoMigrate(&Investment{})
+		if err := database.AutoMigrate(&Investment{}); err != nil {
+			return err
+		}
 	}
 	if !database.Migrator().HasTable("sales") {
 		log.Println("Creando tabla sales...")
-		database.AutoMigrate(&Sale{})
+		if err := database.AutoMigrate(&Sale{}); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -1658,28 +1404,28 @@ func migration002MigrateToTickerIDSchema(database *gorm.DB) error {
 
 		// Si no hay datos, insertar datos de ejemplo
 		var count int64
-		database.Model(&Ticker{}).Count(&count)
+		if err := database.Model(&Ticker{}).Count(&count).Error; err != nil {
+			return err
+		}
 		if count == 0 {
 			log.Println("Insertando datos de ejemplo...")
 			tickers := []Ticker{
-				{Name: "AAPL", CurrentPrice: 195.50},
-				{Name: "GOOGL", CurrentPrice: 2850.00},
-				{Name: "MSFT", CurrentPrice: 340.80},
+				{Name: "AAPL", CurrentPrice: decimal.RequireFromString("195.50")},
+				{Name: "GOOGL", CurrentPrice: decimal.RequireFromString("2850.00")},
+				{Name: "MSFT", CurrentPrice: decimal.RequireFromString("340.80")},
 			}
-			database.Create(&tickers)
-
-			var aaplTicker, googlTicker, msftTicker Ticker
-			database.Where("name = ?", "AAPL").First(&aaplTicker)
-			database.Where("name = ?", "GOOGL").First(&googlTicker)
-			database.Where("name = ?", "MSFT").First(&msftTicker)
+			if err := createInitialTickers(database, &tickers); err != nil {
+				return err
+			}
+			aapl, googl, msft := tickers[0].ID, tickers[1].ID, tickers[2].ID
 
 			investments := []Investment{
-				{TickerID: aaplTicker.ID, PurchaseDate: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC), Shares: 10, PurchasePrice: 150.75, OperationCost: 5.50},
-				{TickerID: googlTicker.ID, PurchaseDate: time.Date(2023, 2, 20, 0, 0, 0, 0, time.UTC), Shares: 5, PurchasePrice: 2750.50, OperationCost: 12.00},
-				{TickerID: msftTicker.ID, PurchaseDate: time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC), Shares: 8, PurchasePrice: 305.20, OperationCost: 7.25},
-				{TickerID: aaplTicker.ID, PurchaseDate: time.Date(2023, 5, 22, 0, 0, 0, 0, time.UTC), Shares: 5, PurchasePrice: 172.25, OperationCost: 5.50},
+				{TickerID: aapl, PurchaseDate: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC), Shares: decimal.NewFromInt(10), PurchasePrice: decimal.RequireFromString("150.75"), OperationCost: decimal.RequireFromString("5.50")},
+				{TickerID: googl, PurchaseDate: time.Date(2023, 2, 20, 0, 0, 0, 0, time.UTC), Shares: decimal.NewFromInt(5), PurchasePrice: decimal.RequireFromString("2750.50"), OperationCost: decimal.RequireFromString("12.00")},
+				{TickerID: msft, PurchaseDate: time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC), Shares: decimal.NewFromInt(8), PurchasePrice: decimal.RequireFromString("305.20"), OperationCost: decimal.RequireFromString("7.25")},
+				{TickerID: aapl, PurchaseDate: time.Date(2023, 5, 22, 0, 0, 0, 0, time.UTC), Shares: decimal.NewFromInt(5), PurchasePrice: decimal.RequireFromString("172.25"), OperationCost: decimal.RequireFromString("5.50")},
 			}
-			database.Create(&investments)
+			return database.Omit("Ticker").Create(&investments).Error
 		}
 		return nil
 	}
@@ -1689,17 +1435,21 @@ func migration002MigrateToTickerIDSchema(database *gorm.DB) error {
 	// 1. Migrar datos de market_data a tickers
 	type OldMarketData struct {
 		Ticker       string `gorm:"primaryKey"`
-		CurrentPrice float64
+		CurrentPrice decimal.Decimal
 	}
 
 	var oldMarketData []OldMarketData
-	database.Table("market_data").Find(&oldMarketData)
+	if err := database.Table("market_data").Find(&oldMarketData).Error; err != nil {
+		return err
+	}
 
 	tickerMap := make(map[string]uint) // mapa de nombre -> ID
 
 	for _, md := range oldMarketData {
 		ticker := Ticker{Name: md.Ticker, CurrentPrice: md.CurrentPrice}
-		database.Create(&ticker)
+		if err := createInitialTickers(database, &ticker); err != nil {
+			return err
+		}
 		tickerMap[md.Ticker] = ticker.ID
 		log.Printf("  Ticker migrado: %s (ID: %d)", md.Ticker, ticker.ID)
 	}
@@ -1712,17 +1462,23 @@ func migration002MigrateToTickerIDSchema(database *gorm.DB) error {
 			Ticker string
 		}
 		var oldInvestments []OldInvestment
-		database.Table("investments").Select("id, ticker").Find(&oldInvestments)
+		if err := database.Table("investments").Select("id, ticker").Find(&oldInvestments).Error; err != nil {
+			return err
+		}
 
 		for _, oi := range oldInvestments {
 			if tickerID, ok := tickerMap[oi.Ticker]; ok {
-				database.Table("investments").Where("id = ?", oi.ID).Update("ticker_id", tickerID)
+				if err := database.Table("investments").Where("id = ?", oi.ID).Update("ticker_id", tickerID).Error; err != nil {
+					return err
+				}
 			}
 		}
 		log.Printf("  Migradas %d inversiones", len(oldInvestments))
 
 		// Eliminar columna ticker antigua de investments
-		database.Migrator().DropColumn(&Investment{}, "ticker")
+		if err := dropColumn(database, &Investment{}, "ticker"); err != nil {
+			return err
+		}
 	}
 
 	// 3. Verificar si hay columna 'ticker' en sales (esquema antiguo)
@@ -1732,27 +1488,41 @@ func migration002MigrateToTickerIDSchema(database *gorm.DB) error {
 			Ticker string
 		}
 		var oldSales []OldSale
-		database.Table("sales").Select("id, ticker").Find(&oldSales)
+		if err := database.Table("sales").Select("id, ticker").Find(&oldSales).Error; err != nil {
+			return err
+		}
 
 		for _, os := range oldSales {
 			if tickerID, ok := tickerMap[os.Ticker]; ok {
-				database.Table("sales").Where("id = ?", os.ID).Update("ticker_id", tickerID)
+				if err := database.Table("sales").Where("id = ?", os.ID).Update("ticker_id", tickerID).Error; err != nil {
+					return err
+				}
 			}
 		}
 		log.Printf("  Migradas %d ventas", len(oldSales))
 
 		// Eliminar columna ticker antigua de sales
-		database.Migrator().DropColumn(&Sale{}, "ticker")
+		if err := dropColumn(database, &Sale{}, "ticker"); err != nil {
+			return err
+		}
 	}
 
 	// 4. Eliminar tabla market_data antigua
-	database.Migrator().DropTable("market_data")
+	if err := database.Migrator().DropTable("market_data"); err != nil {
+		return err
+	}
 	log.Println("  Tabla market_data eliminada")
 
 	log.Println("Migración de datos completada")
 	return nil
 }
 
+// createInitialTickers inserta tickers en la tabla que crea la migración 001,
+// que aún no tiene las columnas de clasificación añadidas después.
+func createInitialTickers(database *gorm.DB, value interface{}) error {
+	return database.Select("CreatedAt", "UpdatedAt", "Name", "CurrentPrice").Create(value).Error
+}
+
 // migration003CreatePriceHistoryTable crea la tabla price_histories
 func migration003CreatePriceHistoryTable(database *gorm.DB) error {
 	log.Println("Creando tabla price_histories...")
@@ -1764,8 +1534,12 @@ func migration003CreatePriceHistoryTable(database *gorm.DB) error {
 		log.Println("  Tabla price_histories creada exitosamente")
 
 		// Crear índices para mejorar el rendimiento
-		database.Exec("CREATE INDEX idx_price_histories_snapshot_id ON price_histories(snapshot_id)")
-		database.Exec("CREATE INDEX idx_price_histories_ticker_id_created_at ON price_histories(ticker_id, created_at)")
+		if err := database.Exec("CREATE INDEX idx_price_histories_snapshot_id ON price_histories(snapshot_id)").Error; err != nil {
+			return err
+		}
+		if err := database.Exec("CREATE INDEX idx_price_histories_ticker_id_created_at ON price_histories(ticker_id, created_at)").Error; err != nil {
+			return err
+		}
 		log.Println("  Índices creados en price_histories")
 	} else {
 		log.Println("  Tabla price_histories ya existe")
@@ -1774,16 +1548,119 @@ func migration003CreatePriceHistoryTable(database *gorm.DB) error {
 	return nil
 }
 
-func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, float64, float64, float64, map[uint]float64, float64, float64, int, error) {
+// migration005CreateAlertTables crea las tablas de alertas y su historial
+func migration005CreateAlertTables(database *gorm.DB) error {
+	log.Println("Creando tablas alerts y alert_events...")
+
+	if err := database.AutoMigrate(&Alert{}, &AlertEvent{}); err != nil {
+		return err
+	}
+	if err := database.Exec("CREATE INDEX IF NOT EXISTS idx_alerts_ticker_id ON alerts(ticker_id)").Error; err != nil {
+		return err
+	}
+	return database.Exec("CREATE INDEX IF NOT EXISTS idx_alert_events_alert_id ON alert_events(alert_id)").Error
+}
+
+// migration006CreateWatchlistTable crea la tabla watchlist_items
+func migration006CreateWatchlistTable(database *gorm.DB) error {
+	log.Println("Creando tabla watchlist_items...")
+	return database.AutoMigrate(&WatchlistItem{})
+}
+
+// migration007AddTickerMetadata agrega las columnas de clasificación a tickers,
+// incluida yahoo_finance_ticker por si 004_add_yahoo_finance_ticker aún no se aplicó
+func migration007AddTickerMetadata(database *gorm.DB) error {
+	columns := []string{"ISIN", "ExchangeMIC", "Currency", "Sector", "Industry", "Country", "AssetClass", "YahooFinanceTicker"}
+	for _, column := range columns {
+		if database.Migrator().HasColumn(&Ticker{}, column) {
+			continue
+		}
+		log.Printf("Agregando columna %s a tickers...", column)
+		if err := database.Migrator().AddColumn(&Ticker{}, column); err != nil {
+			return err
+		}
+	}
+	return database.Exec("CREATE INDEX IF NOT EXISTS idx_tickers_isin ON tickers(isin)").Error
+}
+
+// migration008CreateETFConstituents crea la tabla etf_constituents
+func migration008CreateETFConstituents(database *gorm.DB) error {
+	log.Println("Creando tabla etf_constituents...")
+	return database.AutoMigrate(&ETFConstituent{})
+}
+
+// migration009CreateImportProfiles crea la tabla import_profiles con los
+// perfiles de los brokers soportados
+func migration009CreateImportProfiles(database *gorm.DB) error {
+	log.Println("Creando tabla import_profiles...")
+	if err := database.AutoMigrate(&ImportProfile{}); err != nil {
+		return err
+	}
+	for _, profile := range defaultImportProfiles {
+		if err := database.Where(ImportProfile{Name: profile.Name}).FirstOrCreate(&profile).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration010AddDividendsAndExternalIDs crea la tabla dividends y agrega
+// external_id a compras y ventas para importar extractos sin duplicados
+func migration010AddDividendsAndExternalIDs(database *gorm.DB) error {
+	log.Println("Creando tabla dividends y columnas external_id...")
+	// En las tablas existentes sólo se agrega external_id: AutoMigrate alteraría
+	// las columnas del esquema antiguo y SQLite recrea la tabla para hacerlo
+	for _, model := range []interface{}{&Investment{}, &Sale{}} {
+		if !database.Migrator().HasTable(model) {
+			if err := database.AutoMigrate(model); err != nil {
+				return err
+			}
+			continue
+		}
+		if !database.Migrator().HasColumn(model, "ExternalID") {
+			if err := database.Migrator().AddColumn(model, "ExternalID"); err != nil {
+				return err
+			}
+		}
+		if !database.Migrator().HasIndex(model, "ExternalID") {
+			if err := database.Migrator().CreateIndex(model, "ExternalID"); err != nil {
+				return err
+			}
+		}
+	}
+	return database.AutoMigrate(&Dividend{})
+}
+
+// migration011CreateAuditLogs crea la tabla audit_logs
+func migration011CreateAuditLogs(database *gorm.DB) error {
+	log.Println("Creando tabla audit_logs...")
+	return database.AutoMigrate(&AuditLog{})
+}
+
+// migration013CreateIdempotencyKeys crea la tabla idempotency_keys
+func migration013CreateIdempotencyKeys(database *gorm.DB) error {
+	log.Println("Creando tabla idempotency_keys...")
+	return database.AutoMigrate(&IdempotencyKey{})
+}
+
+// migration014CreateWebhookTables crea las tablas de suscripciones y entregas de webhooks
+func migration014CreateWebhookTables(database *gorm.DB) error {
+	log.Println("Creando tablas webhook_subscriptions y webhook_deliveries...")
+	return database.AutoMigrate(&WebhookSubscription{}, &WebhookDelivery{})
+}
+
+func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, decimal.Decimal, decimal.Decimal, decimal.Decimal, map[uint]decimal.Decimal, float64, decimal.Decimal, int, error) {
 	// 1. Obtener todos los tickers con sus precios
 	var tickers []Ticker
 	db.Find(&tickers)
 
-	tickerPrices := make(map[uint]float64)
+	tickerPrices := make(map[uint]decimal.Decimal)
 	tickerNames := make(map[uint]string)
+	tickerCurrencies := make(map[uint]string)
 	for _, t := range tickers {
 		tickerPrices[t.ID] = t.CurrentPrice
 		tickerNames[t.ID] = t.Name
+		tickerCurrencies[t.ID] = t.Currency
 	}
 
 	// 2. Obtener todas las inversiones de la BD con preload del ticker
@@ -1792,39 +1669,18 @@ func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, flo
 
 	// 3. Construir la vista detallada de inversiones y calcular totales
 	var investmentViews []InvestmentView
-	var totalCapital float64
-	var netProfitLoss float64
-	var totalOperationCost float64
+	totalCapital := decimal.Zero
+	netProfitLoss := decimal.Zero
+	totalOperationCost := decimal.Zero
 
 	for _, i := range investments {
 		currentPrice := tickerPrices[i.TickerID]
 		tickerName := tickerNames[i.TickerID]
-		investedCapital := i.Shares * i.PurchasePrice
-		currentValue := i.Shares * currentPrice
-		profitLoss := currentValue - (investedCapital + i.OperationCost)
-		performance := 0.0
-		if i.PurchasePrice > 0 {
-			performance = (currentPrice - i.PurchasePrice) / i.PurchasePrice * 100
-		}
-
-		view := InvestmentView{
-			ID:              i.ID,
-			TickerID:        i.TickerID,
-			Ticker:          tickerName,
-			PurchaseDate:    i.PurchaseDate.Format("02 Jan 2006 15:04"),
-			Shares:          i.Shares,
-			PurchasePrice:   i.PurchasePrice,
-			OperationCost:   i.OperationCost,
-			InvestedCapital: investedCapital,
-			CurrentPrice:    currentPrice,
-			CurrentValue:    currentValue,
-			ProfitLoss:      profitLoss,
-			Performance:     performance,
-		}
-
-		totalCapital += investedCapital + i.OperationCost
-		totalOperationCost += i.OperationCost
-		netProfitLoss += profitLoss
+		view := newInvestmentView(i, tickerName, currentPrice, tickerCurrencies[i.TickerID])
+
+		totalCapital = totalCapital.Add(view.InvestedCapital).Add(i.OperationCost)
+		totalOperationCost = totalOperationCost.Add(i.OperationCost)
+		netProfitLoss = netProfitLoss.Add(view.ProfitLoss)
 		investmentViews = append(investmentViews, view)
 	}
 
@@ -1837,27 +1693,21 @@ func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, flo
 			summaries[view.TickerID] = summary
 		}
 
-		summary.TotalShares += view.Shares
-		summary.CurrentInvestment += view.InvestedCapital
-		summary.TotalCost += view.OperationCost
-		summary.CurrentValue += view.CurrentValue
-		summary.ProfitLoss += view.ProfitLoss
+		summary.TotalShares = summary.TotalShares.Add(view.Shares)
+		summary.CurrentInvestment = summary.CurrentInvestment.Add(view.InvestedCapital)
+		summary.TotalCost = summary.TotalCost.Add(view.OperationCost)
+		summary.CurrentValue = summary.CurrentValue.Add(view.CurrentValue)
+		summary.ProfitLoss = summary.ProfitLoss.Add(view.ProfitLoss)
 	}
 
 	// 5. Obtener todas las ventas de la BD con preload del ticker
 	var sales []Sale
 	db.Preload("Ticker").Order("sale_date desc").Find(&sales)
 
-	// Calcular el monto total de ventas por ticker
-	tickerSalesAmount := make(map[uint]float64)
-	for _, s := range sales {
-		tickerSalesAmount[s.TickerID] += s.Shares * s.SalePrice
-	}
-
 	// Restar el monto de ventas de CurrentInvestment
-	for tickerID, salesAmount := range tickerSalesAmount {
-		if summary, ok := summaries[tickerID]; ok {
-			summary.CurrentInvestment -= salesAmount
+	for _, s := range sales {
+		if summary, ok := summaries[s.TickerID]; ok {
+			summary.CurrentInvestment = summary.CurrentInvestment.Sub(roundMoney(s.Shares.Mul(s.SalePrice), tickerCurrencies[s.TickerID]))
 		}
 	}
 
@@ -1872,117 +1722,55 @@ func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, flo
 	})
 
 	// Calcular WAC (Weighted Average Cost) histórico para cada venta
-	type Event struct {
-		Date   time.Time
-		Type   string // "buy", "sell"
-		Shares float64
-		Price  float64
-		SaleID uint
-	}
-
-	tickerEvents := make(map[uint][]Event)
-
-	// Agregar compras a eventos (sin incluir costos de operación)
+	// (las compras se agregan sin incluir costos de operación)
+	tickerEvents := make(map[uint][]positionEvent)
 	for _, inv := range investments {
-		tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], Event{
-			Date:   inv.PurchaseDate,
-			Type:   "buy",
-			Shares: inv.Shares,
-			Price:  inv.PurchasePrice,
-		})
+		tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], investmentEvent(inv))
 	}
-
-	// Agregar ventas a eventos
 	for _, s := range sales {
-		tickerEvents[s.TickerID] = append(tickerEvents[s.TickerID], Event{
-			Date:   s.SaleDate,
-			Type:   "sell",
-			Shares: s.Shares,
-			Price:  s.SalePrice,
-			SaleID: s.ID,
-		})
+		tickerEvents[s.TickerID] = append(tickerEvents[s.TickerID], saleEvent(s))
 	}
 
-	saleWACs := make(map[uint]float64)
-	tickerFinalState := make(map[uint]struct {
-		Shares  float64
-		Capital float64
-	})
-
+	saleWACs := make(map[uint]decimal.Decimal)
+	tickerFinalState := make(map[uint]PositionState)
 	for tickerID, events := range tickerEvents {
-		// Ordenar eventos por fecha
-		sort.Slice(events, func(i, j int) bool {
-			if events[i].Date.Equal(events[j].Date) {
-				// Si la fecha es igual, procesar compras antes que ventas
-				return events[i].Type == "buy"
-			}
-			return events[i].Date.Before(events[j].Date)
-		})
-
-		currentShares := 0.0
-		currentCapital := 0.0
-
-		for _, e := range events {
-			if e.Type == "buy" {
-				currentShares += e.Shares
-				currentCapital += e.Shares * e.Price
-			} else if e.Type == "sell" {
-				wac := 0.0
-				if currentShares > 0 {
-					wac = currentCapital / currentShares
-				}
-				saleWACs[e.SaleID] = wac
-
-				// Actualizar posición después de la venta (reducir capital proporcionalmente)
-				currentCapital -= e.Shares * wac
-				currentShares -= e.Shares
-			}
+		state, wacs := replayEventsWithSales(events)
+		for saleID, wac := range wacs {
+			saleWACs[saleID] = wac
 		}
 		// Guardar estado final del ticker
-		tickerFinalState[tickerID] = struct {
-			Shares  float64
-			Capital float64
-		}{currentShares, currentCapital}
+		tickerFinalState[tickerID] = state
 	}
 
 	// Calcular rendimiento del portafolio completo
-	totalPortfolioCurrentValue := 0.0
-	totalPortfolioWACValue := 0.0
+	totalPortfolioCurrentValue := decimal.Zero
+	totalPortfolioWACValue := decimal.Zero
 	for tickerID, state := range tickerFinalState {
-		if state.Shares > 0 {
-			currentPrice := tickerPrices[tickerID]
-			totalPortfolioCurrentValue += state.Shares * currentPrice
-			totalPortfolioWACValue += state.Capital // Capital ya es shares * WAC
+		if state.Shares.IsPositive() {
+			totalPortfolioCurrentValue = totalPortfolioCurrentValue.Add(roundMoney(state.Shares.Mul(tickerPrices[tickerID]), tickerCurrencies[tickerID]))
+			totalPortfolioWACValue = totalPortfolioWACValue.Add(roundMoney(state.Capital, tickerCurrencies[tickerID])) // Capital ya es shares * WAC
 		}
 	}
-	portfolioPerformance := 0.0
-	if totalPortfolioWACValue > 0 {
-		portfolioPerformance = ((totalPortfolioCurrentValue - totalPortfolioWACValue) / totalPortfolioWACValue) * 100
-	}
-	portfolioUtility := totalPortfolioCurrentValue - totalPortfolioWACValue
+	portfolioPerformance := percentChange(totalPortfolioWACValue, totalPortfolioCurrentValue)
+	portfolioUtility := totalPortfolioCurrentValue.Sub(totalPortfolioWACValue)
 
 	// Actualizar summaries con el cálculo correcto basado en WAC
 	for i := range summaryViews {
 		tickerID := summaryViews[i].TickerID
-		if state, ok := tickerFinalState[tickerID]; ok && state.Shares > 0 {
+		if state, ok := tickerFinalState[tickerID]; ok && state.Shares.IsPositive() {
 			currentPrice := tickerPrices[tickerID]
-			wac := 0.0
-			if state.Shares > 0 {
-				wac = state.Capital / state.Shares
-			}
+			currency := tickerCurrencies[tickerID]
 			// Utilidad = (Precio Actual * Acciones) - (WAC * Acciones)
 			summaryViews[i].TotalShares = state.Shares
-			summaryViews[i].CurrentValue = state.Shares * currentPrice
-			summaryViews[i].ProfitLoss = (currentPrice * state.Shares) - (wac * state.Shares)
+			summaryViews[i].CurrentValue = roundMoney(state.Shares.Mul(currentPrice), currency)
+			summaryViews[i].ProfitLoss = summaryViews[i].CurrentValue.Sub(roundMoney(state.Capital, currency))
 			// Rendimiento = ((Precio Actual - WAC) / WAC) * 100
-			if wac > 0 {
-				summaryViews[i].Performance = ((currentPrice - wac) / wac) * 100
-			}
+			summaryViews[i].Performance = percentChange(state.WAC(), currentPrice)
 		} else {
 			// Si no hay acciones en cartera, poner todo en 0
-			summaryViews[i].TotalShares = 0
-			summaryViews[i].CurrentValue = 0
-			summaryViews[i].ProfitLoss = 0
+			summaryViews[i].TotalShares = decimal.Zero
+			summaryViews[i].CurrentValue = decimal.Zero
+			summaryViews[i].ProfitLoss = decimal.Zero
 			summaryViews[i].Performance = 0
 		}
 	}
@@ -1990,57 +1778,316 @@ func getInvestmentData() ([]InvestmentView, []TickerSummaryView, []SaleView, flo
 	// Contar número de posiciones (tickers con acciones > 0)
 	numPositions := 0
 	for _, state := range tickerFinalState {
-		if state.Shares > 0 {
+		if state.Shares.IsPositive() {
 			numPositions++
 		}
 	}
 
 	var saleViews []SaleView
 	for _, s := range sales {
-		tickerName := tickerNames[s.TickerID]
-		currentPrice := tickerPrices[s.TickerID]
-		totalSaleValue := s.Shares * s.SalePrice
-		currentValue := s.Shares * currentPrice
-
-		wac := saleWACs[s.ID]
-		// Utilidad calculada solo con precios, sin costos de operación ni impuestos
-		profit := (s.SalePrice - wac) * s.Shares
-		performance := 0.0
-		if s.SalePrice > 0 {
-			performance = (currentPrice - s.SalePrice) / s.SalePrice * 100
-		}
-		// Proyección: diferencia entre monto actual y monto de venta
-		projection := currentValue - totalSaleValue
-
-		// Rendimiento de la venta vs WAC
-		salePerformance := 0.0
-		if wac > 0 {
-			salePerformance = ((s.SalePrice - wac) / wac) * 100
-		}
-		// Utilidad de la venta
-		saleUtility := (s.SalePrice - wac) * s.Shares
-
-		view := SaleView{
-			ID:              s.ID,
-			TickerID:        s.TickerID,
-			Ticker:          tickerName,
-			SaleDate:        s.SaleDate.Format("02 Jan 2006 15:04"),
-			Shares:          s.Shares,
-			SalePrice:       s.SalePrice,
-			OperationCost:   s.OperationCost,
-			WithheldTax:     s.WithheldTax,
-			TotalSaleValue:  totalSaleValue,
-			CurrentPrice:    currentPrice,
-			CurrentValue:    currentValue,
-			Performance:     performance,
-			Profit:          profit,
-			Projection:      projection,
-			WACAtSale:       wac,
-			SalePerformance: salePerformance,
-			SaleUtility:     saleUtility,
-		}
-		saleViews = append(saleViews, view)
+		view := newSaleView(s, tickerNames[s.TickerID], saleWACs[s.ID], tickerCurrencies[s.TickerID])
+		saleViews = append(saleViews, withCurrentPrice(view, s, tickerPrices[s.TickerID], tickerCurrencies[s.TickerID]))
 	}
 
 	return investmentViews, summaryViews, saleViews, totalCapital, netProfitLoss, totalOperationCost, tickerPrices, portfolioPerformance, portfolioUtility, numPositions, nil
 }
+
+// errNoTickers indica que no hay tickers con los que crear un snapshot.
+var errNoTickers = errors.New("no hay tickers para crear un snapshot")
+
+// createPriceSnapshot guarda el precio actual de todos los tickers bajo un
+// mismo snapshot_id. Quien lo llama ejecuta después notifyPriceSnapshot.
+func createPriceSnapshot() (string, []PriceHistory, error) {
+	// Obtener todos los tickers
+	var tickers []Ticker
+	db.Find(&tickers)
+
+	if len(tickers) == 0 {
+		return "", nil, errNoTickers
+	}
+
+	// Generar un ID único para este snapshot usando timestamp
+	snapshotID := time.Now().Format("20060102-150405")
+
+	// Crear un registro de precio para cada ticker
+	var priceHistories []PriceHistory
+	for _, ticker := range tickers {
+		priceHistories = append(priceHistories, PriceHistory{
+			SnapshotID: snapshotID,
+			TickerID:   ticker.ID,
+			Price:      ticker.CurrentPrice,
+		})
+	}
+
+	// Guardar todos los registros en la base de datos junto con sus webhooks
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&priceHistories).Error; err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, WebhookSnapshotCreated, "", snapshotWebhookData(tx, snapshotID, priceHistories))
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("Snapshot creado: %s con %d precios", snapshotID, len(priceHistories))
+	return snapshotID, priceHistories, nil
+}
+
+// backgroundTasks cuenta las tareas que los handlers lanzan en segundo plano,
+// para poder esperar a que terminen antes de cerrar la base de datos.
+var backgroundTasks sync.WaitGroup
+
+// goBackground ejecuta fn en una goroutine registrada en backgroundTasks.
+func goBackground(fn func()) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		fn()
+	}()
+}
+
+// notifyPriceSnapshot evalúa las alertas de movimiento respecto al snapshot
+// anterior y publica el nuevo snapshot a los clientes en vivo. Los handlers lo
+// lanzan en segundo plano; la línea de comandos espera a que termine.
+func notifyPriceSnapshot(snapshotID string, priceHistories []PriceHistory) {
+	var tickerIDs []uint
+	for _, ph := range priceHistories {
+		tickerIDs = append(tickerIDs, ph.TickerID)
+	}
+	evaluateAlerts("snapshot", tickerIDs...)
+	publishSnapshot(snapshotID, priceHistories)
+}
+
+// getSaleCalculation detalla el cálculo de la utilidad de una venta: las
+// compras previas, el WAC justo antes de vender y la utilidad resultante.
+func getSaleCalculation(id uint) (gin.H, error) {
+	var sale Sale
+	if err := db.Preload("Ticker").First(&sale, id).Error; err != nil {
+		return nil, err
+	}
+
+	// Obtener todas las inversiones y ventas anteriores para este ticker
+	var investments []Investment
+	db.Where("ticker_id = ? AND purchase_date <= ?", sale.TickerID, sale.SaleDate).Order("purchase_date asc").Find(&investments)
+
+	var sales []Sale
+	db.Where("ticker_id = ? AND sale_date <= ?", sale.TickerID, sale.SaleDate).Order("sale_date asc").Find(&sales)
+
+	// Reconstruir la historia para calcular el WAC en el momento de la venta
+	var events []positionEvent
+	for _, inv := range investments {
+		events = append(events, investmentEvent(inv))
+	}
+	for _, s := range sales {
+		// Excluir la venta actual del cálculo histórico (queremos el estado JUSTO ANTES)
+		if s.ID == sale.ID {
+			continue
+		}
+		events = append(events, saleEvent(s))
+	}
+
+	state := replayEvents(events)
+	currentShares := state.Shares
+	currentCapital := state.Capital
+
+	// Calcular WAC final
+	wac := state.WAC()
+
+	// Preparar respuesta
+	type PurchaseInfo struct {
+		Date   string          `json:"date"`
+		Shares decimal.Decimal `json:"shares"`
+		Price  decimal.Decimal `json:"price"`
+		Total  decimal.Decimal `json:"total"`
+	}
+
+	var purchasesList []PurchaseInfo
+	for _, inv := range investments {
+		purchasesList = append(purchasesList, PurchaseInfo{
+			Date:   inv.PurchaseDate.Format("02 Jan 2006 15:04"),
+			Shares: inv.Shares,
+			Price:  inv.PurchasePrice,
+			Total:  roundMoney(inv.Shares.Mul(inv.PurchasePrice), sale.Ticker.Currency),
+		})
+	}
+
+	// Utilidad calculada solo con precios
+	profit := roundMoney(sale.SalePrice.Sub(wac).Mul(sale.Shares), sale.Ticker.Currency)
+
+	return gin.H{
+		"ticker":        sale.Ticker.Name,
+		"sale_date":     sale.SaleDate.Format("02 Jan 2006 15:04"),
+		"shares":        sale.Shares,
+		"sale_price":    sale.SalePrice,
+		"purchases":     purchasesList,
+		"total_capital": roundMoney(currentCapital, sale.Ticker.Currency), // Capital acumulado antes de la venta
+		"total_shares":  currentShares,                                    // Acciones acumuladas antes de la venta
+		"wac":           wac.Round(pricePlaces),
+		"profit":        profit,
+	}, nil
+}
+
+// getPortfolioUtilityHistory calcula la utilidad de la cartera en cada snapshot
+// de precios, reproduciendo las posiciones hasta la fecha de cada uno.
+func getPortfolioUtilityHistory() ([]string, []decimal.Decimal) {
+	// Obtener todos los snapshots ordenados por fecha ascendente
+	snapshots, _ := listSnapshots()
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+
+	if len(snapshots) == 0 {
+		return []string{}, []decimal.Decimal{}
+	}
+
+	// Obtener todas las inversiones y ventas
+	var allInvestments []Investment
+	db.Preload("Ticker").Order("purchase_date asc").Find(&allInvestments)
+
+	var allSales []Sale
+	db.Preload("Ticker").Order("sale_date asc").Find(&allSales)
+
+	// Obtener los precios de todos los snapshots en una sola consulta
+	var priceHistories []PriceHistory
+	db.Find(&priceHistories)
+	pricesBySnapshot := make(map[string]map[uint]decimal.Decimal)
+	for _, ph := range priceHistories {
+		if pricesBySnapshot[ph.SnapshotID] == nil {
+			pricesBySnapshot[ph.SnapshotID] = make(map[uint]decimal.Decimal)
+		}
+		pricesBySnapshot[ph.SnapshotID][ph.TickerID] = ph.Price
+	}
+
+	// Para cada snapshot, calcular la utilidad de la cartera en ese momento
+	var dates []string
+	var utilities []decimal.Decimal
+
+	for _, snapshot := range snapshots {
+		snapshotPrices := pricesBySnapshot[snapshot.SnapshotID]
+
+		// Filtrar inversiones y ventas hasta la fecha del snapshot
+		tickerEvents := make(map[uint][]positionEvent)
+
+		// Agregar compras hasta la fecha del snapshot
+		for _, inv := range allInvestments {
+			if !inv.PurchaseDate.After(snapshot.CreatedAt) {
+				tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], investmentEvent(inv))
+			}
+		}
+
+		// Agregar ventas hasta la fecha del snapshot
+		for _, sale := range allSales {
+			if !sale.SaleDate.After(snapshot.CreatedAt) {
+				tickerEvents[sale.TickerID] = append(tickerEvents[sale.TickerID], saleEvent(sale))
+			}
+		}
+
+		// Calcular el estado de la cartera en este snapshot
+		totalUtility := decimal.Zero
+
+		for tickerID, events := range tickerEvents {
+			state := replayEvents(events)
+
+			// Calcular utilidad para este ticker
+			if state.Shares.IsPositive() {
+				if snapshotPrice, exists := snapshotPrices[tickerID]; exists {
+					utility := snapshotPrice.Mul(state.Shares).Sub(state.Capital)
+					totalUtility = totalUtility.Add(utility)
+				}
+			}
+		}
+
+		dates = append(dates, snapshot.CreatedAt.Format("02 Jan 2006 15:04"))
+		utilities = append(utilities, roundMoney(totalUtility, ""))
+	}
+
+	return dates, utilities
+}
+
+// portfolioExitValue calcula el Valor de Salida: Utilidad Ventas + Utilidad
+// Cartera - Costos de Operación - Número de Posiciones.
+func portfolioExitValue(saleUtility, portfolioUtility, operationCost decimal.Decimal, numPositions int) decimal.Decimal {
+	return saleUtility.Add(portfolioUtility).Sub(operationCost).Sub(decimal.NewFromInt(int64(numPositions)))
+}
+
+// newInvestmentView calcula la vista de una compra valorada al precio actual.
+func newInvestmentView(i Investment, tickerName string, currentPrice decimal.Decimal, currency string) InvestmentView {
+	investedCapital := roundMoney(i.Shares.Mul(i.PurchasePrice), currency)
+	currentValue := roundMoney(i.Shares.Mul(currentPrice), currency)
+	return InvestmentView{
+		ID:              i.ID,
+		TickerID:        i.TickerID,
+		Ticker:          tickerName,
+		PurchaseDate:    i.PurchaseDate.Format("02 Jan 2006 15:04"),
+		Shares:          i.Shares,
+		PurchasePrice:   i.PurchasePrice,
+		OperationCost:   i.OperationCost,
+		InvestedCapital: investedCapital,
+		CurrentPrice:    currentPrice,
+		CurrentValue:    currentValue,
+		ProfitLoss:      currentValue.Sub(investedCapital.Add(i.OperationCost)),
+		Performance:     percentChange(i.PurchasePrice, currentPrice),
+	}
+}
+
+// withCurrentPrice completa la vista de una venta con lo que valdrían hoy
+// las acciones vendidas.
+func withCurrentPrice(view SaleView, s Sale, currentPrice decimal.Decimal, currency string) SaleView {
+	view.CurrentPrice = currentPrice
+	view.CurrentValue = roundMoney(s.Shares.Mul(currentPrice), currency)
+	view.Performance = percentChange(s.SalePrice, currentPrice)
+	// Proyección: diferencia entre monto actual y monto de venta
+	view.Projection = view.CurrentValue.Sub(view.TotalSaleValue)
+	return view
+}
+
+// investmentEditJSON son los datos de una compra (con el ticker precargado)
+// para el formulario de edición.
+func investmentEditJSON(investment Investment) gin.H {
+	return gin.H{
+		"id":             investment.ID,
+		"ticker_id":      investment.TickerID,
+		"ticker":         investment.Ticker.Name,
+		"purchase_date":  investment.PurchaseDate.Format("2006-01-02T15:04"),
+		"shares":         investment.Shares,
+		"purchase_price": investment.PurchasePrice,
+		"operation_cost": investment.OperationCost,
+	}
+}
+
+// saleEditJSON son los datos de una venta (con el ticker precargado) para el
+// formulario de edición.
+func saleEditJSON(sale Sale) gin.H {
+	return gin.H{
+		"id":             sale.ID,
+		"ticker_id":      sale.TickerID,
+		"ticker":         sale.Ticker.Name,
+		"sale_date":      sale.SaleDate.Format("2006-01-02T15:04"),
+		"shares":         sale.Shares,
+		"sale_price":     sale.SalePrice,
+		"operation_cost": sale.OperationCost,
+		"withheld_tax":   sale.WithheldTax,
+	}
+}
+
+// newSaleView calcula la vista de una venta con el WAC que tenía la posición
+// en el momento de vender, redondeado como el resto de precios. La utilidad se
+// calcula solo con precios, sin costos de operación ni impuestos.
+func newSaleView(s Sale, tickerName string, wacAtSale decimal.Decimal, currency string) SaleView {
+	saleUtility := roundMoney(s.SalePrice.Sub(wacAtSale).Mul(s.Shares), currency)
+	return SaleView{
+		ID:              s.ID,
+		TickerID:        s.TickerID,
+		Ticker:          tickerName,
+		SaleDate:        s.SaleDate.Format("02 Jan 2006 15:04"),
+		Version:         recordVersion(s.UpdatedAt),
+		Shares:          s.Shares,
+		SalePrice:       s.SalePrice,
+		OperationCost:   s.OperationCost,
+		WithheldTax:     s.WithheldTax,
+		TotalSaleValue:  roundMoney(s.Shares.Mul(s.SalePrice), currency),
+		Profit:          saleUtility,
+		WACAtSale:       roundPrice(wacAtSale),
+		SalePerformance: percentChange(wacAtSale, s.SalePrice),
+		SaleUtility:     saleUtility,
+	}
+}
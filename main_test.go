@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// useTestDatabase abre una base SQLite en memoria con todas las migraciones
+// aplicadas y la deja en db mientras dura el test.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "sqlite://:memory:")
+	database, err := setupDatabase(true)
+	if err != nil {
+		t.Fatalf("setupDatabase: %v", err)
+	}
+
+	previous := db
+	db = database
+	t.Cleanup(func() {
+		// Las alertas y eventos lanzados por los handlers usan la base del test
+		backgroundTasks.Wait()
+		db = previous
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
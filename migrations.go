@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles contiene las migraciones SQL. Cada versión tiene un fichero
+// <versión>.up.sql y opcionalmente <versión>.down.sql; si una sentencia depende
+// del motor, <versión>.<motor>.up.sql tiene prioridad (p. ej. .sqlite.up.sql).
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// schemaMigration es una migración versionada, escrita en Go o en SQL.
+type schemaMigration struct {
+	Version  string
+	Kind     string // "go" o "sql"
+	Checksum string // SHA-256 del fichero up (solo migraciones SQL)
+	Up       func(*gorm.DB) error
+	Down     func(*gorm.DB) error // nil si la migración es irreversible
+}
+
+// goMigrations son las migraciones que necesitan lógica en Go (migración de
+// datos, AutoMigrate de modelos o datos iniciales).
+var goMigrations = []schemaMigration{
+	{Version: "001_create_initial_schema", Up: migration001CreateInitialSchema, Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable("sales", "investments", "tickers")
+	}},
+	// La migración de datos del esquema antiguo no se puede deshacer
+	{Version: "002_migrate_to_ticker_id_schema", Up: migration002MigrateToTickerIDSchema},
+	{Version: "003_create_price_history_table", Up: migration003CreatePriceHistoryTable, Down: dropTables("price_histories")},
+	{Version: "005_create_alert_tables", Up: migration005CreateAlertTables, Down: dropTables("alert_events", "alerts")},
+	{Version: "006_create_watchlist_table", Up: migration006CreateWatchlistTable, Down: dropTables("watchlist_items")},
+	{Version: "007_add_ticker_metadata", Up: migration007AddTickerMetadata, Down: func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP INDEX IF EXISTS idx_tickers_isin").Error; err != nil {
+			return err
+		}
+		for _, column := range []string{"ISIN", "ExchangeMIC", "Currency", "Sector", "Industry", "Country", "AssetClass"} {
+			if err := dropColumn(tx, &Ticker{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Version: "008_create_etf_constituents", Up: migration008CreateETFConstituents, Down: dropTables("etf_constituents")},
+	{Version: "009_create_import_profiles", Up: migration009CreateImportProfiles, Down: dropTables("import_profiles")},
+	{Version: "010_add_dividends_and_external_ids", Up: migration010AddDividendsAndExternalIDs, Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable("dividends"); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Investment{}, &Sale{}} {
+			if tx.Migrator().HasIndex(model, "ExternalID") {
+				if err := tx.Migrator().DropIndex(model, "ExternalID"); err != nil {
+					return err
+				}
+			}
+			if err := dropColumn(tx, model, "ExternalID"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Version: "011_create_audit_logs", Up: migration011CreateAuditLogs, Down: dropTables("audit_logs")},
+	{Version: "013_create_idempotency_keys", Up: migration013CreateIdempotencyKeys, Down: dropTables("idempotency_keys")},
+	{Version: "014_create_webhook_tables", Up: migration014CreateWebhookTables, Down: dropTables("webhook_deliveries", "webhook_subscriptions")},
+}
+
+// dropColumn elimina una columna si existe. En SQLite usa ALTER TABLE DROP
+// COLUMN en lugar de recrear la tabla como hace GORM, que fallaría por las
+// claves foráneas que apuntan a ella.
+func dropColumn(tx *gorm.DB, model interface{}, column string) error {
+	if !tx.Migrator().HasColumn(model, column) {
+		return nil
+	}
+	if !isSQLite(tx) {
+		return tx.Migrator().DropColumn(model, column)
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	name := column
+	if field := stmt.Schema.LookUpField(column); field != nil {
+		name = field.DBName
+	}
+	return tx.Exec(fmt.Sprintf("ALTER TABLE %q DROP COLUMN %q", stmt.Schema.Table, name)).Error
+}
+
+// dropTables devuelve una migración down que elimina las tablas indicadas.
+func dropTables(tables ...string) func(*gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := tx.Migrator().DropTable(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sqlMigrations lee las migraciones SQL embebidas para el motor indicado.
+func sqlMigrations(dialect string) ([]schemaMigration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	// files[versión][dirección] = ruta del fichero elegido para el motor
+	files := make(map[string]map[string]string)
+	for _, name := range names {
+		parts := strings.Split(strings.TrimSuffix(path.Base(name), ".sql"), ".")
+		var version, fileDialect, direction string
+		switch len(parts) {
+		case 2:
+			version, direction = parts[0], parts[1]
+		case 3:
+			version, fileDialect, direction = parts[0], parts[1], parts[2]
+		default:
+			return nil, fmt.Errorf("nombre de migración inválido: %s", name)
+		}
+		if direction != "up" && direction != "down" {
+			return nil, fmt.Errorf("nombre de migración inválido: %s", name)
+		}
+		if fileDialect != "" && fileDialect != dialect {
+			continue
+		}
+		if files[version] == nil {
+			files[version] = make(map[string]string)
+		}
+		// El fichero específico del motor tiene prioridad sobre el genérico
+		if _, exists := files[version][direction]; !exists || fileDialect != "" {
+			files[version][direction] = name
+		}
+	}
+
+	var migrations []schemaMigration
+	for version, byDirection := range files {
+		upFile, ok := byDirection["up"]
+		if !ok {
+			return nil, fmt.Errorf("la migración %s no tiene fichero up", version)
+		}
+		upSQL, err := migrationFiles.ReadFile(upFile)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(upSQL)
+
+		m := schemaMigration{
+			Version:  version,
+			Kind:     "sql",
+			Checksum: hex.EncodeToString(sum[:]),
+			Up:       execSQL(string(upSQL)),
+		}
+		if downFile, ok := byDirection["down"]; ok {
+			downSQL, err := migrationFiles.ReadFile(downFile)
+			if err != nil {
+				return nil, err
+			}
+			m.Down = execSQL(string(downSQL))
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, nil
+}
+
+// execSQL devuelve una migración que ejecuta el script SQL indicado.
+func execSQL(script string) func(*gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(script).Error
+	}
+}
+
+// loadMigrations devuelve todas las migraciones, Go y SQL, ordenadas por versión.
+func loadMigrations(database *gorm.DB) ([]schemaMigration, error) {
+	migrations, err := sqlMigrations(database.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range goMigrations {
+		m.Kind = "go"
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("versión de migración duplicada: %s", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// MigrationStatus es el estado de una migración para `migrate status`.
+type MigrationStatus struct {
+	Version   string
+	Kind      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // El fichero SQL cambió después de aplicarse
+}
+
+// appliedMigrations devuelve las migraciones registradas indexadas por versión.
+func appliedMigrations(database *gorm.DB) (map[string]Migration, error) {
+	if err := database.AutoMigrate(&Migration{}); err != nil {
+		return nil, err
+	}
+	var records []Migration
+	if err := database.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]Migration)
+	for _, r := range records {
+		applied[r.Name] = r
+	}
+	return applied, nil
+}
+
+// migrationStatus combina las migraciones disponibles con las aplicadas.
+func migrationStatus(database *gorm.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		record, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.Version,
+			Kind:      m.Kind,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+			Modified:  ok && record.Checksum != "" && record.Checksum != m.Checksum,
+		})
+	}
+	return statuses, nil
+}
+
+// runMigrations aplica todas las migraciones pendientes en orden de versión,
+// cada una en su propia transacción. Las versiones antiguas que no se habían
+// aplicado (como 004) también se ejecutan.
+func runMigrations(database *gorm.DB) error {
+	_, err := migrateUp(database, "")
+	return err
+}
+
+// migrateUp aplica las migraciones pendientes hasta la versión indicada
+// (incluida) o todas si target está vacío. Devuelve las versiones aplicadas.
+func migrateUp(database *gorm.DB, target string) ([]string, error) {
+	migrations, err := loadMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+
+	// No aplicar nada si un fichero SQL ya aplicado se modificó
+	for _, m := range migrations {
+		if record, ok := applied[m.Version]; ok && record.Checksum != "" && record.Checksum != m.Checksum {
+			return nil, fmt.Errorf("la migración %s se modificó después de aplicarse (checksum distinto)", m.Version)
+		}
+	}
+
+	var done []string
+	for _, m := range migrations {
+		if target != "" && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		log.Printf("Ejecutando migración: %s", m.Version)
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			// Registrar migración como aplicada
+			return tx.Create(&Migration{Name: m.Version, Checksum: m.Checksum, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error en migración %s: %v", m.Version, err)
+		}
+		log.Printf("Migración completada: %s", m.Version)
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// migrateDown revierte las últimas n migraciones aplicadas, de la más reciente
+// a la más antigua. Devuelve las versiones revertidas.
+func migrateDown(database *gorm.DB, n int) ([]string, error) {
+	migrations, err := loadMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[string]schemaMigration)
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+	if _, err := appliedMigrations(database); err != nil {
+		return nil, err
+	}
+
+	var records []Migration
+	if err := database.Order("name desc").Limit(n).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for _, record := range records {
+		m, ok := byVersion[record.Name]
+		if !ok {
+			return done, fmt.Errorf("la migración %s no existe en esta versión de la aplicación", record.Name)
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("la migración %s es irreversible", record.Name)
+		}
+
+		log.Printf("Revirtiendo migración: %s", m.Version)
+		err := database.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("name = ?", m.Version).Delete(&Migration{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error al revertir %s: %v", m.Version, err)
+		}
+		log.Printf("Migración revertida: %s", m.Version)
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
@@ -0,0 +1,2 @@
+-- Migración 004 (down): Eliminar campo yahoo_finance_ticker de la tabla tickers
+ALTER TABLE tickers DROP COLUMN IF EXISTS yahoo_finance_ticker;
@@ -0,0 +1,3 @@
+-- Migración 004 (down): la columna pertenece también a 007_add_ticker_metadata
+-- en SQLite, por lo que se conserva.
+SELECT 1;
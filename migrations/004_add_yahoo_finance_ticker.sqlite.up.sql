@@ -0,0 +1,4 @@
+-- Migración 004: Agregar campo yahoo_finance_ticker a la tabla tickers
+-- SQLite no admite ADD COLUMN IF NOT EXISTS; el esquema inicial (001) no crea
+-- la columna, la agrega 007_add_ticker_metadata junto al resto de metadatos.
+SELECT 1;
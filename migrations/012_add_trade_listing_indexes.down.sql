@@ -0,0 +1,5 @@
+-- Migración 012 (down): Eliminar los índices de los listados de compras y ventas
+DROP INDEX IF EXISTS idx_investments_ticker_purchase_date;
+DROP INDEX IF EXISTS idx_investments_purchase_date;
+DROP INDEX IF EXISTS idx_sales_ticker_sale_date;
+DROP INDEX IF EXISTS idx_sales_sale_date;
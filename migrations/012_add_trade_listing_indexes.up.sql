@@ -0,0 +1,5 @@
+-- Migración 012: Índices para filtrar y ordenar los listados de compras y ventas
+CREATE INDEX IF NOT EXISTS idx_investments_ticker_purchase_date ON investments (ticker_id, purchase_date);
+CREATE INDEX IF NOT EXISTS idx_investments_purchase_date ON investments (purchase_date);
+CREATE INDEX IF NOT EXISTS idx_sales_ticker_sale_date ON sales (ticker_id, sale_date);
+CREATE INDEX IF NOT EXISTS idx_sales_sale_date ON sales (sale_date);
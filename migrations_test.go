@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openEmptyTestDatabase abre una base SQLite en memoria sin migraciones.
+func openEmptyTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "sqlite://:memory:")
+	database, err := setupDatabase(false)
+	if err != nil {
+		t.Fatalf("setupDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return database
+}
+
+// TestMigrateLegacySchema migra una base con el esquema antiguo, que guardaba
+// el nombre del ticker en cada compra y los precios en market_data.
+func TestMigrateLegacySchema(t *testing.T) {
+	database := openEmptyTestDatabase(t)
+	for _, stmt := range []string{
+		`CREATE TABLE market_data (ticker text PRIMARY KEY, current_price numeric)`,
+		`CREATE TABLE investments (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime,
+			deleted_at datetime, ticker text, purchase_date datetime, shares numeric, purchase_price numeric, operation_cost numeric)`,
+		`INSERT INTO market_data VALUES ('SAN', 4.5), ('BBVA', 9.1)`,
+		`INSERT INTO investments (ticker, purchase_date, shares, purchase_price, operation_cost)
+			VALUES ('BBVA', '2023-01-02 00:00:00', 10, 8.5, 1), ('SAN', '2023-02-03 00:00:00', 100, 3.9, 2)`,
+	} {
+		if err := database.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runMigrations(database); err != nil {
+		t.Fatalf("runMigrations: %v", err)
+	}
+
+	if database.Migrator().HasTable("market_data") {
+		t.Error("la tabla market_data debería haberse eliminado")
+	}
+	if database.Migrator().HasColumn(&Investment{}, "ticker") {
+		t.Error("la columna investments.ticker debería haberse eliminado")
+	}
+	var rows []struct {
+		Name   string
+		Shares string
+	}
+	err := database.Table("investments").Select("tickers.name, investments.shares").
+		Joins("JOIN tickers ON tickers.id = investments.ticker_id").Order("investments.id").Scan(&rows).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Name != "BBVA" || rows[1].Name != "SAN" {
+		t.Fatalf("inversiones migradas %+v, se esperaba BBVA y SAN", rows)
+	}
+	if rows[0].Shares != "10" || rows[1].Shares != "100" {
+		t.Errorf("se perdieron las acciones al migrar: %+v", rows)
+	}
+}
+
+// TestMigrationErrorRollsBack comprueba que una migración que falla no se
+// registra como aplicada y detiene las siguientes.
+func TestMigrationErrorRollsBack(t *testing.T) {
+	database := openEmptyTestDatabase(t)
+
+	original := goMigrations
+	t.Cleanup(func() { goMigrations = original })
+	goMigrations = append(append([]schemaMigration{}, original...),
+		schemaMigration{Version: "998_broken", Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE TABLE broken_probe (id integer)").Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO missing_table VALUES (1)").Error
+		}},
+		schemaMigration{Version: "999_after", Up: func(tx *gorm.DB) error { return nil }})
+
+	err := runMigrations(database)
+	if err == nil || !strings.Contains(err.Error(), "998_broken") {
+		t.Fatalf("se esperaba el error de 998_broken, se obtuvo %v", err)
+	}
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"998_broken", "999_after"} {
+		if _, ok := applied[version]; ok {
+			t.Errorf("%s no debería figurar como aplicada", version)
+		}
+	}
+	if _, ok := applied["014_create_webhook_tables"]; !ok {
+		t.Error("las migraciones anteriores deberían haberse aplicado")
+	}
+	if database.Migrator().HasTable("broken_probe") {
+		t.Error("la transacción de la migración fallida debería haberse deshecho")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Reglas de redondeo de cantidades y precios:
+//   - Acciones: 8 decimales (fracciones de acción y criptomonedas).
+//   - Precios unitarios: 6 decimales.
+//   - Importes (capital, costos, impuestos, dividendos, utilidades): los
+//     decimales de la moneda según ISO 4217 (2 por defecto, 0 para JPY, 3 para
+//     KWD...), redondeando la mitad hacia fuera de cero.
+//
+// Los cálculos intermedios, como el costo promedio ponderado, se hacen sin
+// redondear; solo se redondea al guardar lo que introduce el usuario y al
+// presentar los resultados.
+const (
+	sharesPlaces       int32 = 8
+	pricePlaces        int32 = 6
+	defaultMoneyPlaces int32 = 2
+)
+
+// currencyMinorUnits son las monedas cuyo número de decimales no es 2.
+var currencyMinorUnits = map[string]int32{
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+func init() {
+	// Mantener los importes como números (y no cadenas) en las respuestas JSON
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+// moneyPlaces devuelve los decimales de la moneda indicada.
+func moneyPlaces(currency string) int32 {
+	if places, ok := currencyMinorUnits[strings.ToUpper(currency)]; ok {
+		return places
+	}
+	return defaultMoneyPlaces
+}
+
+// roundMoney redondea un importe a los decimales de su moneda.
+func roundMoney(amount decimal.Decimal, currency string) decimal.Decimal {
+	return amount.Round(moneyPlaces(currency))
+}
+
+// roundShares redondea una cantidad de acciones.
+func roundShares(shares decimal.Decimal) decimal.Decimal {
+	return shares.Round(sharesPlaces)
+}
+
+// roundPrice redondea un precio unitario.
+func roundPrice(price decimal.Decimal) decimal.Decimal {
+	return price.Round(pricePlaces)
+}
+
+// parseDecimal interpreta un número de un formulario, aceptando la coma como
+// separador decimal.
+func parseDecimal(value string) (decimal.Decimal, error) {
+	value = strings.Replace(strings.TrimSpace(value), ",", ".", -1)
+	if value == "" {
+		return decimal.Zero, fmt.Errorf("valor vacío")
+	}
+	return decimal.NewFromString(value)
+}
+
+// percentChange devuelve la variación porcentual de from a to, o 0 si from no
+// es positivo. Los porcentajes son ratios de presentación y se devuelven como
+// float64.
+func percentChange(from, to decimal.Decimal) float64 {
+	if !from.IsPositive() {
+		return 0
+	}
+	return to.Sub(from).Div(from).Mul(decimal.NewFromInt(100)).InexactFloat64()
+}
+
+// percentOf devuelve part como porcentaje de total, o 0 si total no es positivo.
+func percentOf(part, total decimal.Decimal) float64 {
+	if !total.IsPositive() {
+		return 0
+	}
+	return part.Div(total).Mul(decimal.NewFromInt(100)).InexactFloat64()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec es la especificación de la API, embebida en el binario.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Modos de validación contra openapi.yaml (variable OPENAPI_VALIDATE)
+const (
+	OpenAPIValidateOff    = "off"
+	OpenAPIValidateReport = "report" // Registra las discrepancias en el log y en la cabecera X-OpenAPI-Mismatch
+	OpenAPIValidateStrict = "strict" // Además rechaza la petición o sustituye la respuesta por un error
+)
+
+// APICodeOpenAPIMismatch es el código de error cuando una petición o respuesta no cumple la especificación.
+const APICodeOpenAPIMismatch = "openapi_mismatch"
+
+// openAPIMismatchHeader es la cabecera con las discrepancias encontradas.
+const openAPIMismatchHeader = "X-OpenAPI-Mismatch"
+
+// openAPISkippedPrefixes son rutas que no se validan: ficheros estáticos y la propia documentación.
+var openAPISkippedPrefixes = []string{"/static/", "/api/openapi.yaml", "/api/docs", "/api/events"}
+
+// openAPIValidationMode lee el modo de validación del entorno. Por defecto
+// está desactivada, salvo en modo test de Gin (GIN_MODE=test), donde es estricta.
+func openAPIValidationMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("OPENAPI_VALIDATE"))); mode {
+	case "1", "true":
+		return OpenAPIValidateReport
+	case OpenAPIValidateOff, OpenAPIValidateReport, OpenAPIValidateStrict:
+		return mode
+	}
+	if gin.Mode() == gin.TestMode {
+		return OpenAPIValidateStrict
+	}
+	return OpenAPIValidateOff
+}
+
+// loadOpenAPISpec carga y valida la especificación embebida.
+func loadOpenAPISpec() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// registerOpenAPIRoutes sirve la especificación y la página de Swagger UI.
+func registerOpenAPIRoutes(router *gin.Engine) {
+	// Especificación OpenAPI
+	router.GET("/api/openapi.yaml", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", openAPISpec)
+	})
+
+	// Documentación interactiva con Swagger UI
+	router.GET("/api/docs", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "api_docs.html", gin.H{
+			"ActivePage": "api",
+		})
+	})
+}
+
+// openAPIResponseRecorder retiene la respuesta para validarla antes de enviarla.
+type openAPIResponseRecorder struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *openAPIResponseRecorder) WriteHeader(code int)              { w.status = code }
+func (w *openAPIResponseRecorder) WriteHeaderNow()                   {}
+func (w *openAPIResponseRecorder) Write(data []byte) (int, error)    { return w.body.Write(data) }
+func (w *openAPIResponseRecorder) WriteString(s string) (int, error) { return w.body.WriteString(s) }
+func (w *openAPIResponseRecorder) Status() int                       { return w.status }
+func (w *openAPIResponseRecorder) Size() int                         { return w.body.Len() }
+func (w *openAPIResponseRecorder) Written() bool                     { return w.body.Len() > 0 }
+
+// replace sustituye la respuesta retenida por un cuerpo JSON.
+func (w *openAPIResponseRecorder) replace(code int, body interface{}) {
+	w.status = code
+	w.body.Reset()
+	json.NewEncoder(&w.body).Encode(body)
+}
+
+// flush envía al cliente la respuesta retenida.
+func (w *openAPIResponseRecorder) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// newOpenAPIValidator crea el middleware que valida las peticiones y las
+// respuestas JSON contra openapi.yaml. Las rutas de /api/ que no figuran en
+// la especificación también se notifican.
+func newOpenAPIValidator(mode string) (gin.HandlerFunc, error) {
+	doc, err := loadOpenAPISpec()
+	if err != nil {
+		return nil, err
+	}
+	// Se ignoran los servidores declarados para aceptar cualquier host y puerto
+	doc.Servers = nil
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	// Las vistas HTML se documentan como texto
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.PlainBodyDecoder)
+
+	options := &openapi3filter.Options{
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+		MultiError:            true,
+	}
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for _, prefix := range openAPISkippedPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		recorder := &openAPIResponseRecorder{ResponseWriter: c.Writer, status: c.Writer.Status()}
+		c.Writer = recorder
+		defer func() {
+			c.Writer = recorder.ResponseWriter
+			recorder.flush()
+		}()
+
+		var mismatches []string
+		route, pathParams, routeErr := router.FindRoute(c.Request)
+		var input *openapi3filter.RequestValidationInput
+		if routeErr == nil {
+			input = &openapi3filter.RequestValidationInput{
+				Request:    c.Request,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+				mismatches = append(mismatches, "petición: "+singleLine(err.Error()))
+				if mode == OpenAPIValidateStrict {
+					recorder.replace(http.StatusBadRequest, APIErrorResponse{
+						Error: "La petición no cumple openapi.yaml: " + singleLine(err.Error()),
+						Code:  APICodeOpenAPIMismatch,
+					})
+					c.Abort()
+				}
+			}
+		}
+
+		if !c.IsAborted() {
+			c.Next()
+
+			switch {
+			case routeErr != nil:
+				if strings.HasPrefix(path, "/api/") && recorder.status != http.StatusNotFound {
+					mismatches = append(mismatches, fmt.Sprintf("ruta no documentada: %s %s", c.Request.Method, path))
+				}
+			case len(mismatches) == 0:
+				err := openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+					RequestValidationInput: input,
+					Status:                 recorder.status,
+					Header:                 recorder.Header(),
+					Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+					Options:                options,
+				})
+				if err != nil {
+					mismatches = append(mismatches, fmt.Sprintf("respuesta %d: %s", recorder.status, singleLine(err.Error())))
+				}
+			}
+
+			if len(mismatches) > 0 && mode == OpenAPIValidateStrict {
+				recorder.replace(http.StatusInternalServerError, APIErrorResponse{
+					Error: "La respuesta no cumple openapi.yaml: " + strings.Join(mismatches, "; "),
+					Code:  APICodeOpenAPIMismatch,
+				})
+			}
+		}
+
+		if len(mismatches) == 0 {
+			return
+		}
+		report := strings.Join(mismatches, "; ")
+		log.Printf("OpenAPI: %s %s no cumple la especificación: %s", c.Request.Method, path, report)
+		recorder.Header().Set(openAPIMismatchHeader, report)
+		if mode == OpenAPIValidateStrict {
+			recorder.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+	}, nil
+}
+
+// singleLine compacta los mensajes de kin-openapi, que incluyen el esquema en
+// varias líneas, para poder enviarlos en una cabecera.
+func singleLine(message string) string {
+	return strings.Join(strings.Fields(message), " ")
+}
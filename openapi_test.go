@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newStrictAPIRouter crea un router con la validación OpenAPI estricta, como
+// el servidor con OPENAPI_VALIDATE=strict.
+func newStrictAPIRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	validator, err := newOpenAPIValidator(OpenAPIValidateStrict)
+	if err != nil {
+		t.Fatalf("newOpenAPIValidator: %v", err)
+	}
+	router := gin.New()
+	router.Use(validator)
+	return router
+}
+
+// apiCall es una petición de prueba y la respuesta recibida.
+type apiCall struct {
+	router *gin.Engine
+	t      *testing.T
+}
+
+// do envía la petición y comprueba el estado y que la respuesta cumple la
+// especificación. Devuelve la respuesta y el campo data decodificado.
+func (a apiCall) do(method, path, body, ifMatch string, status int) (*httptest.ResponseRecorder, map[string]interface{}) {
+	a.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+
+	if mismatch := w.Header().Get(openAPIMismatchHeader); mismatch != "" {
+		a.t.Errorf("%s %s no cumple openapi.yaml: %s", method, path, mismatch)
+	}
+	if w.Code != status {
+		a.t.Errorf("%s %s: estado %d, se esperaba %d: %s", method, path, w.Code, status, w.Body.String())
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	var data map[string]interface{}
+	if json.Unmarshal(w.Body.Bytes(), &envelope) == nil {
+		json.Unmarshal(envelope.Data, &data)
+	}
+	return w, data
+}
+
+// TestAPIV1MatchesOpenAPI recorre las rutas de /api/v1 con la validación
+// estricta: cualquier discrepancia con openapi.yaml hace fallar la petición.
+func TestAPIV1MatchesOpenAPI(t *testing.T) {
+	useTestDatabase(t)
+	router := newStrictAPIRouter(t)
+	registerAPIV1Routes(router)
+	registerBatchRoutes(router)
+	api := apiCall{router: router, t: t}
+
+	for _, path := range []string{
+		"/api/v1/tickers",
+		"/api/v1/investments?page_size=2&page=1",
+		"/api/v1/sales",
+		"/api/v1/snapshots",
+		"/api/v1/analytics/portfolio-summary",
+		"/api/v1/analytics/portfolio-utility-history",
+		"/api/v1/analytics/ticker-summary",
+	} {
+		api.do(http.MethodGet, path, "", "", http.StatusOK)
+	}
+
+	// Ticker
+	_, ticker := api.do(http.MethodPost, "/api/v1/tickers", `{"name":"SAN","current_price":4.5,"currency":"EUR"}`, "", http.StatusCreated)
+	tickerPath := fmt.Sprintf("/api/v1/tickers/%v", ticker["id"])
+	w, _ := api.do(http.MethodGet, tickerPath, "", "", http.StatusOK)
+	api.do(http.MethodPut, tickerPath, `{"name":"SAN","current_price":4.6,"currency":"EUR"}`, w.Header().Get("ETag"), http.StatusOK)
+	api.do(http.MethodPut, tickerPath, `{"name":"SAN","current_price":4.7}`, w.Header().Get("ETag"), http.StatusPreconditionFailed)
+	api.do(http.MethodPut, tickerPath, `{"name":"SAN","current_price":4.7}`, "", http.StatusPreconditionRequired)
+
+	// Compra
+	_, inv := api.do(http.MethodPost, "/api/v1/investments",
+		fmt.Sprintf(`{"ticker_id":%v,"purchase_date":"2024-01-02","shares":10,"purchase_price":4}`, ticker["id"]), "", http.StatusCreated)
+	invPath := fmt.Sprintf("/api/v1/investments/%v", inv["id"])
+	w, _ = api.do(http.MethodGet, invPath, "", "", http.StatusOK)
+	api.do(http.MethodPut, invPath, fmt.Sprintf(`{"ticker_id":%v,"purchase_date":"2024-01-02","shares":12,"purchase_price":4}`, ticker["id"]),
+		w.Header().Get("ETag"), http.StatusOK)
+
+	// Venta
+	_, sale := api.do(http.MethodPost, "/api/v1/sales",
+		fmt.Sprintf(`{"ticker_id":%v,"sale_date":"2024-02-01","shares":4,"sale_price":5}`, ticker["id"]), "", http.StatusCreated)
+	salePath := fmt.Sprintf("/api/v1/sales/%v", sale["id"])
+	w, _ = api.do(http.MethodGet, salePath, "", "", http.StatusOK)
+	api.do(http.MethodGet, salePath+"/calculation", "", "", http.StatusOK)
+	api.do(http.MethodPut, salePath, fmt.Sprintf(`{"ticker_id":%v,"sale_date":"2024-02-01","shares":40,"sale_price":5}`, ticker["id"]),
+		w.Header().Get("ETag"), http.StatusUnprocessableEntity)
+	api.do(http.MethodPut, salePath, fmt.Sprintf(`{"ticker_id":%v,"sale_date":"2024-02-01","shares":3,"sale_price":5}`, ticker["id"]),
+		w.Header().Get("ETag"), http.StatusOK)
+
+	// Snapshot
+	_, snapshot := api.do(http.MethodPost, "/api/v1/snapshots", "", "", http.StatusCreated)
+	snapshotPath := fmt.Sprintf("/api/v1/snapshots/%v", snapshot["snapshot_id"])
+	api.do(http.MethodGet, snapshotPath, "", "", http.StatusOK)
+
+	// Lote
+	api.do(http.MethodPost, "/api/v1/batch", fmt.Sprintf(`{"operations":[
+		{"op":"create","entity":"investment","data":{"ticker_id":%v,"purchase_date":"2024-03-01","shares":1,"purchase_price":4.2}}]}`,
+		ticker["id"]), "", http.StatusOK)
+	api.do(http.MethodPost, "/api/v1/batch", `{"operations":[{"op":"delete","entity":"sale","id":999}]}`, "", http.StatusNotFound)
+
+	// Bajas e inexistentes
+	api.do(http.MethodDelete, snapshotPath, "", "", http.StatusOK)
+	api.do(http.MethodDelete, salePath, "", "", http.StatusOK)
+	api.do(http.MethodDelete, invPath, "", "", http.StatusOK)
+	api.do(http.MethodDelete, tickerPath, "", "", http.StatusConflict)
+	api.do(http.MethodGet, "/api/v1/tickers/999", "", "", http.StatusNotFound)
+}
+
+// TestOpenAPIStrictRejectsMismatches comprueba que la validación estricta
+// rechaza peticiones inválidas, rutas sin documentar y respuestas que no
+// cumplen el esquema.
+func TestOpenAPIStrictRejectsMismatches(t *testing.T) {
+	useTestDatabase(t)
+	router := newStrictAPIRouter(t)
+	registerAPIV1Routes(router)
+	router.GET("/api/v1/undocumented", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+
+	// Respuesta que no cumple el esquema de una ruta documentada
+	broken := newStrictAPIRouter(t)
+	broken.GET("/api/v1/tickers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": "no es una lista"})
+	})
+
+	tests := []struct {
+		name   string
+		router *gin.Engine
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"cuerpo inválido", router, http.MethodPost, "/api/v1/tickers", `{"name":5,"current_price":"caro"}`, http.StatusBadRequest},
+		{"parámetro inválido", router, http.MethodGet, "/api/v1/sales?page_size=muchos", "", http.StatusBadRequest},
+		{"ID no numérico", router, http.MethodGet, "/api/v1/sales/abc", "", http.StatusBadRequest},
+		{"ruta sin documentar", router, http.MethodGet, "/api/v1/undocumented", "", http.StatusInternalServerError},
+		{"respuesta inválida", broken, http.MethodGet, "/api/v1/tickers", "", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: estado %d, se esperaba %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if w.Header().Get(openAPIMismatchHeader) == "" {
+			t.Errorf("%s: falta la cabecera %s", tt.name, openAPIMismatchHeader)
+		}
+		var resp APIErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != APICodeOpenAPIMismatch {
+			t.Errorf("%s: se esperaba el código %s, se obtuvo %s", tt.name, APICodeOpenAPIMismatch, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// PositionState representa el estado de una posición tras reproducir sus compras y ventas.
+type PositionState struct {
+	Shares  decimal.Decimal
+	Capital decimal.Decimal // Capital ya es shares * WAC
+}
+
+// WAC devuelve el costo promedio ponderado de la posición.
+func (p PositionState) WAC() decimal.Decimal {
+	if p.Shares.IsPositive() {
+		return p.Capital.Div(p.Shares)
+	}
+	return decimal.Zero
+}
+
+// positionEvent es una compra o venta dentro de la reproducción cronológica.
+type positionEvent struct {
+	Date   time.Time
+	Type   string // "buy", "sell"
+	Shares decimal.Decimal
+	Price  decimal.Decimal
+	SaleID uint // Para identificar la venta
+}
+
+// investmentEvent convierte una compra en un evento (sin costos de operación).
+func investmentEvent(inv Investment) positionEvent {
+	return positionEvent{Date: inv.PurchaseDate, Type: "buy", Shares: inv.Shares, Price: inv.PurchasePrice}
+}
+
+// saleEvent convierte una venta en un evento.
+func saleEvent(s Sale) positionEvent {
+	return positionEvent{Date: s.SaleDate, Type: "sell", Shares: s.Shares, Price: s.SalePrice, SaleID: s.ID}
+}
+
+// replayPositions reconstruye la posición de cada ticker con las compras y
+// ventas registradas hasta la fecha indicada (inclusive).
+func replayPositions(until time.Time) (map[uint]PositionState, error) {
+	var investments []Investment
+	if err := db.Where("purchase_date <= ?", until).Find(&investments).Error; err != nil {
+		return nil, err
+	}
+
+	var sales []Sale
+	if err := db.Where("sale_date <= ?", until).Find(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	tickerEvents := make(map[uint][]positionEvent)
+	for _, inv := range investments {
+		tickerEvents[inv.TickerID] = append(tickerEvents[inv.TickerID], investmentEvent(inv))
+	}
+	for _, s := range sales {
+		tickerEvents[s.TickerID] = append(tickerEvents[s.TickerID], saleEvent(s))
+	}
+
+	positions := make(map[uint]PositionState)
+	for tickerID, events := range tickerEvents {
+		positions[tickerID] = replayEvents(events)
+	}
+	return positions, nil
+}
+
+// replayEvents aplica los eventos en orden cronológico (compras antes que
+// ventas en la misma fecha) y devuelve el estado final de la posición.
+func replayEvents(events []positionEvent) PositionState {
+	state, _ := replayEventsWithSales(events)
+	return state
+}
+
+// sortPositionEvents ordena los eventos cronológicamente, con las compras
+// antes que las ventas en la misma fecha.
+func sortPositionEvents(events []positionEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Date.Equal(events[j].Date) {
+			return events[i].Type == "buy" && events[j].Type != "buy"
+		}
+		return events[i].Date.Before(events[j].Date)
+	})
+}
+
+// replayEventsWithSales es como replayEvents pero además devuelve el WAC en
+// el momento de cada venta, indexado por SaleID.
+//
+// Al vender, el capital se reduce en la proporción de acciones vendidas
+// (capital * restantes / acciones), de modo que una posición cerrada vuelve
+// exactamente a cero acciones y cero capital.
+func replayEventsWithSales(events []positionEvent) (PositionState, map[uint]decimal.Decimal) {
+	sortPositionEvents(events)
+
+	saleWACs := make(map[uint]decimal.Decimal)
+	var state PositionState
+	for _, e := range events {
+		if e.Type == "buy" {
+			state.Shares = state.Shares.Add(e.Shares)
+			state.Capital = state.Capital.Add(e.Shares.Mul(e.Price))
+		} else if e.Type == "sell" {
+			saleWACs[e.SaleID] = state.WAC()
+			remaining := state.Shares.Sub(e.Shares)
+			switch {
+			case remaining.IsZero():
+				state.Capital = decimal.Zero
+			case state.Shares.IsPositive():
+				state.Capital = state.Capital.Mul(remaining).Div(state.Shares)
+			}
+			state.Shares = remaining
+		}
+	}
+	return state, saleWACs
+}
+
+// deferPositionsKey marca en el contexto de una transacción que la
+// comprobación de posiciones se hace una sola vez al final.
+type deferPositionsKey struct{}
+
+// deferPositionChecks devuelve tx con la comprobación de posiciones aplazada.
+// Los lotes lo usan para que el orden de sus operaciones no importe: quien lo
+// llama debe ejecutar validatePositions con la tx original antes de confirmar.
+func deferPositionChecks(tx *gorm.DB) *gorm.DB {
+	return tx.WithContext(context.WithValue(tx.Statement.Context, deferPositionsKey{}, true))
+}
+
+// validatePositions comprueba dentro de tx que ninguna venta de los tickers
+// indicados deja la posición con acciones negativas. No hace nada si la
+// comprobación está aplazada con deferPositionChecks.
+func validatePositions(tx *gorm.DB, tickerIDs ...uint) error {
+	if deferred, _ := tx.Statement.Context.Value(deferPositionsKey{}).(bool); deferred {
+		return nil
+	}
+	tickerID, oversold, err := firstOversell(tx, tickerIDs...)
+	if err != nil || oversold == nil {
+		return err
+	}
+	return oversellError(tx, tickerID, *oversold)
+}
+
+// firstOversell devuelve el primer ticker de la lista con una venta que deja
+// la posición con acciones negativas, y esa venta.
+func firstOversell(tx *gorm.DB, tickerIDs ...uint) (uint, *Sale, error) {
+	checked := make(map[uint]bool)
+	for _, tickerID := range tickerIDs {
+		if checked[tickerID] {
+			continue
+		}
+		checked[tickerID] = true
+		oversold, err := findOversell(tx, tickerID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if oversold != nil {
+			return tickerID, oversold, nil
+		}
+	}
+	return 0, nil, nil
+}
+
+// oversellError es el error de validación de una venta sin acciones suficientes.
+func oversellError(tx *gorm.DB, tickerID uint, oversold Sale) error {
+	var ticker Ticker
+	tx.Unscoped().First(&ticker, tickerID)
+	return validationError("La venta %d de %s del %s dejaría la posición con acciones negativas.",
+		oversold.ID, ticker.Name, oversold.SaleDate.Format("02 Jan 2006"))
+}
+
+// findOversell reproduce las compras y ventas de un ticker dentro de tx y
+// devuelve la primera venta que deja la posición con acciones negativas.
+func findOversell(tx *gorm.DB, tickerID uint) (*Sale, error) {
+	var investments []Investment
+	if err := tx.Where("ticker_id = ?", tickerID).Find(&investments).Error; err != nil {
+		return nil, err
+	}
+	var sales []Sale
+	if err := tx.Where("ticker_id = ?", tickerID).Find(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	events := make([]positionEvent, 0, len(investments)+len(sales))
+	for _, inv := range investments {
+		events = append(events, investmentEvent(inv))
+	}
+	salesByID := make(map[uint]Sale, len(sales))
+	for _, s := range sales {
+		events = append(events, saleEvent(s))
+		salesByID[s.ID] = s
+	}
+
+	// Se reproduce evento a evento para detectar el momento del descubierto
+	sortPositionEvents(events)
+	shares := decimal.Zero
+	for _, e := range events {
+		if e.Type == "buy" {
+			shares = shares.Add(e.Shares)
+			continue
+		}
+		shares = shares.Sub(e.Shares)
+		if shares.IsNegative() {
+			s := salesByID[e.SaleID]
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
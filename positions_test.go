@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// requireOversell comprueba que err es el error de validación por vender más
+// acciones de las disponibles.
+func requireOversell(t *testing.T, what string, err error) {
+	t.Helper()
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnprocessableEntity {
+		t.Errorf("%s: se esperaba un error 422 por acciones negativas, se obtuvo %v", what, err)
+	}
+}
+
+// TestServicesRejectOversell comprueba que las altas, cambios y bajas de la
+// capa de servicio (REST, lote y CLI) no dejan posiciones negativas.
+func TestServicesRejectOversell(t *testing.T) {
+	useTestDatabase(t)
+
+	// El ejemplo tiene una compra de 8 MSFT (ticker 3, compra 3) el 10/03/2023
+	sell := func(shares int64) SaleInput {
+		return SaleInput{TickerID: 3, SaleDate: "2023-06-01", Shares: decimal.NewFromInt(shares), SalePrice: decimal.NewFromInt(320)}
+	}
+
+	_, err := createSale(db, "test", sell(9))
+	requireOversell(t, "createSale", err)
+	var count int64
+	db.Model(&Sale{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("la venta rechazada no debería guardarse, hay %d ventas", count)
+	}
+
+	sale, err := createSale(db, "test", sell(5))
+	if err != nil {
+		t.Fatalf("createSale: %v", err)
+	}
+
+	_, err = updateSale(db, "test", sale.ID, "", sell(10))
+	requireOversell(t, "updateSale", err)
+	var stored Sale
+	db.First(&stored, sale.ID)
+	if !stored.Shares.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("la venta cambió a %s acciones pese al error", stored.Shares)
+	}
+
+	_, err = updateInvestment(db, "test", 3, "", InvestmentInput{TickerID: 3, PurchaseDate: "2023-03-10",
+		Shares: decimal.NewFromInt(2), PurchasePrice: decimal.RequireFromString("305.20")})
+	requireOversell(t, "updateInvestment", err)
+
+	requireOversell(t, "deleteInvestment", deleteInvestment(db, "test", 3))
+	if err := db.First(&Investment{}, 3).Error; err != nil {
+		t.Errorf("la compra no debería haberse borrado: %v", err)
+	}
+
+	data, _ := json.Marshal(sell(4))
+	results, _, err := applyBatch("test", []BatchOperation{{Op: BatchCreate, Entity: AuditSale, Data: data}})
+	requireOversell(t, "applyBatch", err)
+	if len(results) != 1 || results[0].Status != BatchFailed {
+		t.Errorf("la operación del lote debería figurar como fallida: %+v", results)
+	}
+
+	// Vender todo lo que queda sí es válido
+	if _, err := createSale(db, "test", sell(3)); err != nil {
+		t.Errorf("createSale con las acciones restantes: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// SnapshotPriceView representa el precio de un ticker en un snapshot junto con
+// la posición que se tenía en ese momento.
+type SnapshotPriceView struct {
+	TickerID     uint            `json:"ticker_id"`
+	Ticker       string          `json:"ticker"`
+	Price        decimal.Decimal `json:"price"`
+	CurrentPrice decimal.Decimal `json:"current_price"`
+	PriceChange  float64         `json:"price_change"` // Cambio porcentual hasta el precio actual
+	Shares       decimal.Decimal `json:"shares"`
+	WAC          decimal.Decimal `json:"wac"`
+	Value        decimal.Decimal `json:"value"`
+	Utility      decimal.Decimal `json:"utility"`
+	Performance  float64         `json:"performance"`
+}
+
+// SnapshotDetail agrupa los precios de un snapshot y la valoración de la cartera.
+type SnapshotDetail struct {
+	SnapshotID     string              `json:"snapshot_id"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Prices         []SnapshotPriceView `json:"prices"`
+	PortfolioValue decimal.Decimal     `json:"portfolio_value"`
+	PortfolioCost  decimal.Decimal     `json:"portfolio_cost"`
+	Utility        decimal.Decimal     `json:"utility"`
+	Performance    float64             `json:"performance"`
+}
+
+// SnapshotSummary resume un snapshot de precios en los listados.
+type SnapshotSummary struct {
+	SnapshotID string    `json:"snapshot_id"`
+	CreatedAt  time.Time `json:"created_at"` // Fecha del primer precio registrado
+	Count      int64     `json:"count"`
+}
+
+// listSnapshots devuelve los snapshots del más reciente al más antiguo. Se
+// agrupan en Go porque SQLite devuelve MIN(created_at) como texto.
+func listSnapshots() ([]SnapshotSummary, error) {
+	var priceHistories []PriceHistory
+	if err := db.Select("snapshot_id", "created_at").Find(&priceHistories).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	snapshots := []SnapshotSummary{}
+	for _, ph := range priceHistories {
+		i, ok := index[ph.SnapshotID]
+		if !ok {
+			i = len(snapshots)
+			index[ph.SnapshotID] = i
+			snapshots = append(snapshots, SnapshotSummary{SnapshotID: ph.SnapshotID, CreatedAt: ph.CreatedAt})
+		}
+		if ph.CreatedAt.Before(snapshots[i].CreatedAt) {
+			snapshots[i].CreatedAt = ph.CreatedAt
+		}
+		snapshots[i].Count++
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// latestSnapshotChanges calcula el cambio porcentual de cada ticker entre los
+// dos snapshots más recientes. Sin dos snapshots devuelve un mapa vacío.
+func latestSnapshotChanges() map[uint]*float64 {
+	changes := make(map[uint]*float64)
+
+	// Los dos primeros snapshots son los más recientes
+	snapshots, _ := listSnapshots()
+	if len(snapshots) < 2 {
+		return changes
+	}
+
+	// Obtener precios del último snapshot
+	var lastPrices []PriceHistory
+	db.Where("snapshot_id = ?", snapshots[0].SnapshotID).Find(&lastPrices)
+
+	// Obtener precios del snapshot anterior
+	var prevPrices []PriceHistory
+	db.Where("snapshot_id = ?", snapshots[1].SnapshotID).Find(&prevPrices)
+	prevPriceMap := make(map[uint]decimal.Decimal)
+	for _, p := range prevPrices {
+		prevPriceMap[p.TickerID] = p.Price
+	}
+
+	// Calcular cambios porcentuales
+	for _, p := range lastPrices {
+		if prevPrice, exists := prevPriceMap[p.TickerID]; exists && prevPrice.IsPositive() {
+			change := percentChange(prevPrice, p.Price)
+			changes[p.TickerID] = &change
+		}
+	}
+	return changes
+}
+
+// registerSnapshotRoutes registra las rutas de detalle, exportación y restauración de snapshots.
+func registerSnapshotRoutes(router *gin.Engine) {
+	// Ruta para mostrar el detalle de un snapshot
+	router.GET("/snapshots/:id", func(c *gin.Context) {
+		detail, err := getSnapshotDetail(c.Param("id"))
+		if err != nil {
+			c.String(http.StatusNotFound, "Snapshot no encontrado.")
+			return
+		}
+
+		c.HTML(http.StatusOK, "snapshot_detail.html", gin.H{
+			"Snapshot":   detail,
+			"ActivePage": "snapshots",
+		})
+	})
+
+	// Ruta para descargar un snapshot en CSV o JSON
+	router.GET("/snapshots/:id/export", func(c *gin.Context) {
+		detail, err := getSnapshotDetail(c.Param("id"))
+		if err != nil {
+			c.String(http.StatusNotFound, "Snapshot no encontrado.")
+			return
+		}
+
+		format := c.DefaultQuery("format", "csv")
+		filename := fmt.Sprintf("snapshot-%s.%s", detail.SnapshotID, format)
+
+		switch format {
+		case "json":
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			c.JSON(http.StatusOK, detail)
+		case "csv":
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			if err := writeSnapshotCSV(c.Writer, detail); err != nil {
+				log.Printf("Error al exportar snapshot %s: %v", detail.SnapshotID, err)
+			}
+		default:
+			c.String(http.StatusBadRequest, "Formato no soportado. Use csv o json.")
+		}
+	})
+
+	// Ruta para aplicar los precios de un snapshot como precios actuales
+	router.POST("/snapshots/:id/restore", func(c *gin.Context) {
+		snapshotID := c.Param("id")
+
+		var priceHistories []PriceHistory
+		db.Where("snapshot_id = ?", snapshotID).Find(&priceHistories)
+		if len(priceHistories) == 0 {
+			c.String(http.StatusNotFound, "Snapshot no encontrado.")
+			return
+		}
+
+		actor := auditActor(c)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, ph := range priceHistories {
+				var before, after Ticker
+				if err := tx.First(&before, ph.TickerID).Error; err != nil {
+					continue // El ticker se eliminó después del snapshot
+				}
+				if before.CurrentPrice.Equal(ph.Price) {
+					continue
+				}
+				if err := tx.Model(&Ticker{}).Where("id = ?", ph.TickerID).Update("current_price", ph.Price).Error; err != nil {
+					return err
+				}
+				tx.First(&after, ph.TickerID)
+				if err := recordAudit(tx, actor, AuditTicker, ph.TickerID, AuditUpdate, before, after); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error al restaurar snapshot %s: %v", snapshotID, err)
+			c.String(http.StatusInternalServerError, "Error al restaurar el snapshot.")
+			return
+		}
+
+		log.Printf("Precios restaurados desde snapshot %s (%d tickers)", snapshotID, len(priceHistories))
+
+		var tickerIDs []uint
+		for _, ph := range priceHistories {
+			tickerIDs = append(tickerIDs, ph.TickerID)
+		}
+		goBackground(func() { evaluateAlerts("restore", tickerIDs...) })
+		goBackground(func() { publishPriceChanges("restore", tickerIDs...) })
+
+		c.Redirect(http.StatusFound, "/precios")
+	})
+}
+
+// getSnapshotDetail obtiene los precios de un snapshot y valora la cartera
+// reproduciendo las posiciones hasta la fecha del snapshot.
+func getSnapshotDetail(snapshotID string) (*SnapshotDetail, error) {
+	var priceHistories []PriceHistory
+	if err := db.Preload("Ticker").Where("snapshot_id = ?", snapshotID).Find(&priceHistories).Error; err != nil {
+		return nil, err
+	}
+	if len(priceHistories) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	// La fecha del snapshot es la del primer precio registrado
+	createdAt := priceHistories[0].CreatedAt
+	for _, ph := range priceHistories {
+		if ph.CreatedAt.Before(createdAt) {
+			createdAt = ph.CreatedAt
+		}
+	}
+
+	positions, err := replayPositions(createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &SnapshotDetail{SnapshotID: snapshotID, CreatedAt: createdAt}
+	for _, ph := range priceHistories {
+		view := SnapshotPriceView{
+			TickerID:     ph.TickerID,
+			Ticker:       ph.Ticker.Name,
+			Price:        ph.Price,
+			CurrentPrice: ph.Ticker.CurrentPrice,
+		}
+		view.PriceChange = percentChange(ph.Price, ph.Ticker.CurrentPrice)
+
+		if state, ok := positions[ph.TickerID]; ok && state.Shares.IsPositive() {
+			cost := roundMoney(state.Capital, ph.Ticker.Currency)
+			view.Shares = state.Shares
+			view.WAC = roundPrice(state.WAC())
+			view.Value = roundMoney(state.Shares.Mul(ph.Price), ph.Ticker.Currency)
+			view.Utility = view.Value.Sub(cost)
+			view.Performance = percentChange(state.WAC(), ph.Price)
+			detail.PortfolioValue = detail.PortfolioValue.Add(view.Value)
+			detail.PortfolioCost = detail.PortfolioCost.Add(cost)
+		}
+		detail.Prices = append(detail.Prices, view)
+	}
+
+	sort.Slice(detail.Prices, func(i, j int) bool {
+		return detail.Prices[i].Ticker < detail.Prices[j].Ticker
+	})
+
+	detail.Utility = detail.PortfolioValue.Sub(detail.PortfolioCost)
+	detail.Performance = percentOf(detail.Utility, detail.PortfolioCost)
+	return detail, nil
+}
+
+// writeSnapshotCSV escribe los precios y posiciones del snapshot en formato CSV.
+func writeSnapshotCSV(w io.Writer, detail *SnapshotDetail) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"snapshot_id", "created_at", "ticker", "price", "current_price", "shares", "wac", "value", "utility", "performance"}); err != nil {
+		return err
+	}
+
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, p := range detail.Prices {
+		record := []string{
+			detail.SnapshotID,
+			detail.CreatedAt.Format(time.RFC3339),
+			p.Ticker,
+			p.Price.String(),
+			p.CurrentPrice.String(),
+			p.Shares.String(),
+			p.WAC.String(),
+			p.Value.String(),
+			p.Utility.String(),
+			formatFloat(p.Performance),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/shopspring/decimal"
+)
+
+// Tipos de periodo de un extracto
+const (
+	StatementMonth   = "month"
+	StatementQuarter = "quarter"
+	StatementCustom  = "custom"
+)
+
+var spanishMonths = []string{"Enero", "Febrero", "Marzo", "Abril", "Mayo", "Junio", "Julio",
+	"Agosto", "Septiembre", "Octubre", "Noviembre", "Diciembre"}
+
+// StatementPeriod es el intervalo [From, To) que cubre un extracto.
+type StatementPeriod struct {
+	Kind  string
+	From  time.Time
+	To    time.Time
+	Label string
+}
+
+// newStatementPeriod construye el periodo mensual o trimestral que contiene la
+// fecha indicada.
+func newStatementPeriod(kind string, date time.Time) (StatementPeriod, error) {
+	year, month := date.Year(), date.Month()
+	switch kind {
+	case StatementMonth:
+		from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		return StatementPeriod{
+			Kind:  kind,
+			From:  from,
+			To:    from.AddDate(0, 1, 0),
+			Label: fmt.Sprintf("%s %d", spanishMonths[month-1], year),
+		}, nil
+	case StatementQuarter:
+		quarter := (int(month)-1)/3 + 1
+		from := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+		return StatementPeriod{
+			Kind:  kind,
+			From:  from,
+			To:    from.AddDate(0, 3, 0),
+			Label: fmt.Sprintf("T%d %d", quarter, year),
+		}, nil
+	}
+	return StatementPeriod{}, fmt.Errorf("tipo de periodo %q inválido", kind)
+}
+
+// newCustomStatementPeriod construye un periodo entre dos fechas (ambas incluidas).
+func newCustomStatementPeriod(from, to time.Time) (StatementPeriod, error) {
+	if to.Before(from) {
+		return StatementPeriod{}, fmt.Errorf("la fecha final es anterior a la inicial")
+	}
+	return StatementPeriod{
+		Kind:  StatementCustom,
+		From:  from,
+		To:    to.AddDate(0, 0, 1),
+		Label: fmt.Sprintf("%s - %s", from.Format("02/01/2006"), to.Format("02/01/2006")),
+	}, nil
+}
+
+// parseStatementPeriod interpreta los parámetros de periodo de la web y la CLI:
+// period=month|quarter con month=AAAA-MM, o from/to con formato AAAA-MM-DD.
+func parseStatementPeriod(kind, month, from, to string) (StatementPeriod, error) {
+	if from != "" || to != "" {
+		start, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return StatementPeriod{}, fmt.Errorf("fecha inicial inválida")
+		}
+		end, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return StatementPeriod{}, fmt.Errorf("fecha final inválida")
+		}
+		return newCustomStatementPeriod(start, end)
+	}
+
+	date := time.Now()
+	if month != "" {
+		var err error
+		if date, err = time.Parse("2006-01", month); err != nil {
+			return StatementPeriod{}, fmt.Errorf("mes inválido, use AAAA-MM")
+		}
+	}
+	if kind == "" {
+		kind = StatementMonth
+	}
+	return newStatementPeriod(kind, date)
+}
+
+// Previous devuelve el periodo inmediatamente anterior de la misma duración.
+func (p StatementPeriod) Previous() StatementPeriod {
+	if p.Kind == StatementCustom {
+		days := int(p.To.Sub(p.From).Hours() / 24)
+		prev, _ := newCustomStatementPeriod(p.From.AddDate(0, 0, -days), p.From.AddDate(0, 0, -1))
+		return prev
+	}
+	prev, _ := newStatementPeriod(p.Kind, p.From.AddDate(0, 0, -1))
+	return prev
+}
+
+// StatementPosition es una posición valorada en una fecha.
+type StatementPosition struct {
+	TickerID uint
+	Ticker   string
+	Currency string
+	Shares   decimal.Decimal
+	Price    decimal.Decimal
+	Value    decimal.Decimal
+	Cost     decimal.Decimal
+	AtCost   bool // No había precio histórico y se valora al coste medio
+}
+
+// StatementTrade es una compra o venta dentro del periodo.
+type StatementTrade struct {
+	Date         time.Time
+	TickerID     uint
+	Ticker       string
+	Currency     string
+	Type         string
+	Shares       decimal.Decimal
+	Price        decimal.Decimal
+	Amount       decimal.Decimal
+	Fees         decimal.Decimal
+	Tax          decimal.Decimal
+	RealizedGain decimal.Decimal
+}
+
+// StatementDividend es un dividendo cobrado dentro del periodo.
+type StatementDividend struct {
+	Date     time.Time
+	TickerID uint
+	Ticker   string
+	Currency string
+	Amount   decimal.Decimal
+	Tax      decimal.Decimal
+	Net      decimal.Decimal
+}
+
+// PortfolioStatement contiene todos los datos de un extracto de cartera.
+type PortfolioStatement struct {
+	Period           StatementPeriod
+	GeneratedAt      time.Time
+	OpeningValue     decimal.Decimal
+	ClosingValue     decimal.Decimal
+	ClosingCost      decimal.Decimal
+	Positions        []StatementPosition
+	Trades           []StatementTrade
+	Dividends        []StatementDividend
+	Purchases        decimal.Decimal
+	SaleProceeds     decimal.Decimal
+	RealizedGains    decimal.Decimal
+	DividendsNet     decimal.Decimal
+	Fees             decimal.Decimal
+	Taxes            decimal.Decimal
+	Gain             decimal.Decimal // Variación de valor descontando aportaciones y retiradas
+	Return           float64         // Rentabilidad del periodo (Modified Dietz), en %
+	PreviousReturn   float64
+	HasPrevious      bool
+	Allocations      []AllocationBreakdown
+	HasCostValuation bool
+	Currency         string   // Moneda de los totales; vacía si la cartera mezcla monedas
+	Currencies       []string // Monedas de las posiciones y movimientos del extracto
+}
+
+// statementPrices devuelve el último precio conocido de cada ticker en la
+// fecha indicada: el precio actual si la fecha es futura o, si no, el último
+// snapshot anterior. Sólo se lee un snapshot por ticker.
+func statementPrices(at time.Time) (map[uint]decimal.Decimal, error) {
+	prices := make(map[uint]decimal.Decimal)
+	if at.After(time.Now()) {
+		var tickers []Ticker
+		if err := db.Find(&tickers).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range tickers {
+			prices[t.ID] = t.CurrentPrice
+		}
+		return prices, nil
+	}
+
+	latest := db.Model(&PriceHistory{}).
+		Select("ticker_id, MAX(created_at) AS created_at").
+		Where("created_at <= ?", at).
+		Group("ticker_id")
+	var histories []PriceHistory
+	err := db.Joins("JOIN (?) AS latest ON latest.ticker_id = price_histories.ticker_id AND latest.created_at = price_histories.created_at", latest).
+		Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, ph := range histories {
+		prices[ph.TickerID] = ph.Price
+	}
+	return prices, nil
+}
+
+// valuePortfolioAt valora las posiciones abiertas en la fecha indicada.
+func valuePortfolioAt(at time.Time, tickerNames map[uint]string) ([]StatementPosition, decimal.Decimal, error) {
+	positions, err := replayPositions(at)
+	if err != nil {
+		return nil, decimal.Zero, err
+	}
+	prices, err := statementPrices(at)
+	if err != nil {
+		return nil, decimal.Zero, err
+	}
+
+	var result []StatementPosition
+	var total decimal.Decimal
+	for tickerID, state := range positions {
+		if !state.Shares.IsPositive() {
+			continue
+		}
+		pos := StatementPosition{
+			TickerID: tickerID,
+			Ticker:   tickerNames[tickerID],
+			Shares:   state.Shares,
+			Cost:     roundMoney(state.Capital, ""),
+		}
+		if price, ok := prices[tickerID]; ok && price.IsPositive() {
+			pos.Price = price
+		} else {
+			pos.Price = roundPrice(state.WAC())
+			pos.AtCost = true
+		}
+		pos.Value = roundMoney(pos.Shares.Mul(pos.Price), "")
+		total = total.Add(pos.Value)
+		result = append(result, pos)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Value.GreaterThan(result[j].Value) })
+	return result, total, nil
+}
+
+// periodReturn calcula la ganancia y la rentabilidad Modified Dietz de un
+// periodo a partir del valor inicial, final y los flujos de caja ponderados.
+func periodReturn(period StatementPeriod, opening, closing, dividends decimal.Decimal, trades []StatementTrade) (decimal.Decimal, float64) {
+	length := period.To.Sub(period.From).Seconds()
+	var netFlow, weightedFlow decimal.Decimal
+	for _, t := range trades {
+		flow := t.Amount.Add(t.Fees)
+		if t.Type == ImportSell {
+			flow = t.Amount.Sub(t.Fees).Sub(t.Tax).Neg()
+		}
+		netFlow = netFlow.Add(flow)
+		if length > 0 {
+			weight := decimal.NewFromFloat(period.To.Sub(t.Date).Seconds() / length)
+			weightedFlow = weightedFlow.Add(flow.Mul(weight))
+		}
+	}
+
+	gain := closing.Sub(opening).Sub(netFlow).Add(dividends)
+	return gain, percentOf(gain, opening.Add(weightedFlow))
+}
+
+// periodActivity obtiene las operaciones y dividendos del periodo a partir de
+// las mismas vistas que usan el dashboard y el resumen.
+func periodActivity(period StatementPeriod, investments []InvestmentView, sales []SaleView) ([]StatementTrade, []StatementDividend) {
+	inPeriod := func(date time.Time) bool {
+		return !date.Before(period.From) && date.Before(period.To)
+	}
+
+	var trades []StatementTrade
+	for _, i := range investments {
+		date, err := time.Parse("02 Jan 2006 15:04", i.PurchaseDate)
+		if err != nil || !inPeriod(date) {
+			continue
+		}
+		trades = append(trades, StatementTrade{
+			Date:     date,
+			TickerID: i.TickerID,
+			Ticker:   i.Ticker,
+			Type:     ImportBuy,
+			Shares:   i.Shares,
+			Price:    i.PurchasePrice,
+			Amount:   i.InvestedCapital,
+			Fees:     i.OperationCost,
+		})
+	}
+	for _, s := range sales {
+		date, err := time.Parse("02 Jan 2006 15:04", s.SaleDate)
+		if err != nil || !inPeriod(date) {
+			continue
+		}
+		trades = append(trades, StatementTrade{
+			Date:         date,
+			TickerID:     s.TickerID,
+			Ticker:       s.Ticker,
+			Type:         ImportSell,
+			Shares:       s.Shares,
+			Price:        s.SalePrice,
+			Amount:       s.TotalSaleValue,
+			Fees:         s.OperationCost,
+			Tax:          s.WithheldTax,
+			RealizedGain: s.SaleUtility,
+		})
+	}
+	sort.Slice(trades, func(i, j int) bool { return trades[i].Date.Before(trades[j].Date) })
+
+	var dividends []Dividend
+	db.Preload("Ticker").Where("payment_date >= ? AND payment_date < ?", period.From, period.To).
+		Order("payment_date asc").Find(&dividends)
+	var dividendViews []StatementDividend
+	for _, d := range dividends {
+		dividendViews = append(dividendViews, StatementDividend{
+			Date:     d.PaymentDate,
+			TickerID: d.TickerID,
+			Ticker:   d.Ticker.Name,
+			Amount:   d.Amount,
+			Tax:      d.WithheldTax,
+			Net:      d.Amount.Sub(d.WithheldTax),
+		})
+	}
+	return trades, dividendViews
+}
+
+// buildPortfolioStatement calcula el extracto del periodo y la rentabilidad
+// del periodo anterior para compararla.
+func buildPortfolioStatement(period StatementPeriod) (*PortfolioStatement, error) {
+	investments, _, sales, _, _, _, _, _, _, _, err := getInvestmentData()
+	if err != nil {
+		return nil, err
+	}
+
+	tickerMap, _ := loadAllocationData(false)
+	tickerNames := make(map[uint]string)
+	for id, t := range tickerMap {
+		tickerNames[id] = t.Name
+	}
+
+	statement := &PortfolioStatement{Period: period, GeneratedAt: time.Now()}
+
+	// Valor al inicio (justo antes del periodo) y al cierre
+	opening := period.From.Add(-time.Nanosecond)
+	closing := period.To.Add(-time.Nanosecond)
+	_, statement.OpeningValue, err = valuePortfolioAt(opening, tickerNames)
+	if err != nil {
+		return nil, err
+	}
+	statement.Positions, statement.ClosingValue, err = valuePortfolioAt(closing, tickerNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range statement.Positions {
+		statement.ClosingCost = statement.ClosingCost.Add(p.Cost)
+		if p.AtCost {
+			statement.HasCostValuation = true
+		}
+	}
+
+	statement.Trades, statement.Dividends = periodActivity(period, investments, sales)
+	for _, t := range statement.Trades {
+		if t.Type == ImportBuy {
+			statement.Purchases = statement.Purchases.Add(t.Amount)
+		} else {
+			statement.SaleProceeds = statement.SaleProceeds.Add(t.Amount)
+			statement.RealizedGains = statement.RealizedGains.Add(t.RealizedGain)
+		}
+		statement.Fees = statement.Fees.Add(t.Fees)
+		statement.Taxes = statement.Taxes.Add(t.Tax)
+	}
+	for _, d := range statement.Dividends {
+		statement.DividendsNet = statement.DividendsNet.Add(d.Net)
+		statement.Taxes = statement.Taxes.Add(d.Tax)
+	}
+	statement.Currencies = statementCurrencies(statement, tickerMap)
+	if len(statement.Currencies) == 1 {
+		statement.Currency = statement.Currencies[0]
+	}
+	statement.Gain, statement.Return = periodReturn(period, statement.OpeningValue, statement.ClosingValue, statement.DividendsNet, statement.Trades)
+
+	// Rentabilidad del periodo anterior
+	previous := period.Previous()
+	_, prevOpening, err := valuePortfolioAt(previous.From.Add(-time.Nanosecond), tickerNames)
+	if err != nil {
+		return nil, err
+	}
+	if prevOpening.IsPositive() || statement.OpeningValue.IsPositive() {
+		prevTrades, prevDividends := periodActivity(previous, investments, sales)
+		var prevDividendsNet decimal.Decimal
+		for _, d := range prevDividends {
+			prevDividendsNet = prevDividendsNet.Add(d.Net)
+		}
+		_, statement.PreviousReturn = periodReturn(previous, prevOpening, statement.OpeningValue, prevDividendsNet, prevTrades)
+		statement.HasPrevious = true
+	}
+
+	// Reparto de la cartera al cierre
+	var summaries []TickerSummaryView
+	for _, p := range statement.Positions {
+		summaries = append(summaries, TickerSummaryView{TickerID: p.TickerID, Ticker: p.Ticker, TotalShares: p.Shares, CurrentValue: p.Value})
+	}
+	for _, currency := range allocationCurrencies(summaries, tickerMap) {
+		for _, d := range allocationDimensions[:2] {
+			statement.Allocations = append(statement.Allocations, computeAllocation(d.Key, d.Label, currency, summaries, tickerMap, nil))
+		}
+	}
+
+	return statement, nil
+}
+
+// statementCurrencies asigna a cada posición, operación y dividendo la moneda
+// de su ticker y devuelve, ordenadas, las monedas que aparecen en el extracto.
+func statementCurrencies(s *PortfolioStatement, tickers map[uint]Ticker) []string {
+	seen := make(map[string]bool)
+	currencyOf := func(tickerID uint) string {
+		currency := tickers[tickerID].Currency
+		if currency != "" {
+			seen[currency] = true
+		}
+		return currency
+	}
+	for i := range s.Positions {
+		s.Positions[i].Currency = currencyOf(s.Positions[i].TickerID)
+	}
+	for i := range s.Trades {
+		s.Trades[i].Currency = currencyOf(s.Trades[i].TickerID)
+	}
+	for i := range s.Dividends {
+		s.Dividends[i].Currency = currencyOf(s.Dividends[i].TickerID)
+	}
+
+	currencies := make([]string, 0, len(seen))
+	for currency := range seen {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// writeStatementPDF dibuja el extracto en PDF con las fuentes estándar, sin
+// depender de un navegador.
+func writeStatementPDF(w io.Writer, s *PortfolioStatement) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(tr("Extracto de Cartera "+s.Period.Label), false)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, tr(fmt.Sprintf("Generado el %s · Página %d de {nb}", s.GeneratedAt.Format("02/01/2006 15:04"), pdf.PageNo())), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Los totales sólo llevan moneda si toda la cartera está en la misma
+	money := func(v decimal.Decimal) string {
+		text := v.StringFixed(moneyPlaces(s.Currency))
+		if s.Currency != "" {
+			text += " " + s.Currency
+		}
+		return tr(text)
+	}
+	// Los importes de cada fila usan los decimales de la moneda de su ticker y,
+	// si la cartera mezcla monedas, también su código
+	amount := func(v decimal.Decimal, currency string) string {
+		text := v.StringFixed(moneyPlaces(currency))
+		if s.Currency == "" && currency != "" {
+			text += " " + currency
+		}
+		return text
+	}
+	percent := func(v float64) string { return fmt.Sprintf("%+.2f%%", v) }
+
+	section := func(title string) {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetTextColor(29, 78, 216)
+		pdf.CellFormat(0, 8, tr(title), "B", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.Ln(1)
+	}
+
+	// table dibuja una tabla con cabecera sombreada y la repite tras cada salto de página
+	table := func(headers []string, widths []float64, aligns string, rows [][]string) {
+		header := func() {
+			pdf.SetFont("Helvetica", "B", 8)
+			pdf.SetFillColor(229, 231, 235)
+			for i, h := range headers {
+				pdf.CellFormat(widths[i], 6, tr(h), "1", 0, string(aligns[i]), true, 0, "")
+			}
+			pdf.Ln(-1)
+			pdf.SetFont("Helvetica", "", 8)
+		}
+		header()
+		_, pageHeight := pdf.GetPageSize()
+		for _, row := range rows {
+			if pdf.GetY()+5 > pageHeight-15 {
+				pdf.AddPage()
+				header()
+			}
+			for i, cell := range row {
+				pdf.CellFormat(widths[i], 5, tr(cell), "1", 0, string(aligns[i]), false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+		if len(rows) == 0 {
+			total := 0.0
+			for _, w := range widths {
+				total += w
+			}
+			pdf.SetTextColor(120, 120, 120)
+			pdf.CellFormat(total, 5, tr("Sin movimientos en el periodo"), "1", 1, "C", false, 0, "")
+			pdf.SetTextColor(0, 0, 0)
+		}
+	}
+
+	// Cabecera
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, tr("Extracto de Cartera"), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(fmt.Sprintf("%s (%s - %s)", s.Period.Label, s.Period.From.Format("02/01/2006"),
+		s.Period.To.AddDate(0, 0, -1).Format("02/01/2006"))), "", 1, "L", false, 0, "")
+
+	// Resumen
+	section("Resumen del Periodo")
+	summary := [][2]string{
+		{"Valor inicial", money(s.OpeningValue)},
+		{"Compras", money(s.Purchases)},
+		{"Ventas", money(s.SaleProceeds)},
+		{"Utilidad realizada", money(s.RealizedGains)},
+		{"Dividendos netos", money(s.DividendsNet)},
+		{"Costos de operación", money(s.Fees)},
+		{"Impuestos retenidos", money(s.Taxes)},
+		{"Valor final", money(s.ClosingValue)},
+		{"Variación de valor (sin aportaciones)", money(s.Gain)},
+		{"Rentabilidad del periodo", percent(s.Return)},
+	}
+	if s.HasPrevious {
+		summary = append(summary,
+			[2]string{"Rentabilidad del periodo anterior (" + s.Period.Previous().Label + ")", percent(s.PreviousReturn)},
+			[2]string{"Diferencia", fmt.Sprintf("%+.2f p.p.", s.Return-s.PreviousReturn)})
+	}
+	for i, line := range summary {
+		pdf.SetFont("Helvetica", "", 10)
+		if i == len(summary)-1 || line[0] == "Valor final" {
+			pdf.SetFont("Helvetica", "B", 10)
+		}
+		pdf.CellFormat(120, 6, tr(line[0]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, line[1], "", 1, "R", false, 0, "")
+	}
+	if len(s.Currencies) > 1 {
+		pdf.SetFont("Helvetica", "I", 7)
+		pdf.CellFormat(0, 5, tr("La cartera tiene importes en "+strings.Join(s.Currencies, ", ")+
+			": los totales los suman sin conversión de divisa."), "", 1, "L", false, 0, "")
+	}
+
+	// Posiciones al cierre
+	section("Posiciones al Cierre")
+	var rows [][]string
+	for _, p := range s.Positions {
+		price := p.Price.StringFixed(4)
+		if p.AtCost {
+			price += " *"
+		}
+		weight := percentOf(p.Value, s.ClosingValue)
+		rows = append(rows, []string{p.Ticker, p.Shares.StringFixed(6), price,
+			amount(p.Cost, p.Currency), amount(p.Value, p.Currency), amount(p.Value.Sub(p.Cost), p.Currency), fmt.Sprintf("%.2f%%", weight)})
+	}
+	table([]string{"Ticker", "Acciones", "Precio", "Coste", "Valor", "Utilidad", "Peso"},
+		[]float64{34, 28, 24, 26, 26, 26, 26}, "LRRRRRR", rows)
+	if s.HasCostValuation {
+		pdf.SetFont("Helvetica", "I", 7)
+		pdf.CellFormat(0, 5, tr("* Sin snapshot de precio en la fecha: valorado al coste medio ponderado."), "", 1, "L", false, 0, "")
+	}
+
+	// Operaciones del periodo
+	section("Operaciones del Periodo")
+	rows = nil
+	for _, t := range s.Trades {
+		kind, gain := "Compra", ""
+		if t.Type == ImportSell {
+			kind, gain = "Venta", amount(t.RealizedGain, t.Currency)
+		}
+		rows = append(rows, []string{t.Date.Format("02/01/2006"), kind, t.Ticker, t.Shares.StringFixed(6),
+			t.Price.StringFixed(4), amount(t.Amount, t.Currency), amount(t.Fees.Add(t.Tax), t.Currency), gain})
+	}
+	table([]string{"Fecha", "Tipo", "Ticker", "Acciones", "Precio", "Importe", "Costos", "Utilidad"},
+		[]float64{22, 16, 26, 26, 24, 26, 20, 30}, "LLLRRRRR", rows)
+
+	// Dividendos
+	section("Dividendos")
+	rows = nil
+	for _, d := range s.Dividends {
+		rows = append(rows, []string{d.Date.Format("02/01/2006"), d.Ticker,
+			amount(d.Amount, d.Currency), amount(d.Tax, d.Currency), amount(d.Net, d.Currency)})
+	}
+	table([]string{"Fecha", "Ticker", "Bruto", "Retención", "Neto"},
+		[]float64{30, 50, 36, 36, 38}, "LLRRR", rows)
+
+	// Distribución
+	for _, a := range s.Allocations {
+		title := "Distribución por " + a.Label
+		if a.Currency != "" {
+			title += " (" + a.Currency + ")"
+		}
+		section(title)
+		rows = nil
+		for _, slice := range a.Slices {
+			rows = append(rows, []string{slice.Label, slice.Value.StringFixed(moneyPlaces(a.Currency)), fmt.Sprintf("%.2f%%", slice.Percent)})
+		}
+		table([]string{a.Label, "Valor", "Peso"}, []float64{90, 50, 50}, "LRR", rows)
+	}
+
+	return pdf.Output(w)
+}
+
+// registerStatementRoutes registra la ruta de descarga de extractos en PDF.
+func registerStatementRoutes(router *gin.Engine) {
+	// Descargar el extracto de un mes, trimestre o rango de fechas
+	router.GET("/statement", func(c *gin.Context) {
+		period, err := parseStatementPeriod(c.Query("period"), c.Query("month"), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "Periodo inválido: %v", err)
+			return
+		}
+
+		statement, err := buildPortfolioStatement(period)
+		if err != nil {
+			log.Printf("Error al generar el extracto: %v", err)
+			c.String(http.StatusInternalServerError, "Error al generar el extracto: %v", err)
+			return
+		}
+
+		filename := fmt.Sprintf("extracto-%s.pdf", period.From.Format("2006-01-02"))
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if err := writeStatementPDF(c.Writer, statement); err != nil {
+			log.Printf("Error al escribir el extracto: %v", err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestStatementPricesLatestPerTicker(t *testing.T) {
+	useTestDatabase(t)
+
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC) }
+	histories := []PriceHistory{
+		{TickerID: 1, Price: decimal.NewFromInt(10)},
+		{TickerID: 1, Price: decimal.NewFromInt(11)},
+		{TickerID: 1, Price: decimal.NewFromInt(12)},
+		{TickerID: 2, Price: decimal.NewFromInt(50)},
+	}
+	for i, date := range []time.Time{day(1), day(5), day(20), day(2)} {
+		histories[i].CreatedAt = date
+	}
+	if err := db.Create(&histories).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	prices, err := statementPrices(day(10))
+	if err != nil {
+		t.Fatalf("statementPrices: %v", err)
+	}
+	if got := prices[1]; !got.Equal(decimal.NewFromInt(11)) {
+		t.Errorf("ticker 1: precio %s, se esperaba 11", got)
+	}
+	if got := prices[2]; !got.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("ticker 2: precio %s, se esperaba 50", got)
+	}
+	if _, ok := prices[3]; ok {
+		t.Error("el ticker 3 no tenía snapshots en la fecha")
+	}
+
+	prices, err = statementPrices(day(1).Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("statementPrices: %v", err)
+	}
+	if len(prices) != 0 {
+		t.Errorf("no debería haber precios antes del primer snapshot: %v", prices)
+	}
+}
+
+func TestStatementCurrencies(t *testing.T) {
+	tickers := map[uint]Ticker{1: {Currency: "EUR"}, 2: {Currency: "USD"}, 3: {}}
+	statement := &PortfolioStatement{
+		Positions: []StatementPosition{{TickerID: 1}, {TickerID: 3}},
+		Trades:    []StatementTrade{{TickerID: 2}},
+		Dividends: []StatementDividend{{TickerID: 1}},
+	}
+
+	currencies := statementCurrencies(statement, tickers)
+	if len(currencies) != 2 || currencies[0] != "EUR" || currencies[1] != "USD" {
+		t.Fatalf("monedas %v, se esperaba [EUR USD]", currencies)
+	}
+	if statement.Positions[0].Currency != "EUR" || statement.Trades[0].Currency != "USD" || statement.Dividends[0].Currency != "EUR" {
+		t.Errorf("no se asignó la moneda de cada fila: %+v %+v %+v", statement.Positions, statement.Trades, statement.Dividends)
+	}
+
+	var buf bytes.Buffer
+	period, _ := newStatementPeriod(StatementMonth, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	statement.Period = period
+	if err := writeStatementPDF(&buf, statement); err != nil {
+		t.Fatalf("writeStatementPDF: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+		t.Error("la salida no es un PDF")
+	}
+}
@@ -0,0 +1,45 @@
+/**
+ * Per-record change history
+ * Loads /api/audit/:entity/:id and renders it inside the edit screens
+ */
+function escapeAuditText(value) {
+    const div = document.createElement('div');
+    div.textContent = value;
+    return div.innerHTML;
+}
+
+async function loadAuditHistory(containerId, entity, id) {
+    const container = document.getElementById(containerId);
+    if (!container) {
+        return;
+    }
+    container.innerHTML = '<p class="text-sm text-gray-500 dark:text-gray-400">Cargando historial...</p>';
+
+    try {
+        const response = await fetch(`/api/audit/${entity}/${id}`);
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        const entries = await response.json();
+        if (!entries || entries.length === 0) {
+            container.innerHTML = '<p class="text-sm text-gray-500 dark:text-gray-400">Sin cambios registrados.</p>';
+            return;
+        }
+
+        container.innerHTML = entries.map(entry => {
+            const changes = (entry.changes || []).map(change => {
+                const before = change.before ? `<span class="line-through text-red-600 dark:text-red-400">${escapeAuditText(change.before)}</span> → ` : '';
+                return `<div><span class="font-medium">${escapeAuditText(change.field)}</span>: ${before}<span class="text-green-600 dark:text-green-400">${escapeAuditText(change.after)}</span></div>`;
+            }).join('');
+            return `<li class="py-2 border-b border-gray-200 dark:border-gray-600">
+                <div class="text-xs text-gray-500 dark:text-gray-400">${escapeAuditText(entry.created_at)} · ${escapeAuditText(entry.action_label)} · ${escapeAuditText(entry.actor)}</div>
+                <div class="text-xs text-gray-700 dark:text-gray-300">${changes}</div>
+            </li>`;
+        }).join('');
+        container.innerHTML = `<ul>${container.innerHTML}</ul>
+            <a href="/auditoria?entity=${entity}&entity_id=${id}" class="text-xs text-blue-600 dark:text-blue-400 hover:underline">Ver en Auditoría</a>`;
+    } catch (error) {
+        console.error('Error:', error);
+        container.innerHTML = '<p class="text-sm text-red-600 dark:text-red-400">Error al cargar el historial.</p>';
+    }
+}
@@ -0,0 +1,61 @@
+/**
+ * Live updates
+ * Connects to /api/events (Server-Sent Events) and dispatches the price,
+ * positions and snapshot events to the handlers registered by each page.
+ * EventSource reconnects by itself if the connection drops.
+ */
+const liveUpdates = (function () {
+    const handlers = {};
+    let source = null;
+
+    function connect() {
+        if (source || !window.EventSource) {
+            return;
+        }
+        source = new EventSource('/api/events');
+        ['price', 'positions', 'snapshot'].forEach(type => {
+            source.addEventListener(type, event => {
+                const data = JSON.parse(event.data);
+                (handlers[type] || []).forEach(handler => handler(data));
+            });
+        });
+        source.onerror = () => console.warn('Conexión de eventos en directo perdida, reintentando...');
+    }
+
+    // Register a handler for an event type and open the connection
+    function on(type, handler) {
+        (handlers[type] = handlers[type] || []).push(handler);
+        connect();
+    }
+
+    // Briefly highlight an element whose value changed
+    function flash(el) {
+        el.classList.add('bg-yellow-100', 'dark:bg-yellow-900');
+        setTimeout(() => el.classList.remove('bg-yellow-100', 'dark:bg-yellow-900'), 1500);
+    }
+
+    // Set a value with its sign (+ when >= 0) and green/red color
+    function setSigned(el, value, decimals, suffix) {
+        if (!el) {
+            return;
+        }
+        const positive = value >= 0;
+        el.textContent = (positive ? '+' : '') + value.toFixed(decimals) + suffix;
+        el.classList.toggle('text-green-600', positive);
+        el.classList.toggle('dark:text-green-400', positive);
+        el.classList.toggle('text-red-600', !positive);
+        el.classList.toggle('dark:text-red-400', !positive);
+        flash(el);
+    }
+
+    // Set a plain value
+    function setText(el, text) {
+        if (!el || el.textContent === text) {
+            return;
+        }
+        el.textContent = text;
+        flash(el);
+    }
+
+    return { on, flash, setSigned, setText };
+})();
@@ -50,19 +50,30 @@ function submitEditForm() {
     fetch(`/api/sale/${saleId}`, {
         method: 'PUT',
         headers: {
-            'Content-Type': 'application/json'
+            'Content-Type': 'application/json',
+            'If-Match': `"${document.getElementById('edit_version').value}"`
         },
         body: JSON.stringify(data)
     })
-        .then(response => response.json())
-        .then(result => {
+        .then(response => response.json().then(result => ({ response, result })))
+        .then(({ response, result }) => {
+            if (response.status === 412) {
+                // Another user changed the sale: reload to show the current values
+                alert('Otra persona modificó esta venta mientras la editabas. Se recargará la página con los valores actuales.');
+                window.location.reload();
+                return;
+            }
             if (result.error) {
                 alert('Error: ' + result.error);
                 return;
             }
 
-            // Update the table row
+            // Update the table row and its version
             updateTableRow(result);
+            const row = document.querySelector(`tr[data-id="${result.id}"]`);
+            if (row) {
+                row.dataset.version = (response.headers.get('ETag') || '').replace(/"/g, '');
+            }
 
             // Close the modal
             closeEditModal();
@@ -158,6 +169,8 @@ function openEditModal(id, ticker, tickerId, saleDate, shares, salePrice, operat
 
     // Populate form fields
     document.getElementById('edit_sale_id').value = id;
+    const row = document.querySelector(`tr[data-id="${id}"]`);
+    document.getElementById('edit_version').value = row ? row.dataset.version : '';
     document.getElementById('edit_ticker_id').value = tickerId;
 
     // Convert date format from "02 Jan 2006 15:04" to "YYYY-MM-DDTHH:MM" for datetime-local input
@@ -186,6 +199,7 @@ function openEditModal(id, ticker, tickerId, saleDate, shares, salePrice, operat
     document.getElementById('edit_sale_price').value = salePrice;
     document.getElementById('edit_operation_cost').value = operationCost;
     document.getElementById('edit_withheld_tax').value = withheldTax;
+    loadAuditHistory('edit-sale-history', 'sale', id);
 
     // Show modal
     const modal = document.getElementById('editSaleModal');
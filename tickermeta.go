@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// assetClassLabels contiene las clases de activo válidas y su nombre para la UI.
+var assetClassLabels = map[string]string{
+	"stock":  "Acción",
+	"etf":    "ETF",
+	"fund":   "Fondo",
+	"bond":   "Bono",
+	"crypto": "Cripto",
+	"other":  "Otro",
+}
+
+var (
+	isinPattern     = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{9}[0-9]$`)
+	micPattern      = regexp.MustCompile(`^[A-Z0-9]{4}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// TickerMetadata agrupa los datos de clasificación editables de un ticker.
+type TickerMetadata struct {
+	ISIN               string
+	ExchangeMIC        string
+	Currency           string
+	Sector             string
+	Industry           string
+	Country            string
+	AssetClass         string
+	YahooFinanceTicker string
+}
+
+// Metadata devuelve los datos de clasificación del ticker.
+func (t Ticker) Metadata() TickerMetadata {
+	return TickerMetadata{
+		ISIN:               t.ISIN,
+		ExchangeMIC:        t.ExchangeMIC,
+		Currency:           t.Currency,
+		Sector:             t.Sector,
+		Industry:           t.Industry,
+		Country:            t.Country,
+		AssetClass:         t.AssetClass,
+		YahooFinanceTicker: t.YahooFinanceTicker,
+	}
+}
+
+// updates devuelve el mapa de columnas a actualizar con GORM.
+func (m TickerMetadata) updates() map[string]interface{} {
+	return map[string]interface{}{
+		"isin":                 m.ISIN,
+		"exchange_mic":         m.ExchangeMIC,
+		"currency":             m.Currency,
+		"sector":               m.Sector,
+		"industry":             m.Industry,
+		"country":              m.Country,
+		"asset_class":          m.AssetClass,
+		"yahoo_finance_ticker": m.YahooFinanceTicker,
+	}
+}
+
+// parseTickerMetadataForm lee y normaliza los campos de metadatos del formulario.
+// Devuelve false si el formulario no incluye metadatos.
+func parseTickerMetadataForm(c *gin.Context) (TickerMetadata, bool) {
+	if _, ok := c.GetPostForm("isin"); !ok {
+		return TickerMetadata{}, false
+	}
+
+	upper := func(field string) string {
+		return strings.ToUpper(strings.TrimSpace(c.PostForm(field)))
+	}
+	return TickerMetadata{
+		ISIN:               strings.ReplaceAll(upper("isin"), " ", ""),
+		ExchangeMIC:        upper("exchange_mic"),
+		Currency:           upper("currency"),
+		Sector:             strings.TrimSpace(c.PostForm("sector")),
+		Industry:           strings.TrimSpace(c.PostForm("industry")),
+		Country:            upper("country"),
+		AssetClass:         strings.ToLower(strings.TrimSpace(c.PostForm("asset_class"))),
+		YahooFinanceTicker: upper("yahoo_finance_ticker"),
+	}, true
+}
+
+// Validate comprueba el formato de los campos informados. Los campos vacíos son válidos.
+func (m TickerMetadata) Validate() error {
+	if m.ISIN != "" && !validISIN(m.ISIN) {
+		return fmt.Errorf("el ISIN %s no es válido", m.ISIN)
+	}
+	if m.ExchangeMIC != "" && !micPattern.MatchString(m.ExchangeMIC) {
+		return fmt.Errorf("el código MIC %s debe tener 4 caracteres alfanuméricos", m.ExchangeMIC)
+	}
+	if m.Currency != "" && !currencyPattern.MatchString(m.Currency) {
+		return fmt.Errorf("la moneda %s debe ser un código ISO 4217 de 3 letras", m.Currency)
+	}
+	if m.Country != "" && !countryPattern.MatchString(m.Country) {
+		return fmt.Errorf("el país %s debe ser un código ISO 3166 de 2 letras", m.Country)
+	}
+	if m.AssetClass != "" {
+		if _, ok := assetClassLabels[m.AssetClass]; !ok {
+			return fmt.Errorf("la clase de activo %s no es válida", m.AssetClass)
+		}
+	}
+	return nil
+}
+
+// validISIN verifica el formato y el dígito de control (Luhn) de un ISIN.
+func validISIN(isin string) bool {
+	if !isinPattern.MatchString(isin) {
+		return false
+	}
+
+	// Convertir letras a números (A=10 ... Z=35) y aplicar Luhn sobre los dígitos resultantes
+	var digits []int
+	for _, r := range isin {
+		if r >= 'A' && r <= 'Z' {
+			v := int(r-'A') + 10
+			digits = append(digits, v/10, v%10)
+		} else {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// trashError es un error de validación de la papelera que se muestra tal cual al usuario.
+type trashError string
+
+func (e trashError) Error() string { return string(e) }
+
+// TrashInvestmentView representa una compra eliminada.
+type TrashInvestmentView struct {
+	ID            uint
+	Ticker        string
+	TickerDeleted bool // El ticker también está en la papelera
+	PurchaseDate  string
+	Shares        decimal.Decimal
+	PurchasePrice decimal.Decimal
+	DeletedAt     string
+}
+
+// TrashSaleView representa una venta eliminada.
+type TrashSaleView struct {
+	ID            uint
+	Ticker        string
+	TickerDeleted bool
+	SaleDate      string
+	Shares        decimal.Decimal
+	SalePrice     decimal.Decimal
+	DeletedAt     string
+}
+
+// TrashTickerView representa un ticker eliminado.
+type TrashTickerView struct {
+	ID           uint
+	Name         string
+	CurrentPrice decimal.Decimal
+	DeletedAt    string
+}
+
+// TrashSnapshotView representa un snapshot eliminado.
+type TrashSnapshotView struct {
+	SnapshotID string
+	Prices     int
+	DeletedAt  string
+}
+
+// registerTrashRoutes registra la papelera y sus acciones de restaurar y purgar.
+func registerTrashRoutes(router *gin.Engine) {
+	// Ruta para mostrar los registros eliminados
+	router.GET("/papelera", func(c *gin.Context) {
+		data, err := getTrashData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener la papelera: %v", err)
+			return
+		}
+		data["ActivePage"] = "papelera"
+		c.HTML(http.StatusOK, "papelera.html", data)
+	})
+
+	// Ruta para recuperar un registro de la papelera
+	router.POST("/papelera/:entity/:id/restore", func(c *gin.Context) {
+		entity, id := c.Param("entity"), c.Param("id")
+		tickerIDs, err := restoreFromTrash(entity, id, auditActor(c))
+		if err != nil {
+			respondTrashError(c, err)
+			return
+		}
+		log.Printf("Restaurado desde la papelera: %s %s", entity, id)
+
+		// Las posiciones y el último snapshot cambian: se reevalúan las alertas
+		goBackground(func() { evaluateAlerts("trash", tickerIDs...) })
+		goBackground(func() { publishPriceChanges("trash", tickerIDs...) })
+
+		c.Redirect(http.StatusFound, "/papelera")
+	})
+
+	// Ruta para eliminar definitivamente un registro de la papelera
+	router.POST("/papelera/:entity/:id/purge", func(c *gin.Context) {
+		entity, id := c.Param("entity"), c.Param("id")
+		if err := purgeFromTrash(entity, id, auditActor(c)); err != nil {
+			respondTrashError(c, err)
+			return
+		}
+		log.Printf("Eliminado definitivamente: %s %s", entity, id)
+		c.Redirect(http.StatusFound, "/papelera")
+	})
+}
+
+// respondTrashError traduce los errores de la papelera a respuestas HTTP.
+func respondTrashError(c *gin.Context, err error) {
+	var te trashError
+	switch {
+	case errors.As(err, &te):
+		c.String(http.StatusBadRequest, te.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.String(http.StatusNotFound, "El registro no está en la papelera.")
+	default:
+		log.Printf("Error en la papelera: %v", err)
+		c.String(http.StatusInternalServerError, "Error al procesar la papelera.")
+	}
+}
+
+// getTrashData obtiene las compras, ventas, tickers y snapshots eliminados,
+// del borrado más reciente al más antiguo.
+func getTrashData() (gin.H, error) {
+	// Los tickers eliminados también se cargan para mostrar su nombre
+	withDeletedTicker := func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }
+
+	var investments []Investment
+	if err := db.Unscoped().Preload("Ticker", withDeletedTicker).Where("deleted_at IS NOT NULL").Order("deleted_at desc").Find(&investments).Error; err != nil {
+		return nil, err
+	}
+	investmentViews := make([]TrashInvestmentView, 0, len(investments))
+	for _, inv := range investments {
+		investmentViews = append(investmentViews, TrashInvestmentView{
+			ID:            inv.ID,
+			Ticker:        inv.Ticker.Name,
+			TickerDeleted: inv.Ticker.DeletedAt.Valid,
+			PurchaseDate:  inv.PurchaseDate.Format("02 Jan 2006"),
+			Shares:        inv.Shares,
+			PurchasePrice: inv.PurchasePrice,
+			DeletedAt:     inv.DeletedAt.Time.Format("02 Jan 2006 15:04"),
+		})
+	}
+
+	var sales []Sale
+	if err := db.Unscoped().Preload("Ticker", withDeletedTicker).Where("deleted_at IS NOT NULL").Order("deleted_at desc").Find(&sales).Error; err != nil {
+		return nil, err
+	}
+	saleViews := make([]TrashSaleView, 0, len(sales))
+	for _, s := range sales {
+		saleViews = append(saleViews, TrashSaleView{
+			ID:            s.ID,
+			Ticker:        s.Ticker.Name,
+			TickerDeleted: s.Ticker.DeletedAt.Valid,
+			SaleDate:      s.SaleDate.Format("02 Jan 2006"),
+			Shares:        s.Shares,
+			SalePrice:     s.SalePrice,
+			DeletedAt:     s.DeletedAt.Time.Format("02 Jan 2006 15:04"),
+		})
+	}
+
+	var tickers []Ticker
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at desc").Find(&tickers).Error; err != nil {
+		return nil, err
+	}
+	tickerViews := make([]TrashTickerView, 0, len(tickers))
+	for _, t := range tickers {
+		tickerViews = append(tickerViews, TrashTickerView{
+			ID:           t.ID,
+			Name:         t.Name,
+			CurrentPrice: t.CurrentPrice,
+			DeletedAt:    t.DeletedAt.Time.Format("02 Jan 2006 15:04"),
+		})
+	}
+
+	// Los snapshots se agrupan por snapshot_id
+	var priceHistories []PriceHistory
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").Find(&priceHistories).Error; err != nil {
+		return nil, err
+	}
+	snapshots := make(map[string]*TrashSnapshotView)
+	deletedAt := make(map[string]time.Time)
+	for _, ph := range priceHistories {
+		view, ok := snapshots[ph.SnapshotID]
+		if !ok {
+			view = &TrashSnapshotView{SnapshotID: ph.SnapshotID}
+			snapshots[ph.SnapshotID] = view
+		}
+		view.Prices++
+		if ph.DeletedAt.Time.After(deletedAt[ph.SnapshotID]) {
+			deletedAt[ph.SnapshotID] = ph.DeletedAt.Time
+		}
+	}
+	snapshotViews := make([]TrashSnapshotView, 0, len(snapshots))
+	for id, view := range snapshots {
+		view.DeletedAt = deletedAt[id].Format("02 Jan 2006 15:04")
+		snapshotViews = append(snapshotViews, *view)
+	}
+	sort.Slice(snapshotViews, func(i, j int) bool {
+		return deletedAt[snapshotViews[i].SnapshotID].After(deletedAt[snapshotViews[j].SnapshotID])
+	})
+
+	return gin.H{
+		"Investments": investmentViews,
+		"Sales":       saleViews,
+		"Tickers":     tickerViews,
+		"Snapshots":   snapshotViews,
+		"Empty":       len(investmentViews)+len(saleViews)+len(tickerViews)+len(snapshotViews) == 0,
+	}, nil
+}
+
+// parseTrashID convierte el ID de la ruta en el de un registro.
+func parseTrashID(idStr string) (uint, error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		return 0, trashError("ID inválido.")
+	}
+	return uint(id), nil
+}
+
+// restoreFromTrash recupera un registro eliminado y devuelve los tickers
+// afectados para recalcular lo que depende de ellos.
+func restoreFromTrash(entity, idStr, actor string) ([]uint, error) {
+	if entity == "snapshot" {
+		return restoreSnapshot(idStr)
+	}
+
+	id, err := parseTrashID(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var tickerID uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		switch entity {
+		case AuditTicker:
+			tickerID = id
+			return restoreRecord(tx, actor, AuditTicker, id, &Ticker{})
+		case AuditInvestment:
+			var inv Investment
+			if err := findDeleted(tx, &inv, id); err != nil {
+				return err
+			}
+			tickerID = inv.TickerID
+			if err := requireActiveTicker(tx, inv.TickerID); err != nil {
+				return err
+			}
+			return restoreRecord(tx, actor, AuditInvestment, id, &Investment{})
+		case AuditSale:
+			var s Sale
+			if err := findDeleted(tx, &s, id); err != nil {
+				return err
+			}
+			tickerID = s.TickerID
+			if err := requireActiveTicker(tx, s.TickerID); err != nil {
+				return err
+			}
+			if err := restoreRecord(tx, actor, AuditSale, id, &Sale{}); err != nil {
+				return err
+			}
+			// Una compra borrada después de la venta puede haber dejado la venta sin acciones
+			oversold, err := findOversell(tx, s.TickerID)
+			if err != nil {
+				return err
+			}
+			if oversold != nil {
+				return trashError(fmt.Sprintf("No se puede restaurar la venta: la venta del %s dejaría la posición con acciones negativas. Restaura antes las compras correspondientes.", oversold.SaleDate.Format("02 Jan 2006")))
+			}
+			return nil
+		default:
+			return trashError("Tipo de registro inválido.")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []uint{tickerID}, nil
+}
+
+// purgeFromTrash elimina definitivamente un registro que está en la papelera.
+func purgeFromTrash(entity, idStr, actor string) error {
+	if entity == "snapshot" {
+		result := db.Unscoped().Where("snapshot_id = ? AND deleted_at IS NOT NULL", idStr).Delete(&PriceHistory{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	}
+
+	id, err := parseTrashID(idStr)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		switch entity {
+		case AuditTicker:
+			var ticker Ticker
+			if err := findDeleted(tx, &ticker, id); err != nil {
+				return err
+			}
+			if err := purgeTickerDependents(tx, id); err != nil {
+				return err
+			}
+			return purgeRecord(tx, actor, AuditTicker, id, &ticker)
+		case AuditInvestment:
+			var inv Investment
+			if err := findDeleted(tx, &inv, id); err != nil {
+				return err
+			}
+			return purgeRecord(tx, actor, AuditInvestment, id, &inv)
+		case AuditSale:
+			var s Sale
+			if err := findDeleted(tx, &s, id); err != nil {
+				return err
+			}
+			return purgeRecord(tx, actor, AuditSale, id, &s)
+		default:
+			return trashError("Tipo de registro inválido.")
+		}
+	})
+}
+
+// findDeleted carga un registro solo si está en la papelera.
+func findDeleted(tx *gorm.DB, record interface{}, id uint) error {
+	return tx.Unscoped().Where("deleted_at IS NOT NULL").First(record, id).Error
+}
+
+// requireActiveTicker comprueba que el ticker de una operación no esté eliminado.
+func requireActiveTicker(tx *gorm.DB, tickerID uint) error {
+	var ticker Ticker
+	if err := tx.First(&ticker, tickerID).Error; err == nil {
+		return nil
+	}
+	if err := tx.Unscoped().First(&ticker, tickerID).Error; err != nil {
+		return trashError("El ticker de la operación ya no existe.")
+	}
+	return trashError(fmt.Sprintf("Restaura primero el ticker %s.", ticker.Name))
+}
+
+// restoreRecord quita la marca de borrado de un registro y lo audita.
+// record debe ser un puntero vacío del modelo.
+func restoreRecord(tx *gorm.DB, actor, entity string, id uint, record interface{}) error {
+	if err := findDeleted(tx, record, id); err != nil {
+		return err
+	}
+	before, err := auditState(record)
+	if err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(record).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.First(record, id).Error; err != nil {
+		return err
+	}
+	return recordAudit(tx, actor, entity, id, AuditRestore, before, record)
+}
+
+// purgeRecord borra físicamente un registro de la papelera y audita su último estado.
+func purgeRecord(tx *gorm.DB, actor, entity string, id uint, record interface{}) error {
+	if err := recordAudit(tx, actor, entity, id, AuditPurge, record, nil); err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(record).Error
+}
+
+// purgeTickerDependents borra los datos auxiliares de un ticker antes de
+// purgarlo. Las operaciones y dividendos, aunque estén en la papelera, lo impiden.
+func purgeTickerDependents(tx *gorm.DB, tickerID uint) error {
+	for _, model := range []interface{}{&Investment{}, &Sale{}, &Dividend{}} {
+		var count int64
+		if err := tx.Unscoped().Model(model).Where("ticker_id = ?", tickerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return trashError("No se puede eliminar definitivamente el ticker porque tiene compras, ventas o dividendos, aunque estén en la papelera.")
+		}
+	}
+
+	for _, model := range []interface{}{&AlertEvent{}, &Alert{}, &WatchlistItem{}, &PriceHistory{}} {
+		if err := tx.Unscoped().Where("ticker_id = ?", tickerID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("etf_ticker_id = ?", tickerID).Delete(&ETFConstituent{}).Error
+}
+
+// restoreSnapshot recupera todos los precios de un snapshot eliminado.
+func restoreSnapshot(snapshotID string) ([]uint, error) {
+	var priceHistories []PriceHistory
+	if err := db.Unscoped().Where("snapshot_id = ? AND deleted_at IS NOT NULL", snapshotID).Find(&priceHistories).Error; err != nil {
+		return nil, err
+	}
+	if len(priceHistories) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err := db.Unscoped().Model(&PriceHistory{}).Where("snapshot_id = ? AND deleted_at IS NOT NULL", snapshotID).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+
+	tickerIDs := make([]uint, 0, len(priceHistories))
+	for _, ph := range priceHistories {
+		tickerIDs = append(tickerIDs, ph.TickerID)
+	}
+	return tickerIDs, nil
+}
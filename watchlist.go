@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// watchlistTrendSnapshots es el número de snapshots usados para la tendencia.
+const watchlistTrendSnapshots = 5
+
+// WatchlistItem representa un ticker en seguimiento con su precio objetivo de entrada.
+type WatchlistItem struct {
+	gorm.Model
+	TickerID    uint            `gorm:"uniqueIndex"`
+	Ticker      Ticker          `gorm:"foreignKey:TickerID"`
+	TargetPrice decimal.Decimal `gorm:"type:numeric"`
+	Notes       string
+}
+
+// WatchlistView representa un ticker en seguimiento para mostrar en la UI.
+type WatchlistView struct {
+	ID             uint
+	TickerID       uint
+	Ticker         string
+	CurrentPrice   decimal.Decimal
+	TargetPrice    decimal.Decimal
+	Notes          string
+	TargetDistance float64           // Distancia porcentual del precio actual al objetivo
+	AtTarget       bool              // El precio actual está en o por debajo del objetivo
+	SharesHeld     decimal.Decimal   // Acciones en cartera, si las hay
+	Trend          []decimal.Decimal // Precios de los últimos snapshots, del más antiguo al más reciente
+	TrendChange    float64           // Cambio porcentual entre el primer y el último snapshot de la tendencia
+	HasTrend       bool
+}
+
+// registerWatchlistRoutes registra las rutas de la lista de seguimiento.
+func registerWatchlistRoutes(router *gin.Engine) {
+	// Ruta para mostrar la lista de seguimiento
+	router.GET("/seguimiento", func(c *gin.Context) {
+		var items []WatchlistItem
+		db.Preload("Ticker").Find(&items)
+
+		positions, err := replayPositions(time.Now())
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+
+		watched := make(map[uint]bool)
+		var views []WatchlistView
+		for _, item := range items {
+			watched[item.TickerID] = true
+			views = append(views, buildWatchlistView(item, positions[item.TickerID]))
+		}
+
+		// Tickers disponibles para añadir (los que aún no están en seguimiento)
+		var tickers []Ticker
+		db.Order("name").Find(&tickers)
+		var available []TickerView
+		for _, t := range tickers {
+			if !watched[t.ID] {
+				available = append(available, TickerView{ID: t.ID, Name: t.Name, CurrentPrice: t.CurrentPrice})
+			}
+		}
+
+		c.HTML(http.StatusOK, "seguimiento.html", gin.H{
+			"Items":      views,
+			"Tickers":    available,
+			"ActivePage": "seguimiento",
+		})
+	})
+
+	// Ruta para añadir un ticker a la lista de seguimiento
+	router.POST("/add-watchlist", func(c *gin.Context) {
+		tickerID, err := strconv.Atoi(c.PostForm("ticker_id"))
+		if err != nil || tickerID <= 0 {
+			c.String(http.StatusBadRequest, "Debe seleccionar un ticker válido.")
+			return
+		}
+
+		targetPrice, err := parseDecimal(c.PostForm("target_price"))
+		if err != nil {
+			targetPrice = decimal.Zero
+		}
+
+		var ticker Ticker
+		if err := db.First(&ticker, tickerID).Error; err != nil {
+			c.String(http.StatusBadRequest, "El ticker seleccionado no existe.")
+			return
+		}
+
+		var existing WatchlistItem
+		if db.Where("ticker_id = ?", tickerID).First(&existing).Error == nil {
+			c.String(http.StatusBadRequest, "El ticker ya está en seguimiento.")
+			return
+		}
+
+		item := WatchlistItem{
+			TickerID:    uint(tickerID),
+			TargetPrice: roundPrice(targetPrice),
+			Notes:       strings.TrimSpace(c.PostForm("notes")),
+		}
+		db.Create(&item)
+
+		log.Printf("Ticker %s añadido a seguimiento", ticker.Name)
+		c.Redirect(http.StatusFound, "/seguimiento")
+	})
+
+	// Ruta para actualizar el precio objetivo y las notas
+	router.POST("/update-watchlist/:id", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		var item WatchlistItem
+		if err := db.First(&item, id).Error; err != nil {
+			c.String(http.StatusNotFound, "Elemento no encontrado.")
+			return
+		}
+
+		targetPrice, err := parseDecimal(c.PostForm("target_price"))
+		if err != nil {
+			targetPrice = item.TargetPrice
+		}
+
+		db.Model(&item).Updates(map[string]interface{}{
+			"target_price": roundPrice(targetPrice),
+			"notes":        strings.TrimSpace(c.PostForm("notes")),
+		})
+
+		log.Printf("Seguimiento %d actualizado", id)
+		c.Redirect(http.StatusFound, "/seguimiento")
+	})
+
+	// Ruta para quitar un ticker de la lista de seguimiento
+	router.POST("/delete-watchlist", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		// Borrado definitivo para poder volver a añadir el mismo ticker
+		db.Unscoped().Delete(&WatchlistItem{}, id)
+		log.Printf("Seguimiento %d eliminado", id)
+		c.Redirect(http.StatusFound, "/seguimiento")
+	})
+}
+
+// buildWatchlistView calcula la distancia al objetivo y la tendencia de snapshots.
+func buildWatchlistView(item WatchlistItem, position PositionState) WatchlistView {
+	view := WatchlistView{
+		ID:           item.ID,
+		TickerID:     item.TickerID,
+		Ticker:       item.Ticker.Name,
+		CurrentPrice: item.Ticker.CurrentPrice,
+		TargetPrice:  item.TargetPrice,
+		Notes:        item.Notes,
+		SharesHeld:   position.Shares,
+	}
+
+	// Distancia = cuánto tiene que bajar (positivo) o ya bajó (negativo) el precio para llegar al objetivo
+	if item.TargetPrice.IsPositive() && item.Ticker.CurrentPrice.IsPositive() {
+		view.TargetDistance = item.Ticker.CurrentPrice.Sub(item.TargetPrice).Div(item.Ticker.CurrentPrice).Mul(decimal.NewFromInt(100)).InexactFloat64()
+		view.AtTarget = item.Ticker.CurrentPrice.LessThanOrEqual(item.TargetPrice)
+	}
+
+	var histories []PriceHistory
+	db.Where("ticker_id = ?", item.TickerID).Order("created_at desc").Limit(watchlistTrendSnapshots).Find(&histories)
+	for i := len(histories) - 1; i >= 0; i-- {
+		view.Trend = append(view.Trend, histories[i].Price)
+	}
+	if len(view.Trend) >= 2 && view.Trend[0].IsPositive() {
+		first := view.Trend[0]
+		last := view.Trend[len(view.Trend)-1]
+		view.TrendChange = percentChange(first, last)
+		view.HasTrend = true
+	}
+
+	return view
+}
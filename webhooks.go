@@ -0,0 +1,621 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Eventos que se pueden suscribir
+const (
+	WebhookInvestmentCreated  = "investment.created"
+	WebhookInvestmentUpdated  = "investment.updated"
+	WebhookInvestmentDeleted  = "investment.deleted"
+	WebhookSaleCreated        = "sale.created"
+	WebhookSaleUpdated        = "sale.updated"
+	WebhookSaleDeleted        = "sale.deleted"
+	WebhookTickerPriceChanged = "ticker.price_changed"
+	WebhookSnapshotCreated    = "snapshot.created"
+	WebhookTest               = "webhook.test" // Envío de prueba desde la UI
+	webhookAllEvents          = "*"
+)
+
+// Estados de una entrega
+const (
+	WebhookPending   = "pending"   // Pendiente del primer intento o de un reintento
+	WebhookDelivered = "delivered" // El destino respondió 2xx
+	WebhookFailed    = "failed"    // Se agotaron los intentos
+)
+
+// Cabeceras de las peticiones de webhook
+const (
+	webhookEventHeader     = "X-Bolsa-Event"
+	webhookDeliveryHeader  = "X-Bolsa-Delivery"
+	webhookTimestampHeader = "X-Bolsa-Timestamp"
+	webhookSignatureHeader = "X-Bolsa-Signature"
+)
+
+// Reintentos y envío
+const (
+	webhookMaxAttempts     = 5
+	webhookRetryBase       = 10 * time.Second // Espera tras el primer fallo; se duplica en cada intento
+	webhookPollInterval    = 2 * time.Second
+	webhookBatchSize       = 50
+	webhookResponseMaxSize = 1024             // Bytes de la respuesta que se guardan en el registro
+	webhookClaimTimeout    = 30 * time.Second // Reserva de una entrega mientras se intenta
+)
+
+// webhookEvents enumera los eventos en el orden de la UI.
+var webhookEvents = []struct {
+	Key   string
+	Label string
+}{
+	{WebhookInvestmentCreated, "Compra registrada"},
+	{WebhookInvestmentUpdated, "Compra modificada"},
+	{WebhookInvestmentDeleted, "Compra eliminada"},
+	{WebhookSaleCreated, "Venta registrada"},
+	{WebhookSaleUpdated, "Venta modificada"},
+	{WebhookSaleDeleted, "Venta eliminada"},
+	{WebhookTickerPriceChanged, "Cambio de precio"},
+	{WebhookSnapshotCreated, "Nuevo snapshot"},
+}
+
+// WebhookSubscription es un destino que recibe los eventos indicados.
+type WebhookSubscription struct {
+	gorm.Model
+	Name   string
+	URL    string
+	Events string // Eventos separados por comas o * para todos
+	Secret string // Clave de la firma HMAC-SHA256
+	Active bool   `gorm:"default:true"`
+}
+
+// Subscribes indica si la suscripción recibe el evento.
+func (s WebhookSubscription) Subscribes(event string) bool {
+	for _, e := range strings.Split(s.Events, ",") {
+		if e == webhookAllEvents || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EventList devuelve los eventos suscritos para la UI.
+func (s WebhookSubscription) EventList() []string {
+	return strings.Split(s.Events, ",")
+}
+
+// WebhookDelivery es el envío de un evento a una suscripción. Se crea en la
+// misma transacción que el cambio que lo origina y un proceso en segundo plano
+// lo entrega, con reintentos, una vez confirmada.
+type WebhookDelivery struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	SubscriptionID uint                `gorm:"index"`
+	Subscription   WebhookSubscription `gorm:"foreignKey:SubscriptionID"`
+	EventID        string              `gorm:"size:64;index"` // Mismo ID para todas las suscripciones de un evento
+	Event          string
+	Payload        string
+	Status         string    `gorm:"index:idx_webhook_deliveries_due"`
+	NextAttemptAt  time.Time `gorm:"index:idx_webhook_deliveries_due"`
+	Attempts       int
+	ResponseStatus int
+	ResponseBody   string
+	Error          string
+	DeliveredAt    *time.Time
+}
+
+// WebhookPayload es el cuerpo JSON que se envía a los destinos.
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Actor     string      `json:"actor,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookClient envía los webhooks con un tiempo máximo por intento.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookKick despierta al proceso de envío cuando hay entregas nuevas.
+var webhookKick = make(chan struct{}, 1)
+
+// newWebhookToken genera un identificador aleatorio con el prefijo indicado.
+func newWebhookToken(prefix string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error al generar el identificador del webhook: %v", err)
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// signWebhook firma el cuerpo con HMAC-SHA256 sobre "<timestamp>.<cuerpo>".
+// El destino repite el cálculo con la misma clave para comprobar el origen y
+// que el cuerpo no se ha modificado.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff devuelve la espera antes del siguiente intento: 10s, 20s, 40s...
+func webhookBackoff(attempts int) time.Duration {
+	return webhookRetryBase << (attempts - 1)
+}
+
+// enqueueWebhookEvent crea una entrega del evento para cada suscripción activa
+// que lo recibe. Se llama con la transacción del cambio, de modo que si esta se
+// deshace no se envía nada.
+func enqueueWebhookEvent(tx *gorm.DB, event, actor string, data func() (interface{}, error)) error {
+	var subscriptions []WebhookSubscription
+	if err := tx.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	var targets []WebhookSubscription
+	for _, s := range subscriptions {
+		if s.Subscribes(event) {
+			targets = append(targets, s)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	_, err := enqueueWebhookDeliveries(tx, targets, event, actor, data)
+	return err
+}
+
+// enqueueWebhookDeliveries crea y devuelve las entregas de un evento para las
+// suscripciones indicadas.
+func enqueueWebhookDeliveries(tx *gorm.DB, targets []WebhookSubscription, event, actor string, data func() (interface{}, error)) ([]WebhookDelivery, error) {
+	content, err := data()
+	if err != nil {
+		return nil, err
+	}
+	payload := WebhookPayload{
+		ID:        newWebhookToken("evt_"),
+		Event:     event,
+		CreatedAt: time.Now(),
+		Actor:     actor,
+		Data:      content,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(targets))
+	for _, s := range targets {
+		deliveries = append(deliveries, WebhookDelivery{
+			SubscriptionID: s.ID,
+			EventID:        payload.ID,
+			Event:          event,
+			Payload:        string(body),
+			Status:         WebhookPending,
+			NextAttemptAt:  payload.CreatedAt,
+		})
+	}
+	if err := tx.Create(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	kickWebhookWorker()
+	return deliveries, nil
+}
+
+// kickWebhookWorker avisa al proceso de envío sin bloquear.
+func kickWebhookWorker() {
+	select {
+	case webhookKick <- struct{}{}:
+	default:
+	}
+}
+
+// auditWebhookEvent traduce una entrada de auditoría al evento de webhook
+// correspondiente. Devuelve "" si el cambio no genera ningún evento.
+func auditWebhookEvent(entry AuditLog) string {
+	switch entry.EntityType {
+	case AuditInvestment, AuditSale:
+		switch entry.Action {
+		case AuditCreate, AuditRestore:
+			return entry.EntityType + ".created"
+		case AuditUpdate:
+			return entry.EntityType + ".updated"
+		case AuditDelete:
+			return entry.EntityType + ".deleted"
+		}
+	case AuditTicker:
+		if entry.Action != AuditUpdate {
+			return ""
+		}
+		before, _ := auditFields(entry.Before)
+		after, _ := auditFields(entry.After)
+		if auditValue(before["CurrentPrice"]) != auditValue(after["CurrentPrice"]) {
+			return WebhookTickerPriceChanged
+		}
+	}
+	return ""
+}
+
+// enqueueAuditWebhooks encola los webhooks de un cambio auditado dentro de su transacción.
+func enqueueAuditWebhooks(tx *gorm.DB, entry AuditLog) error {
+	event := auditWebhookEvent(entry)
+	if event == "" {
+		return nil
+	}
+	return enqueueWebhookEvent(tx, event, entry.Actor, func() (interface{}, error) {
+		return auditWebhookData(tx, entry)
+	})
+}
+
+// auditWebhookData prepara el contenido del evento con la misma vista que la
+// API v1. En las bajas se envía el último estado del registro y en las
+// modificaciones, además, los campos que cambiaron.
+func auditWebhookData(tx *gorm.DB, entry AuditLog) (interface{}, error) {
+	state := entry.After
+	if state == "" {
+		state = entry.Before
+	}
+	data := gin.H{"action": entry.Action}
+	if entry.Action == AuditUpdate {
+		data["changes"] = auditChanges(entry)
+	}
+
+	switch entry.EntityType {
+	case AuditInvestment:
+		var inv Investment
+		if err := json.Unmarshal([]byte(state), &inv); err != nil {
+			return nil, err
+		}
+		tx.Unscoped().First(&inv.Ticker, inv.TickerID)
+		data["investment"] = newAPIInvestment(inv)
+	case AuditSale:
+		var sale Sale
+		if err := json.Unmarshal([]byte(state), &sale); err != nil {
+			return nil, err
+		}
+		tx.Unscoped().First(&sale.Ticker, sale.TickerID)
+		views, err := newAPISales(tx, []Sale{sale})
+		if err != nil {
+			return nil, err
+		}
+		data["sale"] = views[0]
+	case AuditTicker:
+		var before, after Ticker
+		if err := json.Unmarshal([]byte(entry.Before), &before); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(entry.After), &after); err != nil {
+			return nil, err
+		}
+		data["ticker"] = newAPITicker(after)
+		data["previous_price"] = before.CurrentPrice
+		data["current_price"] = after.CurrentPrice
+	}
+	return data, nil
+}
+
+// snapshotWebhookData prepara el contenido del evento snapshot.created.
+func snapshotWebhookData(tx *gorm.DB, snapshotID string, priceHistories []PriceHistory) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		names := make(map[uint]string)
+		var tickers []Ticker
+		if err := tx.Find(&tickers).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range tickers {
+			names[t.ID] = t.Name
+		}
+
+		type snapshotPrice struct {
+			TickerID uint            `json:"ticker_id"`
+			Ticker   string          `json:"ticker"`
+			Price    decimal.Decimal `json:"price"`
+		}
+		prices := make([]snapshotPrice, 0, len(priceHistories))
+		for _, ph := range priceHistories {
+			prices = append(prices, snapshotPrice{TickerID: ph.TickerID, Ticker: names[ph.TickerID], Price: ph.Price})
+		}
+		return gin.H{"snapshot_id": snapshotID, "prices": prices}, nil
+	}
+}
+
+// sendWebhook hace un intento de entrega y devuelve el estado HTTP y el
+// principio de la respuesta.
+func sendWebhook(subscription WebhookSubscription, delivery WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bolsa-gin-webhooks/1.0")
+	req.Header.Set(webhookEventHeader, delivery.Event)
+	req.Header.Set(webhookDeliveryHeader, delivery.EventID)
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookSignatureHeader, signWebhook(subscription.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseMaxSize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("el destino respondió con estado %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// claimWebhookDelivery reserva una entrega pendiente aplazando su próximo
+// intento, para que el proceso de envío y el botón de prueba no la envíen dos
+// veces. Si el servidor cae a mitad del intento, se reintenta al vencer la reserva.
+func claimWebhookDelivery(delivery WebhookDelivery) bool {
+	result := db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, WebhookPending, delivery.NextAttemptAt).
+		Update("next_attempt_at", time.Now().Add(webhookClaimTimeout))
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// attemptWebhookDelivery hace un intento de entrega y programa el siguiente si falla.
+func attemptWebhookDelivery(delivery WebhookDelivery) {
+	if !claimWebhookDelivery(delivery) {
+		return
+	}
+	now := time.Now()
+	updates := map[string]interface{}{"attempts": delivery.Attempts + 1}
+
+	var status int
+	var body string
+	var err error
+	if delivery.Subscription.ID == 0 {
+		err = errors.New("la suscripción ya no existe")
+		updates["attempts"] = webhookMaxAttempts
+	} else {
+		status, body, err = sendWebhook(delivery.Subscription, delivery)
+	}
+	updates["response_status"] = status
+	updates["response_body"] = body
+
+	switch {
+	case err == nil:
+		updates["status"] = WebhookDelivered
+		updates["delivered_at"] = now
+		updates["error"] = ""
+		log.Printf("Webhook %s entregado a %s (intento %d)", delivery.Event, delivery.Subscription.URL, delivery.Attempts+1)
+	case updates["attempts"].(int) >= webhookMaxAttempts:
+		updates["status"] = WebhookFailed
+		updates["error"] = err.Error()
+		log.Printf("Webhook %s descartado tras %d intentos: %v", delivery.Event, updates["attempts"], err)
+	default:
+		updates["error"] = err.Error()
+		updates["next_attempt_at"] = now.Add(webhookBackoff(delivery.Attempts + 1))
+		log.Printf("Error al entregar webhook %s a %s (intento %d): %v", delivery.Event, delivery.Subscription.URL, delivery.Attempts+1, err)
+	}
+
+	if err := db.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Printf("Error al guardar el resultado del webhook %d: %v", delivery.ID, err)
+	}
+}
+
+// attemptWebhookDeliveryNow hace en la propia petición un intento de la
+// entrega indicada, sin esperar al proceso de envío ni tocar las demás. Se
+// vuelve a leer de la base de datos para que la reserva compare la fecha del
+// próximo intento con la precisión guardada. Si el proceso de envío se
+// adelanta, la reserva evita enviarla dos veces.
+func attemptWebhookDeliveryNow(id uint) {
+	var delivery WebhookDelivery
+	if err := db.Preload("Subscription").First(&delivery, id).Error; err != nil {
+		log.Printf("Error al cargar la entrega de webhook %d: %v", id, err)
+		return
+	}
+	attemptWebhookDelivery(delivery)
+}
+
+// processDueWebhooks entrega las entregas pendientes cuyo intento ya toca.
+func processDueWebhooks() {
+	var deliveries []WebhookDelivery
+	err := db.Preload("Subscription").
+		Where("status = ? AND next_attempt_at <= ?", WebhookPending, time.Now()).
+		Order("id").Limit(webhookBatchSize).Find(&deliveries).Error
+	if err != nil {
+		log.Printf("Error al obtener los webhooks pendientes: %v", err)
+		return
+	}
+	for _, d := range deliveries {
+		attemptWebhookDelivery(d)
+	}
+}
+
+// startWebhookWorker arranca el proceso que entrega los webhooks pendientes.
+// Las entregas quedan en la base de datos, así que las que no llegaron a
+// enviarse antes de un reinicio se envían al arrancar el servidor.
+func startWebhookWorker() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+		for {
+			processDueWebhooks()
+			select {
+			case <-ticker.C:
+			case <-webhookKick:
+			}
+		}
+	}()
+}
+
+// parseWebhookForm valida los datos del formulario de alta.
+func parseWebhookForm(c *gin.Context) (WebhookSubscription, error) {
+	target := strings.TrimSpace(c.PostForm("url"))
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebhookSubscription{}, errors.New("la URL debe ser http:// o https://")
+	}
+
+	events := c.PostFormArray("events")
+	if len(events) == 0 {
+		return WebhookSubscription{}, errors.New("selecciona al menos un evento")
+	}
+	for _, e := range events {
+		known := e == webhookAllEvents
+		for _, w := range webhookEvents {
+			known = known || w.Key == e
+		}
+		if !known {
+			return WebhookSubscription{}, fmt.Errorf("evento desconocido: %s", e)
+		}
+	}
+
+	secret := strings.TrimSpace(c.PostForm("secret"))
+	if secret == "" {
+		secret = newWebhookToken("whsec_")
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = u.Host
+	}
+	return WebhookSubscription{Name: name, URL: target, Events: strings.Join(events, ","), Secret: secret, Active: true}, nil
+}
+
+// webhookSubscriptionFromForm carga la suscripción indicada en el campo id.
+func webhookSubscriptionFromForm(c *gin.Context) (WebhookSubscription, bool) {
+	var subscription WebhookSubscription
+	id, err := strconv.Atoi(c.PostForm("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "ID inválido.")
+		return subscription, false
+	}
+	if err := db.First(&subscription, id).Error; err != nil {
+		c.String(http.StatusNotFound, "Webhook no encontrado.")
+		return subscription, false
+	}
+	return subscription, true
+}
+
+// registerWebhookRoutes registra las rutas de gestión de webhooks.
+func registerWebhookRoutes(router *gin.Engine) {
+	// Ruta para mostrar la página de webhooks
+	router.GET("/webhooks", func(c *gin.Context) {
+		var subscriptions []WebhookSubscription
+		db.Order("created_at desc").Find(&subscriptions)
+
+		var deliveries []WebhookDelivery
+		db.Preload("Subscription", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+			Order("id desc").Limit(100).Find(&deliveries)
+
+		c.HTML(http.StatusOK, "webhooks.html", gin.H{
+			"Subscriptions": subscriptions,
+			"Deliveries":    deliveries,
+			"Events":        webhookEvents,
+			"MaxAttempts":   webhookMaxAttempts,
+			"ActivePage":    "webhooks",
+		})
+	})
+
+	// Ruta para crear una suscripción
+	router.POST("/add-webhook", func(c *gin.Context) {
+		subscription, err := parseWebhookForm(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Datos del webhook inválidos: %v", err)
+			return
+		}
+		if err := db.Create(&subscription).Error; err != nil {
+			c.String(http.StatusInternalServerError, "Error al crear el webhook: %v", err)
+			return
+		}
+		log.Printf("Nuevo webhook %s hacia %s (%s)", subscription.Name, subscription.URL, subscription.Events)
+		c.Redirect(http.StatusFound, "/webhooks")
+	})
+
+	// Ruta para activar o desactivar una suscripción
+	router.POST("/toggle-webhook", func(c *gin.Context) {
+		subscription, ok := webhookSubscriptionFromForm(c)
+		if !ok {
+			return
+		}
+		db.Model(&subscription).Update("active", !subscription.Active)
+		if subscription.Active {
+			log.Printf("Webhook %d desactivado", subscription.ID)
+		} else {
+			log.Printf("Webhook %d activado", subscription.ID)
+		}
+		c.Redirect(http.StatusFound, "/webhooks")
+	})
+
+	// Ruta para eliminar una suscripción; sus entregas pendientes se descartan
+	router.POST("/delete-webhook", func(c *gin.Context) {
+		subscription, ok := webhookSubscriptionFromForm(c)
+		if !ok {
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&subscription).Error; err != nil {
+				return err
+			}
+			return tx.Model(&WebhookDelivery{}).
+				Where("subscription_id = ? AND status = ?", subscription.ID, WebhookPending).
+				Updates(map[string]interface{}{"status": WebhookFailed, "error": "webhook eliminado"}).Error
+		})
+		if err != nil {
+			log.Printf("Error al eliminar el webhook %d: %v", subscription.ID, err)
+			c.String(http.StatusInternalServerError, "Error al eliminar el webhook: %v", err)
+			return
+		}
+		log.Printf("Webhook %d eliminado", subscription.ID)
+		c.Redirect(http.StatusFound, "/webhooks")
+	})
+
+	// Ruta para enviar un evento de prueba. El primer intento de esa entrega se
+	// hace en la propia petición para ver el resultado al volver a la página.
+	router.POST("/test-webhook", func(c *gin.Context) {
+		subscription, ok := webhookSubscriptionFromForm(c)
+		if !ok {
+			return
+		}
+		data := func() (interface{}, error) {
+			return gin.H{"message": "Evento de prueba de Bolsa Gin", "webhook": subscription.Name}, nil
+		}
+		deliveries, err := enqueueWebhookDeliveries(db, []WebhookSubscription{subscription}, WebhookTest, auditActor(c), data)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al crear el evento de prueba: %v", err)
+			return
+		}
+		attemptWebhookDeliveryNow(deliveries[0].ID)
+		c.Redirect(http.StatusFound, "/webhooks")
+	})
+
+	// Ruta para reintentar una entrega fallida desde el principio
+	router.POST("/retry-webhook-delivery", func(c *gin.Context) {
+		id, err := strconv.Atoi(c.PostForm("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+		result := db.Model(&WebhookDelivery{}).
+			Where("id = ? AND status = ?", id, WebhookFailed).
+			Updates(map[string]interface{}{"status": WebhookPending, "attempts": 0, "next_attempt_at": time.Now()})
+		if result.RowsAffected == 0 {
+			c.String(http.StatusNotFound, "Entrega fallida no encontrada.")
+			return
+		}
+		log.Printf("Entrega de webhook %d reprogramada", id)
+		attemptWebhookDeliveryNow(uint(id))
+		c.Redirect(http.StatusFound, "/webhooks")
+	})
+}
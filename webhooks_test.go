@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver es un destino de prueba que comprueba la firma de cada
+// petición y responde con los estados indicados, repitiendo el último.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	errors   []string
+}
+
+func newWebhookReceiver(t *testing.T, secret string, statuses ...int) (*webhookReceiver, *httptest.Server) {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhookTimestampHeader), 10, 64)
+		if err != nil {
+			receiver.errors = append(receiver.errors, "marca de tiempo inválida: "+err.Error())
+		}
+		expected := signWebhook(secret, timestamp, body)
+		if !hmac.Equal([]byte(r.Header.Get(webhookSignatureHeader)), []byte(expected)) {
+			receiver.errors = append(receiver.errors, "firma incorrecta: "+r.Header.Get(webhookSignatureHeader))
+		}
+		if r.Header.Get(webhookEventHeader) != WebhookTest {
+			receiver.errors = append(receiver.errors, "evento inesperado: "+r.Header.Get(webhookEventHeader))
+		}
+
+		status := receiver.statuses[len(receiver.statuses)-1]
+		if receiver.requests < len(receiver.statuses) {
+			status = receiver.statuses[receiver.requests]
+		}
+		receiver.requests++
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+func (r *webhookReceiver) check(t *testing.T, requests int) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.errors {
+		t.Error(e)
+	}
+	if r.requests != requests {
+		t.Errorf("peticiones recibidas = %d, se esperaban %d", r.requests, requests)
+	}
+}
+
+// enqueueTestWebhook crea una suscripción al destino y encola un evento de prueba.
+func enqueueTestWebhook(t *testing.T, url, secret string) WebhookDelivery {
+	t.Helper()
+	subscription := WebhookSubscription{Name: "test", URL: url, Events: WebhookTest, Secret: secret, Active: true}
+	if err := db.Create(&subscription).Error; err != nil {
+		t.Fatalf("crear suscripción: %v", err)
+	}
+	data := func() (interface{}, error) { return gin.H{"mensaje": "prueba"}, nil }
+	if err := enqueueWebhookEvent(db, WebhookTest, "test", data); err != nil {
+		t.Fatalf("enqueueWebhookEvent: %v", err)
+	}
+	var delivery WebhookDelivery
+	if err := db.Where("subscription_id = ?", subscription.ID).First(&delivery).Error; err != nil {
+		t.Fatalf("no se creó la entrega: %v", err)
+	}
+	return delivery
+}
+
+// reloadDelivery lee la entrega y adelanta su próximo intento si sigue pendiente.
+func reloadDelivery(t *testing.T, id uint, makeDue bool) WebhookDelivery {
+	t.Helper()
+	var delivery WebhookDelivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		t.Fatalf("leer entrega: %v", err)
+	}
+	if makeDue && delivery.Status == WebhookPending {
+		db.Model(&WebhookDelivery{}).Where("id = ?", id).Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+	return delivery
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	useTestDatabase(t)
+	const secret = "whsec_test"
+	receiver, server := newWebhookReceiver(t, secret, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	delivery := enqueueTestWebhook(t, server.URL, secret)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		processDueWebhooks()
+		d := reloadDelivery(t, delivery.ID, false)
+		if d.Status != WebhookPending || d.Attempts != attempt {
+			t.Fatalf("intento %d: estado %s con %d intentos, se esperaba pending con %d", attempt, d.Status, d.Attempts, attempt)
+		}
+		wait := d.NextAttemptAt.Sub(before)
+		if backoff := webhookBackoff(attempt); wait < backoff-time.Second || wait > backoff+time.Second {
+			t.Errorf("intento %d: siguiente intento en %v, se esperaba %v", attempt, wait, backoff)
+		}
+		if d.Error == "" || d.ResponseStatus == 0 {
+			t.Errorf("intento %d: no se guardó el error (%q, estado %d)", attempt, d.Error, d.ResponseStatus)
+		}
+
+		// Sin adelantar el reintento no se vuelve a enviar
+		processDueWebhooks()
+		receiver.check(t, attempt)
+		reloadDelivery(t, delivery.ID, true)
+	}
+
+	processDueWebhooks()
+	d := reloadDelivery(t, delivery.ID, false)
+	if d.Status != WebhookDelivered || d.Attempts != 3 || d.DeliveredAt == nil {
+		t.Errorf("estado %s con %d intentos (entregado: %v), se esperaba delivered con 3", d.Status, d.Attempts, d.DeliveredAt)
+	}
+	if d.ResponseStatus != http.StatusOK || d.Error != "" {
+		t.Errorf("respuesta %d con error %q, se esperaba 200 sin error", d.ResponseStatus, d.Error)
+	}
+	receiver.check(t, 3)
+}
+
+func TestWebhookFailsAfterMaxAttempts(t *testing.T) {
+	useTestDatabase(t)
+	const secret = "whsec_fail"
+	receiver, server := newWebhookReceiver(t, secret, http.StatusInternalServerError)
+	delivery := enqueueTestWebhook(t, server.URL, secret)
+
+	for i := 0; i < webhookMaxAttempts; i++ {
+		processDueWebhooks()
+		reloadDelivery(t, delivery.ID, true)
+	}
+	d := reloadDelivery(t, delivery.ID, false)
+	if d.Status != WebhookFailed || d.Attempts != webhookMaxAttempts {
+		t.Fatalf("estado %s con %d intentos, se esperaba failed con %d", d.Status, d.Attempts, webhookMaxAttempts)
+	}
+	if d.ResponseStatus != http.StatusInternalServerError || d.DeliveredAt != nil {
+		t.Errorf("respuesta %d (entregado: %v), se esperaba 500 sin entregar", d.ResponseStatus, d.DeliveredAt)
+	}
+
+	// Una entrega fallida no se vuelve a intentar
+	processDueWebhooks()
+	receiver.check(t, webhookMaxAttempts)
+}
+
+func TestWebhookDeletedSubscriptionFails(t *testing.T) {
+	useTestDatabase(t)
+	receiver, server := newWebhookReceiver(t, "whsec_gone", http.StatusOK)
+	delivery := enqueueTestWebhook(t, server.URL, "whsec_gone")
+	db.Delete(&WebhookSubscription{}, delivery.SubscriptionID)
+
+	processDueWebhooks()
+	d := reloadDelivery(t, delivery.ID, false)
+	if d.Status != WebhookFailed || d.Attempts != webhookMaxAttempts {
+		t.Errorf("estado %s con %d intentos, se esperaba failed sin reintentos", d.Status, d.Attempts)
+	}
+	receiver.check(t, 0)
+}
+
+// postWebhookForm envía un formulario a las rutas de webhooks y comprueba la redirección.
+func postWebhookForm(t *testing.T, router *gin.Engine, path string, id uint) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("id="+strconv.FormatUint(uint64(id), 10)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("%s: estado %d, se esperaba 302: %s", path, w.Code, w.Body.String())
+	}
+}
+
+func TestWebhookRoutesAttemptOnlyTheirDelivery(t *testing.T) {
+	useTestDatabase(t)
+	router := gin.New()
+	registerWebhookRoutes(router)
+
+	// Una entrega pendiente de otra suscripción no se envía desde las rutas
+	otherReceiver, otherServer := newWebhookReceiver(t, "whsec_otro", http.StatusOK)
+	other := enqueueTestWebhook(t, otherServer.URL, "whsec_otro")
+
+	receiver, server := newWebhookReceiver(t, "whsec_prueba", http.StatusInternalServerError, http.StatusOK)
+	subscription := WebhookSubscription{Name: "prueba", URL: server.URL, Events: WebhookSaleCreated, Secret: "whsec_prueba", Active: true}
+	db.Create(&subscription)
+
+	postWebhookForm(t, router, "/test-webhook", subscription.ID)
+	receiver.check(t, 1)
+	var delivery WebhookDelivery
+	if err := db.Where("subscription_id = ?", subscription.ID).First(&delivery).Error; err != nil {
+		t.Fatalf("no se creó la entrega de prueba: %v", err)
+	}
+	if delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("entrega de prueba con %d intentos y estado %d, se esperaba 1 intento con 500", delivery.Attempts, delivery.ResponseStatus)
+	}
+
+	// Reintentar una entrega fallida solo envía esa entrega
+	db.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Update("status", WebhookFailed)
+	postWebhookForm(t, router, "/retry-webhook-delivery", delivery.ID)
+	receiver.check(t, 2)
+	if d := reloadDelivery(t, delivery.ID, false); d.Status != WebhookDelivered || d.Attempts != 1 {
+		t.Errorf("entrega reintentada: estado %s con %d intentos, se esperaba delivered con 1", d.Status, d.Attempts)
+	}
+
+	otherReceiver.check(t, 0)
+	if d := reloadDelivery(t, other.ID, false); d.Status != WebhookPending || d.Attempts != 0 {
+		t.Errorf("la entrega de otra suscripción se intentó: estado %s con %d intentos", d.Status, d.Attempts)
+	}
+}
+
+func TestDeleteWebhookCancelsPendingDeliveries(t *testing.T) {
+	useTestDatabase(t)
+	router := gin.New()
+	registerWebhookRoutes(router)
+	_, server := newWebhookReceiver(t, "whsec_borrar", http.StatusOK)
+	delivery := enqueueTestWebhook(t, server.URL, "whsec_borrar")
+
+	postWebhookForm(t, router, "/delete-webhook", delivery.SubscriptionID)
+	if err := db.First(&WebhookSubscription{}, delivery.SubscriptionID).Error; err == nil {
+		t.Error("la suscripción no se eliminó")
+	}
+	if d := reloadDelivery(t, delivery.ID, false); d.Status != WebhookFailed || d.Error != "webhook eliminado" {
+		t.Errorf("entrega pendiente: estado %s con error %q, se esperaba failed", d.Status, d.Error)
+	}
+}
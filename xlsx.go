@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+)
+
+// Formatos de celda de las hojas de cálculo exportadas
+const (
+	xlsxText    = "text"
+	xlsxDate    = "date"
+	xlsxShares  = "shares"
+	xlsxPrice   = "price"
+	xlsxMoney   = "money"
+	xlsxPercent = "percent" // El valor se guarda en tanto por uno
+)
+
+// xlsxNumberFormats asocia cada formato de celda con su código de formato de
+// Excel. Los importes y precios dependen de la moneda (ver xlsxNumberFormat).
+var xlsxNumberFormats = map[string]string{
+	xlsxDate:    "dd/mm/yyyy hh:mm",
+	xlsxShares:  "#,##0.000000",
+	xlsxPercent: "0.00%",
+}
+
+// xlsxNumberFormat devuelve el código de formato de Excel de un formato de
+// celda. Los importes llevan los decimales y el código de su moneda; sin
+// moneda se muestran como números sin símbolo.
+func xlsxNumberFormat(format, currency string) (string, bool) {
+	var code string
+	switch format {
+	case xlsxMoney:
+		code = "#,##0"
+		if places := moneyPlaces(currency); places > 0 {
+			code += "." + strings.Repeat("0", int(places))
+		}
+	case xlsxPrice:
+		code = "#,##0.0000"
+	default:
+		code, ok := xlsxNumberFormats[format]
+		return code, ok
+	}
+	if currency != "" {
+		code += ` "` + currency + `"`
+	}
+	return code, true
+}
+
+// xlsxColumn describe una columna de una hoja exportada.
+type xlsxColumn struct {
+	Header string
+	Format string
+	Width  float64
+	Total  bool // Incluir la suma de la columna en la fila de totales
+}
+
+// xlsxSheet es una tabla que se exporta como una hoja del libro.
+type xlsxSheet struct {
+	Name       string
+	Columns    []xlsxColumn
+	Rows       [][]interface{}
+	Currencies []string // Moneda de los importes de cada fila
+}
+
+// currency devuelve la moneda común a todas las filas, o mixed si hay filas
+// en distintas monedas.
+func (s xlsxSheet) currency() (currency string, mixed bool) {
+	for i, c := range s.Currencies {
+		if i == 0 {
+			currency = c
+		} else if c != currency {
+			return "", true
+		}
+	}
+	return currency, false
+}
+
+// isCurrencyFormat indica si el formato depende de la moneda.
+func isCurrencyFormat(format string) bool {
+	return format == xlsxMoney || format == xlsxPrice
+}
+
+// buildXLSX genera un libro con una hoja por tabla, cabecera fija con filtro,
+// celdas tipadas con formato numérico y una fila de totales.
+func buildXLSX(sheets ...xlsxSheet) (*excelize.File, error) {
+	f := excelize.NewFile()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true, Color: "#FFFFFF"},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#1D4ED8"}, Pattern: 1},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Estilos por formato y moneda para filas de datos y de totales
+	styles := make(map[string]int)
+	styleFor := func(format, currency string, total bool) (int, error) {
+		key := fmt.Sprintf("%s|%s|%t", format, currency, total)
+		if id, ok := styles[key]; ok {
+			return id, nil
+		}
+		style := &excelize.Style{}
+		if code, ok := xlsxNumberFormat(format, currency); ok {
+			style.CustomNumFmt = &code
+		}
+		if total {
+			style.Font = &excelize.Font{Bold: true}
+			style.Border = []excelize.Border{{Type: "top", Color: "#000000", Style: 1}}
+		}
+		id, err := f.NewStyle(style)
+		styles[key] = id
+		return id, err
+	}
+
+	for i, sheet := range sheets {
+		if i == 0 {
+			f.SetSheetName("Sheet1", sheet.Name)
+		} else if _, err := f.NewSheet(sheet.Name); err != nil {
+			return nil, err
+		}
+
+		lastCol, _ := excelize.ColumnNumberToName(len(sheet.Columns))
+		lastRow := len(sheet.Rows) + 1
+		currency, mixed := sheet.currency()
+
+		for col, column := range sheet.Columns {
+			name, _ := excelize.ColumnNumberToName(col + 1)
+			f.SetCellValue(sheet.Name, name+"1", column.Header)
+			width := column.Width
+			if width == 0 {
+				width = 16
+			}
+			f.SetColWidth(sheet.Name, name, name, width)
+			if len(sheet.Rows) == 0 {
+				continue
+			}
+			style, err := styleFor(column.Format, currency, false)
+			if err != nil {
+				return nil, err
+			}
+			f.SetCellStyle(sheet.Name, name+"2", fmt.Sprintf("%s%d", name, lastRow), style)
+
+			// Con varias monedas cada importe lleva la de su fila
+			if mixed && isCurrencyFormat(column.Format) {
+				for r, rowCurrency := range sheet.Currencies {
+					if style, err = styleFor(column.Format, rowCurrency, false); err != nil {
+						return nil, err
+					}
+					cell := fmt.Sprintf("%s%d", name, r+2)
+					f.SetCellStyle(sheet.Name, cell, cell, style)
+				}
+			}
+		}
+		f.SetCellStyle(sheet.Name, "A1", lastCol+"1", headerStyle)
+
+		for r, row := range sheet.Rows {
+			// Excel solo guarda números en coma flotante; los importes exactos
+			// se convierten al escribir la celda
+			for i, value := range row {
+				if d, ok := value.(decimal.Decimal); ok {
+					row[i] = d.InexactFloat64()
+				}
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, r+2)
+			if err := f.SetSheetRow(sheet.Name, cell, &row); err != nil {
+				return nil, err
+			}
+		}
+
+		// Fila de totales con fórmulas para que se recalculen al editar. Los
+		// importes en distintas monedas no se suman
+		if len(sheet.Rows) > 0 {
+			totalRow := lastRow + 1
+			for col, column := range sheet.Columns {
+				name, _ := excelize.ColumnNumberToName(col + 1)
+				cell := fmt.Sprintf("%s%d", name, totalRow)
+				if col == 0 {
+					f.SetCellValue(sheet.Name, cell, "Total")
+				} else if column.Total && !(mixed && isCurrencyFormat(column.Format)) {
+					f.SetCellFormula(sheet.Name, cell, fmt.Sprintf("SUM(%s2:%s%d)", name, name, lastRow))
+				}
+				style, err := styleFor(column.Format, currency, true)
+				if err != nil {
+					return nil, err
+				}
+				f.SetCellStyle(sheet.Name, cell, cell, style)
+			}
+			f.AutoFilter(sheet.Name, fmt.Sprintf("A1:%s%d", lastCol, lastRow), nil)
+		}
+
+		f.SetPanes(sheet.Name, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	}
+
+	return f, nil
+}
+
+// xlsxViewDate convierte las fechas formateadas de las vistas en fechas de
+// Excel. Si no se reconoce el formato se exporta el texto tal cual.
+func xlsxViewDate(value string) interface{} {
+	for _, layout := range []string{"02 Jan 2006 15:04", "02 Jan 2006"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	return value
+}
+
+// tickerCurrencies devuelve la moneda de cada ticker, incluidos los borrados.
+func tickerCurrencies() map[uint]string {
+	var tickers []Ticker
+	db.Unscoped().Select("id", "currency").Find(&tickers)
+	currencies := make(map[uint]string, len(tickers))
+	for _, t := range tickers {
+		currencies[t.ID] = t.Currency
+	}
+	return currencies
+}
+
+// investmentsSheet construye la hoja de compras.
+func investmentsSheet(investments []InvestmentView, currencies map[uint]string) xlsxSheet {
+	sheet := xlsxSheet{
+		Name: "Compras",
+		Columns: []xlsxColumn{
+			{Header: "Acción", Format: xlsxText, Width: 12},
+			{Header: "Fecha", Format: xlsxDate, Width: 18},
+			{Header: "Costo", Format: xlsxMoney, Total: true},
+			{Header: "Acciones", Format: xlsxShares, Total: true},
+			{Header: "Precio Compra", Format: xlsxPrice},
+			{Header: "Monto Invertido", Format: xlsxMoney, Total: true},
+			{Header: "Precio Actual", Format: xlsxPrice},
+			{Header: "Monto Actual", Format: xlsxMoney, Total: true},
+			{Header: "Cambio", Format: xlsxPercent},
+			{Header: "Posición", Format: xlsxMoney, Total: true},
+		},
+	}
+	for _, i := range investments {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			i.Ticker, xlsxViewDate(i.PurchaseDate), i.OperationCost, i.Shares, i.PurchasePrice,
+			i.InvestedCapital, i.CurrentPrice, i.CurrentValue, i.Performance / 100, i.ProfitLoss,
+		})
+		sheet.Currencies = append(sheet.Currencies, currencies[i.TickerID])
+	}
+	return sheet
+}
+
+// salesSheet construye la hoja de ventas.
+func salesSheet(sales []SaleView, currencies map[uint]string) xlsxSheet {
+	sheet := xlsxSheet{
+		Name: "Ventas",
+		Columns: []xlsxColumn{
+			{Header: "Ticker", Format: xlsxText, Width: 12},
+			{Header: "Fecha", Format: xlsxDate, Width: 18},
+			{Header: "Costo", Format: xlsxMoney, Total: true},
+			{Header: "Retención", Format: xlsxMoney, Total: true},
+			{Header: "Acciones", Format: xlsxShares, Total: true},
+			{Header: "Precio Venta", Format: xlsxPrice},
+			{Header: "Monto Venta", Format: xlsxMoney, Total: true},
+			{Header: "WAC en Venta", Format: xlsxPrice},
+			{Header: "Rendimiento", Format: xlsxPercent},
+			{Header: "Utilidad", Format: xlsxMoney, Total: true},
+			{Header: "Precio Actual", Format: xlsxPrice},
+			{Header: "Monto Actual", Format: xlsxMoney, Total: true},
+			{Header: "Cambio", Format: xlsxPercent},
+			{Header: "Proyección", Format: xlsxMoney, Total: true},
+		},
+	}
+	for _, s := range sales {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			s.Ticker, xlsxViewDate(s.SaleDate), s.OperationCost, s.WithheldTax, s.Shares, s.SalePrice,
+			s.TotalSaleValue, s.WACAtSale, s.SalePerformance / 100, s.SaleUtility, s.CurrentPrice,
+			s.CurrentValue, s.Performance / 100, s.Projection,
+		})
+		sheet.Currencies = append(sheet.Currencies, currencies[s.TickerID])
+	}
+	return sheet
+}
+
+// summariesSheet construye la hoja de resumen por ticker.
+func summariesSheet(summaries []TickerSummaryView, currencies map[uint]string) xlsxSheet {
+	sheet := xlsxSheet{
+		Name: "Resumen",
+		Columns: []xlsxColumn{
+			{Header: "Ticker", Format: xlsxText, Width: 12},
+			{Header: "Acciones", Format: xlsxShares, Total: true},
+			{Header: "Inversión Actual", Format: xlsxMoney, Total: true},
+			{Header: "Costos Totales", Format: xlsxMoney, Total: true},
+			{Header: "Valor Actual", Format: xlsxMoney, Total: true},
+			{Header: "Rendimiento", Format: xlsxPercent},
+			{Header: "Posición", Format: xlsxMoney, Total: true},
+		},
+	}
+	for _, s := range summaries {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			s.Ticker, s.TotalShares, s.CurrentInvestment, s.TotalCost, s.CurrentValue, s.Performance / 100, s.ProfitLoss,
+		})
+		sheet.Currencies = append(sheet.Currencies, currencies[s.TickerID])
+	}
+	return sheet
+}
+
+// dividendsSheet construye la hoja de dividendos de un ticker. Los dividendos
+// sin moneda propia usan la del ticker.
+func dividendsSheet(dividends []DividendView, tickerCurrency string) xlsxSheet {
+	sheet := xlsxSheet{
+		Name: "Dividendos",
+		Columns: []xlsxColumn{
+			{Header: "Fecha", Format: xlsxDate, Width: 18},
+			{Header: "Importe Bruto", Format: xlsxMoney, Total: true},
+			{Header: "Impuesto Retenido", Format: xlsxMoney, Total: true},
+			{Header: "Importe Neto", Format: xlsxMoney, Total: true},
+			{Header: "Moneda", Format: xlsxText, Width: 10},
+		},
+	}
+	for _, d := range dividends {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			xlsxViewDate(d.PaymentDate), d.Amount, d.WithheldTax, d.NetAmount, d.Currency,
+		})
+		currency := d.Currency
+		if currency == "" {
+			currency = tickerCurrency
+		}
+		sheet.Currencies = append(sheet.Currencies, currency)
+	}
+	return sheet
+}
+
+// sendXLSX genera el libro y lo envía como descarga.
+func sendXLSX(c *gin.Context, filename string, sheets ...xlsxSheet) {
+	if format := c.DefaultQuery("format", "xlsx"); format != "xlsx" {
+		c.String(http.StatusBadRequest, "Formato no soportado. Use xlsx.")
+		return
+	}
+
+	f, err := buildXLSX(sheets...)
+	if err != nil {
+		log.Printf("Error al generar el XLSX: %v", err)
+		c.String(http.StatusInternalServerError, "Error al generar el fichero: %v", err)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := f.Write(c.Writer); err != nil {
+		log.Printf("Error al enviar el XLSX: %v", err)
+	}
+}
+
+// registerXLSXRoutes registra las rutas de exportación a Excel.
+func registerXLSXRoutes(router *gin.Engine) {
+	date := func() string { return time.Now().Format("20060102") }
+
+	// Exportar el dashboard: resumen, compras y ventas
+	router.GET("/dashboard/export", func(c *gin.Context) {
+		investments, summaries, sales, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+		currencies := tickerCurrencies()
+		sendXLSX(c, "cartera-"+date()+".xlsx", summariesSheet(summaries, currencies),
+			investmentsSheet(investments, currencies), salesSheet(sales, currencies))
+	})
+
+	// Exportar el resumen por ticker
+	router.GET("/resumen/export", func(c *gin.Context) {
+		_, summaries, _, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+		sendXLSX(c, "resumen-"+date()+".xlsx", summariesSheet(summaries, tickerCurrencies()))
+	})
+
+	// Exportar el historial de compras
+	router.GET("/compras/export", func(c *gin.Context) {
+		investments, _, _, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+		sendXLSX(c, "compras-"+date()+".xlsx", investmentsSheet(investments, tickerCurrencies()))
+	})
+
+	// Exportar el historial de ventas
+	router.GET("/ventas/export", func(c *gin.Context) {
+		_, _, sales, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+		sendXLSX(c, "ventas-"+date()+".xlsx", salesSheet(sales, tickerCurrencies()))
+	})
+
+	// Exportar el detalle de un ticker: compras, ventas y dividendos
+	router.GET("/ticker/:id/export", func(c *gin.Context) {
+		tickerID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "ID inválido.")
+			return
+		}
+
+		var ticker Ticker
+		if err := db.First(&ticker, tickerID).Error; err != nil {
+			c.String(http.StatusNotFound, "Ticker no encontrado.")
+			return
+		}
+
+		investments, _, sales, _, _, _, _, _, _, _, err := getInvestmentData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error al obtener los datos: %v", err)
+			return
+		}
+
+		var tickerInvestments []InvestmentView
+		for _, i := range investments {
+			if i.TickerID == ticker.ID {
+				tickerInvestments = append(tickerInvestments, i)
+			}
+		}
+		var tickerSales []SaleView
+		for _, s := range sales {
+			if s.TickerID == ticker.ID {
+				tickerSales = append(tickerSales, s)
+			}
+		}
+		dividends, _ := getTickerDividends(ticker.ID)
+		currencies := map[uint]string{ticker.ID: ticker.Currency}
+
+		sendXLSX(c, fmt.Sprintf("%s-%s.xlsx", ticker.Name, date()),
+			investmentsSheet(tickerInvestments, currencies), salesSheet(tickerSales, currencies), dividendsSheet(dividends, ticker.Currency))
+	})
+}
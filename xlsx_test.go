@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxCellFormat devuelve el código de formato numérico de una celda.
+func xlsxCellFormat(t *testing.T, f *excelize.File, sheet, cell string) string {
+	t.Helper()
+	id, err := f.GetCellStyle(sheet, cell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	style, err := f.GetStyle(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if style.CustomNumFmt == nil {
+		return ""
+	}
+	return *style.CustomNumFmt
+}
+
+func TestXLSXCurrencyFormats(t *testing.T) {
+	summaries := []TickerSummaryView{
+		{TickerID: 1, Ticker: "SAN", TotalShares: decimal.NewFromInt(10), CurrentValue: decimal.NewFromInt(40)},
+		{TickerID: 2, Ticker: "AAPL", TotalShares: decimal.NewFromInt(2), CurrentValue: decimal.NewFromInt(400)},
+		{TickerID: 3, Ticker: "SONY", TotalShares: decimal.NewFromInt(100), CurrentValue: decimal.NewFromInt(150000)},
+	}
+	currencies := map[uint]string{1: "EUR", 2: "USD", 3: "JPY"}
+
+	f, err := buildXLSX(summariesSheet(summaries, currencies))
+	if err != nil {
+		t.Fatalf("buildXLSX: %v", err)
+	}
+	defer f.Close()
+
+	// Columna E: Valor Actual
+	for cell, want := range map[string]string{
+		"E2": `#,##0.00 "EUR"`,
+		"E3": `#,##0.00 "USD"`,
+		"E4": `#,##0 "JPY"`,
+	} {
+		if got := xlsxCellFormat(t, f, "Resumen", cell); got != want {
+			t.Errorf("%s: formato %q, se esperaba %q", cell, got, want)
+		}
+	}
+	if formula, _ := f.GetCellFormula("Resumen", "E5"); formula != "" {
+		t.Errorf("no deberían sumarse importes en distintas monedas: %q", formula)
+	}
+	if formula, _ := f.GetCellFormula("Resumen", "B5"); formula == "" {
+		t.Error("falta el total de acciones")
+	}
+}
+
+func TestXLSXSingleCurrencyTotals(t *testing.T) {
+	summaries := []TickerSummaryView{
+		{TickerID: 1, Ticker: "AAPL", CurrentValue: decimal.NewFromInt(40)},
+		{TickerID: 2, Ticker: "MSFT", CurrentValue: decimal.NewFromInt(400)},
+	}
+	f, err := buildXLSX(summariesSheet(summaries, map[uint]string{1: "USD", 2: "USD"}))
+	if err != nil {
+		t.Fatalf("buildXLSX: %v", err)
+	}
+	defer f.Close()
+
+	if formula, _ := f.GetCellFormula("Resumen", "E4"); formula != "SUM(E2:E3)" {
+		t.Errorf("total = %q, se esperaba SUM(E2:E3)", formula)
+	}
+	if got := xlsxCellFormat(t, f, "Resumen", "E4"); got != `#,##0.00 "USD"` {
+		t.Errorf("formato del total = %q", got)
+	}
+
+	// Sin moneda conocida los importes no llevan símbolo
+	f2, err := buildXLSX(summariesSheet(summaries, nil))
+	if err != nil {
+		t.Fatalf("buildXLSX: %v", err)
+	}
+	defer f2.Close()
+	if got := xlsxCellFormat(t, f2, "Resumen", "E2"); got != "#,##0.00" {
+		t.Errorf("formato sin moneda = %q", got)
+	}
+}